@@ -0,0 +1,150 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: availability/v1/agent.proto
+
+package availabilityv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	NodeAgentService_ListFlaggedImages_FullMethodName = "/availability.v1.NodeAgentService/ListFlaggedImages"
+	NodeAgentService_ReportNodeResults_FullMethodName = "/availability.v1.NodeAgentService/ReportNodeResults"
+)
+
+// NodeAgentServiceClient is the client API for NodeAgentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NodeAgentServiceClient interface {
+	// ListFlaggedImages returns images the agent should verify on its node.
+	ListFlaggedImages(ctx context.Context, in *ListFlaggedImagesRequest, opts ...grpc.CallOption) (*ListFlaggedImagesResponse, error)
+	// ReportNodeResults replaces verification results previously reported for the node.
+	ReportNodeResults(ctx context.Context, in *ReportNodeResultsRequest, opts ...grpc.CallOption) (*ReportNodeResultsResponse, error)
+}
+
+type nodeAgentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNodeAgentServiceClient(cc grpc.ClientConnInterface) NodeAgentServiceClient {
+	return &nodeAgentServiceClient{cc}
+}
+
+func (c *nodeAgentServiceClient) ListFlaggedImages(ctx context.Context, in *ListFlaggedImagesRequest, opts ...grpc.CallOption) (*ListFlaggedImagesResponse, error) {
+	out := new(ListFlaggedImagesResponse)
+	err := c.cc.Invoke(ctx, NodeAgentService_ListFlaggedImages_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeAgentServiceClient) ReportNodeResults(ctx context.Context, in *ReportNodeResultsRequest, opts ...grpc.CallOption) (*ReportNodeResultsResponse, error) {
+	out := new(ReportNodeResultsResponse)
+	err := c.cc.Invoke(ctx, NodeAgentService_ReportNodeResults_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NodeAgentServiceServer is the server API for NodeAgentService service.
+// All implementations must embed UnimplementedNodeAgentServiceServer
+// for forward compatibility
+type NodeAgentServiceServer interface {
+	// ListFlaggedImages returns images the agent should verify on its node.
+	ListFlaggedImages(context.Context, *ListFlaggedImagesRequest) (*ListFlaggedImagesResponse, error)
+	// ReportNodeResults replaces verification results previously reported for the node.
+	ReportNodeResults(context.Context, *ReportNodeResultsRequest) (*ReportNodeResultsResponse, error)
+	mustEmbedUnimplementedNodeAgentServiceServer()
+}
+
+// UnimplementedNodeAgentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedNodeAgentServiceServer struct {
+}
+
+func (UnimplementedNodeAgentServiceServer) ListFlaggedImages(context.Context, *ListFlaggedImagesRequest) (*ListFlaggedImagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFlaggedImages not implemented")
+}
+func (UnimplementedNodeAgentServiceServer) ReportNodeResults(context.Context, *ReportNodeResultsRequest) (*ReportNodeResultsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportNodeResults not implemented")
+}
+func (UnimplementedNodeAgentServiceServer) mustEmbedUnimplementedNodeAgentServiceServer() {}
+
+// UnsafeNodeAgentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NodeAgentServiceServer will
+// result in compilation errors.
+type UnsafeNodeAgentServiceServer interface {
+	mustEmbedUnimplementedNodeAgentServiceServer()
+}
+
+func RegisterNodeAgentServiceServer(s grpc.ServiceRegistrar, srv NodeAgentServiceServer) {
+	s.RegisterService(&NodeAgentService_ServiceDesc, srv)
+}
+
+func _NodeAgentService_ListFlaggedImages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFlaggedImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAgentServiceServer).ListFlaggedImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeAgentService_ListFlaggedImages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAgentServiceServer).ListFlaggedImages(ctx, req.(*ListFlaggedImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeAgentService_ReportNodeResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportNodeResultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeAgentServiceServer).ReportNodeResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NodeAgentService_ReportNodeResults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeAgentServiceServer).ReportNodeResults(ctx, req.(*ReportNodeResultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NodeAgentService_ServiceDesc is the grpc.ServiceDesc for NodeAgentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NodeAgentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "availability.v1.NodeAgentService",
+	HandlerType: (*NodeAgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListFlaggedImages",
+			Handler:    _NodeAgentService_ListFlaggedImages_Handler,
+		},
+		{
+			MethodName: "ReportNodeResults",
+			Handler:    _NodeAgentService_ReportNodeResults_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "availability/v1/agent.proto",
+}
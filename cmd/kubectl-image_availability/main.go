@@ -0,0 +1,224 @@
+// Command kubectl-image_availability is a kubectl plugin querying the API of k8s-image-availability-exporter. Install
+// it into PATH and run "kubectl image-availability".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/apiclient"
+	"github.com/flant/k8s-image-availability-exporter/pkg/handlers"
+)
+
+const usage = `Query the availability of images checked by k8s-image-availability-exporter.
+
+Usage:
+  kubectl image-availability unavailable [flags]           list unavailable images
+  kubectl image-availability workload [flags] KIND/NAME    show images of a workload
+  kubectl image-availability recheck [flags] IMAGE...      check images immediately
+
+Run "kubectl image-availability COMMAND -h" for the flags of a command.
+`
+
+type options struct {
+	kubeconfig    string
+	kubeContext   string
+	namespace     string
+	allNamespaces bool
+
+	service   string
+	server    string
+	tokenFile string
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path to the kubeconfig file, defaults to the KUBECONFIG environment variable or ~/.kube/config")
+	fs.StringVar(&o.kubeContext, "context", "", "kubeconfig context to use")
+	fs.StringVar(&o.namespace, "n", "", "namespace of workloads, defaults to the namespace of the kubeconfig context")
+	fs.BoolVar(&o.allNamespaces, "A", false, "whether to list workloads of all namespaces")
+	fs.StringVar(&o.service, "service", "kube-system/k8s-image-availability-exporter:http", "exporter service to reach through the Kubernetes API service proxy, as namespace/name:port")
+	fs.StringVar(&o.server, "server", "", "URL of the exporter API to use directly instead of the service proxy, e.g. http://localhost:8080")
+	fs.StringVar(&o.tokenFile, "token-file", "", "file with a bearer token for the exporter API, only sent with -server since the service proxy doesn't forward it")
+}
+
+func (o *options) kubeConfig() clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: o.kubeContext})
+}
+
+// workloadNamespace returns the namespace to filter workloads by, empty for all namespaces.
+func (o *options) workloadNamespace() (string, error) {
+	if o.allNamespaces {
+		return "", nil
+	}
+	if len(o.namespace) > 0 {
+		return o.namespace, nil
+	}
+
+	namespace, _, err := o.kubeConfig().Namespace()
+	return namespace, err
+}
+
+func (o *options) client() (*apiclient.Client, error) {
+	if len(o.server) > 0 {
+		var token string
+		if len(o.tokenFile) > 0 {
+			data, err := os.ReadFile(o.tokenFile)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+
+		return apiclient.New(o.server, http.DefaultClient, token), nil
+	}
+
+	namespace, nameAndPort, ok := strings.Cut(o.service, "/")
+	if !ok || len(namespace) == 0 || len(nameAndPort) == 0 {
+		return nil, fmt.Errorf("-service must be namespace/name:port, got %q", o.service)
+	}
+
+	cfg, err := o.kubeConfig().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := rest.HTTPClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := strings.TrimSuffix(cfg.Host, "/") + fmt.Sprintf("/api/v1/namespaces/%s/services/%s/proxy", namespace, nameAndPort)
+	return apiclient.New(baseURL, httpClient, ""), nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var run func(ctx context.Context, o *options, args []string, out io.Writer) error
+	switch os.Args[1] {
+	case "unavailable":
+		run = unavailable
+	case "workload":
+		run = workload
+	case "recheck":
+		run = recheck
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	o := &options{}
+	fs := flag.NewFlagSet("kubectl image-availability "+os.Args[1], flag.ExitOnError)
+	o.register(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	if err := run(context.Background(), o, fs.Args(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func unavailable(ctx context.Context, o *options, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return errors.New("unavailable doesn't accept arguments")
+	}
+
+	namespace, err := o.workloadNamespace()
+	if err != nil {
+		return err
+	}
+	client, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	images, err := client.Images(ctx, handlers.ImagesQuery{Namespace: namespace, Unavailable: true})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tWORKLOAD\tCONTAINER\tIMAGE\tMODE")
+	for _, image := range images {
+		for _, wl := range image.Workloads {
+			fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\t%s\n", wl.Namespace, wl.Kind, wl.Name, wl.Container, image.Image, image.Mode)
+		}
+	}
+	return tw.Flush()
+}
+
+func workload(ctx context.Context, o *options, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("workload requires a single KIND/NAME argument, e.g. deployment/app")
+	}
+	kind, name, ok := strings.Cut(args[0], "/")
+	if !ok || len(kind) == 0 || len(name) == 0 {
+		return fmt.Errorf("workload must be KIND/NAME, got %q", args[0])
+	}
+
+	namespace, err := o.workloadNamespace()
+	if err != nil {
+		return err
+	}
+	client, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	images, err := client.Images(ctx, handlers.ImagesQuery{Namespace: namespace, Kind: strings.ToLower(kind), Name: name})
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("no tracked images of %s", args[0])
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tCONTAINER\tIMAGE\tMODE")
+	for _, image := range images {
+		for _, wl := range image.Workloads {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", wl.Namespace, wl.Container, image.Image, image.Mode)
+		}
+	}
+	return tw.Flush()
+}
+
+func recheck(ctx context.Context, o *options, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("recheck requires at least one IMAGE argument")
+	}
+
+	client, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tMODE\tTRACKED")
+	for _, image := range args {
+		resp, err := client.Recheck(ctx, image)
+		if err != nil {
+			_ = tw.Flush()
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\n", resp.Image, resp.Mode, resp.Tracked)
+	}
+	return tw.Flush()
+}
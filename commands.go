@@ -0,0 +1,218 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/sample-controller/pkg/signals"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/cli"
+	"github.com/flant/k8s-image-availability-exporter/pkg/logging"
+	"github.com/flant/k8s-image-availability-exporter/pkg/version"
+)
+
+// envPrefix is the prefix of environment variables every flag can be set with, e.g.
+// K8S_IMAGE_AVAILABILITY_EXPORTER_CHECK_INTERVAL for --check-interval.
+const envPrefix = "K8S_IMAGE_AVAILABILITY_EXPORTER"
+
+// inventoryCommand prints the image inventory once caches are populated and exits, without checking images.
+const inventoryCommand = "inventory"
+
+// rotationCommand checks images authenticated by a pull secret with candidate credentials once caches are populated
+// and exits with a non-zero code if any of them would become unavailable.
+const rotationCommand = "rotation"
+
+// manifestsCommand checks images of rendered manifests once caches are populated and exits with a non-zero code if
+// any of them are unavailable.
+const manifestsCommand = "manifests"
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "k8s-image-availability-exporter",
+		Short: "Exports the availability of images referenced by workloads of a Kubernetes cluster",
+		Long: "Exports the availability of images referenced by workloads of a Kubernetes cluster.\n\n" +
+			"Every flag can be set with an environment variable as well, e.g. " + cli.EnvName(envPrefix, "check-interval") + " for --check-interval. " +
+			"Flags given on the command line take precedence. Single-dash flags like -check-interval are still accepted.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if err := cli.BindEnv(cmd.Root().PersistentFlags(), envPrefix); err != nil {
+				return err
+			}
+			setupLogging()
+			return nil
+		},
+		// The exporter is run without a subcommand, as it was before subcommands were introduced.
+		Run: func(cmd *cobra.Command, _ []string) {
+			serve()
+		},
+	}
+	root.CompletionOptions.DisableDefaultCmd = true
+	root.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	_ = root.PersistentFlags().MarkDeprecated("validate-config", `use the "verify config" subcommand instead`)
+
+	generateInventory := func(*cobra.Command, []string) { runExporter(inventoryCommand) }
+	checkRotation := func(*cobra.Command, []string) { runExporter(rotationCommand) }
+	checkReplay := func(*cobra.Command, []string) { runReplay(*replayPath) }
+	verifyBundle := func(*cobra.Command, []string) {
+		runBundleVerify(*bundlePath, *bundleInventoryPath, *inventoryFormat, *defaultRegistry)
+	}
+	verifyManifests := func(*cobra.Command, []string) { runVerifyManifests() }
+	diff := func(*cobra.Command, []string) { runDiff(*diffSourcePath, *diffTargetPath, *inventoryFormat) }
+
+	generate := &cobra.Command{Use: "generate", Short: "Generate reports of the cluster", Args: cobra.NoArgs}
+	generate.AddCommand(&cobra.Command{
+		Use:   inventoryCommand,
+		Short: "Print the image inventory once caches are populated, without checking images",
+		Args:  cobra.NoArgs,
+		Run:   generateInventory,
+	})
+
+	check := &cobra.Command{Use: "check", Short: "Check images once and exit", Args: cobra.NoArgs}
+	check.AddCommand(
+		&cobra.Command{
+			Use:   rotationCommand,
+			Short: "Check images authenticated by --rotation-secret with the candidate credentials of --rotation-candidate-path",
+			Long: "Check images authenticated by --rotation-secret with the candidate credentials of --rotation-candidate-path " +
+				"once caches are populated and exit with a non-zero code if any of them would become unavailable.",
+			Args: cobra.NoArgs,
+			Run:  checkRotation,
+		},
+		&cobra.Command{
+			Use:   "replay",
+			Short: "Re-run checks recorded with --record-failed-checks-path against the recorded responses",
+			Long: "Re-run checks recorded with --record-failed-checks-path against the recorded responses, " +
+				"without connecting to a cluster or registries.",
+			Args: cobra.NoArgs,
+			Run:  checkReplay,
+		},
+	)
+
+	verify := &cobra.Command{Use: "verify", Short: "Verify the configuration, an offline bundle or manifests", Args: cobra.NoArgs}
+	verify.AddCommand(
+		&cobra.Command{
+			Use:   "config",
+			Short: "Validate flags and the config file and ping registry endpoints",
+			Long: "Validate flags and the config file and ping registry endpoints, print a report and exit with a non-zero code " +
+				"if anything is wrong, e.g. in a Helm pre-install hook.",
+			Args: cobra.NoArgs,
+			Run:  func(*cobra.Command, []string) { runVerifyConfig() },
+		},
+		&cobra.Command{
+			Use:   "bundle",
+			Short: "Verify that images of --bundle-inventory-path are present in the offline bundle at --bundle-path",
+			Long: "Verify that images of an inventory exported with \"generate inventory\" are present in an offline bundle " +
+				"and exit with a non-zero code if any of them are missing, without connecting to a cluster.",
+			Args: cobra.NoArgs,
+			Run:  verifyBundle,
+		},
+		&cobra.Command{
+			Use:   manifestsCommand,
+			Short: "Check images of the rendered manifests of --manifests-path before they are applied",
+			Long: "Check images of pods and workload controllers of the rendered manifests of --manifests-path, e.g. in CD before kubectl apply, " +
+				"and exit with a non-zero code if any of them are unavailable. Images are checked with pull secrets of the cluster, " +
+				"or with the credentials of --manifests-credentials-path without connecting to a cluster.",
+			Args: cobra.NoArgs,
+			Run:  verifyManifests,
+		},
+	)
+
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "serve",
+			Short: "Watch the cluster and export the availability of images of its workloads",
+			Args:  cobra.NoArgs,
+			Run:   func(*cobra.Command, []string) { serve() },
+		},
+		generate,
+		check,
+		verify,
+		&cobra.Command{
+			Use:   "diff",
+			Short: "Report images of --diff-source-path that are missing or unavailable in --diff-target-path",
+			Long: "Report images of the inventory of one cluster that are missing from or unavailable in the inventory of another, " +
+				"e.g. to verify that the registry mirror of a DR cluster is complete before a failover test, and exit with a non-zero code " +
+				"if there are any, without connecting to a cluster.",
+			Args: cobra.NoArgs,
+			Run:  diff,
+		},
+		&cobra.Command{
+			Use:   "agent",
+			Short: "Verify images flagged by the exporter through the container runtime of the node",
+			Long:  "Run a node agent, which verifies images flagged by the exporter through the container runtime of its node, e.g. in a DaemonSet.",
+			Args:  cobra.NoArgs,
+			Run: func(*cobra.Command, []string) {
+				runAgent(signals.SetupSignalHandler(), *criSocket, *agentExporterAddr, *agentCAFile, *agentTokenFile, *nodeName, *agentInterval, *agentPullTimeout)
+			},
+		},
+		&cobra.Command{
+			Use:   "forward",
+			Short: "Forward connections to a node-local registry",
+			Long:  "Forward connections to a node-local registry, e.g. in a DaemonSet with the host network, so that the exporter can check its images.",
+			Args:  cobra.NoArgs,
+			Run: func(*cobra.Command, []string) {
+				runForwarder(signals.SetupSignalHandler(), *forwardListenAddr, *forwardTarget)
+			},
+		},
+		&cobra.Command{
+			Use:   "aggregate",
+			Short: "Export check results reported by exporters of edge clusters",
+			Long:  "Run a central aggregator, which exports check results reported by exporters of edge clusters without watching a cluster itself.",
+			Args:  cobra.NoArgs,
+			Run: func(*cobra.Command, []string) {
+				runAggregator(signals.SetupSignalHandler(), aggregatorConfig{
+					serverConfig: serverConfig{
+						bindAddr:         *bindAddr,
+						apiAuthFile:      *apiAuthFile,
+						protectMetrics:   *protectMetrics,
+						metricsCacheTTL:  *metricsCacheTTL,
+						tlsCertFile:      *tlsCertFile,
+						tlsKeyFile:       *tlsKeyFile,
+						tlsClientCAFile:  *tlsClientCAFile,
+						httpReadTimeout:  *httpReadTimeout,
+						httpWriteTimeout: *httpWriteTimeout,
+						httpIdleTimeout:  *httpIdleTimeout,
+					},
+					grpcBindAddr: *grpcBindAddr,
+					retention:    *aggregatorRetention,
+				})
+			},
+		},
+		// Subcommands of previous versions.
+		&cobra.Command{Use: "inventory", Deprecated: `use "generate inventory" instead`, Args: cobra.NoArgs, Run: generateInventory},
+		&cobra.Command{Use: "rotation-dry-run", Deprecated: `use "check rotation" instead`, Args: cobra.NoArgs, Run: checkRotation},
+		&cobra.Command{Use: "replay", Deprecated: `use "check replay" instead`, Args: cobra.NoArgs, Run: checkReplay},
+		&cobra.Command{Use: "bundle-verify", Deprecated: `use "verify bundle" instead`, Args: cobra.NoArgs, Run: verifyBundle},
+		&cobra.Command{Use: "verify-manifests", Short: `Alias of "verify manifests"`, Args: cobra.NoArgs, Run: verifyManifests},
+	)
+
+	return root
+}
+
+// serve runs the exporter, or validates the configuration with the deprecated --validate-config flag.
+func serve() {
+	if *validateConfig {
+		runVerifyConfig()
+		return
+	}
+
+	runExporter("")
+}
+
+func setupLogging() {
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+	})
+	logrus.AddHook(logging.NewPrometheusHook())
+}
+
+// exporterUserAgent returns the User-Agent of requests to registries.
+func exporterUserAgent() string {
+	if len(*userAgentStr) > 0 {
+		return *userAgentStr
+	}
+
+	return version.UserAgent(*clusterName)
+}
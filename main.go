@@ -1,62 +1,242 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/agent"
+	"github.com/flant/k8s-image-availability-exporter/pkg/aggregator"
+	"github.com/flant/k8s-image-availability-exporter/pkg/annotations"
+	"github.com/flant/k8s-image-availability-exporter/pkg/auth"
+	"github.com/flant/k8s-image-availability-exporter/pkg/availabilityapi"
+	"github.com/flant/k8s-image-availability-exporter/pkg/bundle"
 	"github.com/flant/k8s-image-availability-exporter/pkg/cli"
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/flux"
+	"github.com/flant/k8s-image-availability-exporter/pkg/forwarder"
+	"github.com/flant/k8s-image-availability-exporter/pkg/grpcapi"
 	"github.com/flant/k8s-image-availability-exporter/pkg/handlers"
-	"github.com/flant/k8s-image-availability-exporter/pkg/logging"
+	"github.com/flant/k8s-image-availability-exporter/pkg/history"
+	"github.com/flant/k8s-image-availability-exporter/pkg/hooks"
+	"github.com/flant/k8s-image-availability-exporter/pkg/inventory"
+	"github.com/flant/k8s-image-availability-exporter/pkg/kubeclient"
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+	"github.com/flant/k8s-image-availability-exporter/pkg/policy"
+	"github.com/flant/k8s-image-availability-exporter/pkg/redisbackend"
 	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+	"github.com/flant/k8s-image-availability-exporter/pkg/reports"
+	"github.com/flant/k8s-image-availability-exporter/pkg/scm"
+	"github.com/flant/k8s-image-availability-exporter/pkg/slo"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+	"github.com/flant/k8s-image-availability-exporter/pkg/validation"
+	"github.com/flant/k8s-image-availability-exporter/pkg/version"
 
 	"github.com/google/go-containerregistry/pkg/name"
 
 	"github.com/sirupsen/logrus"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/sample-controller/pkg/signals"
 	_ "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 func main() {
-	cp := &caPaths{}
+	root := newRootCommand()
+	root.SetArgs(cli.NormalizeArgs(os.Args[1:], root.PersistentFlags()))
+	if err := root.Execute(); err != nil {
+		logrus.Fatal(err)
+	}
+}
 
-	imageCheckInterval := flag.Duration("check-interval", time.Minute, "image re-check interval")
-	ignoredImagesStr := flag.String("ignored-images", "", "tilde-separated image regexes to ignore, each image will be checked against this list of regexes")
-	bindAddr := flag.String("bind-address", ":8080", "address:port to bind /metrics endpoint to")
-	namespaceLabels := flag.String("namespace-label", "", "namespace label for checks")
-	insecureSkipVerify := flag.Bool("skip-registry-cert-verification", false, "whether to skip registries' certificate verification")
-	plainHTTP := flag.Bool("allow-plain-http", false, "whether to fallback to HTTP scheme for registries that don't support HTTPS") // named after the ctr cli flag
-	defaultRegistry := flag.String("default-registry", "", fmt.Sprintf("default registry to use in absence of a fully qualified image name, defaults to %q", name.DefaultRegistry))
-	flag.Var(cp, "capath", "path to a file that contains CA certificates in the PEM format") // named after the curl cli flag
+// runVerifyConfig validates flags and the config file, pings registry endpoints and prints a report, exiting with a
+// non-zero code if anything is wrong.
+func runVerifyConfig() {
+	userAgent := exporterUserAgent()
 
-	forceCheckDisabledControllerKindsParser := cli.NewForceCheckDisabledControllerKindsParser()
-	flag.Func("force-check-disabled-controllers", `comma-separated list of controller kinds for which image is forcibly checked, even when workloads are disabled or suspended. Acceptable values include "Deployment", "StatefulSet", "DaemonSet", "Cronjob" or "*" for all kinds (this option is case-insensitive)`, forceCheckDisabledControllerKindsParser.Parse)
+	report := &validation.Report{}
 
-	flag.Parse()
+	_, err := compileIgnoredImages(*ignoredImagesStr)
+	report.Check("-ignored-images", err)
 
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
-	logrus.AddHook(logging.NewPrometheusHook())
+	_, err = store.ParseMetricStyle(*metricStyleStr)
+	report.Check("-metric-style", err)
+
+	report.Check("-gc-interval", validatePositive(*gcInterval))
+	report.Check("-http-read-timeout", validateNonNegative(*httpReadTimeout))
+	report.Check("-http-write-timeout", validateNonNegative(*httpWriteTimeout))
+	report.Check("-http-idle-timeout", validateNonNegative(*httpIdleTimeout))
+	report.Check("-kube-api-qps", validateKubeAPIRateLimit(*kubeAPIQPS, *kubeAPIBurst))
+	report.Check("-simulate-failures", registry.ValidateFailurePercentage(*simulateFailures))
+	if *sloObjective != 0 {
+		report.Check("-slo-objective", slo.ValidateObjective(*sloObjective))
+		report.Check("-slo-window", validatePositive(*sloWindow))
+	}
+	report.Check("-store-backend", validateStoreBackend(*storeBackendKind, *redisURL, *redisSyncInterval))
+
+	_, err = registry.ParseCheckPlatform(*checkPlatformStr)
+	report.Check("-check-platform", err)
+
+	_, err = registry.ParseOldRegistryMode(*oldRegistryModeStr)
+	report.Check("-old-registry-mode", err)
+
+	_, err = parseCheckWindows(*checkWindowsStr, *checkWindowsTimezone)
+	report.Check("-check-windows", err)
+
+	exporterConfig := &config.Config{}
+	if len(*configPath) > 0 {
+		if loaded, err := config.Load(*configPath); err != nil {
+			report.Check("-config", err)
+		} else {
+			exporterConfig = loaded
+			report.Check("-config", nil)
+		}
+	}
+
+	report.Check("-namespaces", validateNamespaces(splitNonEmpty(*namespacesStr, ","), *namespaceLabels, *exportSeverity, *namespaceReportInterval, *workloadAnnotationInterval, *canaryInterval, *fluxPrecheckInterval))
+	report.Check("-semver-or-digest-namespaces", validatePatterns(splitNonEmpty(*semverOrDigestNamespaces, ",")))
+	report.Check("-digest-namespaces", validatePatterns(splitNonEmpty(*digestNamespaces, ",")))
+	if len(*regoPolicyPath) > 0 {
+		_, err = policy.LoadRego(*regoPolicyPath)
+		report.Check("-rego-policy-path", err)
+	}
+
+	if len(*apiAuthFile) > 0 {
+		_, err = auth.Load(*apiAuthFile)
+		report.Check("-api-auth-file", err)
+	} else if *protectMetrics {
+		report.Check("-protect-metrics", errors.New("requires -api-auth-file"))
+	}
+
+	if len(*tlsCertFile) > 0 {
+		_, err = auth.NewServerTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile)
+		report.Check("-tls-cert-file", err)
+	} else if len(*tlsClientCAFile) > 0 {
+		report.Check("-tls-client-ca-file", errors.New("requires -tls-cert-file"))
+	}
+	if *availabilityAPI && len(*tlsCertFile) == 0 {
+		report.Check("-availability-api", errors.New("requires -tls-cert-file"))
+	}
+
+	_, err = net.ResolveTCPAddr("tcp", *bindAddr)
+	report.Check("-bind-address", err)
+	if len(*grpcBindAddr) > 0 {
+		_, err = net.ResolveTCPAddr("tcp", *grpcBindAddr)
+		report.Check("-grpc-bind-address", err)
+	}
+
+	if len(*aggregatorAddr) > 0 {
+		report.Check("-aggregator-address", validateAggregatorReporting(*clusterName, *aggregatorInterval))
+	}
+
+	if len(*onChangeExec) > 0 {
+		_, err = exec.LookPath(*onChangeExec)
+		report.Check("-on-change-exec", err)
+	}
+	if len(*alertmanagerWebhookURL) > 0 {
+		report.Check("-alertmanager-webhook-url", validateHTTPURL(*alertmanagerWebhookURL))
+	}
 
+	registry.ValidateConfig(
+		report,
+		*insecureSkipVerify,
+		*strictTLS,
+		*plainHTTP,
+		*cp,
+		*defaultRegistry,
+		registry.WorkloadIdentityConfig{
+			Provider:       *wiProvider,
+			TokenPath:      *wiTokenPath,
+			Audience:       *wiAudience,
+			ServiceAccount: *wiServiceAccount,
+			ClientID:       *wiClientID,
+			TenantID:       *wiTenantID,
+			TokenURL:       *wiTokenURL,
+			Registries:     splitNonEmpty(*wiRegistries, ","),
+		},
+		*azureConfigPath,
+		splitNonEmpty(*registryEndpoints, ","),
+		exporterConfig,
+		userAgent,
+		*registrySOCKSProxy,
+	)
+
+	if err := report.Write(os.Stdout); err != nil {
+		logrus.Fatal(err)
+	}
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+// runExporter watches the cluster and checks images of its workloads. The "generate inventory" and "check rotation"
+// subcommands only populate caches, print their report and exit.
+func runExporter(subcommand string) {
 	// set up signals, so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
+	userAgent := exporterUserAgent()
 
 	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
 	if err != nil {
 		logrus.Fatalf("Couldn't get Kubernetes default config: %s", err)
 	}
 
+	if err := registry.ValidateFailurePercentage(*simulateFailures); err != nil {
+		logrus.Fatalf("Invalid -simulate-failures: %v", err)
+	}
+
+	if *sloObjective != 0 {
+		if err := slo.ValidateObjective(*sloObjective); err != nil {
+			logrus.Fatalf("Invalid -slo-objective: %v", err)
+		}
+		if err := validatePositive(*sloWindow); err != nil {
+			logrus.Fatalf("Invalid -slo-window: %v", err)
+		}
+	}
+
+	if err := validateKubeAPIRateLimit(*kubeAPIQPS, *kubeAPIBurst); err != nil {
+		logrus.Fatalf("Invalid -kube-api-qps: %v", err)
+	}
+	cfg.QPS, cfg.Burst = float32(*kubeAPIQPS), *kubeAPIBurst
+	cfg.RateLimiter = kubeclient.NewRateLimiter(cfg.QPS, cfg.Burst)
+	kubeclient.UseRefreshingToken(cfg)
+	if *kubeAPIProtobuf {
+		kubeclient.UseProtobuf(cfg)
+	}
+	namespaces := splitNonEmpty(*namespacesStr, ",")
+	if err := validateNamespaces(namespaces, *namespaceLabels, *exportSeverity, *namespaceReportInterval, *workloadAnnotationInterval, *canaryInterval, *fluxPrecheckInterval); err != nil {
+		logrus.Fatalf("Invalid -namespaces: %v", err)
+	}
+	// Objects of several namespaces can't be streamed by a single watch.
+	if *kubeAPIWatchList && len(namespaces) == 0 {
+		kubeclient.EnableWatchList()
+	}
+	kubeclient.ObserveNegotiation(cfg)
+
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		logrus.Fatalf("Error building kubernetes clientset: %s", err.Error())
@@ -70,40 +250,358 @@ func main() {
 		},
 	)
 	prometheus.MustRegister(liveTicksCounter)
+	prometheus.MustRegister(version.NewBuildInfoCollector())
+
+	regexes, err := compileIgnoredImages(*ignoredImagesStr)
+	if err != nil {
+		logrus.Fatalf("Failed to parse ignored images: %v", err)
+	}
+
+	metricStyle, err := store.ParseMetricStyle(*metricStyleStr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := validatePositive(*gcInterval); err != nil {
+		logrus.Fatalf("Invalid -gc-interval: %v", err)
+	}
+	if err := validateHTTPTimeouts(*httpReadTimeout, *httpWriteTimeout, *httpIdleTimeout); err != nil {
+		logrus.Fatal(err)
+	}
+	if *startupJitter < 0 {
+		logrus.Fatal("-startup-jitter must not be negative")
+	}
+	if *checkJitter < 0 {
+		logrus.Fatal("-check-jitter must not be negative")
+	}
+
+	checkPlatform, err := registry.ParseCheckPlatform(*checkPlatformStr)
+	if err != nil {
+		logrus.Fatalf("Invalid -check-platform: %v", err)
+	}
+
+	oldRegistryMode, err := registry.ParseOldRegistryMode(*oldRegistryModeStr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	checkWindows, err := parseCheckWindows(*checkWindowsStr, *checkWindowsTimezone)
+	if err != nil {
+		logrus.Fatalf("Invalid -check-windows: %v", err)
+	}
+
+	storeBackend, err := newStoreBackend(*storeBackendKind, *redisURL, *redisKeyPrefix, *redisReplicaID, *redisTTL, *redisSyncInterval)
+	if err != nil {
+		logrus.Fatalf("Invalid -store-backend: %v", err)
+	}
+	// Results batched by the backend are written and the replica's share of images is handed over on shutdown.
+	if closer, ok := storeBackend.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	canaryConfig := registry.CanaryConfig{Images: *canaryImages, Timeout: *canaryTimeout}
+	// Subcommands exit right away and don't pull images.
+	if len(subcommand) == 0 {
+		canaryConfig.Interval = *canaryInterval
+	}
+	canaryConfig.NodeSelector, err = labels.ConvertSelectorToLabelsMap(*canaryNodeSelector)
+	if err != nil {
+		logrus.Fatalf("Invalid -canary-node-selector: %v", err)
+	}
 
-	var regexes []regexp.Regexp
-	if *ignoredImagesStr != "" {
-		regexStrings := strings.Split(*ignoredImagesStr, "~")
-		for _, regexStr := range regexStrings {
-			regexes = append(regexes, *regexp.MustCompile(regexStr))
+	var regoPolicy *policy.RegoPolicy
+	if len(*regoPolicyPath) > 0 {
+		regoPolicy, err = policy.LoadRego(*regoPolicyPath)
+		if err != nil {
+			logrus.Fatalf("Failed to load Rego policies: %v", err)
 		}
 	}
 
-	registryChecker := registry.NewChecker(
-		stopCh.Done(),
-		kubeClient,
-		*insecureSkipVerify,
-		*plainHTTP,
-		*cp,
-		forceCheckDisabledControllerKindsParser.ParsedKinds,
-		regexes,
-		*defaultRegistry,
-		*namespaceLabels,
-	)
-	prometheus.MustRegister(registryChecker)
+	exporterConfig := &config.Config{}
+	if len(*configPath) > 0 {
+		exporterConfig, err = config.Load(*configPath)
+		if err != nil {
+			logrus.Fatalf("Failed to load config: %v", err)
+		}
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/healthz", handlers.Healthz)
-	go func() {
-		logrus.Fatal(http.ListenAndServe(*bindAddr, nil))
-	}()
+	registryChecker := registry.NewChecker(stopCh.Done(), kubeClient, registry.CheckerConfig{
+		SkipVerify:                        *insecureSkipVerify,
+		StrictTLS:                         *strictTLS,
+		PlainHTTP:                         *plainHTTP,
+		CAPaths:                           *cp,
+		ForceCheckDisabledControllerKinds: forceCheckDisabledControllerKindsParser.ParsedKinds,
+		IgnoredImages:                     regexes,
+		DefaultRegistry:                   *defaultRegistry,
+		NamespaceLabel:                    *namespaceLabels,
+		WorkloadIdentity: registry.WorkloadIdentityConfig{
+			Provider:       *wiProvider,
+			TokenPath:      *wiTokenPath,
+			Audience:       *wiAudience,
+			ServiceAccount: *wiServiceAccount,
+			ClientID:       *wiClientID,
+			TenantID:       *wiTenantID,
+			TokenURL:       *wiTokenURL,
+			Registries:     splitNonEmpty(*wiRegistries, ","),
+		},
+		AzureConfigPath:                  *azureConfigPath,
+		GCPApplicationDefaultCredentials: *gcpADC,
+		Quay: registry.QuayConfig{
+			Registry:      *quayRegistry,
+			APITokenPath:  *quayAPITokenPath,
+			WarningWindow: *quayExpiryWarningWindow,
+		},
+		TargetPassDuration: *targetPassDuration,
+		RetryPolicy: store.RetryPolicy{
+			BaseDelay:   *failedCheckInterval,
+			MaxDelay:    *failedCheckMaxBackoff,
+			DailyBudget: *failedCheckDailyBudget,
+		},
+		RegistryEndpoints:     splitNonEmpty(*registryEndpoints, ","),
+		RegistryPingInterval:  *registryPingInterval,
+		MaxImagesPerNamespace: *maxImagesPerNamespace,
+		Policy: policy.Config{
+			ApprovedRegistries:       splitNonEmpty(*approvedRegistries, ","),
+			DeniedTags:               splitNonEmpty(*deniedTags, ","),
+			SemverOrDigestNamespaces: splitNonEmpty(*semverOrDigestNamespaces, ","),
+			DigestNamespaces:         splitNonEmpty(*digestNamespaces, ","),
+			Rego:                     regoPolicy,
+		},
+		ManifestCacheTTL:    *manifestCacheTTL,
+		ExportSeverity:      *exportSeverity,
+		CachedImageSeverity: *cachedImageSeverity,
+		TrackOwnedObjects:   *trackOwnedObjects,
+		WorkloadLabels:      splitNonEmpty(*workloadLabels, ","),
+		MetricStyle:         metricStyle,
+		CircuitBreaker: registry.CircuitBreakerConfig{
+			Threshold: *circuitBreakerThreshold,
+			Cooldown:  *circuitBreakerCooldown,
+		},
+		PassTimeBudget:           *passTimeBudget,
+		RegistryPassTimeBudget:   *registryPassTimeBudget,
+		ExporterConfig:           exporterConfig,
+		PriorityClasses:          splitNonEmpty(*priorityClasses, ","),
+		PrioritizePDBWorkloads:   *prioritizePDBWorkloads,
+		GCInterval:               *gcInterval,
+		KeepOrphansFor:           *keepOrphansFor,
+		CheckPlatform:            checkPlatform,
+		CheckEphemeralContainers: *checkEphemeralContainers,
+		FloatingTags:             splitNonEmpty(*floatingTags, ","),
+		OldRegistryMode:          oldRegistryMode,
+		ResyncPeriod:             *informerResyncPeriod,
+		StoreBackend:             storeBackend,
+		StoreBackendSyncInterval: *redisSyncInterval,
+		UserAgent:                userAgent,
+		Canary:                   canaryConfig,
+		DeepCheck:                *deepCheck,
+		SOCKSProxy:               *registrySOCKSProxy,
+		CheckResultCacheTTL:      *checkResultCacheTTL,
+		Namespaces:               namespaces,
+		Secretless:               *secretless,
+		CredentialLockout: registry.CredentialLockoutConfig{
+			Threshold: *credentialLockoutThreshold,
+			Cooldown:  *credentialLockoutCooldown,
+		},
+		Chaos: registry.ChaosConfig{
+			FakeRegistry:      *fakeRegistry,
+			FailurePercentage: *simulateFailures,
+		},
+		RecordFailedChecks: registry.RecordConfig{
+			Path:    *recordFailedChecksPath,
+			MaxSize: *recordFailedChecksMaxSize,
+		},
+		PodImages:           *podImages,
+		DNSFallbackResolver: *dnsFallbackResolver,
+		CheckWindows:        checkWindows,
+		RetagGracePeriod:    *retagGracePeriod,
+	})
+
+	if subcommand == inventoryCommand {
+		if err := registryChecker.Inventory().Write(os.Stdout, *inventoryFormat); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if subcommand == manifestsCommand {
+		verifyManifests(registryChecker.Simulate)
+		return
+	}
+
+	if subcommand == rotationCommand {
+		candidate, err := os.ReadFile(*rotationCandidatePath)
+		if err != nil {
+			logrus.Fatalf("Failed to read the candidate credentials: %v", err)
+		}
+
+		report, err := registryChecker.RotationDryRun(*rotationSecret, candidate)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := report.Write(os.Stdout); err != nil {
+			logrus.Fatal(err)
+		}
+		if regressions := report.Regressions(); regressions > 0 {
+			logrus.Fatalf("%d images would become unavailable with the candidate credentials", regressions)
+		}
+		return
+	}
+
+	if err := registryChecker.Register(prometheus.DefaultRegisterer); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if len(*onChangeExec) > 0 {
+		execHook := hooks.NewExecHook(*onChangeExec, *onChangeExecTimeout)
+		execHook.Run(stopCh.Done())
+		registryChecker.AddTransitionHandler(execHook.Handle)
+	}
+
+	if len(*alertmanagerWebhookURL) > 0 {
+		webhook := hooks.NewAlertmanagerWebhook(*alertmanagerWebhookURL, *alertmanagerWebhookTimeout)
+		webhook.Run(stopCh.Done())
+		registryChecker.AddTransitionHandler(webhook.Handle)
+	}
+
+	var availabilityHistory *history.History
+	if *historyRetention > 0 {
+		availabilityHistory, err = history.New(*historyRetention, *historyPath)
+		if err != nil {
+			logrus.Fatalf("Failed to load the availability history: %v", err)
+		}
+		availabilityHistory.Run(stopCh.Done())
+		registryChecker.AddTransitionHandler(availabilityHistory.Record)
+	}
+
+	if *sloObjective != 0 {
+		tracker := slo.New(*sloObjective, *sloWindow, registryChecker.RangeContainers)
+		tracker.Run(stopCh.Done())
+		prometheus.MustRegister(tracker)
+	}
+
+	if *namespaceReportInterval > 0 {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			logrus.Fatalf("Error building dynamic client: %v", err)
+		}
+		reports.New(dynamicClient, registryChecker.RangeContainers).Run(*namespaceReportInterval, stopCh.Done())
+	}
+
+	if *workloadAnnotationInterval > 0 {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			logrus.Fatalf("Error building dynamic client: %v", err)
+		}
+		annotator := annotations.New(dynamicClient, registryChecker.RangeContainers)
+		if *workloadConditions {
+			annotator.UseConditions()
+		}
+		annotator.Run(*workloadAnnotationInterval, stopCh.Done())
+	}
+
+	if *fluxPrecheckInterval > 0 {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			logrus.Fatalf("Error building dynamic client: %v", err)
+		}
+		prechecker := flux.New(dynamicClient, registryChecker.Simulate)
+		prechecker.Run(*fluxPrecheckInterval, stopCh.Done())
+		prometheus.MustRegister(prechecker)
+	}
+
+	if len(*aggregatorAddr) > 0 {
+		if err := validateAggregatorReporting(*clusterName, *aggregatorInterval); err != nil {
+			logrus.Fatalf("Invalid -aggregator-address: %v", err)
+		}
+		aggregatorConn, err := agent.DialExporter(*aggregatorAddr, *aggregatorCAFile, *aggregatorTokenFile)
+		if err != nil {
+			logrus.Fatalf("Failed to connect to the aggregator: %v", err)
+		}
+		defer aggregatorConn.Close()
+		go aggregator.New(availabilityv1.NewAggregatorServiceClient(aggregatorConn), *clusterName, registryChecker.RangeContainers).Run(stopCh, *aggregatorInterval)
+	}
+
+	servers := newAPIServers(serverConfig{
+		bindAddr:         *bindAddr,
+		apiAuthFile:      *apiAuthFile,
+		protectMetrics:   *protectMetrics,
+		metricsCacheTTL:  *metricsCacheTTL,
+		tlsCertFile:      *tlsCertFile,
+		tlsKeyFile:       *tlsKeyFile,
+		tlsClientCAFile:  *tlsClientCAFile,
+		httpReadTimeout:  *httpReadTimeout,
+		httpWriteTimeout: *httpWriteTimeout,
+		httpIdleTimeout:  *httpIdleTimeout,
+	})
+	authenticator := servers.authenticator
+	// The API server only connects to aggregated APIs over HTTPS, and /apis mustn't be readable over plain HTTP.
+	if *availabilityAPI && servers.tlsConfig == nil {
+		logrus.Fatal("-availability-api requires -tls-cert-file")
+	}
+
+	if len(*grpcBindAddr) > 0 {
+		listener, err := net.Listen("tcp", *grpcBindAddr)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		grpcServer := servers.newGRPCServer()
+		apiServer := grpcapi.NewServer(registryChecker.CheckImage)
+		apiServer.Register(grpcServer)
+		registryChecker.AddTransitionHandler(apiServer.HandleTransition)
+		agentServer := grpcapi.NewNodeAgentServer(registryChecker.FlaggedImages, *agentMaxImages)
+		agentServer.Register(grpcServer)
+		prometheus.MustRegister(agentServer)
+
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				logrus.Fatal(err)
+			}
+		}()
+		go func() {
+			<-stopCh.Done()
+			grpcServer.Stop()
+		}()
+	}
+
+	// OpenMetrics is negotiated to expose exemplars.
+	servers.handleMetrics(promhttp.HandlerOpts{EnableOpenMetrics: true})
+	http.Handle("/api/v1/inventory", authenticator.Middleware(auth.ScopeRead, inventory.Handler(registryChecker.Inventory)))
+	if availabilityHistory != nil {
+		http.Handle("/api/v1/history", authenticator.Middleware(auth.ScopeRead, availabilityHistory.Handler()))
+	}
+	http.Handle(handlers.ImagesAPIPath, authenticator.Middleware(auth.ScopeRead, handlers.Images(registryChecker.RangeContainers)))
+	http.Handle(handlers.ImagesAPIPrefix, authenticator.Middleware(auth.ScopeRecheck, handlers.Recheck(registryChecker.CheckImage)))
+	http.Handle(handlers.SimulateAPIPath, authenticator.Middleware(auth.ScopeRecheck, handlers.Simulate(registryChecker.Simulate)))
+	if *availabilityAPI {
+		availabilityHandler := authenticator.Middleware(auth.ScopeRead, availabilityapi.Handler(registryChecker.RangeContainers))
+		http.Handle(availabilityapi.Path, availabilityHandler)
+		http.Handle(availabilityapi.Path+"/", availabilityHandler)
+	}
+	servers.serveHTTP()
 
 	handlers.UpdateHealth(true)
 
-	wait.Until(func() {
+	// Replicas get different phases of their check cycles, which then stay apart since check intervals are measured from
+	// the end of a pass.
+	if *startupJitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(*startupJitter)))
+		logrus.Infof("Delaying the first check pass by %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-stopCh.Done():
+			return
+		}
+	}
+
+	if *failedCheckInterval > 0 {
+		go wait.JitterUntil(registryChecker.TickFailed, *failedCheckInterval, *checkJitter, true, stopCh.Done())
+	}
+
+	wait.JitterUntil(func() {
 		registryChecker.Tick()
 		liveTicksCounter.Inc()
-	}, *imageCheckInterval, stopCh.Done())
+	}, *imageCheckInterval, *checkJitter, true, stopCh.Done())
 }
 
 type caPaths []string
@@ -116,3 +614,608 @@ func (c *caPaths) Set(value string) error {
 	*c = append(*c, value)
 	return nil
 }
+
+func envOrDefault(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return defaultValue
+}
+
+func compileIgnoredImages(s string) (ret []regexp.Regexp, err error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	for _, regexStr := range strings.Split(s, "~") {
+		regex, err := regexp.Compile(regexStr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *regex)
+	}
+
+	return ret, nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// parseCheckWindows parses -check-windows in -check-windows-timezone, it returns nil if there are no windows.
+func parseCheckWindows(windows, timezone string) (*store.CheckWindows, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -check-windows-timezone: %w", err)
+	}
+
+	return store.ParseCheckWindows(windows, location)
+}
+
+func validatePositive(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("must be positive, got %s", d)
+	}
+
+	return nil
+}
+
+func validateNonNegative(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("must not be negative, got %s", d)
+	}
+
+	return nil
+}
+
+// validateHTTPTimeouts validates timeouts of HTTP servers, 0 disables a timeout.
+func validateHTTPTimeouts(read, write, idle time.Duration) error {
+	for _, timeout := range []struct {
+		flag  string
+		value time.Duration
+	}{{"-http-read-timeout", read}, {"-http-write-timeout", write}, {"-http-idle-timeout", idle}} {
+		if err := validateNonNegative(timeout.value); err != nil {
+			return fmt.Errorf("invalid %s: %w", timeout.flag, err)
+		}
+	}
+
+	return nil
+}
+
+func validateKubeAPIRateLimit(qps float64, burst int) error {
+	if qps <= 0 {
+		return fmt.Errorf("must be positive, got %v", qps)
+	}
+	if burst < 1 {
+		return fmt.Errorf("-kube-api-burst must be at least 1, got %d", burst)
+	}
+
+	return nil
+}
+
+// validateNamespaces checks that no feature needing cluster-wide permissions is enabled along with -namespaces.
+func validateNamespaces(namespaces []string, namespaceLabel string, exportSeverity bool, namespaceReportInterval, workloadAnnotationInterval, canaryInterval, fluxPrecheckInterval time.Duration) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	switch {
+	case len(namespaceLabel) > 0:
+		return errors.New("incompatible with -namespace-label")
+	case exportSeverity:
+		return errors.New("incompatible with -export-severity")
+	case namespaceReportInterval > 0:
+		return errors.New("incompatible with -namespace-report-interval")
+	case workloadAnnotationInterval > 0:
+		return errors.New("incompatible with -workload-annotation-interval")
+	case canaryInterval > 0:
+		return errors.New("incompatible with -canary-interval")
+	case fluxPrecheckInterval > 0:
+		return errors.New("incompatible with -flux-precheck-interval")
+	}
+
+	return nil
+}
+
+const (
+	storeBackendMemory = "memory"
+	storeBackendRedis  = "redis"
+)
+
+func validateStoreBackend(kind, redisURL string, syncInterval time.Duration) error {
+	switch kind {
+	case storeBackendMemory:
+		return nil
+	case storeBackendRedis:
+		if len(redisURL) == 0 {
+			return errors.New("-redis-url is required for the redis backend")
+		}
+		if syncInterval <= 0 {
+			return errors.New("-redis-sync-interval must be positive for the redis backend")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown store backend %q, must be %q or %q", kind, storeBackendMemory, storeBackendRedis)
+}
+
+// newStoreBackend returns nil for the memory backend, which is built into the image store.
+func newStoreBackend(kind, redisURL, keyPrefix, replicaID string, ttl, syncInterval time.Duration) (store.Backend, error) {
+	if err := validateStoreBackend(kind, redisURL, syncInterval); err != nil {
+		return nil, err
+	}
+	if kind == storeBackendMemory {
+		return nil, nil
+	}
+
+	if len(replicaID) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine the replica ID: %w", err)
+		}
+		replicaID = hostname
+	}
+
+	return redisbackend.New(redisURL, keyPrefix, replicaID, ttl)
+}
+
+func runAgent(ctx context.Context, criSocket, exporterAddr, caFile, tokenFile, node string, interval, pullTimeout time.Duration) {
+	if len(exporterAddr) == 0 {
+		logrus.Fatal("-agent-exporter-address is required for the agent")
+	}
+	if len(node) == 0 {
+		logrus.Fatal("-node-name is required for the agent")
+	}
+	if err := validatePositive(interval); err != nil {
+		logrus.Fatalf("Invalid -agent-interval: %v", err)
+	}
+
+	runtimeConn, err := agent.DialRuntime(criSocket)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to the container runtime: %v", err)
+	}
+	defer runtimeConn.Close()
+
+	exporterConn, err := agent.DialExporter(exporterAddr, caFile, tokenFile)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to the exporter: %v", err)
+	}
+	defer exporterConn.Close()
+
+	logrus.WithField("node", node).Info("Starting the node agent")
+	agent.New(
+		criv1.NewImageServiceClient(runtimeConn),
+		availabilityv1.NewNodeAgentServiceClient(exporterConn),
+		node,
+		pullTimeout,
+	).Run(ctx, interval)
+}
+
+// validateAggregatorReporting checks the configuration of reporting check results to an aggregator.
+func validateAggregatorReporting(clusterName string, interval time.Duration) error {
+	if len(clusterName) == 0 {
+		return errors.New("requires -cluster-name to tell clusters apart")
+	}
+	if err := validatePositive(interval); err != nil {
+		return fmt.Errorf("-aggregator-interval %w", err)
+	}
+
+	return nil
+}
+
+// newMetricsHandler returns the /metrics handler, which serves responses rendered within the TTL from a cache if it's
+// positive.
+func newMetricsHandler(cacheTTL time.Duration, opts promhttp.HandlerOpts) http.Handler {
+	var handler http.Handler = promhttp.HandlerFor(prometheus.DefaultGatherer, opts)
+	if cacheTTL > 0 {
+		cache := handlers.NewMetricsCache(handler, cacheTTL)
+		prometheus.MustRegister(cache)
+		handler = cache
+	}
+
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+}
+
+// serverConfig configures the HTTP and gRPC listeners of both the exporter and the aggregator.
+type serverConfig struct {
+	bindAddr         string
+	apiAuthFile      string
+	protectMetrics   bool
+	metricsCacheTTL  time.Duration
+	tlsCertFile      string
+	tlsKeyFile       string
+	tlsClientCAFile  string
+	httpReadTimeout  time.Duration
+	httpWriteTimeout time.Duration
+	httpIdleTimeout  time.Duration
+}
+
+// apiServers holds API credentials and the TLS config shared by the HTTP and gRPC listeners.
+type apiServers struct {
+	config        serverConfig
+	authenticator *auth.Authenticator
+	tlsConfig     *tls.Config
+}
+
+// newAPIServers loads API credentials and the TLS config, exiting if they are misconfigured.
+func newAPIServers(config serverConfig) *apiServers {
+	s := &apiServers{config: config}
+
+	var err error
+	if len(config.apiAuthFile) > 0 {
+		s.authenticator, err = auth.Load(config.apiAuthFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load API credentials: %v", err)
+		}
+	} else if config.protectMetrics {
+		logrus.Fatal("-protect-metrics requires -api-auth-file")
+	}
+
+	if len(config.tlsCertFile) > 0 {
+		s.tlsConfig, err = auth.NewServerTLSConfig(config.tlsCertFile, config.tlsKeyFile, config.tlsClientCAFile)
+		if err != nil {
+			logrus.Fatalf("Failed to configure TLS: %v", err)
+		}
+	} else if len(config.tlsClientCAFile) > 0 {
+		logrus.Fatal("-tls-client-ca-file requires -tls-cert-file")
+	}
+
+	return s
+}
+
+// newGRPCServer returns a gRPC server requiring the scope of the gRPC API if API authentication is enabled.
+func (s *apiServers) newGRPCServer() *grpc.Server {
+	var grpcOptions []grpc.ServerOption
+	if s.tlsConfig != nil {
+		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+	if s.authenticator != nil {
+		grpcOptions = append(grpcOptions,
+			grpc.UnaryInterceptor(s.authenticator.UnaryInterceptor(grpcapi.RequiredScope)),
+			grpc.StreamInterceptor(s.authenticator.StreamInterceptor(grpcapi.RequiredScope)),
+		)
+	}
+
+	return grpc.NewServer(grpcOptions...)
+}
+
+// handleMetrics registers the /metrics and /healthz handlers.
+func (s *apiServers) handleMetrics(opts promhttp.HandlerOpts) {
+	metricsHandler := newMetricsHandler(s.config.metricsCacheTTL, opts)
+	if s.config.protectMetrics {
+		metricsHandler = s.authenticator.Middleware(auth.ScopeRead, metricsHandler)
+	}
+	http.Handle("/metrics", metricsHandler)
+	http.HandleFunc("/healthz", handlers.Healthz)
+}
+
+// serveHTTP serves the registered handlers on the bind address in the background.
+func (s *apiServers) serveHTTP() {
+	go func() {
+		server := &http.Server{
+			Addr:              s.config.bindAddr,
+			TLSConfig:         s.tlsConfig,
+			ReadHeaderTimeout: s.config.httpReadTimeout,
+			ReadTimeout:       s.config.httpReadTimeout,
+			WriteTimeout:      s.config.httpWriteTimeout,
+			IdleTimeout:       s.config.httpIdleTimeout,
+		}
+		if s.tlsConfig != nil {
+			logrus.Fatal(server.ListenAndServeTLS("", ""))
+		}
+		logrus.Fatal(server.ListenAndServe())
+	}()
+}
+
+type aggregatorConfig struct {
+	serverConfig
+	grpcBindAddr string
+	retention    time.Duration
+}
+
+// runAggregator serves the AggregatorService and exports merged metrics of reporting clusters. The HTTP API of
+// the exporter, e.g. /api/v1/images, isn't served, since the aggregator doesn't track images of its own.
+func runAggregator(ctx context.Context, config aggregatorConfig) {
+	if len(config.grpcBindAddr) == 0 {
+		logrus.Fatal("-grpc-bind-address is required for the aggregator")
+	}
+	if err := validatePositive(config.retention); err != nil {
+		logrus.Fatalf("Invalid -aggregator-retention: %v", err)
+	}
+	if err := validateHTTPTimeouts(config.httpReadTimeout, config.httpWriteTimeout, config.httpIdleTimeout); err != nil {
+		logrus.Fatal(err)
+	}
+
+	servers := newAPIServers(config.serverConfig)
+
+	listener, err := net.Listen("tcp", config.grpcBindAddr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	grpcServer := servers.newGRPCServer()
+	aggregatorServer := grpcapi.NewAggregatorServer(config.retention)
+	aggregatorServer.Register(grpcServer)
+	prometheus.MustRegister(aggregatorServer)
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logrus.Fatal(err)
+		}
+	}()
+
+	servers.handleMetrics(promhttp.HandlerOpts{})
+	servers.serveHTTP()
+
+	handlers.UpdateHealth(true)
+
+	logrus.WithField("address", config.grpcBindAddr).Info("Starting the aggregator")
+	<-ctx.Done()
+	grpcServer.GracefulStop()
+}
+
+func runReplay(path string) {
+	if len(path) == 0 {
+		logrus.Fatal("-replay-path is required for replaying checks")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		logrus.Fatalf("Failed to read the recorded checks: %v", err)
+	}
+	defer f.Close()
+
+	report, err := registry.Replay(f)
+	if err != nil {
+		logrus.Fatalf("Failed to replay the recorded checks: %v", err)
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("Replayed %d checks, %d of them are classified differently now", len(report.Checks), report.Changed())
+}
+
+func runBundleVerify(bundlePath, inventoryPath, inventoryFormat, defaultRegistry string) {
+	if len(bundlePath) == 0 || len(inventoryPath) == 0 {
+		logrus.Fatal("-bundle-path and -bundle-inventory-path are required for bundle verification")
+	}
+
+	var opts []name.Option
+	if len(defaultRegistry) > 0 {
+		opts = append(opts, name.WithDefaultRegistry(defaultRegistry))
+	}
+
+	images, err := readInventory(inventoryPath, inventoryFormat)
+	if err != nil {
+		logrus.Fatalf("Failed to read the inventory: %v", err)
+	}
+
+	b, err := bundle.Load(bundlePath, opts...)
+	if err != nil {
+		logrus.Fatalf("Failed to read the bundle: %v", err)
+	}
+
+	report := b.Verify(images, opts...)
+	if err := report.Write(os.Stdout); err != nil {
+		logrus.Fatal(err)
+	}
+	if missing := len(report.Missing); missing > 0 {
+		logrus.Fatalf("%d images are missing from the bundle", missing)
+	}
+}
+
+// runDiff reports images of the source inventory that are missing or unavailable in the target one.
+func runDiff(sourcePath, targetPath, inventoryFormat string) {
+	if len(sourcePath) == 0 || len(targetPath) == 0 {
+		logrus.Fatal("-diff-source-path and -diff-target-path are required for the diff")
+	}
+
+	source, err := readInventory(sourcePath, inventoryFormat)
+	if err != nil {
+		logrus.Fatalf("Failed to read the source inventory: %v", err)
+	}
+	target, err := readInventory(targetPath, inventoryFormat)
+	if err != nil {
+		logrus.Fatalf("Failed to read the target inventory: %v", err)
+	}
+
+	diff := inventory.Compare(source, target)
+	if err := diff.Write(os.Stdout); err != nil {
+		logrus.Fatal(err)
+	}
+	if !diff.Empty() {
+		logrus.Fatalf("%d images are missing from the target cluster, %d are unavailable in it", len(diff.Missing), len(diff.Unavailable))
+	}
+}
+
+func readInventory(path, format string) (inventory.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return inventory.Report{}, err
+	}
+	defer f.Close()
+
+	return inventory.Read(f, format)
+}
+
+// runVerifyManifests checks images of manifests with the credentials of --manifests-credentials-path, or with pull
+// secrets of the cluster once caches are populated if it's empty.
+func runVerifyManifests() {
+	if len(*manifestsCredentialsPath) == 0 {
+		runExporter(manifestsCommand)
+		return
+	}
+
+	credentials, err := os.ReadFile(*manifestsCredentialsPath)
+	if err != nil {
+		logrus.Fatalf("Failed to read the credentials: %v", err)
+	}
+
+	checkPlatform, err := registry.ParseCheckPlatform(*checkPlatformStr)
+	if err != nil {
+		logrus.Fatalf("Invalid -check-platform: %v", err)
+	}
+
+	oldRegistryMode, err := registry.ParseOldRegistryMode(*oldRegistryModeStr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	exporterConfig := &config.Config{}
+	if len(*configPath) > 0 {
+		exporterConfig, err = config.Load(*configPath)
+		if err != nil {
+			logrus.Fatalf("Failed to load config: %v", err)
+		}
+	}
+
+	registryChecker, err := registry.NewOfflineChecker(registry.OfflineConfig{
+		SkipVerify:       *insecureSkipVerify,
+		StrictTLS:        *strictTLS,
+		PlainHTTP:        *plainHTTP,
+		CAPaths:          *cp,
+		DefaultRegistry:  *defaultRegistry,
+		CheckPlatform:    checkPlatform,
+		OldRegistryMode:  oldRegistryMode,
+		ExporterConfig:   exporterConfig,
+		UserAgent:        exporterUserAgent(),
+		SOCKSProxy:       *registrySOCKSProxy,
+		DockerConfigJSON: credentials,
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	verifyManifests(registryChecker.Simulate)
+}
+
+// verifyManifests prints the availability of images of --manifests-path, reports it to --scm-provider, and exits
+// with a non-zero code if any of them are unavailable.
+func verifyManifests(simulate manifests.SimulateFunc) {
+	reporter, err := newSCMReporter()
+	if err != nil {
+		logrus.Fatalf("Invalid -scm-provider: %v", err)
+	}
+
+	objects, err := manifests.Read(*manifestsPath, os.Stdin)
+	if err != nil {
+		logrus.Fatalf("Failed to read manifests: %v", err)
+	}
+
+	report := manifests.Check(objects, *manifestsNamespace, simulate)
+	if err := report.Write(os.Stdout); err != nil {
+		logrus.Fatal(err)
+	}
+	if reporter != nil {
+		if err := reporter.Report(context.Background(), report); err != nil {
+			logrus.Fatalf("Failed to report the verdict to %s: %v", *scmProvider, err)
+		}
+	}
+	if unavailable := report.Unavailable(); unavailable > 0 {
+		logrus.Fatalf("%d images of the manifests are unavailable", unavailable)
+	}
+}
+
+// newSCMReporter returns the reporter of --scm-provider, or nil if it's empty.
+func newSCMReporter() (scm.Reporter, error) {
+	if len(*scmProvider) == 0 {
+		return nil, nil
+	}
+
+	var token string
+	if len(*scmTokenFile) > 0 {
+		data, err := os.ReadFile(*scmTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	return scm.New(scm.Config{
+		Provider:     *scmProvider,
+		APIURL:       *scmAPIURL,
+		Repository:   *scmRepository,
+		Commit:       *scmCommit,
+		MergeRequest: *scmMergeRequest,
+		Token:        token,
+		StatusName:   *scmStatusName,
+		TargetURL:    *scmTargetURL,
+		Timeout:      *scmTimeout,
+	})
+}
+
+func runForwarder(ctx context.Context, listenAddr, target string) {
+	if len(listenAddr) == 0 || len(target) == 0 {
+		logrus.Fatal("-forward-listen-address and -forward-target are required for forwarding")
+	}
+
+	listener, err := listenForwarder(listenAddr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.WithFields(logrus.Fields{"address": listenAddr, "target": target}).Info("Forwarding connections to the node-local registry")
+	if err := forwarder.New(target).Serve(ctx, listener); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// listenForwarder listens on "address:port" or on a unix socket like "unix:///run/forwarder.sock". The socket is only
+// accessible to the user the forwarder runs as, since the forwarded registry may not require authentication.
+func listenForwarder(listenAddr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(listenAddr, "unix://")
+	if !ok {
+		return net.Listen("tcp", listenAddr)
+	}
+
+	// The socket of a previous run is left behind if it wasn't shut down cleanly.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func validateHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return errors.New("missing host")
+	}
+
+	return nil
+}
+
+func splitNonEmpty(s, sep string) (ret []string) {
+	for _, part := range strings.Split(s, sep) {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			ret = append(ret, part)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHTTPTimeouts(t *testing.T) {
+	require.NoError(t, validateHTTPTimeouts(30*time.Second, 2*time.Minute, 2*time.Minute))
+	// Zero disables a timeout.
+	require.NoError(t, validateHTTPTimeouts(0, 0, 0))
+
+	require.EqualError(t, validateHTTPTimeouts(time.Second, -time.Second, time.Second), "invalid -http-write-timeout: must not be negative, got -1s")
+	require.ErrorContains(t, validateHTTPTimeouts(-time.Second, 0, 0), "-http-read-timeout")
+	require.ErrorContains(t, validateHTTPTimeouts(0, 0, -time.Second), "-http-idle-timeout")
+}
+
+func TestListenForwarder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.sock")
+	require.NoError(t, os.WriteFile(path, nil, 0o666))
+
+	// A socket left behind by a previous run is replaced.
+	listener, err := listenForwarder("unix://" + path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket|0o600, info.Mode())
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/util/wait"
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+)
+
+const (
+	resultPresent    = "present"
+	resultPulled     = "pulled"
+	resultPullFailed = "pull_failed"
+	resultError      = "error"
+)
+
+// Agent verifies images flagged by the exporter through the container runtime of its node and reports the results
+// back. Images are pulled without pull secrets of workloads, so the results reflect the configuration of the node
+// itself: registry mirrors, credentials of the runtime and network routes.
+type Agent struct {
+	images      criv1.ImageServiceClient
+	exporter    availabilityv1.NodeAgentServiceClient
+	node        string
+	pullTimeout time.Duration
+}
+
+func New(images criv1.ImageServiceClient, exporter availabilityv1.NodeAgentServiceClient, node string, pullTimeout time.Duration) *Agent {
+	return &Agent{
+		images:      images,
+		exporter:    exporter,
+		node:        node,
+		pullTimeout: pullTimeout,
+	}
+}
+
+// Sync verifies all currently flagged images and reports the results.
+func (a *Agent) Sync(ctx context.Context) error {
+	flagged, err := a.exporter.ListFlaggedImages(ctx, &availabilityv1.ListFlaggedImagesRequest{Node: a.node})
+	if err != nil {
+		return err
+	}
+
+	req := &availabilityv1.ReportNodeResultsRequest{Node: a.node}
+	for _, image := range flagged.GetImages() {
+		result := a.verify(ctx, image)
+		logrus.WithFields(logrus.Fields{
+			"image":   image,
+			"result":  result.GetResult(),
+			"message": result.GetMessage(),
+		}).Debug("Verified flagged image")
+		req.Results = append(req.Results, result)
+	}
+
+	_, err = a.exporter.ReportNodeResults(ctx, req)
+	return err
+}
+
+func (a *Agent) verify(ctx context.Context, image string) *availabilityv1.NodeImageResult {
+	result := &availabilityv1.NodeImageResult{Image: image}
+	defer func() { result.Time = timestamppb.Now() }()
+
+	spec := &criv1.ImageSpec{Image: image}
+
+	imageStatus, err := a.images.ImageStatus(ctx, &criv1.ImageStatusRequest{Image: spec})
+	if err != nil {
+		result.Result, result.Message = resultError, err.Error()
+		return result
+	}
+	if imageStatus.GetImage() != nil {
+		result.Result = resultPresent
+		return result
+	}
+
+	pullCtx, cancel := context.WithTimeout(ctx, a.pullTimeout)
+	defer cancel()
+
+	pulled, err := a.images.PullImage(pullCtx, &criv1.PullImageRequest{Image: spec})
+	if err != nil {
+		result.Result, result.Message = resultPullFailed, err.Error()
+		return result
+	}
+	result.Result = resultPulled
+
+	// The image wasn't present before, it's removed so that verification doesn't fill up the disk of the node.
+	if _, err := a.images.RemoveImage(ctx, &criv1.RemoveImageRequest{Image: &criv1.ImageSpec{Image: pulled.GetImageRef()}}); err != nil {
+		logrus.WithField("image", image).Warnf("Failed to remove the verified image: %v", err)
+	}
+
+	return result
+}
+
+// Run syncs every interval until the context is done.
+func (a *Agent) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := a.Sync(ctx); err != nil {
+			logrus.Errorf("Failed to verify flagged images: %v", err)
+		}
+	}, interval)
+}
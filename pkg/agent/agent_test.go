@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+)
+
+type fakeRuntime struct {
+	criv1.ImageServiceClient
+
+	present  map[string]bool
+	pullable map[string]bool
+	removed  []string
+}
+
+func (r *fakeRuntime) ImageStatus(_ context.Context, req *criv1.ImageStatusRequest, _ ...grpc.CallOption) (*criv1.ImageStatusResponse, error) {
+	if req.GetImage().GetImage() == "broken:v1" {
+		return nil, errors.New("runtime is broken")
+	}
+	if r.present[req.GetImage().GetImage()] {
+		return &criv1.ImageStatusResponse{Image: &criv1.Image{Id: "sha256:present"}}, nil
+	}
+
+	return &criv1.ImageStatusResponse{}, nil
+}
+
+func (r *fakeRuntime) PullImage(_ context.Context, req *criv1.PullImageRequest, _ ...grpc.CallOption) (*criv1.PullImageResponse, error) {
+	if !r.pullable[req.GetImage().GetImage()] {
+		return nil, errors.New("not found")
+	}
+
+	return &criv1.PullImageResponse{ImageRef: "sha256:" + req.GetImage().GetImage()}, nil
+}
+
+func (r *fakeRuntime) RemoveImage(_ context.Context, req *criv1.RemoveImageRequest, _ ...grpc.CallOption) (*criv1.RemoveImageResponse, error) {
+	r.removed = append(r.removed, req.GetImage().GetImage())
+
+	return &criv1.RemoveImageResponse{}, nil
+}
+
+type fakeExporter struct {
+	availabilityv1.NodeAgentServiceClient
+
+	images []string
+	report *availabilityv1.ReportNodeResultsRequest
+}
+
+func (e *fakeExporter) ListFlaggedImages(_ context.Context, _ *availabilityv1.ListFlaggedImagesRequest, _ ...grpc.CallOption) (*availabilityv1.ListFlaggedImagesResponse, error) {
+	return &availabilityv1.ListFlaggedImagesResponse{Images: e.images}, nil
+}
+
+func (e *fakeExporter) ReportNodeResults(_ context.Context, req *availabilityv1.ReportNodeResultsRequest, _ ...grpc.CallOption) (*availabilityv1.ReportNodeResultsResponse, error) {
+	e.report = req
+
+	return &availabilityv1.ReportNodeResultsResponse{}, nil
+}
+
+func TestAgentSync(t *testing.T) {
+	runtime := &fakeRuntime{
+		present:  map[string]bool{"present:v1": true},
+		pullable: map[string]bool{"pullable:v1": true},
+	}
+	exporter := &fakeExporter{images: []string{"present:v1", "pullable:v1", "absent:v1", "broken:v1"}}
+
+	require.NoError(t, New(runtime, exporter, "node-1", time.Minute).Sync(context.Background()))
+
+	require.Equal(t, "node-1", exporter.report.GetNode())
+	results := make(map[string]string)
+	for _, result := range exporter.report.GetResults() {
+		results[result.GetImage()] = result.GetResult()
+		require.NotNil(t, result.GetTime())
+	}
+	require.Equal(t, map[string]string{
+		"present:v1":  resultPresent,
+		"pullable:v1": resultPulled,
+		"absent:v1":   resultPullFailed,
+		"broken:v1":   resultError,
+	}, results)
+	require.Equal(t, []string{"sha256:pullable:v1"}, runtime.removed)
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DialRuntime connects to a CRI endpoint like "unix:///run/containerd/containerd.sock".
+func DialRuntime(endpoint string) (*grpc.ClientConn, error) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return nil, fmt.Errorf("unsupported CRI endpoint %q, only unix sockets are supported", endpoint)
+	}
+
+	return grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+// DialExporter connects to the gRPC API of the exporter. TLS is used if caFile is set, the bearer token is read from
+// tokenFile before every call, so that rotated tokens are picked up.
+func DialExporter(address, caFile, tokenFile string) (*grpc.ClientConn, error) {
+	transportCredentials := insecure.NewCredentials()
+	if len(caFile) > 0 {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transportCredentials = credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	options := []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials)}
+	if len(tokenFile) > 0 {
+		options = append(options, grpc.WithPerRPCCredentials(tokenFileCredentials{path: tokenFile, secure: len(caFile) > 0}))
+	}
+
+	return grpc.Dial(address, options...)
+}
+
+type tokenFileCredentials struct {
+	path   string
+	secure bool
+}
+
+func (c tokenFileCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"authorization": "Bearer " + strings.TrimSpace(string(token))}, nil
+}
+
+func (c tokenFileCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
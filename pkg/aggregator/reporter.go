@@ -0,0 +1,68 @@
+// Package aggregator reports check results of an edge cluster to a central aggregator, so that a fleet of small
+// clusters with intermittent connectivity is monitored from a single place without scraping every one of them.
+package aggregator
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var lastReportTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "k8s_image_availability_exporter_aggregator_last_report_timestamp_seconds",
+	Help: "Time check results were last reported to the aggregator at.",
+})
+
+// Reporter pushes a snapshot of all check results to the aggregator. Every report replaces the previous one, so
+// reports missed while the aggregator is unreachable don't need to be replayed.
+type Reporter struct {
+	client          availabilityv1.AggregatorServiceClient
+	cluster         string
+	rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+}
+
+func New(client availabilityv1.AggregatorServiceClient, cluster string, rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))) *Reporter {
+	return &Reporter{client: client, cluster: cluster, rangeContainers: rangeContainers}
+}
+
+// Sync reports the current check results.
+func (r *Reporter) Sync(ctx context.Context) error {
+	req := &availabilityv1.ReportClusterResultsRequest{Cluster: r.cluster, Time: timestamppb.Now()}
+	r.rangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+		req.Results = append(req.Results, &availabilityv1.ContainerResult{
+			Workload: &availabilityv1.Workload{
+				Namespace: containerInfo.Namespace,
+				Kind:      strings.ToLower(containerInfo.ControllerKind),
+				Name:      containerInfo.ControllerName,
+				Container: containerInfo.Container,
+			},
+			Image: image,
+			Mode:  availMode.String(),
+		})
+	})
+
+	if _, err := r.client.ReportClusterResults(ctx, req); err != nil {
+		return err
+	}
+	lastReportTimestamp.Set(float64(req.GetTime().AsTime().Unix()))
+
+	return nil
+}
+
+// Run reports every interval until the context is done.
+func (r *Reporter) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.Sync(ctx); err != nil {
+			logrus.Warnf("Failed to report check results to the aggregator: %v", err)
+		}
+	}, interval)
+}
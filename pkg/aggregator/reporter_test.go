@@ -0,0 +1,50 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+type fakeAggregator struct {
+	availabilityv1.AggregatorServiceClient
+
+	err    error
+	report *availabilityv1.ReportClusterResultsRequest
+}
+
+func (a *fakeAggregator) ReportClusterResults(_ context.Context, req *availabilityv1.ReportClusterResultsRequest, _ ...grpc.CallOption) (*availabilityv1.ReportClusterResultsResponse, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.report = req
+
+	return &availabilityv1.ReportClusterResultsResponse{}, nil
+}
+
+func TestReporter(t *testing.T) {
+	client := &fakeAggregator{}
+	reporter := New(client, "edge-1", func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		f("a:v1", store.ContainerInfo{Namespace: "default", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent)
+	})
+
+	require.NoError(t, reporter.Sync(context.Background()))
+	require.Equal(t, "edge-1", client.report.GetCluster())
+	require.NotNil(t, client.report.GetTime())
+	require.Len(t, client.report.GetResults(), 1)
+
+	result := client.report.GetResults()[0]
+	require.Equal(t, "a:v1", result.GetImage())
+	require.Equal(t, "absent", result.GetMode())
+	require.Equal(t, "deployment", result.GetWorkload().GetKind())
+	require.Equal(t, "app", result.GetWorkload().GetName())
+
+	client.err = errors.New("unavailable")
+	require.Error(t, reporter.Sync(context.Background()))
+}
@@ -0,0 +1,229 @@
+// Package annotations maintains an annotation listing unavailable images on workload controllers, and optionally a
+// condition of their status, so that tools watching the controllers, e.g. Argo CD custom health checks or admission
+// policies, can react to unavailable images.
+package annotations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// UnavailableImages lists unavailable images of a controller as "container: image (availability mode)", separated
+// by "; ". Controllers whose images are all available don't have it.
+const UnavailableImages = "k8s-image-availability-exporter.flant.com/unavailable-images"
+
+// ConditionImagesAvailable is the type of the condition set to "False" on the status of controllers with unavailable
+// images, see UseConditions.
+const ConditionImagesAvailable = "ImagesAvailable"
+
+// conditionKinds are kinds of controllers whose status keeps conditions set by others. The StatefulSet controller
+// replaces the status as a whole, and CronJobs have no conditions.
+var conditionKinds = map[string]bool{"Deployment": true, "DaemonSet": true}
+
+// resources of controllers that are annotated, by kind.
+var resources = map[string]schema.GroupVersionResource{
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"DaemonSet":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"CronJob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+}
+
+type workload struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// Annotator keeps the annotation of controllers up to date.
+type Annotator struct {
+	client          dynamic.Interface
+	rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+	conditions      bool
+	now             func() time.Time
+
+	// annotated holds the annotation values of controllers, it is listed from the API on the first sync.
+	annotated map[workload]string
+}
+
+func New(client dynamic.Interface, rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))) *Annotator {
+	return &Annotator{client: client, rangeContainers: rangeContainers, now: time.Now}
+}
+
+// UseConditions makes the annotator set the ImagesAvailable condition of Deployments and DaemonSets to "False" along
+// with the annotation, with the annotation value as the message. The condition is removed along with the annotation.
+func (a *Annotator) UseConditions() {
+	a.conditions = true
+}
+
+// Run syncs the annotations every interval until stopCh is closed.
+func (a *Annotator) Run(interval time.Duration, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := a.Sync(context.TODO()); err != nil {
+			logrus.Warnf("Failed to sync workload annotations: %v", err)
+		}
+	}, interval, stopCh)
+}
+
+// Sync sets, updates and removes annotations to match the current availability of images.
+func (a *Annotator) Sync(ctx context.Context) error {
+	if a.annotated == nil {
+		annotated, err := a.list(ctx)
+		if err != nil {
+			return err
+		}
+		a.annotated = annotated
+	}
+
+	desired := a.desired()
+
+	var errs []error
+	for w := range a.annotated {
+		if _, ok := desired[w]; ok {
+			continue
+		}
+		if err := a.update(ctx, w, nil); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+			continue
+		}
+		delete(a.annotated, w)
+	}
+
+	for w, value := range desired {
+		if a.annotated[w] == value {
+			continue
+		}
+		if err := a.update(ctx, w, &value); err != nil {
+			// Pods of controllers that are just removed are still tracked for a while, the annotation is retried
+			// in case the controller is created again.
+			if !apierrors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		a.annotated[w] = value
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a *Annotator) desired() map[workload]string {
+	unavailable := make(map[workload][]string)
+	a.rangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+		if availMode == store.Available {
+			return
+		}
+		if _, ok := resources[containerInfo.ControllerKind]; !ok {
+			return
+		}
+
+		w := workload{kind: containerInfo.ControllerKind, namespace: containerInfo.Namespace, name: containerInfo.ControllerName}
+		unavailable[w] = append(unavailable[w], fmt.Sprintf("%s: %s (%s)", containerInfo.Container, image, availMode))
+	})
+
+	ret := make(map[workload]string, len(unavailable))
+	for w, containers := range unavailable {
+		sort.Strings(containers)
+		ret[w] = strings.Join(containers, "; ")
+	}
+
+	return ret
+}
+
+// list returns annotation values of all controllers that have the annotation.
+func (a *Annotator) list(ctx context.Context) (map[workload]string, error) {
+	ret := make(map[workload]string)
+	for kind, resource := range resources {
+		list, err := a.client.Resource(resource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", resource.Resource, err)
+		}
+
+		for _, item := range list.Items {
+			if value, ok := item.GetAnnotations()[UnavailableImages]; ok {
+				ret[workload{kind: kind, namespace: item.GetNamespace(), name: item.GetName()}] = value
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// update sets the annotation and the condition of a controller, or removes them if value is nil.
+func (a *Annotator) update(ctx context.Context, w workload, value *string) error {
+	if err := a.patchAnnotation(ctx, w, value); err != nil {
+		return err
+	}
+	if !a.conditions || !conditionKinds[w.kind] {
+		return nil
+	}
+
+	// The transition time is kept when the list of unavailable images changes.
+	_, transitioned := a.annotated[w]
+	return a.patchCondition(ctx, w, value, !transitioned)
+}
+
+// patchAnnotation sets the annotation of a controller, or removes it if value is nil.
+func (a *Annotator) patchAnnotation(ctx context.Context, w workload, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{UnavailableImages: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.client.Resource(resources[w.kind]).Namespace(w.namespace).Patch(ctx, w.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate %s %s/%s: %w", strings.ToLower(w.kind), w.namespace, w.name, err)
+	}
+
+	return nil
+}
+
+// patchCondition sets the condition of a controller, or removes it if value is nil. Conditions are merged by type, so
+// conditions of the controller itself are kept.
+func (a *Annotator) patchCondition(ctx context.Context, w workload, value *string, transitioned bool) error {
+	condition := map[string]interface{}{"type": ConditionImagesAvailable}
+	if value == nil {
+		condition["$patch"] = "delete"
+	} else {
+		condition["status"] = metav1.ConditionFalse
+		condition["reason"] = "ImagesUnavailable"
+		condition["message"] = *value
+		if transitioned {
+			condition["lastTransitionTime"] = metav1.NewTime(a.now())
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.client.Resource(resources[w.kind]).Namespace(w.namespace).Patch(ctx, w.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return fmt.Errorf("failed to set the %s condition of %s %s/%s: %w", ConditionImagesAvailable, strings.ToLower(w.kind), w.namespace, w.name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package annotations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+type testContainer struct {
+	image         string
+	containerInfo store.ContainerInfo
+	availMode     store.AvailabilityMode
+}
+
+func newWorkload(kind, namespace, name string, annotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(resources[kind].GroupVersion().WithKind(kind))
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func getAnnotations(t *testing.T, annotator *Annotator, kind, namespace, name string) map[string]string {
+	t.Helper()
+
+	obj, err := annotator.client.Resource(resources[kind]).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return obj.GetAnnotations()
+}
+
+func TestAnnotator_Sync(t *testing.T) {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for kind, resource := range resources {
+		listKinds[resource] = kind + "List"
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		newWorkload("Deployment", "tenant", "app", map[string]string{"owner": "team"}),
+		newWorkload("CronJob", "tenant", "migrate", nil),
+		newWorkload("StatefulSet", "tenant", "db", map[string]string{UnavailableImages: "db: registry.example.com/db:v1 (absent)"}),
+	)
+
+	containers := []testContainer{
+		{"registry.example.com/sidecar:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "sidecar"}, store.Absent},
+		{"registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.AuthnFailure},
+		{"registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "CronJob", ControllerName: "migrate", Container: "migrate"}, store.Available},
+		{"registry.example.com/db:v2", store.ContainerInfo{Namespace: "tenant", ControllerKind: "StatefulSet", ControllerName: "db", Container: "db"}, store.Available},
+		{"registry.example.com/gone:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "gone", Container: "gone"}, store.Absent},
+		{"registry.example.com/pod:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Pod", ControllerName: "pod", Container: "pod"}, store.Absent},
+	}
+	annotator := New(client, func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		for _, c := range containers {
+			f(c.image, c.containerInfo, c.availMode)
+		}
+	})
+
+	require.NoError(t, annotator.Sync(context.TODO()))
+
+	require.Equal(t, map[string]string{
+		"owner":           "team",
+		UnavailableImages: "app: registry.example.com/app:v1 (authentication_failure); sidecar: registry.example.com/sidecar:v1 (absent)",
+	}, getAnnotations(t, annotator, "Deployment", "tenant", "app"))
+	require.Empty(t, getAnnotations(t, annotator, "CronJob", "tenant", "migrate"))
+	require.Empty(t, getAnnotations(t, annotator, "StatefulSet", "tenant", "db"))
+	require.NotContains(t, annotator.annotated, workload{kind: "Deployment", namespace: "tenant", name: "gone"})
+
+	containers = containers[2:]
+	require.NoError(t, annotator.Sync(context.TODO()))
+
+	require.Equal(t, map[string]string{"owner": "team"}, getAnnotations(t, annotator, "Deployment", "tenant", "app"))
+}
+
+func TestAnnotator_UseConditions(t *testing.T) {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for kind, resource := range resources {
+		listKinds[resource] = kind + "List"
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		newWorkload("Deployment", "tenant", "app", nil),
+		newWorkload("StatefulSet", "tenant", "db", nil),
+	)
+
+	containers := []testContainer{
+		{"registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent},
+		{"registry.example.com/db:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "StatefulSet", ControllerName: "db", Container: "db"}, store.Absent},
+	}
+	annotator := New(client, func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		for _, c := range containers {
+			f(c.image, c.containerInfo, c.availMode)
+		}
+	})
+	annotator.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+	annotator.UseConditions()
+
+	// The fake client can't apply strategic merge patch directives to unstructured objects.
+	var patches []string
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetSubresource() != "status" {
+			return false, nil, nil
+		}
+		patches = append(patches, patch.GetName()+" "+string(patch.GetPatch()))
+		return true, nil, nil
+	})
+	statusPatches := func() []string {
+		ret := patches
+		patches = nil
+		return ret
+	}
+
+	require.NoError(t, annotator.Sync(context.TODO()))
+	// StatefulSets are only annotated.
+	require.Equal(t, []string{
+		`app {"status":{"conditions":[{"lastTransitionTime":"2024-03-01T12:00:00Z","message":"app: registry.example.com/app:v1 (absent)","reason":"ImagesUnavailable","status":"False","type":"ImagesAvailable"}]}}`,
+	}, statusPatches())
+
+	// The transition time is kept when unavailable images change.
+	containers[0].availMode = store.AuthzFailure
+	require.NoError(t, annotator.Sync(context.TODO()))
+	require.Equal(t, []string{
+		`app {"status":{"conditions":[{"message":"app: registry.example.com/app:v1 (authorization_failure)","reason":"ImagesUnavailable","status":"False","type":"ImagesAvailable"}]}}`,
+	}, statusPatches())
+
+	containers = nil
+	require.NoError(t, annotator.Sync(context.TODO()))
+	require.Equal(t, []string{
+		`app {"status":{"conditions":[{"$patch":"delete","type":"ImagesAvailable"}]}}`,
+	}, statusPatches())
+}
@@ -0,0 +1,80 @@
+// Package apiclient is a client of the JSON API of the exporter.
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/handlers"
+)
+
+// maxErrorBodySize limits the size of error responses included into errors.
+const maxErrorBodySize = 4096
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+}
+
+// New creates a client of the API at baseURL, e.g. "http://exporter:8080" or a Kubernetes service proxy URL. The token,
+// if any, is sent as a bearer token.
+func New(baseURL string, httpClient *http.Client, token string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient, token: token}
+}
+
+// Images lists tracked images matching the query.
+func (c *Client) Images(ctx context.Context, query handlers.ImagesQuery) ([]handlers.ImageStatus, error) {
+	path := handlers.ImagesAPIPath
+	if values := query.Values(); len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+
+	var resp handlers.ImagesResponse
+	if err := c.do(ctx, http.MethodGet, path, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Images, nil
+}
+
+// Recheck checks the image immediately.
+func (c *Client) Recheck(ctx context.Context, image string) (handlers.RecheckResponse, error) {
+	segments := strings.Split(image, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	var resp handlers.RecheckResponse
+	err := c.do(ctx, http.MethodPost, handlers.ImagesAPIPrefix+strings.Join(segments, "/")+"/recheck", &resp)
+	return resp, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
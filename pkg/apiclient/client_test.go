@@ -0,0 +1,52 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/handlers"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestClient(t *testing.T) {
+	var checked []string
+	mux := http.NewServeMux()
+	mux.Handle(handlers.ImagesAPIPath, handlers.Images(func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		f("registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent)
+		f("registry.example.com/web:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "web", Container: "web"}, store.Available)
+	}))
+	mux.Handle(handlers.ImagesAPIPrefix, handlers.Recheck(func(image string) (store.AvailabilityMode, bool) {
+		checked = append(checked, image)
+		return store.Available, true
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client := New(srv.URL+"/", srv.Client(), "secret")
+
+	images, err := client.Images(context.TODO(), handlers.ImagesQuery{Namespace: "tenant", Unavailable: true})
+	require.NoError(t, err)
+	require.Equal(t, []handlers.ImageStatus{{
+		Image:     "registry.example.com/app:v1",
+		Mode:      "absent",
+		Workloads: []handlers.ImageWorkload{{Namespace: "tenant", Kind: "deployment", Name: "app", Container: "app"}},
+	}}, images)
+
+	resp, err := client.Recheck(context.TODO(), "registry.example.com/app@sha256:abc")
+	require.NoError(t, err)
+	require.Equal(t, handlers.RecheckResponse{Image: "registry.example.com/app@sha256:abc", Mode: "available", Tracked: true}, resp)
+	require.Equal(t, []string{"registry.example.com/app@sha256:abc"}, checked)
+
+	_, err = New(srv.URL, srv.Client(), "").Images(context.TODO(), handlers.ImagesQuery{})
+	require.ErrorContains(t, err, "401 Unauthorized: unauthenticated")
+}
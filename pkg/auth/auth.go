@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scope is a level of API access. Higher scopes include lower ones.
+type Scope int
+
+const (
+	// ScopeRead allows reading image availability: metrics and availability change streams.
+	ScopeRead Scope = iota + 1
+	// ScopeRecheck additionally allows triggering on-demand checks.
+	ScopeRecheck
+)
+
+var scopeNames = map[string]Scope{
+	"read":    ScopeRead,
+	"recheck": ScopeRecheck,
+}
+
+var (
+	errUnauthenticated = errors.New("unauthenticated")
+	errForbidden       = errors.New("insufficient scope")
+)
+
+type tokenScope struct {
+	token []byte
+	scope Scope
+	// namespaces restricts the namespaces the credential may act on, e.g. simulate manifests with pull secrets of,
+	// any namespace is allowed if nil.
+	namespaces map[string]bool
+}
+
+type namespacesKey struct{}
+
+// Authenticator authorizes API requests by bearer tokens or by common names of verified client certificates.
+// A nil Authenticator allows everything.
+type Authenticator struct {
+	tokens      []tokenScope
+	commonNames map[string]tokenScope
+}
+
+// Load reads credentials from a file. Every non-empty line that isn't a comment has the form
+// "token:<bearer token> <scope>" or "cn:<client certificate common name> <scope>", where scope is "read" or "recheck",
+// optionally followed by "namespaces=<namespace>,..." to restrict the namespaces the credential may act on.
+func Load(path string) (*Authenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &Authenticator{commonNames: make(map[string]tokenScope)}
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected a credential, a scope and optionally namespaces", path, lineNum)
+		}
+
+		scope, ok := scopeNames[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown scope %q", path, lineNum, fields[1])
+		}
+
+		grant := tokenScope{scope: scope}
+		if len(fields) == 3 {
+			namespaces, ok := strings.CutPrefix(fields[2], "namespaces=")
+			if !ok || len(namespaces) == 0 {
+				return nil, fmt.Errorf("%s:%d: expected namespaces=<namespace>,... after the scope", path, lineNum)
+			}
+			grant.namespaces = make(map[string]bool)
+			for _, namespace := range strings.Split(namespaces, ",") {
+				grant.namespaces[namespace] = true
+			}
+		}
+
+		kind, identity, _ := strings.Cut(fields[0], ":")
+		if len(identity) == 0 {
+			return nil, fmt.Errorf("%s:%d: empty credential", path, lineNum)
+		}
+
+		switch kind {
+		case "token":
+			grant.token = []byte(identity)
+			a.tokens = append(a.tokens, grant)
+		case "cn":
+			a.commonNames[identity] = grant
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown credential kind %q", path, lineNum, kind)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// authorize checks that either the token or the verified client certificate grants the required scope. It returns
+// the namespaces the credentials granting the scope may act on, which is nil if any namespace is allowed.
+func (a *Authenticator) authorize(token string, peerCertificates []*x509.Certificate, required Scope) (map[string]bool, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	var matched []tokenScope
+
+	if len(token) > 0 {
+		for _, ts := range a.tokens {
+			if subtle.ConstantTimeCompare(ts.token, []byte(token)) == 1 {
+				matched = append(matched, ts)
+			}
+		}
+	}
+
+	if len(peerCertificates) > 0 {
+		if ts, ok := a.commonNames[peerCertificates[0].Subject.CommonName]; ok {
+			matched = append(matched, ts)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, errUnauthenticated
+	}
+
+	var namespaces map[string]bool
+	for _, ts := range matched {
+		if ts.scope < required {
+			continue
+		}
+		if ts.namespaces == nil {
+			return nil, nil
+		}
+
+		if namespaces == nil {
+			namespaces = make(map[string]bool)
+		}
+		for namespace := range ts.namespaces {
+			namespaces[namespace] = true
+		}
+	}
+	if namespaces == nil {
+		return nil, errForbidden
+	}
+
+	return namespaces, nil
+}
+
+// NamespaceAllowed reports whether the credentials of the request may act on the namespace. Requests authorized by
+// Middleware with credentials that aren't restricted to namespaces may act on any namespace.
+func NamespaceAllowed(ctx context.Context, namespace string) bool {
+	namespaces, ok := ctx.Value(namespacesKey{}).(map[string]bool)
+	return !ok || namespaces[namespace]
+}
+
+// Middleware protects an HTTP handler with the required scope.
+func (a *Authenticator) Middleware(required Scope, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var peerCertificates []*x509.Certificate
+		if r.TLS != nil {
+			peerCertificates = r.TLS.PeerCertificates
+		}
+
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		namespaces, err := a.authorize(token, peerCertificates, required)
+		switch err {
+		case nil:
+			if namespaces != nil {
+				r = r.WithContext(context.WithValue(r.Context(), namespacesKey{}, namespaces))
+			}
+			next.ServeHTTP(w, r)
+		case errForbidden:
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	})
+}
+
+// NewServerTLSConfig returns a TLS config for API listeners. Client certificates are requested and verified
+// against the CA only if clientCAFile is set, they are optional so that bearer tokens can be used as well.
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(clientCAFile) > 0 {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+
+	require.NoError(t, os.WriteFile(path, []byte("token:foo read\ntoken:foo admin\n"), 0o600))
+	_, err := Load(path)
+	require.ErrorContains(t, err, `:2: unknown scope "admin"`)
+
+	require.NoError(t, os.WriteFile(path, []byte("# comment\n\ntoken:reader read\ntoken:ops recheck\ncn:ops-tool recheck\n"), 0o600))
+	a, err := Load(path)
+	require.NoError(t, err)
+
+	opsCert := []*x509.Certificate{{Subject: pkix.Name{CommonName: "ops-tool"}}}
+	unknownCert := []*x509.Certificate{{Subject: pkix.Name{CommonName: "unknown"}}}
+
+	require.NoError(t, authorizeErr(a, "reader", nil, ScopeRead))
+	require.ErrorIs(t, authorizeErr(a, "reader", nil, ScopeRecheck), errForbidden)
+	require.NoError(t, authorizeErr(a, "ops", nil, ScopeRecheck))
+	require.NoError(t, authorizeErr(a, "", opsCert, ScopeRecheck))
+	require.ErrorIs(t, authorizeErr(a, "", unknownCert, ScopeRead), errUnauthenticated)
+	require.ErrorIs(t, authorizeErr(a, "wrong", nil, ScopeRead), errUnauthenticated)
+	require.NoError(t, authorizeErr(a, "reader", opsCert, ScopeRecheck))
+
+	require.NoError(t, authorizeErr(nil, "", nil, ScopeRecheck))
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	a := &Authenticator{
+		tokens:      []tokenScope{{token: []byte("reader"), scope: ScopeRead}},
+		commonNames: map[string]tokenScope{"ops-tool": {scope: ScopeRecheck}},
+	}
+	handler := a.Middleware(ScopeRecheck, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	r.Header.Set("Authorization", "Bearer reader")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "ops-tool"}}}}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func authorizeErr(a *Authenticator, token string, peerCertificates []*x509.Certificate, required Scope) error {
+	_, err := a.authorize(token, peerCertificates, required)
+	return err
+}
+
+func TestAuthenticator_namespaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	require.NoError(t, os.WriteFile(path, []byte("token:ops recheck namespaces\n"), 0o600))
+	_, err := Load(path)
+	require.ErrorContains(t, err, ":1: expected namespaces=")
+
+	require.NoError(t, os.WriteFile(path, []byte("token:team-a recheck namespaces=a,shared\ntoken:team-a read\ntoken:ops recheck\n"), 0o600))
+	a, err := Load(path)
+	require.NoError(t, err)
+
+	var allowed map[string]bool
+	handler := a.Middleware(ScopeRecheck, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		allowed = make(map[string]bool)
+		for _, namespace := range []string{"a", "b", "shared"} {
+			allowed[namespace] = NamespaceAllowed(r.Context(), namespace)
+		}
+	}))
+
+	// Credentials granting the scope are restricted to their namespaces, unrestricted credentials with a lower scope
+	// don't lift the restriction.
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Authorization", "Bearer team-a")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, map[string]bool{"a": true, "b": false, "shared": true}, allowed)
+
+	r.Header.Set("Authorization", "Bearer ops")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, map[string]bool{"a": true, "b": true, "shared": true}, allowed)
+}
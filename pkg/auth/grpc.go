@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ScopeFunc returns the scope required to call a gRPC method.
+type ScopeFunc func(fullMethod string) Scope
+
+// UnaryInterceptor protects unary gRPC methods.
+func (a *Authenticator) UnaryInterceptor(requiredScope ScopeFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authorizeContext(ctx, requiredScope(info.FullMethod)); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor protects streaming gRPC methods.
+func (a *Authenticator) StreamInterceptor(requiredScope ScopeFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authorizeContext(ss.Context(), requiredScope(info.FullMethod)); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func (a *Authenticator) authorizeContext(ctx context.Context, required Scope) error {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+
+	var peerCertificates []*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			peerCertificates = tlsInfo.State.PeerCertificates
+		}
+	}
+
+	// Namespace restrictions only apply to HTTP endpoints, none of the gRPC methods act on a namespace.
+	_, err := a.authorize(token, peerCertificates, required)
+	switch err {
+	case nil:
+		return nil
+	case errForbidden:
+		return status.Error(codes.PermissionDenied, errForbidden.Error())
+	default:
+		return status.Error(codes.Unauthenticated, errUnauthenticated.Error())
+	}
+}
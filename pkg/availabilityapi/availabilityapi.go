@@ -0,0 +1,255 @@
+// Package availabilityapi serves availability of tracked images as ImageAvailability objects of an aggregated
+// Kubernetes API, so that controllers and schedulers can read it from the Kubernetes API, e.g. to avoid scheduling
+// workloads with images that can't be pulled, the way they read resource usage from metrics.k8s.io.
+package availabilityapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	// Group differs from the group of ImageAvailabilityReport CRDs, since a group version is either served by the
+	// Kubernetes API server or by an aggregated API server.
+	Group    = "availability.k8s-image-availability-exporter.flant.com"
+	Version  = "v1alpha1"
+	Resource = "imageavailabilities"
+	Kind     = "ImageAvailability"
+
+	// Path is the path of API discovery, the API is served under it.
+	Path = "/apis"
+)
+
+var GroupVersion = schema.GroupVersion{Group: Group, Version: Version}
+
+type rangeContainersFunc func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+
+// Workload is a container referencing an image.
+type Workload struct {
+	Namespace string `json:"namespace"`
+	// Kind is a lowercase controller kind, e.g. "deployment".
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+}
+
+type ImageAvailabilitySpec struct {
+	Image string `json:"image"`
+}
+
+type ImageAvailabilityStatus struct {
+	Available        bool       `json:"available"`
+	AvailabilityMode string     `json:"availabilityMode"`
+	Workloads        []Workload `json:"workloads"`
+}
+
+// ImageAvailability is the availability of a tracked image, named by Name.
+type ImageAvailability struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   ImageAvailabilitySpec   `json:"spec"`
+	Status ImageAvailabilityStatus `json:"status"`
+}
+
+type ImageAvailabilityList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ImageAvailability `json:"items"`
+}
+
+// Name returns the name of the ImageAvailability object of an image. Image references aren't valid object names, so
+// the name is derived from the SHA-256 of the reference. Objects can be listed by image with the "spec.image" field
+// selector as well.
+func Name(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return "sha256-" + hex.EncodeToString(sum[:16])
+}
+
+// Handler serves API discovery under Path, and get and list requests of ImageAvailability objects. Field selectors
+// of "metadata.name", "spec.image" and "status.availabilityMode" are supported, watches aren't.
+func Handler(rangeContainers rangeContainersFunc) http.Handler {
+	groupVersionPath := Group + "/" + Version
+	resourcePath := groupVersionPath + "/" + Resource
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, Path), "/")
+
+		if r.Method != http.MethodGet {
+			writeError(w, apierrors.NewMethodNotSupported(GroupVersion.WithResource(Resource).GroupResource(), strings.ToLower(r.Method)))
+			return
+		}
+		if watch := r.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+			writeError(w, apierrors.NewMethodNotSupported(GroupVersion.WithResource(Resource).GroupResource(), "watch"))
+			return
+		}
+
+		name, isObject := strings.CutPrefix(path, resourcePath+"/")
+		switch {
+		case path == "":
+			writeObject(w, &metav1.APIGroupList{
+				TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+				Groups:   []metav1.APIGroup{apiGroup()},
+			})
+		case path == Group:
+			group := apiGroup()
+			group.TypeMeta = metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}
+			writeObject(w, &group)
+		case path == groupVersionPath:
+			writeObject(w, &metav1.APIResourceList{
+				TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+				GroupVersion: GroupVersion.String(),
+				APIResources: []metav1.APIResource{{
+					Name:       Resource,
+					Kind:       Kind,
+					Namespaced: false,
+					Verbs:      metav1.Verbs{"get", "list"},
+				}},
+			})
+		case path == resourcePath:
+			list(w, r, rangeContainers)
+		case isObject && !strings.Contains(name, "/"):
+			get(w, name, rangeContainers)
+		default:
+			writeError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		}
+	})
+}
+
+func apiGroup() metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: GroupVersion.String(), Version: Version}
+	return metav1.APIGroup{Name: Group, Versions: []metav1.GroupVersionForDiscovery{version}, PreferredVersion: version}
+}
+
+func list(w http.ResponseWriter, r *http.Request, rangeContainers rangeContainersFunc) {
+	fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		writeError(w, apierrors.NewBadRequest(fmt.Sprintf("invalid field selector: %v", err)))
+		return
+	}
+	for _, requirement := range fieldSelector.Requirements() {
+		if _, ok := fieldSet(&ImageAvailability{})[requirement.Field]; !ok {
+			writeError(w, apierrors.NewBadRequest(fmt.Sprintf("field selector %q is not supported", requirement.Field)))
+			return
+		}
+	}
+	labelSelector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeError(w, apierrors.NewBadRequest(fmt.Sprintf("invalid label selector: %v", err)))
+		return
+	}
+
+	resp := &ImageAvailabilityList{
+		TypeMeta: metav1.TypeMeta{Kind: Kind + "List", APIVersion: GroupVersion.String()},
+		Items:    []ImageAvailability{},
+	}
+	for _, obj := range collect(rangeContainers, func(string) bool { return true }) {
+		if fieldSelector.Matches(fieldSet(obj)) && labelSelector.Matches(labels.Set(obj.Labels)) {
+			resp.Items = append(resp.Items, *obj)
+		}
+	}
+
+	writeObject(w, resp)
+}
+
+func get(w http.ResponseWriter, name string, rangeContainers rangeContainersFunc) {
+	objs := collect(rangeContainers, func(image string) bool { return Name(image) == name })
+	if len(objs) == 0 {
+		writeError(w, apierrors.NewNotFound(GroupVersion.WithResource(Resource).GroupResource(), name))
+		return
+	}
+
+	writeObject(w, objs[0])
+}
+
+func fieldSet(obj *ImageAvailability) fields.Set {
+	return fields.Set{
+		"metadata.name":           obj.Name,
+		"spec.image":              obj.Spec.Image,
+		"status.availabilityMode": obj.Status.AvailabilityMode,
+	}
+}
+
+// collect returns objects of images matching the filter, sorted by image.
+func collect(rangeContainers rangeContainersFunc, filter func(image string) bool) []*ImageAvailability {
+	objs := make(map[string]*ImageAvailability)
+	rangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+		if !filter(image) {
+			return
+		}
+
+		obj, ok := objs[image]
+		if !ok {
+			obj = &ImageAvailability{
+				TypeMeta:   metav1.TypeMeta{Kind: Kind, APIVersion: GroupVersion.String()},
+				ObjectMeta: metav1.ObjectMeta{Name: Name(image)},
+				Spec:       ImageAvailabilitySpec{Image: image},
+				Status: ImageAvailabilityStatus{
+					Available:        availMode == store.Available,
+					AvailabilityMode: availMode.String(),
+				},
+			}
+			objs[image] = obj
+		}
+		obj.Status.Workloads = append(obj.Status.Workloads, Workload{
+			Namespace: containerInfo.Namespace,
+			Kind:      strings.ToLower(containerInfo.ControllerKind),
+			Name:      containerInfo.ControllerName,
+			Container: containerInfo.Container,
+		})
+	})
+
+	ret := make([]*ImageAvailability, 0, len(objs))
+	for _, obj := range objs {
+		sort.Slice(obj.Status.Workloads, func(i, j int) bool {
+			a, b := obj.Status.Workloads[i], obj.Status.Workloads[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Container < b.Container
+		})
+		ret = append(ret, obj)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Spec.Image < ret[j].Spec.Image })
+
+	return ret
+}
+
+func writeObject(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		logrus.Errorf("Failed to write availability API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	if err := json.NewEncoder(w).Encode(&status); err != nil {
+		logrus.Errorf("Failed to write availability API response: %v", err)
+	}
+}
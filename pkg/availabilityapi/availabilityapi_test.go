@@ -0,0 +1,98 @@
+package availabilityapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func rangeContainers(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+	f("nginx:1.25", store.ContainerInfo{Namespace: "web", ControllerKind: "Deployment", ControllerName: "front", Container: "nginx"}, store.Available)
+	f("registry.example.com/app:v2", store.ContainerInfo{Namespace: "prod", ControllerKind: "StatefulSet", ControllerName: "db", Container: "app"}, store.Absent)
+	f("registry.example.com/app:v2", store.ContainerInfo{Namespace: "dev", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent)
+}
+
+func request(t *testing.T, method, path string, query url.Values, obj interface{}) int {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path+"?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	Handler(rangeContainers).ServeHTTP(w, r)
+
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(obj))
+	return w.Code
+}
+
+func TestHandler_discovery(t *testing.T) {
+	var groups metav1.APIGroupList
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, "/apis", nil, &groups))
+	require.Len(t, groups.Groups, 1)
+	require.Equal(t, "availability.k8s-image-availability-exporter.flant.com/v1alpha1", groups.Groups[0].PreferredVersion.GroupVersion)
+
+	var resources metav1.APIResourceList
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, "/apis/"+Group+"/"+Version, nil, &resources))
+	require.Equal(t, "APIResourceList", resources.Kind)
+	require.Equal(t, []metav1.APIResource{{Name: Resource, Kind: Kind, Verbs: metav1.Verbs{"get", "list"}}}, resources.APIResources)
+}
+
+func TestHandler_list(t *testing.T) {
+	path := "/apis/" + Group + "/" + Version + "/" + Resource
+
+	var list ImageAvailabilityList
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, path, nil, &list))
+	require.Equal(t, "ImageAvailabilityList", list.Kind)
+	require.Len(t, list.Items, 2)
+	require.Equal(t, "nginx:1.25", list.Items[0].Spec.Image)
+
+	list = ImageAvailabilityList{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, path, url.Values{"fieldSelector": {"status.availabilityMode!=available"}}, &list))
+	require.Len(t, list.Items, 1)
+	require.Equal(t, ImageAvailability{
+		TypeMeta:   metav1.TypeMeta{Kind: Kind, APIVersion: GroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: Name("registry.example.com/app:v2")},
+		Spec:       ImageAvailabilitySpec{Image: "registry.example.com/app:v2"},
+		Status: ImageAvailabilityStatus{
+			AvailabilityMode: "absent",
+			Workloads: []Workload{
+				{Namespace: "dev", Kind: "deployment", Name: "app", Container: "app"},
+				{Namespace: "prod", Kind: "statefulset", Name: "db", Container: "app"},
+			},
+		},
+	}, list.Items[0])
+
+	list = ImageAvailabilityList{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, path, url.Values{"fieldSelector": {"spec.image=nginx:1.25"}}, &list))
+	require.Len(t, list.Items, 1)
+	require.True(t, list.Items[0].Status.Available)
+
+	var status metav1.Status
+	require.Equal(t, http.StatusBadRequest, request(t, http.MethodGet, path, url.Values{"fieldSelector": {"spec.registry=docker.io"}}, &status))
+	require.Equal(t, metav1.StatusReasonBadRequest, status.Reason)
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(t, http.MethodGet, path, url.Values{"watch": {"true"}}, &status))
+	require.Equal(t, http.StatusMethodNotAllowed, request(t, http.MethodPost, path, nil, &status))
+}
+
+func TestHandler_get(t *testing.T) {
+	path := "/apis/" + Group + "/" + Version + "/" + Resource + "/"
+
+	var obj ImageAvailability
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, path+Name("nginx:1.25"), nil, &obj))
+	require.Equal(t, "nginx:1.25", obj.Spec.Image)
+	require.Equal(t, []Workload{{Namespace: "web", Kind: "deployment", Name: "front", Container: "nginx"}}, obj.Status.Workloads)
+
+	var status metav1.Status
+	require.Equal(t, http.StatusNotFound, request(t, http.MethodGet, path+Name("nginx:1.26"), nil, &status))
+	require.Equal(t, metav1.StatusReasonNotFound, status.Reason)
+	require.Equal(t, "Status", status.Kind)
+
+	require.Equal(t, http.StatusNotFound, request(t, http.MethodGet, path+Name("nginx:1.25")+"/status", nil, &status))
+}
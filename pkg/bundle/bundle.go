@@ -0,0 +1,236 @@
+// Package bundle verifies that images of a cluster are present in an offline bundle, e.g. before an upgrade of an
+// air-gapped cluster, so that missing images are found before the bundle is carried over.
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/inventory"
+)
+
+const (
+	// ociIndexFile is the index of an OCI image layout.
+	ociIndexFile = "index.json"
+	// dockerManifestFile lists images of a "docker save" archive.
+	dockerManifestFile = "manifest.json"
+
+	// annotationRefName is the OCI annotation with the name of an image, either a full reference or just a tag.
+	annotationRefName = "org.opencontainers.image.ref.name"
+	// annotationContainerdImageName is the annotation with the full reference containerd and Docker add.
+	annotationContainerdImageName = "io.containerd.image.name"
+)
+
+var errUnknownFormat = errors.New("neither an OCI image layout nor a Docker archive")
+
+// Bundle is the set of images in an OCI image layout or a Docker archive.
+type Bundle struct {
+	path string
+	// tags are full names of tagged images.
+	tags map[string]bool
+	// digests are full names of images by their manifest digests.
+	digests map[string]bool
+	// unnamedDigests are manifest digests of images only named with a tag or not named at all, which digest-pinned
+	// images of any repository match.
+	unnamedDigests map[string]bool
+}
+
+// Load reads the list of images of an OCI image layout directory, or a tar archive of either an OCI image layout or
+// a "docker save" archive, optionally gzipped. Images are named with the options, so that names given without a
+// registry match the images of the cluster.
+func Load(bundlePath string, opts ...name.Option) (*Bundle, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ociIndex, dockerManifest []byte
+	if info.IsDir() {
+		ociIndex, err = os.ReadFile(filepath.Join(bundlePath, ociIndexFile))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ociIndex, dockerManifest, err = readArchive(bundlePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ociIndex == nil && dockerManifest == nil {
+		return nil, fmt.Errorf("%s: %w", bundlePath, errUnknownFormat)
+	}
+
+	b := &Bundle{path: bundlePath, tags: map[string]bool{}, digests: map[string]bool{}, unnamedDigests: map[string]bool{}}
+	// Archives of recent Docker versions are OCI image layouts that have a Docker manifest as well.
+	if ociIndex != nil {
+		if err := b.addOCIIndex(ociIndex, opts); err != nil {
+			return nil, fmt.Errorf("%s: %w", ociIndexFile, err)
+		}
+	}
+	if dockerManifest != nil {
+		if err := b.addDockerManifest(dockerManifest, opts); err != nil {
+			return nil, fmt.Errorf("%s: %w", dockerManifestFile, err)
+		}
+	}
+
+	return b, nil
+}
+
+func readArchive(archivePath string) (ociIndex, dockerManifest []byte, err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var archive io.Reader = r
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return ociIndex, dockerManifest, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", archivePath, err)
+		}
+
+		switch path.Clean(strings.TrimPrefix(hdr.Name, "./")) {
+		case ociIndexFile:
+			ociIndex, err = io.ReadAll(tr)
+		case dockerManifestFile:
+			dockerManifest, err = io.ReadAll(tr)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (b *Bundle) addOCIIndex(data []byte, opts []name.Option) error {
+	index, err := v1.ParseIndexManifest(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		digest := desc.Digest.String()
+
+		refName := desc.Annotations[annotationContainerdImageName]
+		if len(refName) == 0 {
+			refName = desc.Annotations[annotationRefName]
+		}
+		// Per the OCI spec, the name may be just a tag, which doesn't tell the repository.
+		if !strings.ContainsAny(refName, ":/@") {
+			b.unnamedDigests[digest] = true
+			continue
+		}
+
+		ref, err := name.ParseReference(refName, opts...)
+		if err != nil {
+			return fmt.Errorf("image %q: %w", refName, err)
+		}
+		if tag, ok := ref.(name.Tag); ok {
+			b.tags[tag.Name()] = true
+		}
+		b.digests[ref.Context().Digest(digest).Name()] = true
+	}
+
+	return nil
+}
+
+func (b *Bundle) addDockerManifest(data []byte, opts []name.Option) error {
+	var manifest tarball.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+
+	// Docker archives don't keep manifest digests of images, so only tags are matched.
+	for _, desc := range manifest {
+		for _, repoTag := range desc.RepoTags {
+			tag, err := name.NewTag(repoTag, opts...)
+			if err != nil {
+				return fmt.Errorf("image %q: %w", repoTag, err)
+			}
+			b.tags[tag.Name()] = true
+		}
+	}
+
+	return nil
+}
+
+// Contains reports whether the image is in the bundle. Tagged images match images with the same tag, digest-pinned
+// images match images with the same manifest digest.
+func (b *Bundle) Contains(ref name.Reference) bool {
+	switch ref := ref.(type) {
+	case name.Tag:
+		return b.tags[ref.Name()]
+	case name.Digest:
+		return b.digests[ref.Name()] || b.unnamedDigests[ref.DigestStr()]
+	}
+
+	return false
+}
+
+// MissingImage is an image of the cluster missing from the bundle, along with workloads that use it.
+type MissingImage struct {
+	Image     string               `json:"image"`
+	Error     string               `json:"error,omitempty"`
+	Workloads []inventory.Workload `json:"workloads"`
+}
+
+// Report lists images of the cluster missing from the bundle.
+type Report struct {
+	Bundle  string         `json:"bundle"`
+	Present int            `json:"present"`
+	Missing []MissingImage `json:"missing"`
+}
+
+// Verify checks that every image of the inventory is in the bundle. Images that can't be parsed are reported as
+// missing with the parsing error.
+func (b *Bundle) Verify(images inventory.Report, opts ...name.Option) Report {
+	report := Report{Bundle: b.path, Missing: []MissingImage{}}
+	for _, img := range images.Images {
+		ref, err := name.ParseReference(img.Image, opts...)
+		if err != nil {
+			report.Missing = append(report.Missing, MissingImage{Image: img.Image, Error: err.Error(), Workloads: img.Workloads})
+			continue
+		}
+		if !b.Contains(ref) {
+			report.Missing = append(report.Missing, MissingImage{Image: img.Image, Workloads: img.Workloads})
+			continue
+		}
+		report.Present++
+	}
+
+	return report
+}
+
+func (r Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,117 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/inventory"
+)
+
+const (
+	digestA = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	digestB = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	digestC = "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+)
+
+const ociIndex = `{
+  "schemaVersion": 2,
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.index.v1+json", "digest": "` + digestA + `", "size": 1,
+     "annotations": {"io.containerd.image.name": "docker.io/library/nginx:1.25", "org.opencontainers.image.ref.name": "1.25"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + digestB + `", "size": 1,
+     "annotations": {"org.opencontainers.image.ref.name": "v1"}}
+  ]
+}`
+
+const dockerManifest = `[{"Config": "config.json", "RepoTags": ["registry.example.com/app:v2"], "Layers": []}]`
+
+func writeArchive(t *testing.T, path string, gzipped bool, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var (
+		w  io.Writer = f
+		gz *gzip.Writer
+	)
+	if gzipped {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for fileName, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: fileName, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gz != nil {
+		require.NoError(t, gz.Close())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	layoutDir := filepath.Join(dir, "layout")
+	require.NoError(t, os.Mkdir(layoutDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(layoutDir, "index.json"), []byte(ociIndex), 0o644))
+
+	dockerArchive := filepath.Join(dir, "images.tar.gz")
+	writeArchive(t, dockerArchive, true, map[string]string{"./index.json": ociIndex, "manifest.json": dockerManifest})
+
+	emptyArchive := filepath.Join(dir, "empty.tar")
+	writeArchive(t, emptyArchive, false, map[string]string{"README": "nothing"})
+	_, err := Load(emptyArchive)
+	require.ErrorIs(t, err, errUnknownFormat)
+
+	for _, bundlePath := range []string{layoutDir, dockerArchive} {
+		b, err := Load(bundlePath)
+		require.NoError(t, err, bundlePath)
+
+		for image, contains := range map[string]bool{
+			"nginx:1.25":                  true,
+			"nginx@" + digestA:            true,
+			"nginx:1.24":                  false,
+			"other@" + digestA:            false,
+			"any/repository@" + digestB:   true,
+			"nginx@" + digestC:            false,
+			"registry.example.com/app:v2": bundlePath == dockerArchive,
+		} {
+			ref, err := name.ParseReference(image)
+			require.NoError(t, err)
+			require.Equal(t, contains, b.Contains(ref), "%s in %s", image, bundlePath)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), []byte(ociIndex), 0o644))
+
+	b, err := Load(dir)
+	require.NoError(t, err)
+
+	workloads := []inventory.Workload{{Namespace: "prod", Kind: "deployment", Name: "web", Container: "nginx"}}
+	report := b.Verify(inventory.Report{Images: []inventory.Image{
+		{Image: "nginx:1.25", Workloads: workloads},
+		{Image: "nginx:1.24", Workloads: workloads},
+		{Image: "Nginx:latest", Workloads: workloads},
+	}})
+
+	require.Equal(t, dir, report.Bundle)
+	require.Equal(t, 1, report.Present)
+	require.Len(t, report.Missing, 2)
+	require.Equal(t, MissingImage{Image: "nginx:1.24", Workloads: workloads}, report.Missing[0])
+	require.Equal(t, "Nginx:latest", report.Missing[1].Image)
+	require.NotEmpty(t, report.Missing[1].Error)
+}
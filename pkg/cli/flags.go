@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// NormalizeArgs rewrites single-dash long flags like "-check-interval=1m", which the exporter accepted before it
+// switched to POSIX-style flags, to their double-dash form, so that existing manifests keep working. Values of
+// non-boolean flags given as separate arguments and arguments after "--" are left intact.
+func NormalizeArgs(args []string, flags *pflag.FlagSet) []string {
+	ret := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(ret, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "-") {
+			ret = append(ret, arg)
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		flag := flags.Lookup(name)
+		// The help flag is only added once the command is executed.
+		if flag == nil && name != "help" {
+			ret = append(ret, arg)
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			arg = "-" + arg
+		}
+		ret = append(ret, arg)
+
+		// The value of a non-boolean flag may be the next argument, even if it starts with a dash.
+		if flag != nil && !hasValue && len(flag.NoOptDefVal) == 0 && i+1 < len(args) {
+			i++
+			ret = append(ret, args[i])
+		}
+	}
+
+	return ret
+}
+
+// EnvName returns the name of the environment variable of the flag, e.g. "K8S_IMAGE_AVAILABILITY_EXPORTER_CHECK_INTERVAL"
+// for the "check-interval" flag and the "K8S_IMAGE_AVAILABILITY_EXPORTER" prefix.
+func EnvName(prefix, flagName string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// BindEnv sets flags that weren't given on the command line from their environment variables, so that every option
+// can be set through the environment, e.g. from a ConfigMap in a Helm chart. Flags given on the command line take
+// precedence.
+func BindEnv(flags *pflag.FlagSet, prefix string) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+
+		envName := EnvName(prefix, flag.Name)
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", envName, setErr)
+		}
+	})
+
+	return err
+}
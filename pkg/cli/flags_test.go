@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFlagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Duration("check-interval", time.Minute, "")
+	flags.String("ignored-images", "", "")
+	flags.Bool("secretless", false, "")
+	flags.Int("kube-api-burst", 10, "")
+
+	return flags
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	flags := newTestFlagSet()
+
+	require.Equal(t,
+		[]string{"generate", "inventory", "--check-interval=2m", "--secretless", "--ignored-images", "-foo", "--kube-api-burst", "20", "-h", "--help", "--", "-secretless"},
+		NormalizeArgs([]string{"generate", "inventory", "-check-interval=2m", "-secretless", "-ignored-images", "-foo", "--kube-api-burst", "20", "-h", "-help", "--", "-secretless"}, flags),
+	)
+}
+
+func TestBindEnv(t *testing.T) {
+	flags := newTestFlagSet()
+	require.NoError(t, flags.Parse([]string{"--ignored-images=from-flag"}))
+
+	t.Setenv("KIAE_CHECK_INTERVAL", "5m")
+	t.Setenv("KIAE_IGNORED_IMAGES", "from-env")
+	t.Setenv("KIAE_SECRETLESS", "true")
+	require.NoError(t, BindEnv(flags, "KIAE"))
+
+	checkInterval, err := flags.GetDuration("check-interval")
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, checkInterval)
+
+	ignoredImages, err := flags.GetString("ignored-images")
+	require.NoError(t, err)
+	require.Equal(t, "from-flag", ignoredImages, "flags take precedence")
+
+	secretless, err := flags.GetBool("secretless")
+	require.NoError(t, err)
+	require.True(t, secretless)
+
+	t.Setenv("KIAE_KUBE_API_BURST", "many")
+	require.ErrorContains(t, BindEnv(newTestFlagSet(), "KIAE"), "KIAE_KUBE_API_BURST")
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config holds settings that don't fit into command-line flags, e.g. per-registry ones. It may contain secrets and
+// is usually mounted from a Secret.
+type Config struct {
+	Registries           []Registry            `json:"registries,omitempty"`
+	NodePools            []NodePool            `json:"nodePools,omitempty"`
+	ImageRewrites        []ImageRewrite        `json:"imageRewrites,omitempty"`
+	WorkloadMetricLabels []WorkloadMetricLabel `json:"workloadMetricLabels,omitempty"`
+}
+
+// Registry configures requests to registries matching Host.
+type Registry struct {
+	// Host is a registry host with an optional port, or a pattern in the path.Match syntax, e.g. "*.example.com".
+	Host string `json:"host"`
+	// Headers are set on every request to the registry, e.g. ones required by a gateway in front of it.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Username and Password are used for registries that no workload has pull secrets for, e.g. mirrors of public
+	// images, instead of accessing them anonymously.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// ManifestGetFallback makes checks retry with a manifest GET request if a HEAD request is rejected as
+	// unauthenticated, for registries that only authenticate GET requests, e.g. older Nexus and Artifactory versions.
+	ManifestGetFallback bool `json:"manifestGetFallback,omitempty"`
+	// ServerName overrides the TLS server name sent with SNI and verified in the certificate of the registry, e.g.
+	// for a gateway in front of it that routes by SNI.
+	ServerName string `json:"serverName,omitempty"`
+	// HostHeader overrides the Host header of requests to the registry.
+	HostHeader string `json:"hostHeader,omitempty"`
+	// DialAddress is the address connections to the registry are made to instead of its host, either "host:port",
+	// e.g. of a NodePort or a forwarder on the node, or "unix:///path/to/socket". Environment variables like
+	// "${HOST_IP}" are expanded.
+	DialAddress string `json:"dialAddress,omitempty"`
+	// Redirects is how redirects of requests to the registry, e.g. of manifests or blobs to presigned URLs of object
+	// storage, are handled, one of the Redirects* values. Redirects are followed by default.
+	Redirects string `json:"redirects,omitempty"`
+	// TokenEndpoint overrides the realm of the authentication challenge of the registry, for pull-through caches that
+	// serve /v2/ but whose tokens are issued by another host, or that don't send a challenge at all.
+	TokenEndpoint string `json:"tokenEndpoint,omitempty"`
+	// TokenService overrides the service tokens are requested for, the service of the challenge is kept by default.
+	TokenService string `json:"tokenService,omitempty"`
+}
+
+const (
+	// RedirectsFollow follows redirects, the Authorization header is only dropped for hosts outside of the domain of
+	// the registry.
+	RedirectsFollow = "follow"
+	// RedirectsStripAuthorization follows redirects, but never sends the Authorization header and configured headers
+	// to hosts other than the registry, since presigned URLs carry their own authorization.
+	RedirectsStripAuthorization = "stripAuthorization"
+	// RedirectsNone doesn't follow redirects.
+	RedirectsNone = "none"
+)
+
+// NodePool configures checks of images of DaemonSets restricted to a pool of nodes, e.g. GPU nodes that pull images
+// from a different registry or have a different architecture.
+type NodePool struct {
+	Name string `json:"name"`
+	// NodeSelector selects DaemonSets whose pod template node selector includes all of the labels.
+	NodeSelector map[string]string `json:"nodeSelector"`
+	// Mirrors replace image prefixes, as written in the pod template, before checking images.
+	Mirrors []Mirror `json:"mirrors,omitempty"`
+	// Platform like "linux/arm64" overrides the platform manifest lists are resolved for.
+	Platform string `json:"platform,omitempty"`
+}
+
+// Mirror replaces the From image prefix with To.
+type Mirror struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImageRewrite maps images referenced in pod templates to the location they are actually pulled from, e.g. if an
+// admission controller rewrites images of pods.
+type ImageRewrite struct {
+	// Pattern is a regular expression matched against the image as written in the pod template.
+	Pattern string `json:"pattern"`
+	// Replacement replaces matches of Pattern and may refer to its submatches, e.g. "mirror.example.com/gcr/$1".
+	Replacement string `json:"replacement"`
+}
+
+// WorkloadMetricLabel adds the value of a label or an annotation of workloads to availability metrics, e.g. to route
+// alerts by team.
+type WorkloadMetricLabel struct {
+	// Name is the name of the metric label.
+	Name string `json:"name"`
+	// Either Label or Annotation is the key of the workload label or annotation the value is taken from.
+	Label      string `json:"label,omitempty"`
+	Annotation string `json:"annotation,omitempty"`
+	// AllowedValues bound the cardinality of the metric label, other values are exported as OtherValue.
+	AllowedValues []string `json:"allowedValues"`
+}
+
+// OtherValue is the value of workload metric labels whose workload value isn't allowed.
+const OtherValue = "other"
+
+var (
+	metricLabelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedMetricLabels are labels of availability metrics, labels of -workload-labels are prefixed with "label_".
+	reservedMetricLabels = map[string]struct{}{
+		"namespace": {}, "container": {}, "kind": {}, "name": {}, "image": {}, "original_image": {},
+	}
+)
+
+// Value returns the value of the metric label for a workload with the labels and annotations, it's empty if the
+// workload doesn't have the label or the annotation.
+func (l WorkloadMetricLabel) Value(labels, annotations map[string]string) string {
+	value := labels[l.Label]
+	if len(l.Annotation) > 0 {
+		value = annotations[l.Annotation]
+	}
+	if len(value) == 0 {
+		return ""
+	}
+
+	for _, allowed := range l.AllowedValues {
+		if value == allowed {
+			return value
+		}
+	}
+
+	return OtherValue
+}
+
+// Selects reports whether a pod template node selector restricts pods to the pool.
+func (p NodePool) Selects(nodeSelector map[string]string) bool {
+	for key, value := range p.NodeSelector {
+		if v, ok := nodeSelector[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Rewrite replaces the prefix of the image with the first matching mirror. The prefix must end at a path, tag or
+// digest boundary, so "registry.example.com/app" doesn't match "registry.example.com/application".
+func (p NodePool) Rewrite(image string) string {
+	for _, mirror := range p.Mirrors {
+		rest, ok := strings.CutPrefix(image, mirror.From)
+		if !ok {
+			continue
+		}
+		if len(rest) == 0 || strings.ContainsRune("/:@", rune(rest[0])) {
+			return mirror.To + rest
+		}
+	}
+
+	return image
+}
+
+// ExpandedDialAddress returns DialAddress with environment variables expanded.
+func (r Registry) ExpandedDialAddress() string {
+	return os.ExpandEnv(r.DialAddress)
+}
+
+// HasCredentials reports whether static credentials are configured for the registry.
+func (r Registry) HasCredentials() bool {
+	return len(r.Username) > 0
+}
+
+// Load reads and validates a YAML config file. Unknown fields are rejected to catch typos.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	for i, registry := range c.Registries {
+		if len(registry.Host) == 0 {
+			return fmt.Errorf("registries[%d]: empty host", i)
+		}
+		if _, err := path.Match(registry.Host, ""); err != nil {
+			return fmt.Errorf("registries[%d]: invalid host pattern %q: %w", i, registry.Host, err)
+		}
+
+		if (len(registry.Username) > 0) != (len(registry.Password) > 0) {
+			return fmt.Errorf("registries[%d]: both username and password must be set", i)
+		}
+
+		if len(registry.DialAddress) > 0 {
+			if err := validateDialAddress(registry.DialAddress); err != nil {
+				return fmt.Errorf("registries[%d]: invalid dial address %q: %w", i, registry.DialAddress, err)
+			}
+		}
+
+		switch registry.Redirects {
+		case "", RedirectsFollow, RedirectsStripAuthorization, RedirectsNone:
+		default:
+			return fmt.Errorf("registries[%d]: redirects must be one of %q, %q or %q", i, RedirectsFollow, RedirectsStripAuthorization, RedirectsNone)
+		}
+
+		if len(registry.TokenEndpoint) > 0 {
+			u, err := url.Parse(registry.TokenEndpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return fmt.Errorf("registries[%d]: tokenEndpoint must be an absolute http or https URL", i)
+			}
+		} else if len(registry.TokenService) > 0 {
+			return fmt.Errorf("registries[%d]: tokenService requires tokenEndpoint", i)
+		}
+
+		for name := range registry.Headers {
+			if len(name) == 0 || strings.ContainsAny(name, " \t\r\n:") {
+				return fmt.Errorf("registries[%d]: invalid header name %q", i, name)
+			}
+			switch http.CanonicalHeaderKey(name) {
+			case "Authorization":
+				return fmt.Errorf("registries[%d]: the Authorization header is set by the exporter and can't be overridden", i)
+			case "Host":
+				return fmt.Errorf("registries[%d]: the Host header can't be set among headers, use hostHeader", i)
+			}
+		}
+	}
+
+	names := make(map[string]struct{})
+	for i, pool := range c.NodePools {
+		if len(pool.Name) == 0 {
+			return fmt.Errorf("nodePools[%d]: empty name", i)
+		}
+		if _, ok := names[pool.Name]; ok {
+			return fmt.Errorf("nodePools[%d]: duplicate name %q", i, pool.Name)
+		}
+		names[pool.Name] = struct{}{}
+
+		if len(pool.NodeSelector) == 0 {
+			return fmt.Errorf("nodePools[%d]: empty node selector", i)
+		}
+
+		for j, mirror := range pool.Mirrors {
+			if len(mirror.From) == 0 || len(mirror.To) == 0 {
+				return fmt.Errorf("nodePools[%d].mirrors[%d]: both from and to must be set", i, j)
+			}
+		}
+
+		if len(pool.Platform) > 0 {
+			platform, err := v1.ParsePlatform(pool.Platform)
+			if err != nil {
+				return fmt.Errorf("nodePools[%d]: invalid platform: %w", i, err)
+			}
+			if len(platform.OS) == 0 || len(platform.Architecture) == 0 {
+				return fmt.Errorf("nodePools[%d]: platform %q must have both OS and architecture", i, pool.Platform)
+			}
+		}
+	}
+
+	for i, rewrite := range c.ImageRewrites {
+		if len(rewrite.Pattern) == 0 || len(rewrite.Replacement) == 0 {
+			return fmt.Errorf("imageRewrites[%d]: both pattern and replacement must be set", i)
+		}
+		if _, err := regexp.Compile(rewrite.Pattern); err != nil {
+			return fmt.Errorf("imageRewrites[%d]: invalid pattern: %w", i, err)
+		}
+	}
+
+	metricLabels := make(map[string]struct{})
+	for i, label := range c.WorkloadMetricLabels {
+		if !metricLabelNameRegex.MatchString(label.Name) || strings.HasPrefix(label.Name, "__") {
+			return fmt.Errorf("workloadMetricLabels[%d]: invalid metric label name %q", i, label.Name)
+		}
+		if _, ok := reservedMetricLabels[label.Name]; ok || strings.HasPrefix(label.Name, "label_") {
+			return fmt.Errorf("workloadMetricLabels[%d]: metric label %q is reserved", i, label.Name)
+		}
+		if _, ok := metricLabels[label.Name]; ok {
+			return fmt.Errorf("workloadMetricLabels[%d]: duplicate metric label %q", i, label.Name)
+		}
+		metricLabels[label.Name] = struct{}{}
+
+		if (len(label.Label) > 0) == (len(label.Annotation) > 0) {
+			return fmt.Errorf("workloadMetricLabels[%d]: exactly one of label and annotation must be set", i)
+		}
+		if len(label.AllowedValues) == 0 {
+			return fmt.Errorf("workloadMetricLabels[%d]: allowedValues must be set to bound the cardinality of the metric label", i)
+		}
+	}
+
+	return nil
+}
+
+func validateDialAddress(address string) error {
+	if socketPath, ok := strings.CutPrefix(address, "unix://"); ok {
+		if !path.IsAbs(socketPath) {
+			return fmt.Errorf("socket path %q must be absolute", socketPath)
+		}
+		return nil
+	}
+
+	_, _, err := net.SplitHostPort(address)
+	return err
+}
+
+// Match returns the settings of the first registry matching the host, if any.
+func (c *Config) Match(host string) (Registry, bool) {
+	if c == nil {
+		return Registry{}, false
+	}
+
+	for _, registry := range c.Registries {
+		if ok, _ := path.Match(registry.Host, host); ok {
+			return registry, true
+		}
+	}
+
+	return Registry{}, false
+}
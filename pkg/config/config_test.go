@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	config, err := Load(writeConfig(t, `
+registries:
+- host: registry.example.com
+  headers:
+    X-Org-Token: secret
+- host: "*.example.com"
+`))
+	require.NoError(t, err)
+
+	registry, ok := config.Match("registry.example.com")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"X-Org-Token": "secret"}, registry.Headers)
+
+	registry, ok = config.Match("mirror.example.com")
+	require.True(t, ok)
+	require.Empty(t, registry.Headers)
+
+	_, ok = config.Match("docker.io")
+	require.False(t, ok)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	for _, content := range []string{
+		"registry:\n- host: registry.example.com\n",
+		"registries:\n- headers:\n    X-Org-Token: secret\n",
+		"registries:\n- host: \"[\"\n",
+		"registries:\n- host: registry.example.com\n  headers:\n    authorization: secret\n",
+		"registries:\n- host: registry.example.com\n  headers:\n    host: gateway.example.com\n",
+		"registries:\n- host: registry.example.com\n  username: mirror\n",
+		"registries:\n- host: localhost:5000\n  dialAddress: unix://registry.sock\n",
+		"registries:\n- host: localhost:5000\n  dialAddress: registry-cache\n",
+		"registries:\n- host: registry.example.com\n  redirects: strip\n",
+		"registries:\n- host: registry.example.com\n  tokenEndpoint: auth.example.com/token\n",
+		"registries:\n- host: registry.example.com\n  tokenService: registry.example.com\n",
+		"nodePools:\n- nodeSelector:\n    pool: gpu\n",
+		"nodePools:\n- name: gpu\n",
+		"nodePools:\n- name: gpu\n  nodeSelector:\n    pool: gpu\n  mirrors:\n  - from: registry.example.com\n",
+		"nodePools:\n- name: gpu\n  nodeSelector:\n    pool: gpu\n  platform: linux\n",
+		"imageRewrites:\n- pattern: \"^gcr.io/(.*)\"\n",
+		"imageRewrites:\n- pattern: \"^gcr.io/(.*\"\n  replacement: mirror.example.com/gcr/$1\n",
+	} {
+		_, err := Load(writeConfig(t, content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestNodePool(t *testing.T) {
+	config, err := Load(writeConfig(t, `
+nodePools:
+- name: gpu
+  nodeSelector:
+    node.example.com/pool: gpu
+  mirrors:
+  - from: registry.example.com/nvidia
+    to: gpu-registry.example.com/nvidia
+  platform: linux/arm64
+`))
+	require.NoError(t, err)
+	require.Len(t, config.NodePools, 1)
+	pool := config.NodePools[0]
+
+	require.True(t, pool.Selects(map[string]string{"node.example.com/pool": "gpu", "kubernetes.io/os": "linux"}))
+	require.False(t, pool.Selects(map[string]string{"node.example.com/pool": "cpu"}))
+	require.False(t, pool.Selects(nil))
+
+	require.Equal(t, "gpu-registry.example.com/nvidia/dcgm-exporter:3.3", pool.Rewrite("registry.example.com/nvidia/dcgm-exporter:3.3"))
+	require.Equal(t, "gpu-registry.example.com/nvidia:1.0", pool.Rewrite("registry.example.com/nvidia:1.0"))
+	require.Equal(t, "registry.example.com/nvidia-driver:1.0", pool.Rewrite("registry.example.com/nvidia-driver:1.0"))
+	require.Equal(t, "docker.io/library/busybox:1.36", pool.Rewrite("docker.io/library/busybox:1.36"))
+}
+
+func TestWorkloadMetricLabel(t *testing.T) {
+	config, err := Load(writeConfig(t, `
+workloadMetricLabels:
+- name: team
+  annotation: example.com/team
+  allowedValues: [payments, search]
+- name: owner
+  label: owner
+  allowedValues: [alice]
+`))
+	require.NoError(t, err)
+	require.Len(t, config.WorkloadMetricLabels, 2)
+	team, owner := config.WorkloadMetricLabels[0], config.WorkloadMetricLabels[1]
+
+	labels := map[string]string{"owner": "bob", "team": "search"}
+	annotations := map[string]string{"example.com/team": "payments"}
+	require.Equal(t, "payments", team.Value(labels, annotations))
+	require.Equal(t, OtherValue, owner.Value(labels, annotations))
+	require.Equal(t, "", team.Value(labels, nil))
+
+	for _, content := range []string{
+		"workloadMetricLabels:\n- name: team\n  annotation: example.com/team\n",
+		"workloadMetricLabels:\n- name: team\n  allowedValues: [payments]\n",
+		"workloadMetricLabels:\n- name: team\n  label: team\n  annotation: example.com/team\n  allowedValues: [payments]\n",
+		"workloadMetricLabels:\n- name: namespace\n  label: team\n  allowedValues: [payments]\n",
+		"workloadMetricLabels:\n- name: label_team\n  label: team\n  allowedValues: [payments]\n",
+		"workloadMetricLabels:\n- name: example.com/team\n  label: team\n  allowedValues: [payments]\n",
+		"workloadMetricLabels:\n- name: team\n  label: team\n  allowedValues: [payments]\n- name: team\n  label: owner\n  allowedValues: [alice]\n",
+	} {
+		_, err := Load(writeConfig(t, content))
+		require.Error(t, err, content)
+	}
+}
@@ -0,0 +1,335 @@
+// Package flux checks images of Flux HelmReleases and Kustomizations that aren't reconciled yet, so that GitOps
+// promotions referencing unavailable images fail fast, before the workloads are updated.
+package flux
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var predeployAvailableDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_predeploy_image_available",
+	"Non-zero indicates that an image of a Flux HelmRelease or Kustomization pending reconciliation is available, availability_mode is the result of its check.",
+	[]string{"kind", "namespace", "name", "image", "availability_mode"}, nil,
+)
+
+const (
+	KindHelmRelease   = "HelmRelease"
+	KindKustomization = "Kustomization"
+)
+
+// Resources are the Flux resources images are inferred from, in the order of preference of their API versions. Flux
+// releases before 2.3 only serve the beta versions of HelmReleases, and 2.0 and later serve v1 Kustomizations.
+var Resources = map[string][]schema.GroupVersionResource{
+	KindHelmRelease: {
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Resource: "helmreleases"},
+	},
+	KindKustomization: {
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"},
+	},
+}
+
+// Result is the availability of an image of an object pending reconciliation.
+type Result struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Image     string
+	Mode      string
+}
+
+// Prechecker checks images inferred from Flux objects pending reconciliation. Charts aren't rendered and
+// kustomizations aren't built, images are inferred from values of HelmReleases and image overrides of
+// Kustomizations, which is where promotions usually change them.
+type Prechecker struct {
+	client   dynamic.Interface
+	simulate manifests.SimulateFunc
+
+	// served is the API version found to be served per kind, and unserved the kinds none of the versions were found
+	// for, so that's only logged once. Both are only accessed by Sync.
+	served   map[string]schema.GroupVersionResource
+	unserved map[string]bool
+
+	lock    sync.Mutex
+	results []Result
+}
+
+func New(client dynamic.Interface, simulate manifests.SimulateFunc) *Prechecker {
+	return &Prechecker{
+		client:   client,
+		simulate: simulate,
+		served:   make(map[string]schema.GroupVersionResource),
+		unserved: make(map[string]bool),
+	}
+}
+
+// Run checks images of pending objects every interval until stopCh is closed.
+func (p *Prechecker) Run(interval time.Duration, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := p.Sync(context.TODO()); err != nil {
+			logrus.Warnf("Failed to check images of pending Flux objects: %v", err)
+		}
+	}, interval, stopCh)
+}
+
+// Sync checks images of objects pending reconciliation and replaces the results of the previous sync.
+func (p *Prechecker) Sync(ctx context.Context) error {
+	var results []Result
+	for _, kind := range []string{KindHelmRelease, KindKustomization} {
+		list, err := p.list(ctx, kind)
+		if err != nil {
+			return fmt.Errorf("failed to list %ss: %w", kind, err)
+		}
+		if list == nil {
+			continue
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if !Pending(obj) {
+				continue
+			}
+
+			images := Images(kind, obj)
+			if len(images) == 0 {
+				continue
+			}
+
+			spec := corev1.PodSpec{}
+			for j, image := range images {
+				spec.Containers = append(spec.Containers, corev1.Container{Name: fmt.Sprintf("image-%d", j), Image: image})
+			}
+			for _, container := range p.simulate(targetNamespace(obj), spec) {
+				results = append(results, Result{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName(), Image: container.Image, Mode: container.Mode})
+				if container.Mode != store.Available.String() {
+					logrus.WithFields(logrus.Fields{
+						"kind":              kind,
+						"namespace":         obj.GetNamespace(),
+						"name":              obj.GetName(),
+						"image_name":        container.Image,
+						"availability_mode": container.Mode,
+					}).Warnf("An image of a pending Flux object is unavailable: %s", container.Error)
+				}
+			}
+		}
+	}
+
+	p.lock.Lock()
+	p.results = results
+	p.lock.Unlock()
+
+	return nil
+}
+
+// list lists objects of the kind with the API version found to be served last time, or the first of Resources that
+// is served otherwise, e.g. after Flux was installed or upgraded. It returns nil if Flux or none of the versions are
+// installed.
+func (p *Prechecker) list(ctx context.Context, kind string) (*unstructured.UnstructuredList, error) {
+	if resource, ok := p.served[kind]; ok {
+		list, err := p.client.Resource(resource).List(ctx, metav1.ListOptions{})
+		if !apierrors.IsNotFound(err) {
+			return list, err
+		}
+		delete(p.served, kind)
+	}
+
+	for _, resource := range Resources[kind] {
+		list, err := p.client.Resource(resource).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.served[kind] = resource
+		delete(p.unserved, kind)
+		return list, nil
+	}
+
+	if !p.unserved[kind] {
+		versions := make([]string, 0, len(Resources[kind]))
+		for _, resource := range Resources[kind] {
+			versions = append(versions, resource.GroupVersion().String())
+		}
+		logrus.Infof("Skipping %ss, none of %s are served, Flux may not be installed", kind, strings.Join(versions, ", "))
+		p.unserved[kind] = true
+	}
+
+	return nil, nil
+}
+
+// Results returns the results of the last sync.
+func (p *Prechecker) Results() []Result {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]Result(nil), p.results...)
+}
+
+// Pending reports whether the object changed since its last reconciliation, or its last reconciliation failed.
+func Pending(obj *unstructured.Unstructured) bool {
+	observedGeneration, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if !found || observedGeneration != obj.GetGeneration() {
+		return true
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Ready" {
+			return condition["status"] != string(metav1.ConditionTrue)
+		}
+	}
+
+	return true
+}
+
+// targetNamespace returns the namespace workloads of the object are applied to, pull secrets of its default service
+// account are used for checks.
+func targetNamespace(obj *unstructured.Unstructured) string {
+	if namespace, _, _ := unstructured.NestedString(obj.Object, "spec", "targetNamespace"); len(namespace) > 0 {
+		return namespace
+	}
+
+	return obj.GetNamespace()
+}
+
+// Images returns sorted images inferred from the object: overrides of Kustomizations with a new tag or digest, and
+// values of HelmReleases that are either image references under an "image" key or maps with "repository" and "tag"
+// or "digest" keys, optionally with a "registry" key, as most charts structure them.
+func Images(kind string, obj *unstructured.Unstructured) []string {
+	images := make(map[string]struct{})
+
+	switch kind {
+	case KindKustomization:
+		overrides, _, _ := unstructured.NestedSlice(obj.Object, "spec", "images")
+		for _, o := range overrides {
+			override, ok := o.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if image := overrideImage(override); len(image) > 0 {
+				images[image] = struct{}{}
+			}
+		}
+	case KindHelmRelease:
+		values, _, _ := unstructured.NestedMap(obj.Object, "spec", "values")
+		walkValues(values, images)
+	}
+
+	ret := make([]string, 0, len(images))
+	for image := range images {
+		ret = append(ret, image)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+func overrideImage(override map[string]interface{}) string {
+	name, _ := override["newName"].(string)
+	if len(name) == 0 {
+		name, _ = override["name"].(string)
+	}
+	tag, _ := override["newTag"].(string)
+	digest, _ := override["digest"].(string)
+	if len(name) == 0 || (len(tag) == 0 && len(digest) == 0) {
+		// The tag is whatever the manifests reference.
+		return ""
+	}
+
+	return reference("", name, tag, digest)
+}
+
+func walkValues(values map[string]interface{}, images map[string]struct{}) {
+	if repository, ok := values["repository"].(string); ok {
+		registry, _ := values["registry"].(string)
+		tag := scalarString(values["tag"])
+		digest, _ := values["digest"].(string)
+		if len(repository) > 0 && (len(tag) > 0 || len(digest) > 0) {
+			images[reference(registry, repository, tag, digest)] = struct{}{}
+		}
+	}
+
+	for key, value := range values {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			walkValues(value, images)
+		case []interface{}:
+			for _, item := range value {
+				if m, ok := item.(map[string]interface{}); ok {
+					walkValues(m, images)
+				}
+			}
+		case string:
+			// Images without a tag or digest are ignored, since they usually get one from another value.
+			if key == "image" && strings.ContainsAny(value, ":@") && !strings.Contains(value, "://") {
+				images[value] = struct{}{}
+			}
+		}
+	}
+}
+
+// scalarString returns tags written as numbers in YAML, e.g. 1.25, as strings.
+func scalarString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case int64, float64:
+		return fmt.Sprint(value)
+	}
+
+	return ""
+}
+
+func reference(registry, repository, tag, digest string) string {
+	image := repository
+	if len(registry) > 0 {
+		image = strings.TrimSuffix(registry, "/") + "/" + repository
+	}
+	if len(tag) > 0 {
+		image += ":" + tag
+	}
+	if len(digest) > 0 {
+		image += "@" + digest
+	}
+
+	return image
+}
+
+// Describe implements prometheus.Collector.
+func (p *Prechecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- predeployAvailableDesc
+}
+
+// Collect implements prometheus.Collector.
+func (p *Prechecker) Collect(ch chan<- prometheus.Metric) {
+	for _, result := range p.Results() {
+		var value float64
+		if result.Mode == store.Available.String() {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(predeployAvailableDesc, prometheus.GaugeValue, value,
+			strings.ToLower(result.Kind), result.Namespace, result.Name, result.Image, result.Mode)
+	}
+}
@@ -0,0 +1,155 @@
+package flux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func newObject(kind, name string, generation, observedGeneration int64, ready string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": spec,
+		"status": map[string]interface{}{
+			"observedGeneration": observedGeneration,
+			"conditions":         []interface{}{map[string]interface{}{"type": "Ready", "status": ready}},
+		},
+	}}
+	obj.SetGroupVersionKind(Resources[kind][0].GroupVersion().WithKind(kind))
+	obj.SetNamespace("flux-system")
+	obj.SetName(name)
+	obj.SetGeneration(generation)
+	return obj
+}
+
+func TestImages(t *testing.T) {
+	release := newObject(KindHelmRelease, "app", 1, 1, "True", map[string]interface{}{
+		"values": map[string]interface{}{
+			"image": map[string]interface{}{"registry": "registry.example.com", "repository": "app", "tag": "v2"},
+			"sidecar": map[string]interface{}{
+				"image": "registry.example.com/sidecar:v1",
+			},
+			"workers": []interface{}{
+				map[string]interface{}{"image": map[string]interface{}{"repository": "registry.example.com/worker", "tag": int64(3)}},
+			},
+			// Images without a tag get it from another value.
+			"migrations": map[string]interface{}{"image": "registry.example.com/migrations", "repository": "registry.example.com/migrations"},
+			"url":        "https://example.com",
+		},
+	})
+	require.Equal(t, []string{
+		"registry.example.com/app:v2",
+		"registry.example.com/sidecar:v1",
+		"registry.example.com/worker:3",
+	}, Images(KindHelmRelease, release))
+
+	kustomization := newObject(KindKustomization, "app", 1, 1, "True", map[string]interface{}{
+		"images": []interface{}{
+			map[string]interface{}{"name": "app", "newName": "registry.example.com/app", "newTag": "v2"},
+			map[string]interface{}{"name": "registry.example.com/db", "digest": "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+			map[string]interface{}{"name": "registry.example.com/cache", "newName": "mirror.example.com/cache"},
+		},
+	})
+	require.Equal(t, []string{
+		"registry.example.com/app:v2",
+		"registry.example.com/db@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	}, Images(KindKustomization, kustomization))
+}
+
+func TestPending(t *testing.T) {
+	require.False(t, Pending(newObject(KindHelmRelease, "app", 2, 2, "True", nil)))
+	require.True(t, Pending(newObject(KindHelmRelease, "app", 3, 2, "True", nil)))
+	require.True(t, Pending(newObject(KindHelmRelease, "app", 2, 2, "False", nil)))
+}
+
+func listKinds() map[schema.GroupVersionResource]string {
+	ret := make(map[schema.GroupVersionResource]string)
+	for kind, resources := range Resources {
+		for _, resource := range resources {
+			ret[resource] = kind + "List"
+		}
+	}
+	return ret
+}
+
+func helmReleaseValues(tag string) map[string]interface{} {
+	return map[string]interface{}{
+		"targetNamespace": "prod",
+		"values":          map[string]interface{}{"image": map[string]interface{}{"repository": "registry.example.com/app", "tag": tag}},
+	}
+}
+
+func TestPrechecker_Sync(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds(),
+		newObject(KindHelmRelease, "promoted", 2, 1, "True", helmReleaseValues("v2")),
+		newObject(KindHelmRelease, "reconciled", 1, 1, "True", helmReleaseValues("v1")),
+	)
+
+	var namespaces []string
+	prechecker := New(client, func(namespace string, spec corev1.PodSpec) []registry.SimulatedContainer {
+		namespaces = append(namespaces, namespace)
+		var ret []registry.SimulatedContainer
+		for _, container := range spec.Containers {
+			ret = append(ret, registry.SimulatedContainer{Container: container.Name, Image: container.Image, Mode: store.Absent.String()})
+		}
+		return ret
+	})
+
+	require.NoError(t, prechecker.Sync(context.TODO()))
+	require.Equal(t, []string{"prod"}, namespaces)
+	require.Equal(t, []Result{
+		{Kind: KindHelmRelease, Namespace: "flux-system", Name: "promoted", Image: "registry.example.com/app:v2", Mode: "absent"},
+	}, prechecker.Results())
+	require.Equal(t, 1, testutil.CollectAndCount(prechecker))
+}
+
+func TestPrechecker_SyncServedVersion(t *testing.T) {
+	promoted := newObject(KindHelmRelease, "promoted", 2, 1, "True", helmReleaseValues("v2"))
+	promoted.SetAPIVersion("helm.toolkit.fluxcd.io/v2beta1")
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds(), promoted)
+
+	// Only v2beta1 HelmReleases are served, and no Kustomizations at all.
+	var listed []string
+	client.PrependReactor("list", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		resource := action.GetResource()
+		listed = append(listed, resource.GroupVersion().String())
+		if resource.Version == "v2beta1" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(resource.GroupResource(), "")
+	})
+
+	prechecker := New(client, func(_ string, spec corev1.PodSpec) []registry.SimulatedContainer {
+		var ret []registry.SimulatedContainer
+		for _, container := range spec.Containers {
+			ret = append(ret, registry.SimulatedContainer{Container: container.Name, Image: container.Image, Mode: store.Available.String()})
+		}
+		return ret
+	})
+
+	require.NoError(t, prechecker.Sync(context.TODO()))
+	require.Equal(t, []Result{
+		{Kind: KindHelmRelease, Namespace: "flux-system", Name: "promoted", Image: "registry.example.com/app:v2", Mode: "available"},
+	}, prechecker.Results())
+	require.Equal(t, []string{
+		"helm.toolkit.fluxcd.io/v2", "helm.toolkit.fluxcd.io/v2beta2", "helm.toolkit.fluxcd.io/v2beta1",
+		"kustomize.toolkit.fluxcd.io/v1",
+	}, listed)
+
+	// The served version is listed right away on the next sync.
+	listed = nil
+	require.NoError(t, prechecker.Sync(context.TODO()))
+	require.Equal(t, []string{"helm.toolkit.fluxcd.io/v2beta1", "kustomize.toolkit.fluxcd.io/v1"}, listed)
+	require.True(t, prechecker.unserved[KindKustomization])
+}
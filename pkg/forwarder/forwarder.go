@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Forwarder forwards TCP connections to a target that isn't reachable from other nodes, e.g. a node-local registry
+// listening on localhost or on a unix socket.
+type Forwarder struct {
+	network string
+	address string
+	dialer  net.Dialer
+}
+
+// New creates a forwarder to a target like "127.0.0.1:5000" or "unix:///run/registry.sock".
+func New(target string) *Forwarder {
+	if path, ok := strings.CutPrefix(target, "unix://"); ok {
+		return &Forwarder{network: "unix", address: path}
+	}
+
+	return &Forwarder{network: "tcp", address: target}
+}
+
+// Serve accepts connections until the context is done.
+func (f *Forwarder) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		go f.forward(ctx, conn)
+	}
+}
+
+func (f *Forwarder) forward(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	target, err := f.dialer.DialContext(ctx, f.network, f.address)
+	if err != nil {
+		logrus.WithField("target", f.address).Errorf("Failed to connect to the forwarding target: %v", err)
+		return
+	}
+	defer target.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(target, conn)
+		closeWrite(target)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, target)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+}
+
+// closeWrite signals the end of the stream to the peer while still reading its response.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
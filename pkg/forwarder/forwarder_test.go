@@ -0,0 +1,43 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarder(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "registry.sock")
+	socket, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	registry := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	}))
+	registry.Listener = socket
+	registry.Start()
+	defer registry.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- New("unix://"+socketPath).Serve(ctx, listener) }()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/v2/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(body))
+
+	cancel()
+	require.NoError(t, <-done)
+}
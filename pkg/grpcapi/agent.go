@@ -0,0 +1,113 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+)
+
+// nodeResultTTL is how long results of a node are exported after its agent last reported them. It covers agents
+// that were removed along with their nodes.
+const nodeResultTTL = time.Hour
+
+var nodePullDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_node_pull",
+	"Result of the last verification of a flagged image through the container runtime of a node: present, pulled, pull_failed or error, the value is always 1.",
+	[]string{"image", "node", "result"},
+	nil,
+)
+
+type nodeReport struct {
+	// results are indexed by image.
+	results  map[string]*availabilityv1.NodeImageResult
+	received time.Time
+}
+
+// NodeAgentServer implements the NodeAgentService. It hands flagged images out to node agents and exports the
+// results they report.
+type NodeAgentServer struct {
+	availabilityv1.UnimplementedNodeAgentServiceServer
+
+	flaggedImages func() []string
+	maxImages     int
+
+	lock    sync.Mutex
+	reports map[string]nodeReport
+}
+
+// NewNodeAgentServer creates a server handing at most maxImages of flaggedImages out to each agent, 0 means
+// unlimited.
+func NewNodeAgentServer(flaggedImages func() []string, maxImages int) *NodeAgentServer {
+	return &NodeAgentServer{
+		flaggedImages: flaggedImages,
+		maxImages:     maxImages,
+		reports:       make(map[string]nodeReport),
+	}
+}
+
+// Register registers the service within a gRPC server.
+func (s *NodeAgentServer) Register(grpcServer *grpc.Server) {
+	availabilityv1.RegisterNodeAgentServiceServer(grpcServer, s)
+}
+
+func (s *NodeAgentServer) ListFlaggedImages(_ context.Context, req *availabilityv1.ListFlaggedImagesRequest) (*availabilityv1.ListFlaggedImagesResponse, error) {
+	if len(strings.TrimSpace(req.GetNode())) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "node must not be empty")
+	}
+
+	images := s.flaggedImages()
+	if s.maxImages > 0 && len(images) > s.maxImages {
+		images = images[:s.maxImages]
+	}
+
+	return &availabilityv1.ListFlaggedImagesResponse{Images: images}, nil
+}
+
+func (s *NodeAgentServer) ReportNodeResults(_ context.Context, req *availabilityv1.ReportNodeResultsRequest) (*availabilityv1.ReportNodeResultsResponse, error) {
+	node := strings.TrimSpace(req.GetNode())
+	if len(node) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "node must not be empty")
+	}
+
+	report := nodeReport{results: make(map[string]*availabilityv1.NodeImageResult), received: time.Now()}
+	for _, result := range req.GetResults() {
+		report.results[result.GetImage()] = result
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.reports[node] = report
+
+	return &availabilityv1.ReportNodeResultsResponse{}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (s *NodeAgentServer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodePullDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *NodeAgentServer) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for node, report := range s.reports {
+		if time.Since(report.received) > nodeResultTTL {
+			delete(s.reports, node)
+			continue
+		}
+
+		for image, result := range report.results {
+			ch <- prometheus.MustNewConstMetric(nodePullDesc, prometheus.GaugeValue, 1, image, node, result.GetResult())
+		}
+	}
+}
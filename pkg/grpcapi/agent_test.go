@@ -0,0 +1,43 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+)
+
+func TestNodeAgentServer(t *testing.T) {
+	srv := NewNodeAgentServer(func() []string { return []string{"a:v1", "b:v1", "c:v1"} }, 2)
+	ctx := context.Background()
+
+	_, err := srv.ListFlaggedImages(ctx, &availabilityv1.ListFlaggedImagesRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	flagged, err := srv.ListFlaggedImages(ctx, &availabilityv1.ListFlaggedImagesRequest{Node: "node-1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:v1", "b:v1"}, flagged.GetImages())
+
+	_, err = srv.ReportNodeResults(ctx, &availabilityv1.ReportNodeResultsRequest{
+		Node: "node-1",
+		Results: []*availabilityv1.NodeImageResult{
+			{Image: "a:v1", Result: "pull_failed"},
+			{Image: "b:v1", Result: "pulled"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, testutil.CollectAndCount(srv))
+
+	// A new report replaces the previous one.
+	_, err = srv.ReportNodeResults(ctx, &availabilityv1.ReportNodeResultsRequest{
+		Node:    "node-1",
+		Results: []*availabilityv1.NodeImageResult{{Image: "a:v1", Result: "pulled"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, testutil.CollectAndCount(srv))
+}
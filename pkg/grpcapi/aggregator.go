@@ -0,0 +1,166 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var (
+	clusterLastReportDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_cluster_last_report_timestamp_seconds",
+		"Time the results of the cluster were last collected at by its exporter.",
+		[]string{"cluster"},
+		nil,
+	)
+	clusterContainersDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_cluster_containers",
+		"Number of containers in the last report of the cluster.",
+		[]string{"cluster"},
+		nil,
+	)
+	clusterAvailabilityDescs = make(map[string]*prometheus.Desc)
+)
+
+func init() {
+	labels := []string{"cluster", "namespace", "container", "image", "kind", "name"}
+	for availMode, mode := range store.AvailabilityModeDescMap {
+		clusterAvailabilityDescs[mode] = prometheus.NewDesc("k8s_image_availability_exporter_"+mode, availMode.Help(), labels, nil)
+	}
+}
+
+type clusterReport struct {
+	results   []*availabilityv1.ContainerResult
+	collected time.Time
+	received  time.Time
+}
+
+// AggregatorServer implements the AggregatorService. It exports availability metrics reported by exporters of edge
+// clusters, labeled with the cluster, so that a fleet of clusters is monitored from a single place. Since edge
+// clusters may be offline for a while, the last report of a cluster is exported until retention passes. The cluster
+// is taken from the report, so any caller allowed to report can report as any cluster.
+type AggregatorServer struct {
+	availabilityv1.UnimplementedAggregatorServiceServer
+
+	retention time.Duration
+	now       func() time.Time
+
+	lock    sync.Mutex
+	reports map[string]clusterReport
+}
+
+// NewAggregatorServer creates a server exporting reports of every cluster for retention after they were received.
+func NewAggregatorServer(retention time.Duration) *AggregatorServer {
+	return &AggregatorServer{
+		retention: retention,
+		now:       time.Now,
+		reports:   make(map[string]clusterReport),
+	}
+}
+
+// Register registers the service within a gRPC server.
+func (s *AggregatorServer) Register(grpcServer *grpc.Server) {
+	availabilityv1.RegisterAggregatorServiceServer(grpcServer, s)
+}
+
+func (s *AggregatorServer) ReportClusterResults(_ context.Context, req *availabilityv1.ReportClusterResultsRequest) (*availabilityv1.ReportClusterResultsResponse, error) {
+	cluster := strings.TrimSpace(req.GetCluster())
+	if len(cluster) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "cluster must not be empty")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	collected := now
+	if req.GetTime() != nil {
+		collected = req.GetTime().AsTime()
+	}
+	// Reports delayed by an unstable connection must not replace newer ones.
+	if prev, ok := s.reports[cluster]; ok && collected.Before(prev.collected) {
+		return &availabilityv1.ReportClusterResultsResponse{}, nil
+	}
+
+	s.reports[cluster] = clusterReport{results: uniqueResults(req.GetResults()), collected: collected, received: now}
+
+	return &availabilityv1.ReportClusterResultsResponse{}, nil
+}
+
+type resultKey struct {
+	namespace string
+	container string
+	image     string
+	kind      string
+	name      string
+}
+
+// uniqueResults drops results with the same labels as a later one, since duplicate series fail the whole scrape.
+func uniqueResults(results []*availabilityv1.ContainerResult) []*availabilityv1.ContainerResult {
+	indexes := make(map[resultKey]int, len(results))
+	unique := make([]*availabilityv1.ContainerResult, 0, len(results))
+	for _, result := range results {
+		workload := result.GetWorkload()
+		key := resultKey{
+			namespace: workload.GetNamespace(),
+			container: workload.GetContainer(),
+			image:     result.GetImage(),
+			kind:      workload.GetKind(),
+			name:      workload.GetName(),
+		}
+		if i, ok := indexes[key]; ok {
+			unique[i] = result
+			continue
+		}
+		indexes[key] = len(unique)
+		unique = append(unique, result)
+	}
+
+	return unique
+}
+
+// Describe implements prometheus.Collector.
+func (s *AggregatorServer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clusterLastReportDesc
+	ch <- clusterContainersDesc
+	for _, desc := range clusterAvailabilityDescs {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (s *AggregatorServer) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for cluster, report := range s.reports {
+		if s.now().Sub(report.received) > s.retention {
+			delete(s.reports, cluster)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(clusterLastReportDesc, prometheus.GaugeValue, float64(report.collected.Unix()), cluster)
+		ch <- prometheus.MustNewConstMetric(clusterContainersDesc, prometheus.GaugeValue, float64(len(report.results)), cluster)
+
+		for _, result := range report.results {
+			workload := result.GetWorkload()
+			for mode, desc := range clusterAvailabilityDescs {
+				var value float64
+				if mode == result.GetMode() {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value,
+					cluster, workload.GetNamespace(), workload.GetContainer(), result.GetImage(), workload.GetKind(), workload.GetName())
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestAggregatorServer(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := NewAggregatorServer(time.Hour)
+	srv.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := srv.ReportClusterResults(ctx, &availabilityv1.ReportClusterResultsRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	workload := &availabilityv1.Workload{Namespace: "default", Kind: "deployment", Name: "app", Container: "app"}
+	_, err = srv.ReportClusterResults(ctx, &availabilityv1.ReportClusterResultsRequest{
+		Cluster: "edge-1",
+		Results: []*availabilityv1.ContainerResult{{Workload: workload, Image: "a:v1", Mode: "absent"}},
+		Time:    timestamppb.New(now),
+	})
+	require.NoError(t, err)
+
+	modes := len(store.AvailabilityModeDescMap)
+	require.Equal(t, 2+modes, testutil.CollectAndCount(srv))
+	require.NoError(t, testutil.CollectAndCompare(srv, strings.NewReader(`
+# HELP k8s_image_availability_exporter_absent Non-zero indicates that the image of the container is absent from its registry.
+# TYPE k8s_image_availability_exporter_absent gauge
+k8s_image_availability_exporter_absent{cluster="edge-1",container="app",image="a:v1",kind="deployment",name="app",namespace="default"} 1
+`), "k8s_image_availability_exporter_absent"))
+
+	// Duplicate results don't make the scrape fail, the last one wins.
+	_, err = srv.ReportClusterResults(ctx, &availabilityv1.ReportClusterResultsRequest{
+		Cluster: "edge-1",
+		Results: []*availabilityv1.ContainerResult{
+			{Workload: workload, Image: "a:v1", Mode: "absent"},
+			{Workload: workload, Image: "a:v1", Mode: "available"},
+		},
+		Time: timestamppb.New(now),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2+modes, testutil.CollectAndCount(srv))
+	require.NoError(t, testutil.CollectAndCompare(srv, strings.NewReader(`
+# HELP k8s_image_availability_exporter_cluster_containers Number of containers in the last report of the cluster.
+# TYPE k8s_image_availability_exporter_cluster_containers gauge
+k8s_image_availability_exporter_cluster_containers{cluster="edge-1"} 1
+# HELP k8s_image_availability_exporter_absent Non-zero indicates that the image of the container is absent from its registry.
+# TYPE k8s_image_availability_exporter_absent gauge
+k8s_image_availability_exporter_absent{cluster="edge-1",container="app",image="a:v1",kind="deployment",name="app",namespace="default"} 0
+`), "k8s_image_availability_exporter_cluster_containers", "k8s_image_availability_exporter_absent"))
+
+	// A delayed report doesn't replace a newer one.
+	_, err = srv.ReportClusterResults(ctx, &availabilityv1.ReportClusterResultsRequest{
+		Cluster: "edge-1",
+		Time:    timestamppb.New(now.Add(-time.Minute)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2+modes, testutil.CollectAndCount(srv))
+
+	_, err = srv.ReportClusterResults(ctx, &availabilityv1.ReportClusterResultsRequest{
+		Cluster: "edge-1",
+		Time:    timestamppb.New(now.Add(time.Minute)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, testutil.CollectAndCount(srv))
+
+	// Clusters that stopped reporting are dropped once the retention passes.
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, 0, testutil.CollectAndCount(srv))
+}
@@ -0,0 +1,156 @@
+package grpcapi
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/auth"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// subscriberQueueSize is the number of events buffered per Watch stream. Slow subscribers miss events instead of
+// blocking image checks.
+const subscriberQueueSize = 256
+
+type checkFunc func(image string) (availMode store.AvailabilityMode, tracked bool)
+
+type subscriber struct {
+	namespaces []string
+	events     chan *availabilityv1.WatchResponse
+}
+
+// Server implements the AvailabilityService. Availability changes are fed to it with HandleTransition.
+type Server struct {
+	availabilityv1.UnimplementedAvailabilityServiceServer
+
+	check checkFunc
+
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewServer(check checkFunc) *Server {
+	return &Server{
+		check:       check,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// Register registers the service within a gRPC server.
+func (s *Server) Register(grpcServer *grpc.Server) {
+	availabilityv1.RegisterAvailabilityServiceServer(grpcServer, s)
+}
+
+func (s *Server) Check(_ context.Context, req *availabilityv1.CheckRequest) (*availabilityv1.CheckResponse, error) {
+	image := strings.TrimSpace(req.GetImage())
+	if len(image) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "image must not be empty")
+	}
+
+	availMode, tracked := s.check(image)
+
+	return &availabilityv1.CheckResponse{
+		Image:   image,
+		Mode:    availMode.String(),
+		Tracked: tracked,
+	}, nil
+}
+
+func (s *Server) Watch(req *availabilityv1.WatchRequest, stream availabilityv1.AvailabilityService_WatchServer) error {
+	sub := &subscriber{
+		namespaces: req.GetNamespaces(),
+		events:     make(chan *availabilityv1.WatchResponse, subscriberQueueSize),
+	}
+
+	s.lock.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.subscribers, sub)
+		s.lock.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-sub.events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// HandleTransition broadcasts an availability change to all Watch streams. It never blocks.
+func (s *Server) HandleTransition(transition store.Transition) {
+	event := &availabilityv1.WatchResponse{
+		Image:   transition.Image,
+		OldMode: transition.OldModeString(),
+		NewMode: transition.NewMode.String(),
+		Time:    timestamppb.New(time.Now()),
+	}
+
+	namespaces := make([]string, 0, len(transition.ContainerInfos))
+	for _, ci := range transition.ContainerInfos {
+		event.Workloads = append(event.Workloads, &availabilityv1.Workload{
+			Namespace: ci.Namespace,
+			Kind:      strings.ToLower(ci.ControllerKind),
+			Name:      ci.ControllerName,
+			Container: ci.Container,
+		})
+		namespaces = append(namespaces, ci.Namespace)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for sub := range s.subscribers {
+		if !sub.matches(namespaces) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			logrus.WithField("image", transition.Image).Warn("Watch stream is too slow, dropping availability event")
+		}
+	}
+}
+
+func (sub *subscriber) matches(namespaces []string) bool {
+	if len(sub.namespaces) == 0 {
+		return true
+	}
+
+	for _, namespace := range namespaces {
+		if slices.Contains(sub.namespaces, namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequiredScope returns the scope required to call a method of the services.
+func RequiredScope(fullMethod string) auth.Scope {
+	switch fullMethod {
+	case availabilityv1.AvailabilityService_Check_FullMethodName, availabilityv1.NodeAgentService_ReportNodeResults_FullMethodName,
+		availabilityv1.AggregatorService_ReportClusterResults_FullMethodName:
+		return auth.ScopeRecheck
+	}
+
+	return auth.ScopeRead
+}
@@ -0,0 +1,79 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	availabilityv1 "github.com/flant/k8s-image-availability-exporter/api/availability/v1"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(func(image string) (store.AvailabilityMode, bool) {
+		return store.Absent, image == "tracked:v1"
+	})
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	srv.Register(grpcServer)
+	go func() { _ = grpcServer.Serve(listener) }()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := availabilityv1.NewAvailabilityServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := client.Check(ctx, &availabilityv1.CheckRequest{Image: "tracked:v1"})
+	require.NoError(t, err)
+	require.Equal(t, "absent", resp.GetMode())
+	require.True(t, resp.GetTracked())
+
+	_, err = client.Check(ctx, &availabilityv1.CheckRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err := client.Watch(ctx, &availabilityv1.WatchRequest{Namespaces: []string{"prod"}})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		srv.lock.Lock()
+		defer srv.lock.Unlock()
+		return len(srv.subscribers) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	srv.HandleTransition(store.Transition{
+		Image:          "dev:v1",
+		NewMode:        store.Absent,
+		FirstCheck:     true,
+		ContainerInfos: []store.ContainerInfo{{Namespace: "dev", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}},
+	})
+	srv.HandleTransition(store.Transition{
+		Image:          "prod:v1",
+		OldMode:        store.Available,
+		NewMode:        store.Absent,
+		ContainerInfos: []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}},
+	})
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "prod:v1", event.GetImage())
+	require.Equal(t, "available", event.GetOldMode())
+	require.Equal(t, "absent", event.GetNewMode())
+	require.Len(t, event.GetWorkloads(), 1)
+	require.Equal(t, "deployment", event.GetWorkloads()[0].GetKind())
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const ImagesAPIPath = "/api/v1/images"
+
+type rangeContainersFunc func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+
+// ImageWorkload is a container referencing an image.
+type ImageWorkload struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+}
+
+// ImageStatus is the availability of a tracked image along with the containers referencing it.
+type ImageStatus struct {
+	Image     string          `json:"image"`
+	Mode      string          `json:"mode"`
+	Workloads []ImageWorkload `json:"workloads"`
+}
+
+// ImagesResponse lists tracked images.
+type ImagesResponse struct {
+	Images []ImageStatus `json:"images"`
+}
+
+// ImagesQuery filters tracked images. Workloads not matching the query are omitted, and so are images without
+// matching workloads. Empty fields match everything.
+type ImagesQuery struct {
+	Namespace string
+	// Kind is a lowercase controller kind, e.g. "deployment".
+	Kind        string
+	Name        string
+	Unavailable bool
+}
+
+func parseImagesQuery(q url.Values) ImagesQuery {
+	return ImagesQuery{
+		Namespace:   q.Get("namespace"),
+		Kind:        strings.ToLower(q.Get("kind")),
+		Name:        q.Get("name"),
+		Unavailable: q.Get("unavailable") == "true",
+	}
+}
+
+// Values encodes the query as URL query parameters.
+func (q ImagesQuery) Values() url.Values {
+	ret := url.Values{}
+	for key, value := range map[string]string{"namespace": q.Namespace, "kind": q.Kind, "name": q.Name} {
+		if len(value) > 0 {
+			ret.Set(key, value)
+		}
+	}
+	if q.Unavailable {
+		ret.Set("unavailable", "true")
+	}
+
+	return ret
+}
+
+func (q ImagesQuery) matches(workload ImageWorkload) bool {
+	return (len(q.Namespace) == 0 || workload.Namespace == q.Namespace) &&
+		(len(q.Kind) == 0 || workload.Kind == q.Kind) &&
+		(len(q.Name) == 0 || workload.Name == q.Name)
+}
+
+// Images handles GET /api/v1/images by listing tracked images and their availability, filtered by ImagesQuery.
+func Images(rangeContainers rangeContainersFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := parseImagesQuery(r.URL.Query())
+		images := make(map[string]*ImageStatus)
+		rangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+			if query.Unavailable && availMode == store.Available {
+				return
+			}
+
+			workload := ImageWorkload{
+				Namespace: containerInfo.Namespace,
+				Kind:      strings.ToLower(containerInfo.ControllerKind),
+				Name:      containerInfo.ControllerName,
+				Container: containerInfo.Container,
+			}
+			if !query.matches(workload) {
+				return
+			}
+
+			status, ok := images[image]
+			if !ok {
+				status = &ImageStatus{Image: image, Mode: availMode.String()}
+				images[image] = status
+			}
+			status.Workloads = append(status.Workloads, workload)
+		})
+
+		resp := ImagesResponse{Images: make([]ImageStatus, 0, len(images))}
+		for _, status := range images {
+			sort.Slice(status.Workloads, func(i, j int) bool {
+				a, b := status.Workloads[i], status.Workloads[j]
+				if a.Namespace != b.Namespace {
+					return a.Namespace < b.Namespace
+				}
+				if a.Kind != b.Kind {
+					return a.Kind < b.Kind
+				}
+				if a.Name != b.Name {
+					return a.Name < b.Name
+				}
+				return a.Container < b.Container
+			})
+			resp.Images = append(resp.Images, *status)
+		}
+		sort.Slice(resp.Images, func(i, j int) bool { return resp.Images[i].Image < resp.Images[j].Image })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logrus.Errorf("Failed to write images response: %v", err)
+		}
+	}
+}
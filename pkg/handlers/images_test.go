@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestImages(t *testing.T) {
+	handler := Images(func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		f("app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent)
+		f("app:v1", store.ContainerInfo{Namespace: "other", ControllerKind: "CronJob", ControllerName: "job", Container: "job"}, store.Absent)
+		f("sidecar:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "sidecar"}, store.Available)
+	})
+
+	for query, expected := range map[string]string{
+		"": `{"images":[
+			{"image":"app:v1","mode":"absent","workloads":[
+				{"namespace":"other","kind":"cronjob","name":"job","container":"job"},
+				{"namespace":"tenant","kind":"deployment","name":"app","container":"app"}]},
+			{"image":"sidecar:v1","mode":"available","workloads":[
+				{"namespace":"tenant","kind":"deployment","name":"app","container":"sidecar"}]}]}`,
+		"?" + ImagesQuery{Namespace: "tenant", Unavailable: true}.Values().Encode(): `{"images":[
+			{"image":"app:v1","mode":"absent","workloads":[
+				{"namespace":"tenant","kind":"deployment","name":"app","container":"app"}]}]}`,
+		"?namespace=tenant&kind=Deployment&name=app": `{"images":[
+			{"image":"app:v1","mode":"absent","workloads":[
+				{"namespace":"tenant","kind":"deployment","name":"app","container":"app"}]},
+			{"image":"sidecar:v1","mode":"available","workloads":[
+				{"namespace":"tenant","kind":"deployment","name":"app","container":"sidecar"}]}]}`,
+		"?namespace=missing": `{"images":[]}`,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, ImagesAPIPath+query, nil))
+		require.Equal(t, http.StatusOK, w.Code, query)
+		require.JSONEq(t, expected, w.Body.String(), query)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, ImagesAPIPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxCachedFormats limits the number of distinct Accept and Accept-Encoding combinations responses are cached for,
+// responses in other formats are rendered for every scrape.
+const maxCachedFormats = 8
+
+// MetricsCache serves a response of the metrics handler rendered within the TTL, so that several Prometheus replicas
+// scraping a large store don't make the exporter gather and encode all of its metrics for every scrape. Responses are
+// cached per negotiated format, i.e. by the Accept and Accept-Encoding headers, and concurrent scrapes wait for a
+// single response to be rendered.
+type MetricsCache struct {
+	next http.Handler
+	ttl  time.Duration
+	now  func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*cachedResponse
+
+	hits prometheus.Counter
+}
+
+type cachedResponse struct {
+	// lock is held while the response is rendered.
+	lock     sync.Mutex
+	rendered time.Time
+	header   http.Header
+	body     []byte
+}
+
+func NewMetricsCache(next http.Handler, ttl time.Duration) *MetricsCache {
+	return &MetricsCache{
+		next:    next,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*cachedResponse),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "k8s_image_availability_exporter",
+			Name:      "metrics_cache_hits_total",
+			Help:      "Number of scrapes served with metrics rendered for a previous scrape.",
+		}),
+	}
+}
+
+func (c *MetricsCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entry := c.entry(r.Header.Get("Accept") + "\n" + r.Header.Get("Accept-Encoding"))
+	if entry == nil {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if entry.header != nil && c.now().Sub(entry.rendered) < c.ttl {
+		c.hits.Inc()
+		entry.write(w)
+		return
+	}
+
+	recorder := &responseRecorder{header: make(http.Header), code: http.StatusOK}
+	c.next.ServeHTTP(recorder, r)
+	// Errors aren't cached, so that the next scrape retries.
+	if recorder.code != http.StatusOK {
+		entry.header = nil
+		recorder.write(w)
+		return
+	}
+
+	entry.rendered, entry.header, entry.body = c.now(), recorder.header, recorder.body.Bytes()
+	entry.write(w)
+}
+
+func (c *MetricsCache) entry(key string) *cachedResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= maxCachedFormats {
+			return nil
+		}
+		entry = &cachedResponse{}
+		c.entries[key] = entry
+	}
+
+	return entry
+}
+
+func (e *cachedResponse) write(w http.ResponseWriter) {
+	for key, values := range e.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.body)
+}
+
+// Describe implements prometheus.Collector.
+func (c *MetricsCache) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *MetricsCache) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+}
+
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) write(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.code)
+	_, _ = w.Write(r.body.Bytes())
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsCache(t *testing.T) {
+	renders, fail := 0, false
+	cache := NewMetricsCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "gathering failed", http.StatusInternalServerError)
+			return
+		}
+		renders++
+		w.Header().Set("Content-Type", r.Header.Get("Accept"))
+		_, _ = fmt.Fprintf(w, "render %d", renders)
+	}), 10*time.Second)
+
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	scrape := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", accept)
+		cache.ServeHTTP(w, r)
+		return w
+	}
+
+	w := scrape("text/plain")
+	require.Equal(t, "render 1", w.Body.String())
+
+	// Scrapes within the TTL are served from the cache.
+	now = now.Add(5 * time.Second)
+	w = scrape("text/plain")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "render 1", w.Body.String())
+	require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	require.Equal(t, float64(1), testutil.ToFloat64(cache.hits))
+
+	// Responses are cached per format.
+	w = scrape("application/openmetrics-text")
+	require.Equal(t, "render 2", w.Body.String())
+	require.Equal(t, "application/openmetrics-text", w.Header().Get("Content-Type"))
+
+	now = now.Add(10 * time.Second)
+	require.Equal(t, "render 3", scrape("text/plain").Body.String())
+
+	// Errors aren't cached.
+	now = now.Add(10 * time.Second)
+	fail = true
+	require.Equal(t, http.StatusInternalServerError, scrape("text/plain").Code)
+	fail = false
+	require.Equal(t, "render 4", scrape("text/plain").Body.String())
+	require.Equal(t, float64(1), testutil.ToFloat64(cache.hits))
+
+	// Formats beyond the limit aren't cached.
+	for i := 0; i < maxCachedFormats; i++ {
+		scrape(fmt.Sprintf("format-%d", i))
+	}
+	renders = 0
+	require.Equal(t, "render 1", scrape("format-last").Body.String())
+	require.Equal(t, "render 2", scrape("format-last").Body.String())
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	ImagesAPIPrefix = "/api/v1/images/"
+	recheckSuffix   = "/recheck"
+)
+
+type checkFunc func(image string) (availMode store.AvailabilityMode, tracked bool)
+
+// RecheckResponse is the result of an on-demand check.
+type RecheckResponse struct {
+	Image   string `json:"image"`
+	Mode    string `json:"mode"`
+	Tracked bool   `json:"tracked"`
+}
+
+// Recheck handles POST /api/v1/images/{ref}/recheck by checking the image immediately. The reference may contain
+// slashes, either as is or escaped.
+func Recheck(check checkFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		image, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, ImagesAPIPrefix), recheckSuffix)
+		if !ok || len(image) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		availMode, tracked := check(image)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RecheckResponse{Image: image, Mode: availMode.String(), Tracked: tracked}); err != nil {
+			logrus.WithField("image", image).Errorf("Failed to write recheck response: %v", err)
+		}
+	}
+}
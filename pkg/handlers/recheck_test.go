@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestRecheck(t *testing.T) {
+	var checked []string
+	handler := Recheck(func(image string) (store.AvailabilityMode, bool) {
+		checked = append(checked, image)
+		return store.Absent, true
+	})
+
+	for path, expectedStatus := range map[string]int{
+		"/api/v1/images/registry.example.com/app:v1/recheck":   http.StatusOK,
+		"/api/v1/images/registry.example.com%2Fapp:v2/recheck": http.StatusOK,
+		"/api/v1/images//recheck":                              http.StatusNotFound,
+		"/api/v1/images/app:v1":                                http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, path, nil))
+		require.Equal(t, expectedStatus, w.Code, path)
+	}
+	require.ElementsMatch(t, []string{"registry.example.com/app:v1", "registry.example.com/app:v2"}, checked)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/api/v1/images/app:v1/recheck", nil))
+	require.JSONEq(t, `{"image":"app:v1","mode":"absent","tracked":true}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/images/app:v1/recheck", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
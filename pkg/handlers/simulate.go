@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/auth"
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+)
+
+const SimulateAPIPath = "/api/v1/simulate"
+
+// maxManifestSize limits the size of manifests accepted by the simulation endpoint.
+const maxManifestSize = 4 << 20
+
+// Simulate handles POST /api/v1/simulate by checking images of pods and workload controllers in a YAML or JSON
+// manifest, possibly with multiple documents, with pull secrets of the namespace they would be applied to. The
+// namespace query parameter overrides namespaces of the objects, which default to "default". Other objects are
+// ignored. Manifests with objects in namespaces the credentials of the request are restricted from are rejected.
+func Simulate(simulate manifests.SimulateFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		objects, err := manifests.Decode(http.MaxBytesReader(w, r.Body, maxManifestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Objects are checked with pull secrets of their namespaces, which credentials restricted to other namespaces
+		// must not be able to use.
+		namespace := r.URL.Query().Get("namespace")
+		for _, objNamespace := range manifests.Namespaces(objects, namespace) {
+			if !auth.NamespaceAllowed(r.Context(), objNamespace) {
+				http.Error(w, fmt.Sprintf("not allowed to simulate manifests in namespace %q", objNamespace), http.StatusForbidden)
+				return
+			}
+		}
+
+		resp := manifests.Check(objects, namespace, simulate)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logrus.Errorf("Failed to write simulation response: %v", err)
+		}
+	}
+}
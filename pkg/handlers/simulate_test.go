@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/auth"
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const simulatedManifest = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+# A custom resource
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: staging
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    spec:
+      serviceAccountName: app
+      initContainers:
+      - name: migrations
+        image: registry.example.com/migrations:v1
+      containers:
+      - name: app
+        image: registry.example.com/app:v1
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: registry.example.com/cleanup:v1
+`
+
+func TestSimulate(t *testing.T) {
+	namespaces := make(map[string]string)
+	handler := Simulate(func(namespace string, spec corev1.PodSpec) (ret []registry.SimulatedContainer) {
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			namespaces[container.Image] = namespace
+			mode := store.Available
+			if strings.Contains(container.Image, "cleanup") {
+				mode = store.Absent
+			}
+			ret = append(ret, registry.SimulatedContainer{Container: container.Name, Image: container.Image, Mode: mode.String()})
+		}
+		return
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, SimulateAPIPath, strings.NewReader(simulatedManifest)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp manifests.Report
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.False(t, resp.Available)
+	require.Len(t, resp.Objects, 2)
+	require.Equal(t, "Deployment", resp.Objects[0].Kind)
+	require.Len(t, resp.Objects[0].Containers, 2)
+	require.Equal(t, "CronJob", resp.Objects[1].Kind)
+	require.Equal(t, map[string]string{
+		"registry.example.com/migrations:v1": "staging",
+		"registry.example.com/app:v1":        "staging",
+		"registry.example.com/cleanup:v1":    "default",
+	}, namespaces)
+
+	// The namespace parameter overrides namespaces of objects.
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, SimulateAPIPath+"?namespace=prod", strings.NewReader(simulatedManifest)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "prod", namespaces["registry.example.com/app:v1"])
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, SimulateAPIPath, strings.NewReader("kind: [")))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, SimulateAPIPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestSimulate_namespaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	require.NoError(t, os.WriteFile(path, []byte("token:staging recheck namespaces=staging\n"), 0o600))
+	authenticator, err := auth.Load(path)
+	require.NoError(t, err)
+
+	var simulated int
+	handler := authenticator.Middleware(auth.ScopeRecheck, Simulate(func(string, corev1.PodSpec) []registry.SimulatedContainer {
+		simulated++
+		return nil
+	}))
+	simulate := func(query string) int {
+		r := httptest.NewRequest(http.MethodPost, SimulateAPIPath+query, strings.NewReader(simulatedManifest))
+		r.Header.Set("Authorization", "Bearer staging")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, simulate("?namespace=staging"))
+	require.Equal(t, 2, simulated)
+
+	// Pull secrets of other namespaces can't be used, neither with the namespace parameter nor with namespaces of
+	// the objects, the CronJob is in the default namespace.
+	require.Equal(t, http.StatusForbidden, simulate("?namespace=prod"))
+	require.Equal(t, http.StatusForbidden, simulate(""))
+	require.Equal(t, 2, simulated)
+}
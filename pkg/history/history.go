@@ -0,0 +1,236 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const compactionInterval = time.Hour
+
+// Event is a recorded image availability transition.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Image   string    `json:"image"`
+	OldMode string    `json:"oldMode"`
+	NewMode string    `json:"newMode"`
+	// Workloads lists affected containers as "kind/namespace/name/container".
+	Workloads []string `json:"workloads"`
+}
+
+// History retains image availability transitions for a period of time, so that questions like "when did this tag
+// disappear" can be answered after Prometheus retention is over. Events are kept in memory and, if a path is set,
+// appended to a JSON lines file that is loaded on startup and compacted periodically.
+type History struct {
+	retention time.Duration
+	path      string
+	now       func() time.Time
+
+	lock   sync.RWMutex
+	events []Event
+	file   *os.File
+}
+
+// New creates a history, loading previously recorded events from the file at path, if any. An empty path keeps
+// events in memory only.
+func New(retention time.Duration, path string) (*History, error) {
+	h := &History{retention: retention, path: path, now: time.Now}
+	if len(path) == 0 {
+		return h, nil
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *History) load() error {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A partially written line is expected after a crash.
+			logrus.Warnf("Skipping malformed history event at %s:%d: %v", h.path, lineNum, err)
+			continue
+		}
+		h.events = append(h.events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", h.path, err)
+	}
+
+	sort.SliceStable(h.events, func(i, j int) bool { return h.events[i].Time.Before(h.events[j].Time) })
+
+	return nil
+}
+
+// Run compacts the history until stopCh is closed.
+func (h *History) Run(stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := h.compact(); err != nil {
+			logrus.Errorf("Failed to compact the availability history: %v", err)
+		}
+	}, compactionInterval, stopCh)
+}
+
+// compact drops expired events and rewrites the file with the rest of them.
+func (h *History) compact() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	cutoff := h.now().Add(-h.retention)
+	expired := sort.Search(len(h.events), func(i int) bool { return !h.events[i].Time.Before(cutoff) })
+	h.events = append([]Event(nil), h.events[expired:]...)
+
+	if len(h.path) == 0 {
+		return nil
+	}
+
+	tmpPath := h.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, event := range h.events {
+		if err := encoder.Encode(event); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, h.path); err != nil {
+		return err
+	}
+
+	if h.file != nil {
+		_ = h.file.Close()
+	}
+	h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0o644)
+
+	return err
+}
+
+// Record is a transition handler that appends the transition to the history.
+func (h *History) Record(transition store.Transition) {
+	event := Event{
+		Time:      h.now(),
+		Image:     transition.Image,
+		OldMode:   transition.OldModeString(),
+		NewMode:   transition.NewMode.String(),
+		Workloads: make([]string, 0, len(transition.ContainerInfos)),
+	}
+	for _, ci := range transition.ContainerInfos {
+		event.Workloads = append(event.Workloads, fmt.Sprintf("%s/%s/%s/%s", strings.ToLower(ci.ControllerKind), ci.Namespace, ci.ControllerName, ci.Container))
+	}
+	sort.Strings(event.Workloads)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.events = append(h.events, event)
+
+	if h.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithField("image_name", transition.Image).Errorf("Failed to encode the history event: %v", err)
+		return
+	}
+	if _, err := h.file.Write(append(line, '\n')); err != nil {
+		logrus.WithField("image_name", transition.Image).Errorf("Failed to write the history event: %v", err)
+	}
+}
+
+// Query returns events of the image, or of all images if it is empty, that happened in the [since, until) range.
+// Zero times don't limit the range.
+func (h *History) Query(image string, since, until time.Time) []Event {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ret := []Event{}
+	for _, event := range h.events {
+		if len(image) > 0 && event.Image != image {
+			continue
+		}
+		if !since.IsZero() && event.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !event.Time.Before(until) {
+			continue
+		}
+		ret = append(ret, event)
+	}
+
+	return ret
+}
+
+// Handler serves events filtered by the "image", "since" and "until" query parameters, times are in RFC 3339.
+func (h *History) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+
+		var since, until time.Time
+		for param, t := range map[string]*time.Time{"since": &since, "until": &until} {
+			value := query.Get(param)
+			if len(value) == 0 {
+				continue
+			}
+
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %q: %v", param, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.Query(query.Get("image"), since, until)); err != nil {
+			logrus.Errorf("Failed to write history response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Now().Add(-2 * time.Hour)
+	now := start
+
+	h, err := New(24*time.Hour, path)
+	require.NoError(t, err)
+	h.now = func() time.Time { return now }
+
+	containerInfos := []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}}
+	h.Record(store.Transition{Image: "app:v1", NewMode: store.Absent, FirstCheck: true, ContainerInfos: containerInfos})
+	now = now.Add(time.Hour)
+	h.Record(store.Transition{Image: "app:v1", OldMode: store.Absent, NewMode: store.Available, ContainerInfos: containerInfos})
+	h.Record(store.Transition{Image: "other:v1", NewMode: store.AuthnFailure, FirstCheck: true})
+
+	events := h.Query("app:v1", time.Time{}, time.Time{})
+	require.Len(t, events, 2)
+	require.Equal(t, "none", events[0].OldMode)
+	require.Equal(t, "absent", events[0].NewMode)
+	require.Equal(t, []string{"deployment/default/web/app"}, events[0].Workloads)
+	require.Len(t, h.Query("", now, time.Time{}), 2)
+
+	// Events survive restarts until they expire.
+	h, err = New(24*time.Hour, path)
+	require.NoError(t, err)
+	require.Len(t, h.Query("", time.Time{}, time.Time{}), 3)
+
+	h.now = func() time.Time { return start.Add(24*time.Hour + time.Minute) }
+	require.NoError(t, h.compact())
+	require.Len(t, h.Query("", time.Time{}, time.Time{}), 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+}
+
+func TestHistory_Handler(t *testing.T) {
+	h, err := New(time.Hour, "")
+	require.NoError(t, err)
+	h.Record(store.Transition{Image: "app:v1", NewMode: store.Absent, FirstCheck: true})
+
+	rec := httptest.NewRecorder()
+	h.Handler()(rec, httptest.NewRequest(http.MethodGet, "/api/v1/history?image=app:v1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var events []Event
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &events))
+	require.Len(t, events, 1)
+
+	rec = httptest.NewRecorder()
+	h.Handler()(rec, httptest.NewRequest(http.MethodGet, "/api/v1/history?since=yesterday", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,227 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	webhookQueueSize = 256
+
+	webhookReceiver = "k8s-image-availability-exporter"
+	webhookVersion  = "4"
+)
+
+var kindAlertNames = map[string]string{
+	"deployment":  "Deployment",
+	"statefulset": "StatefulSet",
+	"daemonset":   "DaemonSet",
+	"cronjob":     "CronJob",
+	"pod":         "Pod",
+}
+
+// webhookMessage is the payload Alertmanager sends to webhook receivers.
+type webhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []webhookAlert    `json:"alerts"`
+}
+
+type webhookAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertmanagerWebhook sends image availability transitions to a webhook receiver in the Alertmanager format, so
+// existing receivers can be used without Prometheus alerting rules. An unavailable image fires an alert per
+// affected container, named like the chart's alerts, e.g. DeploymentImageUnavailable. The alerts are resolved once
+// the image becomes available.
+type AlertmanagerWebhook struct {
+	url    string
+	client *http.Client
+
+	queue chan store.Transition
+
+	// firingSince holds the start time of firing alerts by image, only accessed from the sending goroutine.
+	firingSince map[string]time.Time
+
+	deliveries *prometheus.CounterVec
+}
+
+func NewAlertmanagerWebhook(url string, timeout time.Duration) *AlertmanagerWebhook {
+	return &AlertmanagerWebhook{
+		url:         url,
+		client:      &http.Client{Timeout: timeout},
+		queue:       make(chan store.Transition, webhookQueueSize),
+		firingSince: make(map[string]time.Time),
+		deliveries: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "k8s_image_availability_exporter",
+				Name:      "webhook_deliveries_total",
+				Help:      "Number of Alertmanager webhook deliveries, differentiated by result.",
+			},
+			[]string{"result"},
+		),
+	}
+}
+
+// Run sends queued transitions one by one until stopCh is closed.
+func (h *AlertmanagerWebhook) Run(stopCh <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case transition := <-h.queue:
+				h.send(transition)
+			}
+		}
+	}()
+}
+
+// Handle queues a transition, it never blocks and drops the transition if the queue is full.
+func (h *AlertmanagerWebhook) Handle(transition store.Transition) {
+	select {
+	case h.queue <- transition:
+	default:
+		h.deliveries.WithLabelValues("dropped").Inc()
+		logrus.WithField("image_name", transition.Image).Warn("Alertmanager webhook queue is full, dropping the transition")
+	}
+}
+
+func (h *AlertmanagerWebhook) send(transition store.Transition) {
+	message := h.message(transition, time.Now())
+	if len(message.Alerts) == 0 {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"image_name": transition.Image,
+		"status":     message.Status,
+	})
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		h.deliveries.WithLabelValues("failure").Inc()
+		log.Errorf("Failed to encode the Alertmanager webhook message: %v", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		h.deliveries.WithLabelValues("failure").Inc()
+		log.Errorf("Failed to create the Alertmanager webhook request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.deliveries.WithLabelValues("failure").Inc()
+		log.Errorf("Alertmanager webhook delivery failed: %v", err)
+		return
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		h.deliveries.WithLabelValues("failure").Inc()
+		log.Errorf("Alertmanager webhook receiver responded with %s", resp.Status)
+		return
+	}
+
+	h.deliveries.WithLabelValues("success").Inc()
+}
+
+// message builds a message with alerts of all containers affected by the transition, grouped by image.
+func (h *AlertmanagerWebhook) message(transition store.Transition, now time.Time) webhookMessage {
+	status, startsAt, endsAt := string(model.AlertFiring), now, time.Time{}
+	if transition.NewMode == store.Available {
+		status, endsAt = string(model.AlertResolved), now
+
+		var ok bool
+		if startsAt, ok = h.firingSince[transition.Image]; !ok {
+			startsAt = now
+		}
+		delete(h.firingSince, transition.Image)
+	} else if since, ok := h.firingSince[transition.Image]; ok {
+		// The image is still unavailable, but for another reason.
+		startsAt = since
+	} else {
+		h.firingSince[transition.Image] = now
+	}
+
+	message := webhookMessage{
+		Version:           webhookVersion,
+		GroupKey:          fmt.Sprintf("{}:{image=%q}", transition.Image),
+		Status:            status,
+		Receiver:          webhookReceiver,
+		GroupLabels:       map[string]string{"image": transition.Image},
+		CommonAnnotations: map[string]string{"availability_mode": transition.NewMode.String()},
+		Alerts:            []webhookAlert{},
+	}
+
+	for _, ci := range transition.ContainerInfos {
+		kind := strings.ToLower(ci.ControllerKind)
+		labels := map[string]string{
+			"alertname": kindAlertNames[kind] + "ImageUnavailable",
+			"namespace": ci.Namespace,
+			"name":      ci.ControllerName,
+			"container": ci.Container,
+			"image":     transition.Image,
+			"severity":  "critical",
+		}
+
+		message.Alerts = append(message.Alerts, webhookAlert{
+			Status: status,
+			Labels: labels,
+			Annotations: map[string]string{
+				"message": fmt.Sprintf("Image %s from container %s in %s %s from namespace %s is not available in docker registry.",
+					transition.Image, ci.Container, kind, ci.ControllerName, ci.Namespace),
+				"availability_mode": transition.NewMode.String(),
+			},
+			StartsAt:    startsAt,
+			EndsAt:      endsAt,
+			Fingerprint: fmt.Sprintf("%016x", model.LabelsToSignature(labels)),
+		})
+	}
+
+	sort.Slice(message.Alerts, func(i, j int) bool { return message.Alerts[i].Fingerprint < message.Alerts[j].Fingerprint })
+
+	message.CommonLabels = make(map[string]string)
+	for i, alert := range message.Alerts {
+		for name, value := range alert.Labels {
+			if i == 0 {
+				message.CommonLabels[name] = value
+			} else if message.CommonLabels[name] != value {
+				delete(message.CommonLabels, name)
+			}
+		}
+	}
+
+	return message
+}
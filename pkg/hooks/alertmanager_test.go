@@ -0,0 +1,40 @@
+package hooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestAlertmanagerWebhook_message(t *testing.T) {
+	h := &AlertmanagerWebhook{firingSince: make(map[string]time.Time)}
+	containerInfos := []store.ContainerInfo{
+		{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"},
+		{Namespace: "default", ControllerKind: "CronJob", ControllerName: "cleanup", Container: "app"},
+	}
+
+	firedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	message := h.message(store.Transition{Image: "app:v1", OldMode: store.Available, NewMode: store.Absent, ContainerInfos: containerInfos}, firedAt)
+	require.Equal(t, "firing", message.Status)
+	require.Len(t, message.Alerts, 2)
+	require.Equal(t, map[string]string{"image": "app:v1", "namespace": "default", "container": "app", "severity": "critical"}, message.CommonLabels)
+	require.ElementsMatch(t, []string{"DeploymentImageUnavailable", "CronJobImageUnavailable"},
+		[]string{message.Alerts[0].Labels["alertname"], message.Alerts[1].Labels["alertname"]})
+	require.True(t, message.Alerts[0].EndsAt.IsZero())
+
+	// Another reason keeps the alerts firing since the first transition.
+	message = h.message(store.Transition{Image: "app:v1", OldMode: store.Absent, NewMode: store.AuthzFailure, ContainerInfos: containerInfos}, firedAt.Add(time.Minute))
+	require.Equal(t, "firing", message.Status)
+	require.Equal(t, firedAt, message.Alerts[0].StartsAt)
+	require.Equal(t, "authorization_failure", message.CommonAnnotations["availability_mode"])
+
+	resolvedAt := firedAt.Add(time.Hour)
+	message = h.message(store.Transition{Image: "app:v1", OldMode: store.AuthzFailure, NewMode: store.Available, ContainerInfos: containerInfos}, resolvedAt)
+	require.Equal(t, "resolved", message.Status)
+	require.Equal(t, firedAt, message.Alerts[0].StartsAt)
+	require.Equal(t, resolvedAt, message.Alerts[0].EndsAt)
+	require.Empty(t, h.firingSince)
+}
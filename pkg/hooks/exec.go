@@ -0,0 +1,113 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const execQueueSize = 256
+
+// ExecHook runs an executable on every image availability transition. The executable is called with the image,
+// the old and the new availability mode as arguments, the same values and affected workloads are passed in
+// environment variables as well.
+type ExecHook struct {
+	path    string
+	timeout time.Duration
+
+	queue chan store.Transition
+
+	executions *prometheus.CounterVec
+}
+
+func NewExecHook(path string, timeout time.Duration) *ExecHook {
+	return &ExecHook{
+		path:    path,
+		timeout: timeout,
+		queue:   make(chan store.Transition, execQueueSize),
+		executions: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "k8s_image_availability_exporter",
+				Name:      "hook_executions_total",
+				Help:      "Number of availability change hook executions, differentiated by result.",
+			},
+			[]string{"result"},
+		),
+	}
+}
+
+// Run executes queued transitions one by one until stopCh is closed.
+func (h *ExecHook) Run(stopCh <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case transition := <-h.queue:
+				h.exec(transition)
+			}
+		}
+	}()
+}
+
+// Handle queues a transition, it never blocks and drops the transition if the queue is full.
+func (h *ExecHook) Handle(transition store.Transition) {
+	select {
+	case h.queue <- transition:
+	default:
+		h.executions.WithLabelValues("dropped").Inc()
+		logrus.WithField("image_name", transition.Image).Warn("Availability change hook queue is full, dropping the transition")
+	}
+}
+
+func (h *ExecHook) exec(transition store.Transition) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	oldMode, newMode := transition.OldModeString(), transition.NewMode.String()
+
+	cmd := exec.CommandContext(ctx, h.path, transition.Image, oldMode, newMode)
+	cmd.Env = append(os.Environ(),
+		"K8S_IAE_IMAGE="+transition.Image,
+		"K8S_IAE_OLD_MODE="+oldMode,
+		"K8S_IAE_NEW_MODE="+newMode,
+		"K8S_IAE_WORKLOADS="+formatWorkloads(transition.ContainerInfos),
+	)
+
+	log := logrus.WithFields(logrus.Fields{
+		"image_name": transition.Image,
+		"old_mode":   oldMode,
+		"new_mode":   newMode,
+	})
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		h.executions.WithLabelValues("failure").Inc()
+		log.Errorf("Availability change hook %q failed: %v, output: %s", h.path, err, output)
+		return
+	}
+
+	h.executions.WithLabelValues("success").Inc()
+	log.Debugf("Availability change hook %q succeeded, output: %s", h.path, output)
+}
+
+// formatWorkloads returns a sorted comma-separated list of "kind/namespace/name/container" entries.
+func formatWorkloads(containerInfos []store.ContainerInfo) string {
+	workloads := make([]string, 0, len(containerInfos))
+	for _, ci := range containerInfos {
+		workloads = append(workloads, fmt.Sprintf("%s/%s/%s/%s", strings.ToLower(ci.ControllerKind), ci.Namespace, ci.ControllerName, ci.Container))
+	}
+	sort.Strings(workloads)
+
+	return strings.Join(workloads, ",")
+}
@@ -0,0 +1,54 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestExecHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo "$1 $2 $3" > "`+out+`"
+echo "$K8S_IAE_IMAGE $K8S_IAE_OLD_MODE $K8S_IAE_NEW_MODE $K8S_IAE_WORKLOADS" >> "`+out+`"
+test "$1" != "broken:v1"
+`), 0o700))
+
+	h := NewExecHook(script, 5*time.Second)
+	transition := store.Transition{
+		Image:   "app:v1",
+		OldMode: store.Available,
+		NewMode: store.Absent,
+		ContainerInfos: []store.ContainerInfo{
+			{Namespace: "prod", ControllerKind: "StatefulSet", ControllerName: "db", Container: "db"},
+			{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "web", Container: "app"},
+		},
+	}
+
+	h.exec(transition)
+	output, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "app:v1 available absent\napp:v1 available absent deployment/prod/web/app,statefulset/prod/db/db\n", string(output))
+	require.Equal(t, float64(1), testutil.ToFloat64(h.executions.WithLabelValues("success")))
+
+	h.exec(store.Transition{Image: "broken:v1", FirstCheck: true, NewMode: store.Absent})
+	output, err = os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "broken:v1 none absent\nbroken:v1 none absent \n", string(output))
+	require.Equal(t, float64(1), testutil.ToFloat64(h.executions.WithLabelValues("failure")))
+
+	// The hook isn't running, so transitions beyond the queue size are dropped.
+	for i := 0; i < execQueueSize+2; i++ {
+		h.Handle(transition)
+	}
+	require.Len(t, h.queue, execQueueSize)
+	require.Equal(t, float64(2), testutil.ToFloat64(h.executions.WithLabelValues("dropped")))
+}
@@ -0,0 +1,74 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// DiffImage is an image of the source cluster that is missing or unavailable in the target cluster.
+type DiffImage struct {
+	Image string `json:"image"`
+	// AvailabilityMode is the availability of the image in the target cluster, it's empty for missing images.
+	AvailabilityMode string     `json:"availabilityMode,omitempty"`
+	Workloads        []Workload `json:"workloads"`
+}
+
+// Diff lists images of the source cluster that can't be relied on in the target cluster, e.g. in a DR cluster
+// before a failover.
+type Diff struct {
+	// Present is the number of images of the source cluster the target cluster references and didn't find
+	// unavailable.
+	Present int `json:"present"`
+	// Missing images aren't referenced by any workload of the target cluster.
+	Missing []DiffImage `json:"missing"`
+	// Unavailable images are referenced in the target cluster, but weren't available there in the last check.
+	Unavailable []DiffImage `json:"unavailable"`
+}
+
+// Compare reports images of the source report that are missing from or unavailable in the target report. Images that
+// aren't available in the source cluster either aren't reported, since the target cluster can't be expected to pull
+// them. Images that weren't checked, e.g. in reports of the "generate inventory" subcommand, count as available.
+// Workloads of the source cluster are reported.
+func Compare(source, target Report) Diff {
+	diff := Diff{Missing: []DiffImage{}, Unavailable: []DiffImage{}}
+
+	targetImages := make(map[string]Image, len(target.Images))
+	for _, img := range target.Images {
+		targetImages[img.Image] = img
+	}
+
+	for _, img := range source.Images {
+		if !available(img) {
+			continue
+		}
+
+		targetImg, ok := targetImages[img.Image]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, DiffImage{Image: img.Image, Workloads: img.Workloads})
+		case !available(targetImg):
+			diff.Unavailable = append(diff.Unavailable, DiffImage{Image: img.Image, AvailabilityMode: targetImg.AvailabilityMode, Workloads: img.Workloads})
+		default:
+			diff.Present++
+		}
+	}
+
+	return diff
+}
+
+func available(img Image) bool {
+	return len(img.AvailabilityMode) == 0 || img.AvailabilityMode == store.Available.String()
+}
+
+// Empty reports whether all images of the source cluster are present in the target cluster.
+func (d Diff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unavailable) == 0
+}
+
+func (d Diff) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
@@ -0,0 +1,42 @@
+package inventory
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	web := []Workload{{Namespace: "prod", Kind: "deployment", Name: "web", Container: "nginx"}}
+	source := Report{Images: []Image{
+		{Image: "nginx:1.25", AvailabilityMode: "available", Workloads: web},
+		{Image: "registry.example.com/app:v1", AvailabilityMode: "available", Workloads: web},
+		{Image: "registry.example.com/app:v2", Workloads: web},
+		{Image: "registry.example.com/broken:v1", AvailabilityMode: "absent", Workloads: web},
+	}}
+	target := Report{Images: []Image{
+		{Image: "nginx:1.25", AvailabilityMode: "available"},
+		{Image: "registry.example.com/app:v1", AvailabilityMode: "authn_failure"},
+		{Image: "registry.example.com/extra:v1", AvailabilityMode: "available"},
+	}}
+
+	diff := Compare(source, target)
+	require.Equal(t, Diff{
+		Present:     1,
+		Missing:     []DiffImage{{Image: "registry.example.com/app:v2", Workloads: web}},
+		Unavailable: []DiffImage{{Image: "registry.example.com/app:v1", AvailabilityMode: "authn_failure", Workloads: web}},
+	}, diff)
+	require.False(t, diff.Empty())
+
+	// Images that weren't checked in the target cluster count as present.
+	target.Images[1].AvailabilityMode = ""
+	target.Images = append(target.Images, Image{Image: "registry.example.com/app:v2"})
+	diff = Compare(source, target)
+	require.True(t, diff.Empty())
+	require.Equal(t, 3, diff.Present)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.Write(&buf))
+	require.JSONEq(t, `{"present": 3, "missing": [], "unavailable": []}`, buf.String())
+}
@@ -0,0 +1,198 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+var csvHeader = []string{"image", "registry", "namespace", "kind", "name", "container", "pull_policy"}
+
+type Workload struct {
+	Namespace  string `json:"namespace"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Container  string `json:"container"`
+	PullPolicy string `json:"pullPolicy"`
+}
+
+type Image struct {
+	Image    string `json:"image"`
+	Registry string `json:"registry"`
+	// AvailabilityMode is the result of the last check of the image, it's empty if the image wasn't checked, e.g. in
+	// reports of the "generate inventory" subcommand. It isn't included in CSV.
+	AvailabilityMode string     `json:"availabilityMode,omitempty"`
+	Workloads        []Workload `json:"workloads"`
+}
+
+type Registry struct {
+	Registry  string `json:"registry"`
+	Images    int    `json:"images"`
+	Workloads int    `json:"workloads"`
+}
+
+// Report lists images used by workloads regardless of their availability.
+type Report struct {
+	Images     []Image    `json:"images"`
+	Registries []Registry `json:"registries"`
+}
+
+// Build makes a report out of containers referencing every image. Images that can't be parsed are reported with
+// an empty registry.
+func Build(images map[string][]store.ContainerInfo, registryOf func(image string) string) Report {
+	report := Report{Images: make([]Image, 0, len(images)), Registries: []Registry{}}
+	registries := make(map[string]*Registry)
+
+	for image, containerInfos := range images {
+		if len(containerInfos) == 0 {
+			continue
+		}
+
+		img := Image{Image: image, Registry: registryOf(image)}
+		for _, ci := range containerInfos {
+			img.Workloads = append(img.Workloads, Workload{
+				Namespace:  ci.Namespace,
+				Kind:       strings.ToLower(ci.ControllerKind),
+				Name:       ci.ControllerName,
+				Container:  ci.Container,
+				PullPolicy: ci.PullPolicy,
+			})
+		}
+		sort.Slice(img.Workloads, func(i, j int) bool {
+			a, b := img.Workloads[i], img.Workloads[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Container < b.Container
+		})
+		report.Images = append(report.Images, img)
+
+		r, ok := registries[img.Registry]
+		if !ok {
+			r = &Registry{Registry: img.Registry}
+			registries[img.Registry] = r
+		}
+		r.Images++
+		r.Workloads += len(img.Workloads)
+	}
+
+	sort.Slice(report.Images, func(i, j int) bool { return report.Images[i].Image < report.Images[j].Image })
+
+	for _, r := range registries {
+		report.Registries = append(report.Registries, *r)
+	}
+	sort.Slice(report.Registries, func(i, j int) bool { return report.Registries[i].Registry < report.Registries[j].Registry })
+
+	return report
+}
+
+// Write writes the report in the JSON or CSV format. CSV has a row per container and doesn't include per-registry
+// counts.
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, img := range r.Images {
+			for _, wl := range img.Workloads {
+				if err := cw.Write([]string{img.Image, img.Registry, wl.Namespace, wl.Kind, wl.Name, wl.Container, wl.PullPolicy}); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown inventory format %q, must be %q or %q", format, FormatJSON, FormatCSV)
+	}
+}
+
+// Read reads a report written by Write in the JSON or CSV format. Per-registry counts aren't restored from CSV.
+func Read(r io.Reader, format string) (Report, error) {
+	switch format {
+	case FormatJSON:
+		var report Report
+		if err := json.NewDecoder(r).Decode(&report); err != nil {
+			return Report{}, err
+		}
+		return report, nil
+	case FormatCSV:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return Report{}, err
+		}
+		if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+			return Report{}, fmt.Errorf("unexpected CSV header, must be %q", strings.Join(csvHeader, ","))
+		}
+
+		report := Report{Registries: []Registry{}}
+		for _, record := range records[1:] {
+			if n := len(report.Images); n == 0 || report.Images[n-1].Image != record[0] {
+				report.Images = append(report.Images, Image{Image: record[0], Registry: record[1]})
+			}
+			img := &report.Images[len(report.Images)-1]
+			img.Workloads = append(img.Workloads, Workload{
+				Namespace:  record[2],
+				Kind:       record[3],
+				Name:       record[4],
+				Container:  record[5],
+				PullPolicy: record[6],
+			})
+		}
+		return report, nil
+	default:
+		return Report{}, fmt.Errorf("unknown inventory format %q, must be %q or %q", format, FormatJSON, FormatCSV)
+	}
+}
+
+// Handler serves the report, the format is selected with the "format" query parameter and defaults to JSON.
+func Handler(build func() Report) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "", FormatJSON:
+			format = FormatJSON
+			w.Header().Set("Content-Type", "application/json")
+		case FormatCSV:
+			w.Header().Set("Content-Type", "text/csv")
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+
+		if err := build().Write(w, format); err != nil {
+			logrus.Errorf("Failed to write inventory report: %v", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package inventory
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func testReport() Report {
+	return Build(map[string][]store.ContainerInfo{
+		"nginx:1.25": {
+			{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "web", Container: "nginx", PullPolicy: "IfNotPresent"},
+			{Namespace: "dev", ControllerKind: "Deployment", ControllerName: "web", Container: "nginx"},
+		},
+		"registry.example.com/app:v1": {
+			{Namespace: "prod", ControllerKind: "StatefulSet", ControllerName: "app", Container: "app"},
+		},
+		"registry.example.com/unused:v1": nil,
+	}, func(image string) string {
+		if strings.HasPrefix(image, "registry.example.com/") {
+			return "registry.example.com"
+		}
+		return "index.docker.io"
+	})
+}
+
+func TestBuild(t *testing.T) {
+	report := testReport()
+
+	require.Len(t, report.Images, 2)
+	require.Equal(t, "nginx:1.25", report.Images[0].Image)
+	require.Equal(t, Workload{Namespace: "dev", Kind: "deployment", Name: "web", Container: "nginx"}, report.Images[0].Workloads[0])
+	require.Equal(t, []Registry{
+		{Registry: "index.docker.io", Images: 1, Workloads: 2},
+		{Registry: "registry.example.com", Images: 1, Workloads: 1},
+	}, report.Registries)
+}
+
+func TestReport_Write(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testReport().Write(&buf, FormatCSV))
+	require.Equal(t, `image,registry,namespace,kind,name,container,pull_policy
+nginx:1.25,index.docker.io,dev,deployment,web,nginx,
+nginx:1.25,index.docker.io,prod,deployment,web,nginx,IfNotPresent
+registry.example.com/app:v1,registry.example.com,prod,statefulset,app,app,
+`, buf.String())
+
+	require.Error(t, testReport().Write(&buf, "xml"))
+}
+
+func TestRead(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatCSV} {
+		var buf bytes.Buffer
+		require.NoError(t, testReport().Write(&buf, format))
+
+		report, err := Read(&buf, format)
+		require.NoError(t, err, format)
+		require.Equal(t, testReport().Images, report.Images, format)
+	}
+
+	_, err := Read(strings.NewReader("image\nnginx:1.25\n"), FormatCSV)
+	require.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	handler := Handler(testReport)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/inventory", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), `"registry": "registry.example.com"`)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/inventory?format=csv", nil))
+	require.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/inventory?format=xml", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,176 @@
+package kubeclient
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+var errStreamingList = errors.New("streaming lists are not supported across several namespaces")
+
+// NewMultiNamespaceListWatch returns a ListerWatcher of objects in the namespaces that lists and watches objects in
+// each of the namespaces separately, so that only namespaced permissions are needed. newListWatch returns the
+// ListerWatcher of a single namespace.
+func NewMultiNamespaceListWatch(namespaces []string, newListWatch func(namespace string) cache.ListerWatcher) cache.ListerWatcher {
+	lw := &multiNamespaceListWatch{resourceVersions: make([]string, len(namespaces))}
+	for _, namespace := range namespaces {
+		lw.listWatches = append(lw.listWatches, newListWatch(namespace))
+	}
+
+	return lw
+}
+
+type multiNamespaceListWatch struct {
+	listWatches []cache.ListerWatcher
+
+	lock sync.Mutex
+	// resourceVersions are the last resource versions seen in each of the namespaces, watches are resumed from.
+	resourceVersions []string
+	// generation is incremented by every list, so that events of watches started before it are not observed.
+	generation int
+}
+
+func (m *multiNamespaceListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	// Neither continue tokens nor resource versions are shared between lists of different namespaces, so the whole
+	// list of every namespace is read at once.
+	options.Limit, options.Continue = 0, ""
+	options.ResourceVersion, options.ResourceVersionMatch = "", ""
+
+	var (
+		list             runtime.Object
+		items            []runtime.Object
+		resourceVersions = make([]string, len(m.listWatches))
+	)
+	for i, lw := range m.listWatches {
+		obj, err := lw.List(options)
+		if err != nil {
+			return nil, err
+		}
+
+		listMeta, err := meta.ListAccessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		resourceVersions[i] = listMeta.GetResourceVersion()
+
+		namespaceItems, err := meta.ExtractList(obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, namespaceItems...)
+
+		if list == nil {
+			list = obj
+		}
+	}
+
+	if err := meta.SetList(list, items); err != nil {
+		return nil, err
+	}
+	listMeta, _ := meta.ListAccessor(list)
+	listMeta.SetResourceVersion(strings.Join(resourceVersions, "/"))
+	listMeta.SetContinue("")
+
+	m.lock.Lock()
+	m.resourceVersions = resourceVersions
+	m.generation++
+	m.lock.Unlock()
+
+	return list, nil
+}
+
+// Watch watches each of the namespaces from the last resource version seen in it, since the resource version the
+// reflector passes is only valid in a single namespace.
+func (m *multiNamespaceListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	if options.SendInitialEvents != nil && *options.SendInitialEvents {
+		return nil, errStreamingList
+	}
+
+	m.lock.Lock()
+	resourceVersions := append([]string(nil), m.resourceVersions...)
+	generation := m.generation
+	m.lock.Unlock()
+
+	w := &multiWatch{result: make(chan watch.Event), stopCh: make(chan struct{})}
+	for i, lw := range m.listWatches {
+		namespaceOptions := options
+		namespaceOptions.ResourceVersion = resourceVersions[i]
+
+		namespaceWatch, err := lw.Watch(namespaceOptions)
+		if err != nil {
+			w.Stop()
+			return nil, err
+		}
+		w.watches = append(w.watches, namespaceWatch)
+	}
+
+	w.wg.Add(len(w.watches))
+	for i, namespaceWatch := range w.watches {
+		i := i
+		go w.forward(namespaceWatch, func(resourceVersion string) { m.observe(generation, i, resourceVersion) })
+	}
+	go func() {
+		w.wg.Wait()
+		close(w.result)
+	}()
+
+	return w, nil
+}
+
+func (m *multiNamespaceListWatch) observe(generation, i int, resourceVersion string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if generation == m.generation {
+		m.resourceVersions[i] = resourceVersion
+	}
+}
+
+// multiWatch merges watches of several namespaces. It stops once any of them ends, so that the reflector starts over.
+type multiWatch struct {
+	watches []watch.Interface
+	result  chan watch.Event
+
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func (w *multiWatch) forward(namespaceWatch watch.Interface, observe func(resourceVersion string)) {
+	defer w.wg.Done()
+	defer w.Stop()
+
+	for event := range namespaceWatch.ResultChan() {
+		select {
+		case w.result <- event:
+		case <-w.stopCh:
+			return
+		}
+
+		if event.Type == watch.Error {
+			continue
+		}
+		if accessor, err := meta.Accessor(event.Object); err == nil {
+			observe(accessor.GetResourceVersion())
+		}
+	}
+}
+
+func (w *multiWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		for _, namespaceWatch := range w.watches {
+			namespaceWatch.Stop()
+		}
+	})
+}
+
+func (w *multiWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
@@ -0,0 +1,62 @@
+package kubeclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMultiNamespaceListWatch(t *testing.T) {
+	watches := map[string]*watch.FakeWatcher{}
+	watchedFrom := map[string]string{}
+	lw := NewMultiNamespaceListWatch([]string{"a", "b"}, func(namespace string) cache.ListerWatcher {
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				require.Zero(t, options.Limit)
+				require.Empty(t, options.ResourceVersion)
+				return &corev1.SecretList{
+					ListMeta: metav1.ListMeta{ResourceVersion: namespace + "-1"},
+					Items:    []corev1.Secret{{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "secret"}}},
+				}, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				watchedFrom[namespace] = options.ResourceVersion
+				watches[namespace] = watch.NewFake()
+				return watches[namespace], nil
+			},
+		}
+	})
+
+	obj, err := lw.List(metav1.ListOptions{Limit: 500, ResourceVersion: "0"})
+	require.NoError(t, err)
+	list := obj.(*corev1.SecretList)
+	require.Len(t, list.Items, 2)
+	require.Equal(t, "a-1/b-1", list.ResourceVersion)
+
+	w, err := lw.Watch(metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "a-1", "b": "b-1"}, watchedFrom)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "new", ResourceVersion: "b-2"}}
+	go watches["b"].Add(secret)
+	event := <-w.ResultChan()
+	require.Equal(t, watch.Added, event.Type)
+	require.Equal(t, secret, event.Object)
+
+	// The merged watch ends with any of the namespace watches, and is resumed from the last seen resource versions.
+	watches["a"].Stop()
+	for range w.ResultChan() {
+	}
+	_, err = lw.Watch(metav1.ListOptions{ResourceVersion: "b-2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "a-1", "b": "b-2"}, watchedFrom)
+
+	sendInitialEvents := true
+	_, err = lw.Watch(metav1.ListOptions{SendInitialEvents: &sendInitialEvents})
+	require.ErrorIs(t, err, errStreamingList)
+}
@@ -0,0 +1,111 @@
+package kubeclient
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
+// watchListEnv is the environment variable client-go reflectors check to stream the initial list of objects
+// through a watch instead of listing them.
+const watchListEnv = "ENABLE_CLIENT_GO_WATCH_LIST_ALPHA"
+
+var (
+	responseContentTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_image_availability_exporter_kube_api_responses_total",
+		Help: "Number of successful responses of the Kubernetes API, by their content type.",
+	}, []string{"content_type"})
+	watchListSupported = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_image_availability_exporter_kube_api_watch_list",
+		Help: "Whether the Kubernetes API server accepted the last request to stream the initial list of objects through a watch (1) or the informer fell back to listing them (0).",
+	})
+)
+
+// UseProtobuf makes the client request built-in objects in the protobuf encoding, which is cheaper to decode and
+// allocates less than JSON. Clients of custom resources, e.g. the dynamic client, keep using JSON.
+func UseProtobuf(cfg *rest.Config) {
+	cfg.ContentType = runtime.ContentTypeProtobuf
+	cfg.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+}
+
+// EnableWatchList makes informers created afterwards stream the initial list of objects through a watch, so that
+// objects are not held in memory as a whole list. Informers fall back to listing objects if the API server doesn't
+// support it.
+func EnableWatchList() {
+	if err := os.Setenv(watchListEnv, "true"); err != nil {
+		logrus.Errorf("Failed to enable streaming lists: %v", err)
+	}
+}
+
+// ObserveNegotiation reports the content type the API server responds with and whether it accepts streaming lists.
+func ObserveNegotiation(cfg *rest.Config) {
+	cfg.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return &negotiationTransport{next: next, contentTypes: map[string]bool{}}
+	})
+}
+
+type negotiationTransport struct {
+	next http.RoundTripper
+
+	lock         sync.Mutex
+	contentTypes map[string]bool
+	watchList    *bool
+}
+
+func (t *negotiationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if query := req.URL.Query(); query.Get("watch") == "true" && query.Get("sendInitialEvents") == "true" {
+		t.observeWatchList(resp.StatusCode == http.StatusOK)
+	}
+	if resp.StatusCode < http.StatusMultipleChoices {
+		if contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			t.observeContentType(contentType)
+		}
+	}
+
+	return resp, nil
+}
+
+func (t *negotiationTransport) observeContentType(contentType string) {
+	responseContentTypes.WithLabelValues(contentType).Inc()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.contentTypes[contentType] {
+		t.contentTypes[contentType] = true
+		logrus.Infof("The Kubernetes API server responds with %s", contentType)
+	}
+}
+
+func (t *negotiationTransport) observeWatchList(supported bool) {
+	if supported {
+		watchListSupported.Set(1)
+	} else {
+		watchListSupported.Set(0)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.watchList != nil && *t.watchList == supported {
+		return
+	}
+	t.watchList = &supported
+	if supported {
+		logrus.Info("The Kubernetes API server supports streaming lists, informers stream the initial list of objects through a watch")
+	} else {
+		logrus.Warn("The Kubernetes API server doesn't support streaming lists, informers fall back to listing objects")
+	}
+}
@@ -0,0 +1,53 @@
+package kubeclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
+func TestObserveNegotiation(t *testing.T) {
+	watchList := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sendInitialEvents") == "true" && !watchList {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", runtime.ContentTypeProtobuf+";stream=watch")
+	}))
+	defer srv.Close()
+
+	cfg := &rest.Config{Host: srv.URL}
+	UseProtobuf(cfg)
+	ObserveNegotiation(cfg)
+	transport, err := rest.TransportFor(cfg)
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+	get := func(path string) {
+		resp, err := client.Get(srv.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	protobufResponses := testutil.ToFloat64(responseContentTypes.WithLabelValues(runtime.ContentTypeProtobuf))
+	get("/api/v1/pods?watch=true&sendInitialEvents=true")
+	require.Equal(t, 0.0, testutil.ToFloat64(watchListSupported))
+	require.Equal(t, protobufResponses, testutil.ToFloat64(responseContentTypes.WithLabelValues(runtime.ContentTypeProtobuf)))
+
+	watchList = true
+	get("/api/v1/pods?watch=true&sendInitialEvents=true")
+	require.Equal(t, 1.0, testutil.ToFloat64(watchListSupported))
+	require.Equal(t, protobufResponses+1, testutil.ToFloat64(responseContentTypes.WithLabelValues(runtime.ContentTypeProtobuf)))
+
+	// Plain requests don't change whether streaming lists are supported.
+	watchList = false
+	get("/api/v1/pods")
+	require.Equal(t, 1.0, testutil.ToFloat64(watchListSupported))
+
+	require.Equal(t, runtime.ContentTypeProtobuf+","+runtime.ContentTypeJSON, cfg.AcceptContentTypes)
+}
@@ -0,0 +1,71 @@
+package kubeclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// throttleWarningThreshold is the wait of a single request that is considered a burst worth logging.
+const throttleWarningThreshold = time.Second
+
+var rateLimiterWait = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "k8s_image_availability_exporter_kube_api_rate_limiter_wait_seconds",
+	Help:    "Time Kubernetes API requests waited for the client-side rate limiter.",
+	Buckets: []float64{0.005, 0.025, 0.1, 0.5, 1, 5, 15, 60},
+})
+
+// rateLimiter reports waits for the client-side rate limiter of the Kubernetes API client, so that bursts, e.g.
+// during the initial sync of informers in a large cluster, are visible. A warning is logged at most every warnEvery.
+type rateLimiter struct {
+	flowcontrol.RateLimiter
+
+	observe   func(seconds float64)
+	now       func() time.Time
+	warnEvery time.Duration
+
+	lock     sync.Mutex
+	warnedAt time.Time
+}
+
+// NewRateLimiter returns an instrumented token bucket rate limiter allowing qps requests per second with bursts of
+// up to burst requests, to be set as rest.Config.RateLimiter.
+func NewRateLimiter(qps float32, burst int) flowcontrol.RateLimiter {
+	return &rateLimiter{
+		RateLimiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		observe:     rateLimiterWait.Observe,
+		now:         time.Now,
+		warnEvery:   time.Minute,
+	}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	start := l.now()
+	err := l.RateLimiter.Wait(ctx)
+	waited := l.now().Sub(start)
+
+	l.observe(waited.Seconds())
+	if waited >= throttleWarningThreshold && l.shouldWarn() {
+		logrus.Warnf("Kubernetes API requests are throttled by the client for %s, consider raising -kube-api-qps and -kube-api-burst", waited.Round(time.Millisecond))
+	}
+
+	return err
+}
+
+func (l *rateLimiter) shouldWarn() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	if !l.warnedAt.IsZero() && now.Sub(l.warnedAt) < l.warnEvery {
+		return false
+	}
+	l.warnedAt = now
+
+	return true
+}
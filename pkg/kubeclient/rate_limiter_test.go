@@ -0,0 +1,51 @@
+package kubeclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// slowRateLimiter advances the clock by wait on every Wait.
+type slowRateLimiter struct {
+	flowcontrol.RateLimiter
+	now  *time.Time
+	wait time.Duration
+}
+
+func (l *slowRateLimiter) Wait(_ context.Context) error {
+	*l.now = l.now.Add(l.wait)
+	return nil
+}
+
+func Test_rateLimiter(t *testing.T) {
+	now := time.Now()
+	slow := &slowRateLimiter{RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter(), now: &now}
+
+	var observed []float64
+	l := &rateLimiter{
+		RateLimiter: slow,
+		observe:     func(seconds float64) { observed = append(observed, seconds) },
+		now:         func() time.Time { return now },
+		warnEvery:   time.Minute,
+	}
+
+	slow.wait = 10 * time.Millisecond
+	require.NoError(t, l.Wait(context.Background()))
+	require.True(t, l.warnedAt.IsZero())
+
+	slow.wait = 2 * time.Second
+	require.NoError(t, l.Wait(context.Background()))
+	warnedAt := l.warnedAt
+	require.False(t, warnedAt.IsZero())
+
+	// Warnings are rate limited as well.
+	require.NoError(t, l.Wait(context.Background()))
+	require.Equal(t, warnedAt, l.warnedAt)
+
+	require.Equal(t, []float64{0.01, 2, 2}, observed)
+	require.Equal(t, float32(5), NewRateLimiter(5, 10).QPS())
+}
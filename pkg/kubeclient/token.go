@@ -0,0 +1,114 @@
+package kubeclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+)
+
+// tokenRefreshPeriod is how often the token file is re-read. The kubelet rotates bound ServiceAccount tokens once
+// 80% of their lifetime passes, so a new token is picked up long before the old one expires.
+const tokenRefreshPeriod = time.Minute
+
+var errEmptyToken = errors.New("the token file is empty")
+
+// AuthRefreshFailures counts failures to refresh credentials, by the credentials that failed to refresh.
+var AuthRefreshFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8s_image_availability_exporter_auth_refresh_failures_total",
+	Help: "Number of failures to refresh credentials of the Kubernetes API client or credentials exchanged for registry tokens.",
+}, []string{"credentials"})
+
+// UseRefreshingToken makes the client read its bearer token from the token file, e.g. the bound ServiceAccount token
+// of the pod, every tokenRefreshPeriod and right after the API server rejects the current token, so that rotated
+// tokens are picked up even if the previous one is revoked early. It does nothing for clients without a token file.
+func UseRefreshingToken(cfg *rest.Config) {
+	if len(cfg.BearerTokenFile) == 0 {
+		return
+	}
+
+	path := cfg.BearerTokenFile
+	cfg.BearerToken, cfg.BearerTokenFile = "", ""
+	cfg.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return &tokenFileTransport{path: path, next: next, now: time.Now}
+	})
+}
+
+type tokenFileTransport struct {
+	path string
+	next http.RoundTripper
+	now  func() time.Time
+
+	lock   sync.Mutex
+	token  string
+	readAt time.Time
+}
+
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.current(false)
+	resp, err := t.roundTrip(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	fresh := t.current(true)
+	if fresh == token || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	logrus.Info("The Kubernetes API server rejected the ServiceAccount token, retrying with the rotated one")
+	return t.roundTrip(retry, fresh)
+}
+
+func (t *tokenFileTransport) roundTrip(req *http.Request, token string) (*http.Response, error) {
+	if len(token) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// current returns the token, re-reading the file if it was last read more than tokenRefreshPeriod ago or force is
+// set. The previous token is kept if the file can't be read.
+func (t *tokenFileTransport) current(force bool) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	if !force && !t.readAt.IsZero() && now.Sub(t.readAt) < tokenRefreshPeriod {
+		return t.token
+	}
+	t.readAt = now
+
+	data, err := os.ReadFile(t.path)
+	if err == nil && len(strings.TrimSpace(string(data))) == 0 {
+		err = errEmptyToken
+	}
+	if err != nil {
+		AuthRefreshFailures.WithLabelValues("kube_api").Inc()
+		logrus.Errorf("Failed to refresh the Kubernetes API token, using the previous one: %v", err)
+		return t.token
+	}
+
+	t.token = strings.TrimSpace(string(data))
+	return t.token
+}
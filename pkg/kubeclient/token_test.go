@@ -0,0 +1,76 @@
+package kubeclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func TestUseRefreshingToken(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("old\n"), 0o600))
+
+	valid := "old"
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		seen = append(seen, token)
+		if token != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &rest.Config{Host: srv.URL, BearerToken: "old", BearerTokenFile: tokenPath}
+	UseRefreshingToken(cfg)
+	require.Empty(t, cfg.BearerToken)
+	require.Empty(t, cfg.BearerTokenFile)
+
+	transport, err := rest.TransportFor(cfg)
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+	get := func() int {
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get())
+
+	// The old token is revoked right after the rotation, the request is retried with the new one.
+	require.NoError(t, os.WriteFile(tokenPath, []byte("new\n"), 0o600))
+	valid = "new"
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, []string{"old", "old", "new"}, seen)
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, []string{"old", "old", "new", "new"}, seen)
+}
+
+func Test_tokenFileTransport_current(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("old"), 0o600))
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	transport := &tokenFileTransport{path: tokenPath, now: func() time.Time { return now }}
+	require.Equal(t, "old", transport.current(false))
+
+	require.NoError(t, os.WriteFile(tokenPath, []byte("new"), 0o600))
+	require.Equal(t, "old", transport.current(false))
+	now = now.Add(tokenRefreshPeriod)
+	require.Equal(t, "new", transport.current(false))
+
+	// The previous token is used until the file can be read again.
+	failures := testutil.ToFloat64(AuthRefreshFailures.WithLabelValues("kube_api"))
+	require.NoError(t, os.Remove(tokenPath))
+	require.Equal(t, "new", transport.current(true))
+	require.Equal(t, failures+1, testutil.ToFloat64(AuthRefreshFailures.WithLabelValues("kube_api")))
+}
@@ -0,0 +1,211 @@
+// Package manifests checks images of rendered Kubernetes manifests before they are applied, e.g. by the simulation
+// endpoint or by a CD pipeline before kubectl apply.
+package manifests
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// Stdin is the path manifests are read from standard input with.
+const Stdin = "-"
+
+// SimulateFunc checks images of a pod spec as if it was applied to the namespace.
+type SimulateFunc func(namespace string, spec corev1.PodSpec) []registry.SimulatedContainer
+
+// Object is the availability of images of an object in a manifest.
+type Object struct {
+	Kind       string                        `json:"kind"`
+	Namespace  string                        `json:"namespace"`
+	Name       string                        `json:"name"`
+	Containers []registry.SimulatedContainer `json:"containers"`
+}
+
+// Report lists objects of manifests. Available is set if all of their images are available.
+type Report struct {
+	Available bool     `json:"available"`
+	Objects   []Object `json:"objects"`
+}
+
+// Unavailable returns the number of containers whose images aren't available.
+func (r Report) Unavailable() (ret int) {
+	for _, obj := range r.Objects {
+		for _, container := range obj.Containers {
+			if container.Mode != store.Available.String() {
+				ret++
+			}
+		}
+	}
+
+	return
+}
+
+// Write writes the report as indented JSON.
+func (r Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Check checks images of the objects with simulate. A non-empty namespace overrides namespaces of the objects, which
+// default to "default".
+func Check(objects []runtime.Object, namespace string, simulate SimulateFunc) Report {
+	report := Report{Available: true, Objects: []Object{}}
+	for _, obj := range objects {
+		meta, spec, _ := PodSpecOf(obj)
+		objNamespace := namespaceOf(meta, namespace)
+
+		report.Objects = append(report.Objects, Object{
+			Kind:       obj.GetObjectKind().GroupVersionKind().Kind,
+			Namespace:  objNamespace,
+			Name:       meta.Name,
+			Containers: simulate(objNamespace, spec),
+		})
+	}
+	report.Available = report.Unavailable() == 0
+
+	return report
+}
+
+// Namespaces returns the distinct namespaces Check checks the objects for, i.e. whose pull secrets it uses.
+func Namespaces(objects []runtime.Object, namespace string) (ret []string) {
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		meta, _, _ := PodSpecOf(obj)
+		if objNamespace := namespaceOf(meta, namespace); !seen[objNamespace] {
+			seen[objNamespace] = true
+			ret = append(ret, objNamespace)
+		}
+	}
+
+	return
+}
+
+// namespaceOf returns the namespace an object is checked for: the overriding namespace if it's not empty, the
+// object's own one otherwise, or "default" if neither is set.
+func namespaceOf(meta metav1.ObjectMeta, namespace string) string {
+	if len(namespace) > 0 {
+		return namespace
+	}
+	if len(meta.Namespace) > 0 {
+		return meta.Namespace
+	}
+
+	return metav1.NamespaceDefault
+}
+
+// Read decodes objects of the manifests at path: a YAML or JSON file, a directory whose *.yaml, *.yml and *.json
+// files are read recursively in lexical order, or standard input if path is "-".
+func Read(path string, stdin io.Reader) ([]runtime.Object, error) {
+	if path == Stdin {
+		return Decode(stdin)
+	}
+
+	var objects []runtime.Object
+	err := filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Files given explicitly are read whatever their extension is.
+		if filePath != path {
+			switch filepath.Ext(filePath) {
+			case ".yaml", ".yml", ".json":
+			default:
+				return nil
+			}
+		}
+
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fileObjects, err := Decode(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filePath, err)
+		}
+		objects = append(objects, fileObjects...)
+		return nil
+	})
+
+	return objects, err
+}
+
+// Decode decodes objects of a YAML or JSON manifest, possibly with multiple documents, that have a pod spec. Other
+// objects, including ones of kinds that aren't built into Kubernetes, are skipped.
+func Decode(r io.Reader) ([]runtime.Object, error) {
+	var objects []runtime.Object
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var fields map[string]interface{}
+		if err := utilyaml.Unmarshal(doc, &fields); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+		if runtime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if _, _, ok := PodSpecOf(obj); ok {
+			objects = append(objects, obj)
+		}
+	}
+}
+
+// PodSpecOf returns the metadata and the pod spec of a pod or a workload controller.
+func PodSpecOf(obj runtime.Object) (metav1.ObjectMeta, corev1.PodSpec, bool) {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return o.ObjectMeta, o.Spec, true
+	case *appsv1.Deployment:
+		return o.ObjectMeta, o.Spec.Template.Spec, true
+	case *appsv1.StatefulSet:
+		return o.ObjectMeta, o.Spec.Template.Spec, true
+	case *appsv1.DaemonSet:
+		return o.ObjectMeta, o.Spec.Template.Spec, true
+	case *appsv1.ReplicaSet:
+		return o.ObjectMeta, o.Spec.Template.Spec, true
+	case *batchv1.Job:
+		return o.ObjectMeta, o.Spec.Template.Spec, true
+	case *batchv1.CronJob:
+		return o.ObjectMeta, o.Spec.JobTemplate.Spec.Template.Spec, true
+	}
+
+	return metav1.ObjectMeta{}, corev1.PodSpec{}, false
+}
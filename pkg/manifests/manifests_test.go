@@ -0,0 +1,126 @@
+package manifests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const deploymentManifest = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+# A custom resource
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: staging
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      initContainers:
+        - name: migrations
+          image: registry.example.com/migrations:v1
+      containers:
+        - name: app
+          image: registry.example.com/app:v1
+`
+
+const cronJobManifest = `{
+  "apiVersion": "batch/v1",
+  "kind": "CronJob",
+  "metadata": {"name": "cleanup"},
+  "spec": {
+    "schedule": "* * * * *",
+    "jobTemplate": {"spec": {"template": {"spec": {"containers": [{"name": "cleanup", "image": "registry.example.com/cleanup:v1"}]}}}}
+  }
+}`
+
+func names(objects []runtime.Object) (ret []string) {
+	for _, obj := range objects {
+		meta, _, _ := PodSpecOf(obj)
+		ret = append(ret, obj.GetObjectKind().GroupVersionKind().Kind+"/"+meta.Name)
+	}
+	return
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "deployment.yaml"), []byte(deploymentManifest), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cronjob.json"), []byte(cronJobManifest), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "NOTES.txt"), []byte("kind: ["), 0o644))
+
+	objects, err := Read(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"CronJob/cleanup", "Deployment/app"}, names(objects))
+
+	objects, err = Read(Stdin, strings.NewReader(deploymentManifest))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Deployment/app"}, names(objects))
+
+	// Files given explicitly are read whatever their extension is.
+	_, err = Read(filepath.Join(dir, "NOTES.txt"), nil)
+	require.ErrorContains(t, err, "NOTES.txt")
+
+	_, err = Read(filepath.Join(dir, "missing"), nil)
+	require.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	objects, err := Decode(strings.NewReader(deploymentManifest + "---\n" + cronJobManifest))
+	require.NoError(t, err)
+
+	namespaces := make(map[string]string)
+	simulate := func(namespace string, spec corev1.PodSpec) (ret []registry.SimulatedContainer) {
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			namespaces[container.Image] = namespace
+			mode := store.Available
+			if strings.Contains(container.Image, "cleanup") {
+				mode = store.Absent
+			}
+			ret = append(ret, registry.SimulatedContainer{Container: container.Name, Image: container.Image, Mode: mode.String()})
+		}
+		return
+	}
+
+	report := Check(objects, "", simulate)
+	require.False(t, report.Available)
+	require.Equal(t, 1, report.Unavailable())
+	require.Len(t, report.Objects, 2)
+	require.Len(t, report.Objects[0].Containers, 2)
+	require.Equal(t, map[string]string{
+		"registry.example.com/migrations:v1": "staging",
+		"registry.example.com/app:v1":        "staging",
+		"registry.example.com/cleanup:v1":    "default",
+	}, namespaces)
+
+	report = Check(objects[:1], "prod", simulate)
+	require.True(t, report.Available)
+	require.Equal(t, "prod", namespaces["registry.example.com/app:v1"])
+
+	_, err = Decode(strings.NewReader("kind: ["))
+	require.Error(t, err)
+}
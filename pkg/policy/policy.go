@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var containerLabels = []string{"namespace", "container", "image", "kind", "name"}
+
+const (
+	deniedTagPolicy              = "denied_tag"
+	semverOrDigestRequiredPolicy = "semver_or_digest_required"
+	digestRequiredPolicy         = "digest_required"
+)
+
+var builtinPolicies = map[string]bool{
+	deniedTagPolicy:              true,
+	semverOrDigestRequiredPolicy: true,
+	digestRequiredPolicy:         true,
+}
+
+var (
+	unapprovedRegistryDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_from_unapproved_registry",
+		"Non-zero indicates that the image is pulled from a registry that doesn't match any of the approved prefixes.",
+		containerLabels, nil,
+	)
+	policyViolationDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_policy_violation",
+		"Non-zero indicates that the image reference violates the tag policy.",
+		append(containerLabels, "policy"), nil,
+	)
+)
+
+var semverRegex = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// Config describes image policies. Policies are independent of image availability.
+type Config struct {
+	// ApprovedRegistries is a list of approved repository prefixes, e.g. "registry.example.com" or
+	// "docker.io/example". The allowlist policy is disabled if it is empty.
+	ApprovedRegistries []string
+	// DeniedTags is a list of tags that must not be used, e.g. "latest". References without a tag are considered
+	// to use the "latest" tag.
+	DeniedTags []string
+	// SemverOrDigestNamespaces is a list of namespace patterns in which images must be referenced either by
+	// a semantic version tag or by a digest.
+	SemverOrDigestNamespaces []string
+	// DigestNamespaces is a list of namespace patterns in which images must be referenced by a digest.
+	DigestNamespaces []string
+	// Rego policies are evaluated in addition to the built-in ones, if set. Their violations are reported with the
+	// names of the violated policies, except for names of the built-in policies.
+	Rego *RegoPolicy
+}
+
+type parseFunc func(image string) (name.Reference, error)
+
+// Engine evaluates policies for every container referencing an image.
+type Engine struct {
+	approvedRegistries       []string
+	deniedTags               []string
+	semverOrDigestNamespaces []string
+	digestNamespaces         []string
+	rego                     *RegoPolicy
+
+	parse parseFunc
+}
+
+// Container is a container referencing an image.
+type Container struct {
+	Image string
+	Info  store.ContainerInfo
+}
+
+func NewEngine(config Config, parse parseFunc) *Engine {
+	e := &Engine{
+		deniedTags:               config.DeniedTags,
+		semverOrDigestNamespaces: config.SemverOrDigestNamespaces,
+		digestNamespaces:         config.DigestNamespaces,
+		rego:                     config.Rego,
+
+		parse: parse,
+	}
+
+	for _, prefix := range config.ApprovedRegistries {
+		e.approvedRegistries = append(e.approvedRegistries, normalizePrefix(prefix))
+	}
+
+	return e
+}
+
+// Enabled reports whether any policy is configured.
+func (e *Engine) Enabled() bool {
+	return len(e.approvedRegistries) > 0 || len(e.deniedTags) > 0 || len(e.semverOrDigestNamespaces) > 0 || len(e.digestNamespaces) > 0 ||
+		e.rego != nil
+}
+
+// Describe sends descriptors of policy metrics.
+func (e *Engine) Describe(ch chan<- *prometheus.Desc) {
+	ch <- unapprovedRegistryDesc
+	ch <- policyViolationDesc
+}
+
+// EvaluateAll returns policy metrics for all containers. Cached results of Rego policies are kept for the containers
+// passed to the previous call only.
+func (e *Engine) EvaluateAll(containers []Container) (ret []prometheus.Metric) {
+	if e.rego != nil {
+		e.rego.rotate()
+	}
+
+	for _, container := range containers {
+		ret = append(ret, e.Evaluate(container.Image, container.Info)...)
+	}
+
+	return
+}
+
+// Evaluate returns policy metrics for a container. Images that can't be parsed aren't evaluated, since they are
+// already reported as having a bad image format.
+func (e *Engine) Evaluate(image string, ci store.ContainerInfo) (ret []prometheus.Metric) {
+	ref, err := e.parse(image)
+	if err != nil {
+		return nil
+	}
+
+	labelValues := []string{ci.Namespace, ci.Container, image, strings.ToLower(ci.ControllerKind), ci.ControllerName}
+
+	if len(e.approvedRegistries) > 0 {
+		ret = append(ret, prometheus.MustNewConstMetric(unapprovedRegistryDesc, prometheus.GaugeValue,
+			boolToFloat(!e.approved(ref)), labelValues...))
+	}
+
+	tag, byDigest := tagOf(ref)
+
+	if len(e.deniedTags) > 0 {
+		ret = append(ret, newPolicyViolationMetric(deniedTagPolicy, !byDigest && slices.Contains(e.deniedTags, tag), labelValues))
+	}
+	if matchesNamespace(ci.Namespace, e.semverOrDigestNamespaces) {
+		ret = append(ret, newPolicyViolationMetric(semverOrDigestRequiredPolicy, !byDigest && !semverRegex.MatchString(tag), labelValues))
+	}
+	if matchesNamespace(ci.Namespace, e.digestNamespaces) {
+		ret = append(ret, newPolicyViolationMetric(digestRequiredPolicy, !byDigest, labelValues))
+	}
+
+	if e.rego != nil {
+		for _, policy := range e.rego.violations(newRegoInput(image, ref, ci)) {
+			if builtinPolicies[policy] {
+				logrus.WithField("image_name", image).Warnf("Ignoring a violation of the Rego policy %q named like a built-in policy", policy)
+				continue
+			}
+			ret = append(ret, newPolicyViolationMetric(policy, true, labelValues))
+		}
+	}
+
+	return
+}
+
+func newPolicyViolationMetric(policy string, violated bool, labelValues []string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(policyViolationDesc, prometheus.GaugeValue, boolToFloat(violated), append(labelValues, policy)...)
+}
+
+// tagOf returns the tag of a reference, or reports that the reference is pinned by a digest.
+func tagOf(ref name.Reference) (tag string, byDigest bool) {
+	switch r := ref.(type) {
+	case name.Digest:
+		return "", true
+	case name.Tag:
+		return r.TagStr(), false
+	}
+
+	return ref.Identifier(), false
+}
+
+func matchesNamespace(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *Engine) approved(ref name.Reference) bool {
+	repository := ref.Context().Name()
+	for _, prefix := range e.approvedRegistries {
+		if repository == prefix || strings.HasPrefix(repository, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizePrefix normalizes the registry part of a prefix the same way image references are normalized,
+// e.g. "docker.io" becomes "index.docker.io".
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	host, repository, _ := strings.Cut(prefix, "/")
+	if registry, err := name.NewRegistry(host); err == nil {
+		host = registry.RegistryStr()
+	}
+
+	if len(repository) == 0 {
+		return host
+	}
+
+	return host + "/" + repository
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
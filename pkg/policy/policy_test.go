@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(image string) (name.Reference, error) {
+	return name.ParseReference(image)
+}
+
+func TestEngine_approved(t *testing.T) {
+	e := NewEngine(Config{ApprovedRegistries: []string{"registry.example.com", "docker.io/example/"}}, parse)
+	require.True(t, e.Enabled())
+
+	for image, approved := range map[string]bool{
+		"registry.example.com/app:v1":        true,
+		"registry.example.com:5000/app:v1":   false,
+		"example/app:v1":                     true,
+		"index.docker.io/example/app:v1":     true,
+		"docker.io/examplecorp/app:v1":       false,
+		"nginx:1.25":                         false,
+		"registry.example.com.evil.io/app:1": false,
+	} {
+		ref, err := parse(image)
+		require.NoError(t, err)
+		require.Equal(t, approved, e.approved(ref), image)
+	}
+
+	require.False(t, NewEngine(Config{}, parse).Enabled())
+}
+
+func Test_tagOf(t *testing.T) {
+	for image, expected := range map[string]struct {
+		tag      string
+		byDigest bool
+	}{
+		"nginx":        {tag: "latest"},
+		"nginx:1.25.3": {tag: "1.25.3"},
+		"nginx:1.25.3@sha256:0000000000000000000000000000000000000000000000000000000000000000": {byDigest: true},
+	} {
+		ref, err := parse(image)
+		require.NoError(t, err)
+
+		tag, byDigest := tagOf(ref)
+		require.Equal(t, expected.tag, tag, image)
+		require.Equal(t, expected.byDigest, byDigest, image)
+	}
+
+	require.True(t, semverRegex.MatchString("v1.2.3-rc.1"))
+	require.False(t, semverRegex.MatchString("1.2"))
+}
@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	// regoQuery is the rule policies define violations of a container in, as a set of policy names.
+	regoQuery = "data.k8s_image_availability_exporter.violations"
+
+	regoEvalTimeout = time.Second
+)
+
+// regoInput is the input document of Rego policies.
+type regoInput struct {
+	Image      string `json:"image"`
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	Namespace  string `json:"namespace"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Container  string `json:"container"`
+	PullPolicy string `json:"pull_policy"`
+}
+
+// RegoPolicy evaluates user-supplied Rego policies for every container. Results are cached for containers that
+// are still present, since evaluation happens on every scrape.
+type RegoPolicy struct {
+	query rego.PreparedEvalQuery
+
+	lock     sync.Mutex
+	current  map[regoInput][]string
+	previous map[regoInput][]string
+}
+
+// LoadRego loads Rego policies from a file or a directory.
+func LoadRego(path string) (*RegoPolicy, error) {
+	query, err := rego.New(
+		rego.Query(regoQuery),
+		rego.Load([]string{path}, nil),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegoPolicy{
+		query:   query,
+		current: make(map[regoInput][]string),
+	}, nil
+}
+
+func newRegoInput(image string, ref name.Reference, ci store.ContainerInfo) regoInput {
+	input := regoInput{
+		Image:      image,
+		Registry:   ref.Context().RegistryStr(),
+		Repository: ref.Context().RepositoryStr(),
+		Namespace:  ci.Namespace,
+		Kind:       strings.ToLower(ci.ControllerKind),
+		Name:       ci.ControllerName,
+		Container:  ci.Container,
+		PullPolicy: ci.PullPolicy,
+	}
+
+	switch r := ref.(type) {
+	case name.Digest:
+		input.Digest = r.DigestStr()
+	case name.Tag:
+		input.Tag = r.TagStr()
+	}
+
+	return input
+}
+
+// rotate drops cached results of containers that weren't evaluated since the previous rotation.
+func (p *RegoPolicy) rotate() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.previous, p.current = p.current, make(map[regoInput][]string, len(p.current))
+}
+
+// violations returns names of the policies the container violates.
+func (p *RegoPolicy) violations(input regoInput) []string {
+	p.lock.Lock()
+	if violations, ok := p.current[input]; ok {
+		p.lock.Unlock()
+		return violations
+	}
+	if violations, ok := p.previous[input]; ok {
+		p.current[input] = violations
+		p.lock.Unlock()
+		return violations
+	}
+	p.lock.Unlock()
+
+	violations, err := p.eval(input)
+	if err != nil {
+		// Failed evaluations aren't cached, so that they are retried on the next scrape.
+		logrus.WithField("image_name", input.Image).Errorf("Failed to evaluate Rego policies: %v", err)
+		return nil
+	}
+
+	p.lock.Lock()
+	p.current[input] = violations
+	p.lock.Unlock()
+
+	return violations
+}
+
+func (p *RegoPolicy) eval(input regoInput) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), regoEvalTimeout)
+	defer cancel()
+
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+
+	// An undefined rule means there are no violations.
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+
+	values, ok := results[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a set of strings, got %T", regoQuery, results[0].Expressions[0].Value)
+	}
+
+	violations := make([]string, 0, len(values))
+	for _, value := range values {
+		policy, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a set of strings, got an element of type %T", regoQuery, value)
+		}
+		violations = append(violations, policy)
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const testRegoPolicy = `package k8s_image_availability_exporter
+
+import rego.v1
+
+violations contains "registry_not_in_org" if {
+	not startswith(input.repository, "example/")
+	input.registry == "index.docker.io"
+}
+
+violations contains "ifnotpresent_in_prod" if {
+	input.namespace == "prod"
+	input.pull_policy == "IfNotPresent"
+}
+
+violations contains "denied_tag" if {
+	input.tag == "latest"
+}
+`
+
+func policyNames(t *testing.T, metrics []prometheus.Metric) (ret []string) {
+	t.Helper()
+
+	for _, m := range metrics {
+		var metric dto.Metric
+		require.NoError(t, m.Write(&metric))
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "policy" && metric.GetGauge().GetValue() == 1 {
+				ret = append(ret, label.GetValue())
+			}
+		}
+	}
+
+	return
+}
+
+func TestEngine_rego(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	require.NoError(t, os.WriteFile(path, []byte(testRegoPolicy), 0o600))
+
+	regoPolicy, err := LoadRego(path)
+	require.NoError(t, err)
+
+	e := NewEngine(Config{Rego: regoPolicy}, parse)
+	require.True(t, e.Enabled())
+
+	prod := store.ContainerInfo{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app", PullPolicy: "IfNotPresent"}
+	require.ElementsMatch(t, []string{"registry_not_in_org", "ifnotpresent_in_prod"}, policyNames(t, e.EvaluateAll([]Container{
+		{Image: "nginx:latest", Info: prod},
+	})), "violations named like built-in policies must be ignored")
+
+	require.Empty(t, policyNames(t, e.EvaluateAll([]Container{
+		{Image: "example/app:v1", Info: store.ContainerInfo{Namespace: "dev", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}},
+	})))
+	require.Len(t, regoPolicy.current, 1)
+	require.Len(t, regoPolicy.previous, 1, "results of containers gone since the previous evaluation must be dropped")
+}
+
+func TestLoadRego(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	require.NoError(t, os.WriteFile(path, []byte("package k8s_image_availability_exporter\nviolations contains"), 0o600))
+
+	_, err := LoadRego(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package redisbackend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestBackend(t *testing.T) {
+	srv := miniredis.RunT(t)
+	require.NoError(t, srv.Set("other", "value"))
+	require.NoError(t, srv.Set("prefix:image:corrupted", "value"))
+
+	b, err := New("redis://"+srv.Addr(), "prefix:", "replica-0", time.Hour)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.NoError(t, b.Save("registry.example.com/app:v1", store.Absent))
+	require.NoError(t, b.Save("registry.example.com/app:v2", store.Available))
+	require.NoError(t, b.Save("registry.example.com/app:v1", store.AuthnFailure))
+
+	// Results are written in batches.
+	known, err := b.Load()
+	require.NoError(t, err)
+	require.Empty(t, known)
+
+	require.NoError(t, b.flush(context.TODO()))
+	known, err = b.Load()
+	require.NoError(t, err)
+	require.Equal(t, map[string]store.AvailabilityMode{
+		"registry.example.com/app:v1": store.AuthnFailure,
+		"registry.example.com/app:v2": store.Available,
+	}, known)
+
+	srv.FastForward(2 * time.Hour)
+	known, err = b.Load()
+	require.NoError(t, err)
+	require.Empty(t, known)
+
+	_, err = New("redis://"+srv.Addr()+"/invalid", "prefix:", "replica-0", time.Hour)
+	require.Error(t, err)
+}
+
+func TestBackend_Owns(t *testing.T) {
+	srv := miniredis.RunT(t)
+
+	first, err := New("redis://"+srv.Addr(), "prefix:", "replica-0", time.Hour)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := New("redis://"+srv.Addr(), "prefix:", "replica-1", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, first.heartbeat(context.TODO()))
+
+	images := make([]string, 100)
+	for i := range images {
+		images[i] = fmt.Sprintf("registry.example.com/app:v%d", i)
+	}
+
+	// Every image is checked by exactly one replica, and both replicas get a share.
+	var owned int
+	for _, image := range images {
+		require.NotEqual(t, first.Owns(image), second.Owns(image), image)
+		if first.Owns(image) {
+			owned++
+		}
+	}
+	require.Greater(t, owned, 0)
+	require.Less(t, owned, len(images))
+
+	// Images of a replica that is gone are taken over.
+	require.NoError(t, second.Close())
+	require.NoError(t, first.heartbeat(context.TODO()))
+	for _, image := range images {
+		require.True(t, first.Owns(image), image)
+	}
+
+	// So are images of a replica that stopped renewing its registration.
+	require.NoError(t, srv.Set("prefix:replica:replica-2", ""))
+	srv.SetTTL("prefix:replica:replica-2", replicaTTL)
+	require.NoError(t, first.heartbeat(context.TODO()))
+	require.Len(t, first.replicas, 2)
+	srv.FastForward(replicaTTL)
+	require.NoError(t, first.heartbeat(context.TODO()))
+	require.Equal(t, []string{"replica-0"}, first.replicas)
+}
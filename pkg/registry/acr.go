@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	azureAuthorityURLFmt          = "https://%s/%s/oauth2/v2.0/token"
+	azurePublicCloudAuthorityHost = "login.microsoftonline.com"
+	azureManagementScope          = "https://management.azure.com/.default"
+	azureManagementResource       = "https://management.azure.com/"
+	azureIMDSTokenURL             = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureRegistryUsername         = "00000000-0000-0000-0000-000000000000"
+	// ACR refresh tokens are documented to be valid for 3 hours. The lifetime is taken from the token itself
+	// when possible.
+	azureRefreshTokenTTL = 3 * time.Hour
+	// azureManagedIdentityClientID is set as the client ID in azure.json of AKS clusters using a managed identity.
+	azureManagedIdentityClientID = "msi"
+)
+
+var (
+	azureRegistryPatterns = []string{"*.azurecr.io", "*.azurecr.cn", "*.azurecr.us"}
+
+	azureAuthorityHosts = map[string]string{
+		"azurepubliccloud":       azurePublicCloudAuthorityHost,
+		"azurechinacloud":        "login.chinacloudapi.cn",
+		"azureusgovernmentcloud": "login.microsoftonline.us",
+	}
+)
+
+// azureCloudConfig is the subset of the Azure cloud provider config (azure.json) that is used to obtain AAD tokens.
+type azureCloudConfig struct {
+	Cloud                       string `json:"cloud"`
+	TenantID                    string `json:"tenantId"`
+	AADClientID                 string `json:"aadClientId"`
+	AADClientSecret             string `json:"aadClientSecret"`
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension"`
+	UserAssignedIdentityID      string `json:"userAssignedIdentityID"`
+}
+
+type azureConfigCredentials struct {
+	config azureCloudConfig
+	tokenClient
+
+	imdsURL string
+}
+
+// newAzureConfigKeychain returns a keychain exchanging AAD tokens of the service principal or the managed identity
+// from the Azure cloud provider config for ACR refresh tokens, the same way the kubelet credential provider does.
+func newAzureConfigKeychain(configPath string, transport http.RoundTripper) (*exchangeKeychain, error) {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &azureConfigCredentials{tokenClient: newTokenClient(transport), imdsURL: azureIMDSTokenURL}
+	if err := json.Unmarshal(raw, &c.config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	if !c.useManagedIdentity() && (len(c.config.TenantID) == 0 || len(c.config.AADClientID) == 0 || len(c.config.AADClientSecret) == 0) {
+		return nil, fmt.Errorf("%s contains neither service principal credentials nor a managed identity configuration", configPath)
+	}
+
+	return newExchangeKeychain("azure.json", azureRegistryPatterns, c.exchange), nil
+}
+
+func (c *azureConfigCredentials) useManagedIdentity() bool {
+	return c.config.UseManagedIdentityExtension || c.config.AADClientID == azureManagedIdentityClientID
+}
+
+func (c *azureConfigCredentials) exchange(registry string) (authn.AuthConfig, time.Time, error) {
+	var aadAccessToken string
+	var err error
+	if c.useManagedIdentity() {
+		aadAccessToken, err = c.managedIdentityToken()
+	} else {
+		aadAccessToken, err = c.servicePrincipalToken()
+	}
+	if err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
+	}
+
+	return exchangeACRRefreshToken(c.tokenClient, registry, c.config.TenantID, aadAccessToken)
+}
+
+func (c *azureConfigCredentials) servicePrincipalToken() (string, error) {
+	authorityHost, ok := azureAuthorityHosts[strings.ToLower(c.config.Cloud)]
+	if !ok {
+		authorityHost = azurePublicCloudAuthorityHost
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := c.postForm(fmt.Sprintf(azureAuthorityURLFmt, authorityHost, url.PathEscape(c.config.TenantID)), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.config.AADClientID},
+		"client_secret": {c.config.AADClientSecret},
+		"scope":         {azureManagementScope},
+	}, &resp)
+
+	return resp.AccessToken, err
+}
+
+func (c *azureConfigCredentials) managedIdentityToken() (string, error) {
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {azureManagementResource},
+	}
+	if len(c.config.UserAssignedIdentityID) > 0 {
+		query.Set("client_id", c.config.UserAssignedIdentityID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.imdsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = c.do(req, &resp)
+
+	return resp.AccessToken, err
+}
+
+// exchangeACRRefreshToken exchanges an AAD access token for an ACR refresh token, which is then used as a password
+// of the well-known null GUID user.
+func exchangeACRRefreshToken(client tokenClient, registry, tenantID, aadAccessToken string) (authn.AuthConfig, time.Time, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {aadAccessToken},
+	}
+	if len(tenantID) > 0 {
+		form.Set("tenant", tenantID)
+	}
+
+	var acrResp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := client.postForm((&url.URL{Scheme: "https", Host: registry, Path: "/oauth2/exchange"}).String(), form, &acrResp); err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
+	}
+
+	expiresAt, ok := jwtExpiry(acrResp.RefreshToken)
+	if !ok {
+		expiresAt = time.Now().Add(azureRefreshTokenTTL)
+	}
+
+	return authn.AuthConfig{Username: azureRegistryUsername, Password: acrResp.RefreshToken}, expiresAt, nil
+}
+
+// jwtExpiry returns the "exp" claim of a JWT without verifying it.
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(claims.Exp, 0), true
+}
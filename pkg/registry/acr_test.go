@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_azureConfigKeychain_managedIdentity(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	refreshToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, expiresAt.Unix()))) + ".sig"
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			require.Equal(t, "true", r.Header.Get("Metadata"))
+			require.Equal(t, "identity-id", r.URL.Query().Get("client_id"))
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "aad-token"})
+		case "/oauth2/exchange":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "aad-token", r.PostForm.Get("access_token"))
+			require.Equal(t, r.Host, r.PostForm.Get("service"))
+			_ = json.NewEncoder(w).Encode(map[string]string{"refresh_token": refreshToken})
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	configPath := filepath.Join(t.TempDir(), "azure.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"tenantId":"tenant","aadClientId":"msi","aadClientSecret":"msi","userAssignedIdentityID":"identity-id"}`), 0o600))
+
+	_, err := newAzureConfigKeychain(configPath, srv.Client().Transport)
+	require.NoError(t, err)
+
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c := &azureConfigCredentials{tokenClient: newTokenClient(srv.Client().Transport), imdsURL: srv.URL + "/metadata/identity/oauth2/token"}
+	require.NoError(t, json.Unmarshal([]byte(`{"tenantId":"tenant","aadClientId":"msi","userAssignedIdentityID":"identity-id"}`), &c.config))
+
+	auth, exp, err := c.exchange(srvURL.Host)
+	require.NoError(t, err)
+	require.Equal(t, azureRegistryUsername, auth.Username)
+	require.Equal(t, refreshToken, auth.Password)
+	require.True(t, exp.Equal(expiresAt))
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"tenantId":"tenant"}`), 0o600))
+	_, err = newAzureConfigKeychain(configPath, srv.Client().Transport)
+	require.Error(t, err)
+}
+
+func Test_exchangeKeychain_staleCredential(t *testing.T) {
+	var fail bool
+	expiresAt := time.Now().Add(time.Minute)
+	kc := newExchangeKeychain("test", []string{"*.azurecr.io"}, func(string) (authn.AuthConfig, time.Time, error) {
+		if fail {
+			return authn.AuthConfig{}, time.Time{}, errors.New("exchange failed")
+		}
+		return authn.AuthConfig{Username: "user", Password: "password"}, expiresAt, nil
+	})
+
+	target := name.MustParseReference("example.azurecr.io/app:v1").Context()
+
+	_, err := kc.Resolve(target)
+	require.NoError(t, err)
+
+	// The credential is about to expire, but it is still used if renewal fails.
+	fail = true
+	auth, err := kc.Resolve(target)
+	require.NoError(t, err)
+	authConfig, err := auth.Authorization()
+	require.NoError(t, err)
+	require.Equal(t, "password", authConfig.Password)
+
+	expiresAt = time.Now().Add(-time.Second)
+	kc.cache[target.RegistryStr()] = cachedCredential{expiresAt: expiresAt}
+	auth, err = kc.Resolve(target)
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, auth)
+}
+
+func Test_jwtExpiry(t *testing.T) {
+	_, ok := jwtExpiry("opaque")
+	require.False(t, ok)
+
+	exp, ok := jwtExpiry("e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1700000000}`)) + ".")
+	require.True(t, ok)
+	require.Equal(t, int64(1700000000), exp.Unix())
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var badImageNameDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_bad_image_name",
+	"Container referencing an image that can't be parsed or contains unexpanded templating, along with the error, the "+
+		"value is always 1.",
+	[]string{"namespace", "kind", "name", "container", "image", "error"}, nil,
+)
+
+// badImageNames remembers why images couldn't be parsed, so that the error is exported along with the containers
+// referencing the image.
+type badImageNames struct {
+	lock   sync.Mutex
+	errors map[string]string
+}
+
+func newBadImageNames() *badImageNames {
+	return &badImageNames{errors: make(map[string]string)}
+}
+
+func (b *badImageNames) record(image string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.errors[image] = err.Error()
+}
+
+func (b *badImageNames) forget(image string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.errors, image)
+}
+
+// collect exports containers of tracked images that are reported as bad image names or invalid references, and forgets
+// untracked images.
+func (b *badImageNames) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	type badContainer struct {
+		image         string
+		containerInfo store.ContainerInfo
+	}
+
+	tracked := make(map[string]struct{})
+	var containers []badContainer
+	imageStore.RangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+		tracked[image] = struct{}{}
+		if availMode == store.BadImageName || availMode == store.InvalidReference {
+			containers = append(containers, badContainer{image: image, containerInfo: containerInfo})
+		}
+	})
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for image := range b.errors {
+		if _, ok := tracked[image]; !ok {
+			delete(b.errors, image)
+		}
+	}
+
+	for _, c := range containers {
+		errStr, ok := b.errors[c.image]
+		if !ok {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(badImageNameDesc, prometheus.GaugeValue, 1,
+			c.containerInfo.Namespace, strings.ToLower(c.containerInfo.ControllerKind), c.containerInfo.ControllerName,
+			c.containerInfo.Container, c.image, errStr)
+	}
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestChecker_badImageNames(t *testing.T) {
+	const badImage = "registry.example.com/App:v1"
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+
+	replicas := int32(1)
+	rc := &Checker{
+		controllerIndexers: ControllerIndexers{
+			namespaceIndexer: namespaceIndexer,
+			deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate(badImage)},
+			}),
+			statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+			daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+			cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+		},
+		badImageNames: newBadImageNames(),
+	}
+	require.Equal(t, []string{"default/deployment/app/app"}, rc.containersOf(badImage))
+
+	templatedImage := "registry.example.com/app:{{ .Values.tag }}"
+	availMode := rc.checkImageAvailability(logrus.WithField("image_name", templatedImage), templatedImage, authn.DefaultKeychain, nil)
+	require.Equal(t, store.InvalidReference, availMode)
+	require.Contains(t, rc.badImageNames.errors, templatedImage)
+
+	availMode = rc.checkImageAvailability(logrus.WithField("image_name", badImage), badImage, authn.DefaultKeychain, nil)
+	require.Equal(t, store.BadImageName, availMode)
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.BadImageName }, 1, 1)
+	imageStore.ReconcileImage(badImage, rc.controllerIndexers.GetContainerInfosForImage(badImage))
+	imageStore.Check()
+
+	ch := make(chan prometheus.Metric, 10)
+	rc.badImageNames.collect(imageStore, ch)
+	close(ch)
+	require.Len(t, ch, 1)
+
+	var m dto.Metric
+	require.NoError(t, (<-ch).Write(&m))
+	labels := make(map[string]string)
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	require.Equal(t, "default", labels["namespace"])
+	require.Equal(t, "deployment", labels["kind"])
+	require.Equal(t, "app", labels["name"])
+	require.Equal(t, "app", labels["container"])
+	require.Equal(t, badImage, labels["image"])
+	require.Contains(t, labels["error"], "repository can only contain")
+
+	// Untracked images are forgotten.
+	rc.badImageNames.collect(store.NewImageStore(nil, 1, 1), make(chan prometheus.Metric, 1))
+	require.Empty(t, rc.badImageNames.errors)
+}
@@ -0,0 +1,315 @@
+package registry
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	canaryLabel         = "k8s-image-availability-exporter.flant.com/canary"
+	canaryContainerName = "canary"
+	// canaryCommand doesn't exist in images, so that nothing from the image is run once it's pulled.
+	canaryCommand = "/k8s-image-availability-exporter-canary"
+
+	canaryPulled     = "pulled"
+	canaryPullFailed = "pull_failed"
+	canaryTimeout    = "timeout"
+	canaryError      = "error"
+)
+
+var (
+	canaryPullDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_canary_pull",
+		"Result of the last canary pull of the image by a node: pulled, pull_failed, timeout or error, the value is always 1.",
+		[]string{"image", "namespace", "node", "result"}, nil,
+	)
+	canaryPullDurationDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_canary_pull_duration_seconds",
+		"Time from creating the canary pod of the image until the image was pulled or failed to be pulled.",
+		[]string{"image"}, nil,
+	)
+)
+
+// CanaryConfig configures canary pulls, which verify that sampled images can actually be pulled by nodes.
+type CanaryConfig struct {
+	// Interval of canary pull passes, canary pulls are disabled if zero.
+	Interval time.Duration
+	// Images is the number of images sampled for a pass.
+	Images int
+	// Timeout of a single canary pull.
+	Timeout time.Duration
+	// NodeSelector selects nodes for canary pods. Node selectors of DaemonSets are used if it's empty.
+	NodeSelector map[string]string
+}
+
+type canaryResult struct {
+	namespace string
+	node      string
+	result    string
+	duration  time.Duration
+}
+
+// canaryPuller pulls a sample of tracked images with short-lived pods, which are created in the namespace of a workload
+// referencing the image with its service account and pull secrets, so that node-level mirrors and credentials are
+// verified along with the registry. Containers of the pods are never started.
+type canaryPuller struct {
+	client             kubernetes.Interface
+	config             CanaryConfig
+	controllerIndexers ControllerIndexers
+	imageStore         *store.ImageStore
+	pollInterval       time.Duration
+
+	lock    sync.Mutex
+	results map[string]canaryResult
+}
+
+func newCanaryPuller(client kubernetes.Interface, config CanaryConfig, controllerIndexers ControllerIndexers, imageStore *store.ImageStore) *canaryPuller {
+	return &canaryPuller{
+		client:             client,
+		config:             config,
+		controllerIndexers: controllerIndexers,
+		imageStore:         imageStore,
+		pollInterval:       2 * time.Second,
+		results:            make(map[string]canaryResult),
+	}
+}
+
+// Run deletes canary pods left by previous runs of the exporter and runs canary pull passes until stopCh is closed.
+func (c *canaryPuller) Run(stopCh <-chan struct{}) {
+	ctx := wait.ContextForChannel(stopCh)
+
+	go func() {
+		c.deleteLeftovers(ctx)
+		wait.UntilWithContext(ctx, c.pass, c.config.Interval)
+	}()
+}
+
+func (c *canaryPuller) deleteLeftovers(ctx context.Context) {
+	pods, err := c.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: canaryLabel + "=true"})
+	if err != nil {
+		logrus.Warnf("Failed to list canary pods left by previous runs: %v", err)
+		return
+	}
+
+	for i := range pods.Items {
+		c.deletePod(&pods.Items[i])
+	}
+}
+
+func (c *canaryPuller) pass(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, image := range c.sample() {
+		wg.Add(1)
+		go func(image string) {
+			defer wg.Done()
+			c.pull(ctx, image)
+		}(image)
+	}
+	wg.Wait()
+}
+
+// sample returns up to config.Images random tracked images.
+func (c *canaryPuller) sample() []string {
+	seen := make(map[string]struct{})
+	var images []string
+	c.imageStore.RangeContainers(func(image string, _ store.ContainerInfo, _ store.AvailabilityMode) {
+		if _, ok := seen[image]; !ok {
+			seen[image] = struct{}{}
+			images = append(images, image)
+		}
+	})
+
+	rand.Shuffle(len(images), func(i, j int) { images[i], images[j] = images[j], images[i] })
+	if len(images) > c.config.Images {
+		images = images[:c.config.Images]
+	}
+
+	return images
+}
+
+func (c *canaryPuller) pull(ctx context.Context, image string) {
+	var ci *controllerWithContainerInfos
+	for _, obj := range c.controllerIndexers.GetObjectsByImageIndex(image) {
+		if cis := obj.(*controllerWithContainerInfos); c.controllerIndexers.validCi(cis) {
+			ci = cis
+			break
+		}
+	}
+	if ci == nil {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{"image_name": image, "namespace": ci.Namespace})
+	start := time.Now()
+
+	pod, err := c.client.CoreV1().Pods(ci.Namespace).Create(ctx, c.pod(image, ci), metav1.CreateOptions{})
+	if err != nil {
+		log.Warnf("Failed to create the canary pod: %v", err)
+		c.record(image, canaryResult{namespace: ci.Namespace, result: canaryError})
+		return
+	}
+	defer c.deletePod(pod)
+
+	result, node, message := c.wait(ctx, pod)
+	log = log.WithFields(logrus.Fields{"node": node, "result": result})
+	if result == canaryPulled {
+		log.Debug("The canary pod pulled the image")
+	} else {
+		log.Warnf("The canary pod didn't pull the image: %s", message)
+	}
+
+	c.record(image, canaryResult{namespace: ci.Namespace, node: node, result: result, duration: time.Since(start)})
+}
+
+// wait polls the pod until the image is pulled or fails to be pulled.
+func (c *canaryPuller) wait(ctx context.Context, pod *corev1.Pod) (result, node, message string) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	_ = wait.PollUntilContextCancel(ctx, c.pollInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := c.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+
+		node = current.Spec.NodeName
+		result, message = canaryPullResult(current)
+		return len(result) > 0, nil
+	})
+
+	if len(result) == 0 {
+		return canaryTimeout, node, "timed out waiting for the image to be pulled"
+	}
+
+	return result, node, message
+}
+
+// canaryPullResult returns the result of the pull by the canary container, or an empty string if the image is still
+// being pulled.
+func canaryPullResult(pod *corev1.Pod) (result, message string) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != canaryContainerName {
+			continue
+		}
+
+		if len(status.ImageID) > 0 || status.State.Running != nil || status.State.Terminated != nil {
+			return canaryPulled, ""
+		}
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull", "RegistryUnavailable", "SignatureValidationFailed":
+				return canaryPullFailed, waiting.Reason + ": " + waiting.Message
+			case "CreateContainerError", "RunContainerError", "CrashLoopBackOff", "StartError":
+				// The container could only be created from a pulled image.
+				return canaryPulled, ""
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// pod returns a pod with the service account and pull secrets of the controller, which is compliant with the
+// restricted Pod Security Standard.
+func (c *canaryPuller) pod(image string, ci *controllerWithContainerInfos) *corev1.Pod {
+	nodeSelector := c.config.NodeSelector
+	if len(nodeSelector) == 0 {
+		nodeSelector = ci.nodeSelector
+	}
+
+	var (
+		activeDeadlineSeconds = int64(c.config.Timeout.Seconds()) + 1
+		gracePeriodSeconds    = int64(0)
+		automountToken        = false
+		runAsNonRoot          = true
+		runAsUser             = int64(65534)
+		privilegeEscalation   = false
+		resources             = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("10m"),
+			corev1.ResourceMemory: resource.MustParse("16Mi"),
+		}
+	)
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "k8s-image-availability-exporter-canary-",
+			Namespace:    ci.Namespace,
+			Labels:       map[string]string{canaryLabel: "true"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:         &activeDeadlineSeconds,
+			TerminationGracePeriodSeconds: &gracePeriodSeconds,
+			ServiceAccountName:            ci.serviceAccountName,
+			AutomountServiceAccountToken:  &automountToken,
+			ImagePullSecrets:              ci.pullSecretReferences,
+			NodeSelector:                  nodeSelector,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				RunAsUser:      &runAsUser,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name:            canaryContainerName,
+				Image:           image,
+				ImagePullPolicy: corev1.PullAlways,
+				Command:         []string{canaryCommand},
+				Resources:       corev1.ResourceRequirements{Requests: resources, Limits: resources},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &privilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+			}},
+		},
+	}
+}
+
+func (c *canaryPuller) deletePod(pod *corev1.Pod) {
+	gracePeriodSeconds := int64(0)
+	err := c.client.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriodSeconds})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"namespace": pod.Namespace, "pod": pod.Name}).Warnf("Failed to delete the canary pod: %v", err)
+	}
+}
+
+func (c *canaryPuller) record(image string, result canaryResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.results[image] = result
+}
+
+// collect exports results of tracked images and forgets the rest.
+func (c *canaryPuller) collect(ch chan<- prometheus.Metric) {
+	tracked := make(map[string]struct{})
+	c.imageStore.RangeContainers(func(image string, _ store.ContainerInfo, _ store.AvailabilityMode) {
+		tracked[image] = struct{}{}
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for image, result := range c.results {
+		if _, ok := tracked[image]; !ok {
+			delete(c.results, image)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(canaryPullDesc, prometheus.GaugeValue, 1, image, result.namespace, result.node, result.result)
+		if result.result == canaryPulled || result.result == canaryPullFailed {
+			ch <- prometheus.MustNewConstMetric(canaryPullDurationDesc, prometheus.GaugeValue, result.duration.Seconds(), image)
+		}
+	}
+}
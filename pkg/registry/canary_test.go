@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_canaryPullResult(t *testing.T) {
+	for expected, status := range map[string]corev1.ContainerStatus{
+		"":               {Name: canaryContainerName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+		canaryPulled:     {Name: canaryContainerName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "RunContainerError"}}},
+		canaryPullFailed: {Name: canaryContainerName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+	} {
+		result, _ := canaryPullResult(&corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}}})
+		require.Equal(t, expected, result, status.State.Waiting.Reason)
+	}
+
+	result, _ := canaryPullResult(&corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+		Name:    canaryContainerName,
+		ImageID: "registry.example.com/app@sha256:0123",
+		State:   corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "StartError"}},
+	}}}})
+	require.Equal(t, canaryPulled, result)
+}
+
+func Test_canaryPuller(t *testing.T) {
+	const (
+		pulledImage = "registry.example.com/app:v1"
+		failedImage = "registry.example.com/app:missing"
+	)
+
+	client := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "old", Name: "leftover", Labels: map[string]string{canaryLabel: "true"},
+	}})
+	var (
+		lock    sync.Mutex
+		created []*corev1.Pod
+	)
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = strings.TrimSuffix(pod.GenerateName, "-") + "-" + strings.TrimPrefix(pod.Spec.Containers[0].Image, "registry.example.com/app:")
+		pod.Spec.NodeName = "node-1"
+		state := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "StartError"}}
+		if pod.Spec.Containers[0].Image == failedImage {
+			state = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "not found"}}
+		}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: canaryContainerName, State: state}}
+		lock.Lock()
+		created = append(created, pod.DeepCopy())
+		lock.Unlock()
+		return false, nil, nil
+	})
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+	replicas := int32(1)
+	template := podTemplate(pulledImage)
+	template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Name: "missing", Image: failedImage})
+	template.Spec.ServiceAccountName = "app"
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	controllerIndexers := ControllerIndexers{
+		namespaceIndexer: namespaceIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+	}
+
+	imageStore := store.NewImageStore(nil, 1, 1)
+	for _, image := range []string{pulledImage, failedImage} {
+		imageStore.ReconcileImage(image, controllerIndexers.GetContainerInfosForImage(image))
+	}
+
+	c := newCanaryPuller(client, CanaryConfig{Images: 10, Timeout: time.Second}, controllerIndexers, imageStore)
+	c.pollInterval = 10 * time.Millisecond
+	c.deleteLeftovers(context.TODO())
+	c.pass(context.TODO())
+
+	require.Len(t, created, 2)
+	pod := created[0]
+	require.Equal(t, "default", pod.Namespace)
+	require.Equal(t, "app", pod.Spec.ServiceAccountName)
+	require.Equal(t, []corev1.LocalObjectReference{{Name: "registry"}}, pod.Spec.ImagePullSecrets)
+	require.Equal(t, corev1.PullAlways, pod.Spec.Containers[0].ImagePullPolicy)
+	require.Equal(t, []string{canaryCommand}, pod.Spec.Containers[0].Command)
+
+	// Canary pods are deleted, along with the ones left by previous runs.
+	pods, err := client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, pods.Items)
+
+	ch := make(chan prometheus.Metric, 10)
+	c.collect(ch)
+	close(ch)
+
+	results := make(map[string]string)
+	for metric := range ch {
+		if !strings.Contains(metric.Desc().String(), "canary_pull\"") {
+			continue
+		}
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		require.Equal(t, "node-1", labels["node"])
+		results[labels["image"]] = labels["result"]
+	}
+	require.Equal(t, map[string]string{pulledImage: canaryPulled, failedImage: canaryPullFailed}, results)
+}
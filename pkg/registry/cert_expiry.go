@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var registryCertExpiryDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_registry_cert_expiry_timestamp",
+	"Expiry of the TLS leaf certificate the registry last presented, as a Unix timestamp in seconds.",
+	[]string{"registry"}, nil,
+)
+
+// certExpiry records the expiry of TLS leaf certificates presented by registries, labeled with the registry of
+// the checked reference the request was made for, see withCheckedRegistry. Certificates presented by identity
+// providers registry tokens are obtained from are not recorded, since requests to them aren't registry API requests.
+type certExpiry struct {
+	lock       sync.Mutex
+	registries map[string]time.Time
+}
+
+func newCertExpiry() *certExpiry {
+	return &certExpiry{registries: make(map[string]time.Time)}
+}
+
+func (c *certExpiry) transport(next http.RoundTripper) http.RoundTripper {
+	return &certExpiryTransport{certExpiry: c, next: next}
+}
+
+func (c *certExpiry) record(registry string, cert *x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.registries[registry] = cert.NotAfter
+}
+
+// collect exports certificate expiry of registries of tracked images, and forgets registries no tracked image is in.
+func (c *certExpiry) collect(imageStore *store.ImageStore, registryOf func(image string) string, ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	empty := len(c.registries) == 0
+	c.lock.Unlock()
+	if empty {
+		return
+	}
+
+	images := make(map[string]struct{})
+	imageStore.RangeContainers(func(image string, _ store.ContainerInfo, _ store.AvailabilityMode) {
+		images[image] = struct{}{}
+	})
+	tracked := make(map[string]struct{})
+	for image := range images {
+		tracked[registryOf(image)] = struct{}{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for registry, notAfter := range c.registries {
+		if _, ok := tracked[registry]; !ok {
+			delete(c.registries, registry)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(registryCertExpiryDesc, prometheus.GaugeValue, float64(notAfter.Unix()), registry)
+	}
+}
+
+type checkedRegistryKey struct{}
+
+// withCheckedRegistry tags registry requests made with the context with the registry of the checked reference, which
+// their certificates are recorded for.
+func withCheckedRegistry(ctx context.Context, registry string) context.Context {
+	return context.WithValue(ctx, checkedRegistryKey{}, registry)
+}
+
+type certExpiryTransport struct {
+	certExpiry *certExpiry
+	next       http.RoundTripper
+}
+
+func (t *certExpiryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	registry, _ := req.Context().Value(checkedRegistryKey{}).(string)
+	if len(registry) == 0 || req.URL.Scheme != "https" || !strings.HasPrefix(req.URL.Path, "/v2/") {
+		return resp, err
+	}
+
+	if err != nil {
+		// The handshake fails once the certificate expired, the error still carries it.
+		var invalidErr x509.CertificateInvalidError
+		if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired && invalidErr.Cert != nil && !IsRedirectFailure(err) {
+			t.certExpiry.record(registry, invalidErr.Cert)
+		}
+		return resp, err
+	}
+
+	// Responses of hosts the registry redirected to are returned by redirectTransport.
+	if resp.Request != nil && resp.Request.URL.Host != req.URL.Host {
+		return resp, nil
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		t.certExpiry.record(registry, resp.TLS.PeerCertificates[0])
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func certExpiries(t *testing.T, c *certExpiry, imageStore *store.ImageStore) map[string]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 10)
+	c.collect(imageStore, func(image string) string { return strings.SplitN(image, "/", 2)[0] }, ch)
+	close(ch)
+
+	ret := make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		ret[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+
+	return ret
+}
+
+func Test_certExpiry(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	for _, image := range []string{"registry.example.com/app:v1", "mirror.example.com/app:v1"} {
+		imageStore.ReconcileImage(image, []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}})
+	}
+
+	c := newCertExpiry()
+	client := &http.Client{Transport: c.transport(server.Client().Transport)}
+	get := func(path string) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(withCheckedRegistry(context.Background(), "mirror.example.com"), http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Requests to identity providers aren't recorded, and neither are requests not made for checks.
+	get("/token")
+	resp, err := client.Get(server.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, certExpiries(t, c, imageStore))
+
+	// Certificates are recorded for the registry of the checked reference rather than the host requests are sent to.
+	get("/v2/")
+	require.Equal(t, map[string]float64{
+		"mirror.example.com": float64(server.Certificate().NotAfter.Unix()),
+	}, certExpiries(t, c, imageStore))
+
+	// Expired certificates are recorded from the failed handshake.
+	notAfter := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	expired := c.transport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("tls: failed to verify certificate: %w", x509.CertificateInvalidError{
+			Cert:   &x509.Certificate{NotAfter: notAfter},
+			Reason: x509.Expired,
+		})
+	}))
+	req, err := http.NewRequestWithContext(withCheckedRegistry(context.Background(), "registry.example.com"), http.MethodGet, "https://registry.example.com/v2/", nil)
+	require.NoError(t, err)
+	_, err = expired.RoundTrip(req)
+	require.Error(t, err)
+	require.Equal(t, float64(notAfter.Unix()), certExpiries(t, c, imageStore)["registry.example.com"])
+
+	// Registries no tracked image is in are forgotten.
+	imageStore = store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	imageStore.ReconcileImage("registry.example.com/app:v1", []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}})
+	require.Equal(t, map[string]float64{"registry.example.com": float64(notAfter.Unix())}, certExpiries(t, c, imageStore))
+	require.Len(t, c.registries, 1)
+}
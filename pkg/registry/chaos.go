@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var simulatedFailuresDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_simulated_failures",
+	"Number of images reported as absent by -simulate-failures regardless of their actual availability.",
+	nil, nil,
+)
+
+// ChaosConfig configures rehearsing alerting on unavailable images.
+type ChaosConfig struct {
+	// FakeRegistry makes every image be checked against an in-memory registry that has every image instead of real
+	// registries.
+	FakeRegistry bool
+	// FailurePercentage is the percentage of images reported as absent regardless of their availability.
+	FailurePercentage float64
+}
+
+// ValidateFailurePercentage checks that the percentage of simulated failures is in the [0, 100] range.
+func ValidateFailurePercentage(percentage float64) error {
+	if percentage < 0 || percentage > 100 {
+		return fmt.Errorf("must be between 0 and 100, got %v", percentage)
+	}
+
+	return nil
+}
+
+// simulatedFailures selects images to report as absent. Images are selected by a hash of their name, so that the
+// same images fail on every pass and alerts keep firing rather than flapping.
+type simulatedFailures struct {
+	// threshold is the number of hash buckets out of 10000 that fail.
+	threshold uint32
+}
+
+func newSimulatedFailures(percentage float64) *simulatedFailures {
+	return &simulatedFailures{threshold: uint32(percentage * 100)}
+}
+
+func (s *simulatedFailures) fails(image string) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(image))
+
+	return h.Sum32()%10000 < s.threshold
+}
+
+func (s *simulatedFailures) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	var failing int
+	seen := make(map[string]bool)
+	imageStore.RangeContainers(func(image string, _ store.ContainerInfo, _ store.AvailabilityMode) {
+		if seen[image] {
+			return
+		}
+		seen[image] = true
+		if s.fails(image) {
+			failing++
+		}
+	})
+
+	ch <- prometheus.MustNewConstMetric(simulatedFailuresDesc, prometheus.GaugeValue, float64(failing))
+}
+
+// fakeRegistry is an in-memory registry serving requests to every registry host. It has a manifest for every tag and
+// digest, so that the exporter can run without access to registries, e.g. in integration tests of alerting. Blobs
+// aren't served.
+type fakeRegistry struct {
+	manifest []byte
+	digest   string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest":    "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size":      2,
+		},
+		"layers": []interface{}{},
+	})
+	sum := sha256.Sum256(manifest)
+
+	return &fakeRegistry{manifest: manifest, digest: "sha256:" + hex.EncodeToString(sum[:])}
+}
+
+func (f *fakeRegistry) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Request:    req,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Docker-Distribution-Api-Version": {"registry/2.0"}},
+		StatusCode: http.StatusNotFound,
+		Body:       http.NoBody,
+	}
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	path := req.URL.Path
+	switch {
+	case path == "/v2/" || path == "/v2":
+		resp.StatusCode = http.StatusOK
+	case strings.HasPrefix(path, "/v2/") && strings.Contains(path, "/manifests/") && (req.Method == http.MethodHead || req.Method == http.MethodGet):
+		reference := path[strings.LastIndex(path, "/manifests/")+len("/manifests/"):]
+		// Only the digest of a manifest requested by a digest is checked against the reference, its content doesn't
+		// match it.
+		digest := f.digest
+		if strings.Contains(reference, ":") {
+			digest = reference
+		}
+
+		resp.StatusCode = http.StatusOK
+		resp.Header.Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		resp.Header.Set("Docker-Content-Digest", digest)
+		resp.ContentLength = int64(len(f.manifest))
+		if req.Method == http.MethodGet {
+			resp.Body = io.NopCloser(strings.NewReader(string(f.manifest)))
+		}
+	}
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+
+	return resp, nil
+}
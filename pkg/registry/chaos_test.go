@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestFakeRegistry(t *testing.T) {
+	fake := newFakeRegistry()
+
+	for _, image := range []string{
+		"nginx:1.25",
+		"registry.example.com/team/app:v1",
+		"registry.example.com/app@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+	} {
+		ref, err := name.ParseReference(image)
+		require.NoError(t, err)
+
+		availMode, digest, err := check(ref, authn.NewMultiKeychain(), authn.NewMultiKeychain(), fake, nil, true)
+		require.NoError(t, err, image)
+		require.Equal(t, store.Available, availMode, image)
+		if d, ok := ref.(name.Digest); ok {
+			require.Equal(t, d.DigestStr(), digest)
+		} else {
+			require.Equal(t, fake.digest, digest)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/app/blobs/"+fake.digest, nil)
+	require.NoError(t, err)
+	resp, err := fake.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSimulatedFailures(t *testing.T) {
+	require.NoError(t, ValidateFailurePercentage(12.5))
+	require.Error(t, ValidateFailurePercentage(-1))
+	require.Error(t, ValidateFailurePercentage(101))
+
+	all, half := newSimulatedFailures(100), newSimulatedFailures(50)
+
+	var failing int
+	for i := 0; i < 1000; i++ {
+		image := fmt.Sprintf("registry.example.com/app-%d:v1", i)
+		require.True(t, all.fails(image))
+		// The same images fail every time.
+		require.Equal(t, half.fails(image), half.fails(image))
+		if half.fails(image) {
+			failing++
+		}
+	}
+	require.InDelta(t, 500, failing, 100)
+}
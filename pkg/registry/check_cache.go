@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var checkResultCacheHitsDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_check_result_cache_hits_total",
+	"Number of checks answered from the result of an identical check made with the same credentials within the TTL.",
+	nil, nil,
+)
+
+type checkResult struct {
+	availMode store.AvailabilityMode
+	digest    string
+	err       error
+	checkedAt time.Time
+}
+
+// checkResultCache remembers results of recent checks by the checked reference, platform and a hash of the resolved
+// credentials, so that the same image referenced under different names, or checked for several namespaces with
+// the same effective credentials, isn't checked again within the TTL. Checks with different credentials never share
+// results.
+type checkResultCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	lock    sync.Mutex
+	results map[string]checkResult
+
+	hits atomic.Uint64
+}
+
+func newCheckResultCache(ttl time.Duration) *checkResultCache {
+	return &checkResultCache{
+		ttl:     ttl,
+		now:     time.Now,
+		results: make(map[string]checkResult),
+	}
+}
+
+func (c *checkResultCache) get(key string) (checkResult, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result, ok := c.results[key]
+	if !ok || c.now().Sub(result.checkedAt) > c.ttl {
+		return checkResult{}, false
+	}
+
+	c.hits.Add(1)
+	return result, true
+}
+
+// store remembers the result of a check. Transient failures, which are likely to resolve on a retry, and legacy
+// manifests, which are counted per response, aren't cached.
+func (c *checkResultCache) store(key string, availMode store.AvailabilityMode, digest string, err error) {
+	if availMode == store.RegistryUnavailable || availMode == store.UnknownError || IsOldRegistry(err) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	c.results[key] = checkResult{availMode: availMode, digest: digest, err: err, checkedAt: now}
+
+	for key, result := range c.results {
+		if now.Sub(result.checkedAt) > c.ttl {
+			delete(c.results, key)
+		}
+	}
+}
+
+func (c *checkResultCache) collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(checkResultCacheHitsDesc, prometheus.CounterValue, float64(c.hits.Load()))
+}
+
+// checkResultKey identifies a check of the reference for the platform with the credentials the keychains resolve to.
+// Credentials are hashed, so that the cache never holds secrets.
+func checkResultKey(ref name.Reference, kc, fallbackKc authn.Keychain, platform *v1.Platform) (string, error) {
+	_, credentialsHash, err := resolveCredentials(ref, kc, fallbackKc)
+	if err != nil {
+		return "", err
+	}
+
+	key := ref.Name() + " " + credentialsHash
+	if platform != nil {
+		key += " " + platform.String()
+	}
+
+	return key, nil
+}
+
+// resolveCredentials returns the credentials the image is checked with and the hex-encoded SHA-256 of them.
+func resolveCredentials(ref name.Reference, kc, fallbackKc authn.Keychain) (*authn.AuthConfig, string, error) {
+	authenticator, err := checkKeychain(kc, fallbackKc).Resolve(ref.Context())
+	if err != nil {
+		return nil, "", err
+	}
+	authConfig, err := authenticator.Authorization()
+	if err != nil {
+		return nil, "", err
+	}
+	credentials, err := json.Marshal(authConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	credentialsHash := sha256.Sum256(credentials)
+
+	return authConfig, hex.EncodeToString(credentialsHash[:]), nil
+}
+
+// cachedCheck returns the result of an identical check made within the TTL, and the key to store the result of a new
+// check under. The key is empty if the cache is disabled or credentials can't be resolved.
+func (rc *Checker) cachedCheck(log *logrus.Entry, ref name.Reference, kc authn.Keychain, platform *v1.Platform) (result checkResult, key string, ok bool) {
+	if rc.checkResults == nil {
+		return checkResult{}, "", false
+	}
+
+	key, err := checkResultKey(ref, kc, rc.fallbackKeychain, platform)
+	if err != nil {
+		log.Debugf("Not using the check result cache, failed to resolve credentials: %v", err)
+		return checkResult{}, "", false
+	}
+
+	result, ok = rc.checkResults.get(key)
+	return result, key, ok
+}
+
+// storeCheck caches the result of a check made after cachedCheck returned the key.
+func (rc *Checker) storeCheck(key string, availMode store.AvailabilityMode, digest string, err error) {
+	if rc.checkResults == nil || len(key) == 0 {
+		return
+	}
+
+	rc.checkResults.store(key, availMode, digest, err)
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+type fixedKeychain struct {
+	authenticator authn.Authenticator
+}
+
+func (k fixedKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.authenticator, nil
+}
+
+func Test_checkResultKey(t *testing.T) {
+	short, err := name.ParseReference("nginx")
+	require.NoError(t, err)
+	full, err := name.ParseReference("index.docker.io/library/nginx:latest")
+	require.NoError(t, err)
+
+	alice := fixedKeychain{authn.FromConfig(authn.AuthConfig{Username: "alice", Password: "secret"})}
+	bob := fixedKeychain{authn.FromConfig(authn.AuthConfig{Username: "bob", Password: "secret"})}
+
+	key := func(ref name.Reference, kc authn.Keychain) string {
+		key, err := checkResultKey(ref, kc, authn.DefaultKeychain, nil)
+		require.NoError(t, err)
+		return key
+	}
+
+	// The same image under different names with the same credentials is the same check.
+	require.Equal(t, key(short, alice), key(full, alice))
+	require.NotEqual(t, key(short, alice), key(short, bob))
+	// Pull secrets without credentials for the registry fall back to the default keychain.
+	require.Equal(t, key(short, nil), key(short, fixedKeychain{authn.Anonymous}))
+	require.NotContains(t, key(short, alice), "secret")
+}
+
+func Test_checkResultCache(t *testing.T) {
+	c := newCheckResultCache(time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.store("absent", store.Absent, "", errors.New("not found"))
+	c.store("available", store.Available, "sha256:1", nil)
+	// Transient failures are retried.
+	c.store("unavailable", store.RegistryUnavailable, "", errors.New("timeout"))
+
+	result, ok := c.get("absent")
+	require.True(t, ok)
+	require.Equal(t, store.Absent, result.availMode)
+	require.EqualError(t, result.err, "not found")
+	result, ok = c.get("available")
+	require.True(t, ok)
+	require.Equal(t, "sha256:1", result.digest)
+	_, ok = c.get("unavailable")
+	require.False(t, ok)
+	require.EqualValues(t, 2, c.hits.Load())
+
+	now = now.Add(time.Minute + time.Second)
+	_, ok = c.get("available")
+	require.False(t, ok)
+
+	// Expired results are forgotten.
+	c.store("new", store.Available, "sha256:2", nil)
+	require.Len(t, c.results, 1)
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var checksDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_checks_total",
+	"Number of image checks made against the registry, by the resulting availability mode. Exemplars reference the "+
+		"check_id logged with the last check.",
+	[]string{"registry", "availability_mode"}, nil,
+)
+
+type checkCounterKey struct {
+	registry  string
+	availMode store.AvailabilityMode
+}
+
+type checkCount struct {
+	count       uint64
+	lastCheckID string
+	lastCheckAt time.Time
+}
+
+// checkCounter counts image checks and remembers the ID of the last check of every series, so that a change of the
+// counter can be traced to the log entries of the check that caused it.
+type checkCounter struct {
+	now func() time.Time
+
+	lock   sync.Mutex
+	counts map[checkCounterKey]*checkCount
+}
+
+func newCheckCounter() *checkCounter {
+	return &checkCounter{
+		now:    time.Now,
+		counts: make(map[checkCounterKey]*checkCount),
+	}
+}
+
+// newCheckID returns a random ID in the format of a W3C trace ID.
+func newCheckID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func (c *checkCounter) record(registry string, availMode store.AvailabilityMode, checkID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := checkCounterKey{registry: registry, availMode: availMode}
+	count, ok := c.counts[key]
+	if !ok {
+		count = &checkCount{}
+		c.counts[key] = count
+	}
+
+	count.count++
+	count.lastCheckID = checkID
+	count.lastCheckAt = c.now()
+}
+
+// collect exports the counters with exemplars, which are only exposed in the OpenMetrics format.
+func (c *checkCounter) collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, count := range c.counts {
+		m := prometheus.MustNewConstMetric(checksDesc, prometheus.CounterValue, float64(count.count), key.registry, key.availMode.String())
+		ch <- prometheus.MustNewMetricWithExemplars(m, prometheus.Exemplar{
+			Value:     1,
+			Labels:    prometheus.Labels{"check_id": count.lastCheckID},
+			Timestamp: count.lastCheckAt,
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_checkCounter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newCheckCounter()
+	c.now = func() time.Time { return now }
+
+	firstID, lastID := newCheckID(), newCheckID()
+	require.Len(t, firstID, 32)
+	require.NotEqual(t, firstID, lastID)
+
+	c.record("registry.example.com", store.Available, firstID)
+	c.record("registry.example.com", store.Available, lastID)
+	c.record("registry.example.com", store.Absent, firstID)
+
+	ch := make(chan prometheus.Metric, 10)
+	c.collect(ch)
+	close(ch)
+
+	values := make(map[string]*dto.Counter)
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "availability_mode" {
+				values[label.GetValue()] = m.GetCounter()
+			}
+		}
+	}
+
+	require.Equal(t, float64(2), values["available"].GetValue())
+	require.Equal(t, lastID, values["available"].GetExemplar().GetLabel()[0].GetValue())
+	require.Equal(t, now.Unix(), values["available"].GetExemplar().GetTimestamp().GetSeconds())
+	require.Equal(t, float64(1), values["absent"].GetValue())
+}
@@ -3,20 +3,27 @@ package registry
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/google"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/sirupsen/logrus"
 
@@ -28,9 +35,13 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/inventory"
+	"github.com/flant/k8s-image-availability-exporter/pkg/policy"
 	"github.com/flant/k8s-image-availability-exporter/pkg/store"
 )
 
+// Initial batch sizes, these are adjusted per registry if the target pass duration is set.
 const (
 	failedCheckBatchSize = 20
 	checkBatchSize       = 50
@@ -39,8 +50,19 @@ const (
 type registryCheckerConfig struct {
 	defaultRegistry string
 	plainHTTP       bool
+	checkPlatform   *v1.Platform
+	exporterConfig  *config.Config
+	oldRegistryMode OldRegistryMode
+	nodePools       []nodePool
+	imageRewrites   []imageRewrite
 }
 
+var skippedReconcilesDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_skipped_reconciles_total",
+	"Number of controller updates that didn't change container images and weren't reconciled.",
+	nil, nil,
+)
+
 type Checker struct {
 	imageStore *store.ImageStore
 
@@ -50,52 +72,160 @@ type Checker struct {
 	statefulSetsInformer   appsv1informers.StatefulSetInformer
 	daemonSetsInformer     appsv1informers.DaemonSetInformer
 	cronJobsInformer       batchv1informers.CronJobInformer
-	secretsInformer        corev1informers.SecretInformer
 
 	controllerIndexers ControllerIndexers
 
 	ignoredImagesRegex []regexp.Regexp
 
-	registryTransport *http.Transport
+	registryTransport http.RoundTripper
+	fallbackKeychain  authn.Keychain
+
+	credentialSources *credentialSources
+	skippedReconciles atomic.Uint64
+	checkCounter      *checkCounter
+	certExpiry        *certExpiry
+
+	oldRegistryResponses *oldRegistryResponses
+
+	quayTokenWatcher  *quayTokenWatcher
+	registryPinger    *registryPinger
+	manifestCache     *manifestCache
+	checkResults      *checkResultCache
+	circuitBreaker    *circuitBreaker
+	dnsDiagnostics    *dnsDiagnostics
+	lockout           *credentialLockout
+	simulatedFailures *simulatedFailures
+	recorder          *checkRecorder
+	tagDrift          *tagDrift
+	retags            *retags
+	badImageNames     *badImageNames
+	imageAges         *imageAges
+	provenances       *provenances
+	sboms             *sboms
+	referrers         *referrersSupport
+	canaryPuller      *canaryPuller
+	imageSeverity     *imageSeverity
+
+	policyEngine *policy.Engine
 
 	kubeClient *kubernetes.Clientset
 
 	config registryCheckerConfig
 }
 
-func NewChecker(
-	stopCh <-chan struct{},
-	kubeClient *kubernetes.Clientset,
-	skipVerify bool,
-	plainHTTP bool,
-	caPths []string,
-	forceCheckDisabledControllerKinds []string,
-	ignoredImages []regexp.Regexp,
-	defaultRegistry string,
-	namespaceLabel string,
-) *Checker {
+// CheckerConfig configures a Checker created with NewChecker. Zero values of optional settings, e.g. of TTLs,
+// intervals and thresholds, disable the features they configure.
+type CheckerConfig struct {
+	SkipVerify                        bool
+	StrictTLS                         bool
+	PlainHTTP                         bool
+	CAPaths                           []string
+	ForceCheckDisabledControllerKinds []string
+	IgnoredImages                     []regexp.Regexp
+	DefaultRegistry                   string
+	NamespaceLabel                    string
+	WorkloadIdentity                  WorkloadIdentityConfig
+	AzureConfigPath                   string
+	GCPApplicationDefaultCredentials  bool
+	Quay                              QuayConfig
+	TargetPassDuration                time.Duration
+	RetryPolicy                       store.RetryPolicy
+	RegistryEndpoints                 []string
+	RegistryPingInterval              time.Duration
+	MaxImagesPerNamespace             int
+	Policy                            policy.Config
+	ManifestCacheTTL                  time.Duration
+	ExportSeverity                    bool
+	CachedImageSeverity               float64
+	TrackOwnedObjects                 bool
+	WorkloadLabels                    []string
+	MetricStyle                       store.MetricStyle
+	CircuitBreaker                    CircuitBreakerConfig
+	PassTimeBudget                    time.Duration
+	RegistryPassTimeBudget            time.Duration
+	ExporterConfig                    *config.Config
+	PriorityClasses                   []string
+	PrioritizePDBWorkloads            bool
+	GCInterval                        time.Duration
+	KeepOrphansFor                    time.Duration
+	CheckPlatform                     *v1.Platform
+	CheckEphemeralContainers          bool
+	FloatingTags                      []string
+	OldRegistryMode                   OldRegistryMode
+	ResyncPeriod                      time.Duration
+	StoreBackend                      store.Backend
+	StoreBackendSyncInterval          time.Duration
+	UserAgent                         string
+	Canary                            CanaryConfig
+	DeepCheck                         bool
+	SOCKSProxy                        string
+	CheckResultCacheTTL               time.Duration
+	Namespaces                        []string
+	Secretless                        bool
+	CredentialLockout                 CredentialLockoutConfig
+	Chaos                             ChaosConfig
+	RecordFailedChecks                RecordConfig
+	PodImages                         bool
+	DNSFallbackResolver               string
+	CheckWindows                      *store.CheckWindows
+	RetagGracePeriod                  time.Duration
+}
+
+func NewChecker(stopCh <-chan struct{}, kubeClient *kubernetes.Clientset, cfg CheckerConfig) *Checker {
 	informerFactory := informers.NewSharedInformerFactory(kubeClient, time.Hour)
+	if len(cfg.Namespaces) > 0 {
+		useNamespacedInformers(informerFactory, cfg.Namespaces, watchedResources(kubeClient, cfg.TrackOwnedObjects, cfg.CheckEphemeralContainers || cfg.PodImages, cfg.PrioritizePDBWorkloads), nil)
+	}
 
-	customTransport := http.DefaultTransport.(*http.Transport).Clone()
-	if skipVerify {
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	} else if len(caPths) > 0 {
-		rootCAs, _ := x509.SystemCertPool()
-		if rootCAs == nil {
-			rootCAs = x509.NewCertPool()
-		}
-		for _, caPath := range caPths {
-			pemCerts, err := os.ReadFile(caPath)
-			if err != nil {
-				logrus.Fatalf("Failed to open file %q: %v", caPath, err)
-			}
-			if ok := rootCAs.AppendCertsFromPEM(pemCerts); !ok {
-				logrus.Fatalf("Error parsing %q content as a PEM encoded certificate", caPath)
-			}
+	tlsConfig, err := newTLSConfig(cfg.SkipVerify, cfg.StrictTLS, cfg.PlainHTTP, cfg.CAPaths)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	proxy, err := parseSOCKSProxy(cfg.SOCKSProxy)
+	if err != nil {
+		logrus.Fatalf("Invalid -registry-socks-proxy: %v", err)
+	}
+
+	certExpiry := newCertExpiry()
+	registryTransport := certExpiry.transport(newRegistryTransport(tlsConfig, cfg.StrictTLS, cfg.ExporterConfig, cfg.UserAgent, proxy))
+	if cfg.Chaos.FakeRegistry {
+		logrus.Warn("Images are checked against the fake in-memory registry instead of real registries")
+		registryTransport = newFakeRegistry()
+	}
+
+	var keychains []authn.Keychain
+	if staticKeychain := newStaticKeychain(cfg.ExporterConfig); staticKeychain != nil {
+		keychains = append(keychains, staticKeychain)
+	}
+	if len(cfg.WorkloadIdentity.Provider) > 0 {
+		wiKeychain, err := newWorkloadIdentityKeychain(cfg.WorkloadIdentity, registryTransport)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		wiKeychain.Run(stopCh)
+		keychains = append(keychains, wiKeychain)
+	}
+	if len(cfg.AzureConfigPath) > 0 {
+		azureKeychain, err := newAzureConfigKeychain(cfg.AzureConfigPath, registryTransport)
+		if err != nil {
+			logrus.Fatalf("Failed to load Azure cloud provider config: %v", err)
 		}
-		customTransport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+		azureKeychain.Run(stopCh)
+		keychains = append(keychains, azureKeychain)
+	}
+
+	if cfg.GCPApplicationDefaultCredentials {
+		keychains = append(keychains, google.Keychain)
+	}
+
+	var fallbackKeychain authn.Keychain = authn.DefaultKeychain
+	if len(keychains) > 0 {
+		fallbackKeychain = authn.NewMultiKeychain(append(keychains, authn.DefaultKeychain)...)
 	}
 
+	credentialSources := newCredentialSources()
+
 	rc := &Checker{
 		serviceAccountInformer: informerFactory.Core().V1().ServiceAccounts(),
 		namespacesInformer:     informerFactory.Core().V1().Namespaces(),
@@ -103,27 +233,134 @@ func NewChecker(
 		statefulSetsInformer:   informerFactory.Apps().V1().StatefulSets(),
 		daemonSetsInformer:     informerFactory.Apps().V1().DaemonSets(),
 		cronJobsInformer:       informerFactory.Batch().V1().CronJobs(),
-		secretsInformer:        informerFactory.Core().V1().Secrets(),
 
-		ignoredImagesRegex: ignoredImages,
+		ignoredImagesRegex: cfg.IgnoredImages,
+
+		registryTransport: registryTransport,
+		fallbackKeychain:  credentialSources.chain([]sourcedKeychain{{source: credentialSourceFallback, keychain: fallbackKeychain}}, true),
+		credentialSources: credentialSources,
+		checkCounter:      newCheckCounter(),
+		certExpiry:        certExpiry,
 
-		registryTransport: customTransport,
+		oldRegistryResponses: newOldRegistryResponses(),
+		badImageNames:        newBadImageNames(),
 
 		kubeClient: kubeClient,
 
 		config: registryCheckerConfig{
-			defaultRegistry: defaultRegistry,
-			plainHTTP:       plainHTTP,
+			defaultRegistry: cfg.DefaultRegistry,
+			plainHTTP:       cfg.PlainHTTP,
+			checkPlatform:   cfg.CheckPlatform,
+			exporterConfig:  cfg.ExporterConfig,
+			oldRegistryMode: cfg.OldRegistryMode,
 		},
 	}
 
-	rc.imageStore = store.NewImageStore(rc.Check, checkBatchSize, failedCheckBatchSize)
+	rc.config.nodePools, err = newNodePools(cfg.ExporterConfig.NodePools)
+	if err != nil {
+		logrus.Fatalf("Invalid node pools: %v", err)
+	}
+	rc.config.imageRewrites, err = newImageRewrites(cfg.ExporterConfig.ImageRewrites)
+	if err != nil {
+		logrus.Fatalf("Invalid image rewrites: %v", err)
+	}
+
+	if cfg.CheckResultCacheTTL > 0 {
+		rc.checkResults = newCheckResultCache(cfg.CheckResultCacheTTL)
+	}
+	if cfg.ManifestCacheTTL > 0 {
+		rc.manifestCache = newManifestCache(cfg.ManifestCacheTTL)
+		rc.registryTransport = rc.manifestCache.transport(registryTransport)
+	}
 
-	err := rc.namespacesInformer.Informer().AddIndexers(namespaceIndexers(namespaceLabel))
+	if cfg.CircuitBreaker.Threshold > 0 {
+		rc.circuitBreaker = newCircuitBreaker(cfg.CircuitBreaker)
+	}
+	if cfg.CredentialLockout.Threshold > 0 {
+		rc.lockout = newCredentialLockout(cfg.CredentialLockout)
+	}
+	rc.dnsDiagnostics, err = newDNSDiagnostics(cfg.DNSFallbackResolver)
 	if err != nil {
-		panic(err)
+		logrus.Fatalf("Invalid -dns-fallback-resolver: %v", err)
+	}
+
+	if len(cfg.RecordFailedChecks.Path) > 0 {
+		rc.recorder, err = newCheckRecorder(cfg.RecordFailedChecks.Path, cfg.RecordFailedChecks.MaxSize)
+		if err != nil {
+			logrus.Fatalf("Failed to open -record-failed-checks-path: %v", err)
+		}
+	}
+
+	if cfg.Chaos.FailurePercentage > 0 {
+		logrus.Warnf("%v%% of images are reported as absent regardless of their availability", cfg.Chaos.FailurePercentage)
+		rc.simulatedFailures = newSimulatedFailures(cfg.Chaos.FailurePercentage)
+	}
+
+	if len(cfg.FloatingTags) > 0 {
+		rc.tagDrift = newTagDrift(cfg.FloatingTags)
+	}
+
+	if cfg.DeepCheck {
+		rc.imageAges = newImageAges()
+		rc.provenances = newProvenances()
+		rc.sboms = newSBOMs()
+		rc.referrers = newReferrersSupport()
+	}
+
+	if len(cfg.Quay.Registry) > 0 && len(cfg.Quay.APITokenPath) > 0 {
+		rc.quayTokenWatcher = newQuayTokenWatcher(cfg.Quay, registryTransport)
+		rc.quayTokenWatcher.Run(stopCh)
+	}
+
+	rc.policyEngine = policy.NewEngine(cfg.Policy, func(image string) (name.Reference, error) {
+		return parseImageName(image, cfg.DefaultRegistry, cfg.PlainHTTP)
+	})
+
+	if len(cfg.RegistryEndpoints) > 0 {
+		rc.registryPinger, err = newRegistryPinger(cfg.RegistryEndpoints, registryTransport)
+		if err != nil {
+			logrus.Fatalf("Failed to parse registry endpoints: %v", err)
+		}
+		rc.registryPinger.Run(cfg.RegistryPingInterval, stopCh)
+	}
+
+	rc.imageStore = store.NewImageStore(rc.Check, checkBatchSize, failedCheckBatchSize)
+	rc.imageStore.UseFairScheduling(rc.registryOf)
+	if cfg.TargetPassDuration > 0 {
+		rc.imageStore.UseBatchTuner(store.NewBatchTuner(cfg.TargetPassDuration, checkBatchSize, failedCheckBatchSize, rc.registryOf))
+	}
+	if cfg.PassTimeBudget > 0 || cfg.RegistryPassTimeBudget > 0 {
+		rc.imageStore.UsePassBudget(store.NewPassBudget(cfg.PassTimeBudget, cfg.RegistryPassTimeBudget, rc.registryOf))
+	}
+	if cfg.CheckWindows != nil {
+		rc.imageStore.UseCheckWindows(cfg.CheckWindows)
+	}
+	if cfg.RetagGracePeriod > 0 {
+		rc.retags = newRetags(cfg.RetagGracePeriod)
+	}
+	if len(rc.config.imageRewrites) > 0 {
+		rc.imageStore.UseImageRewrite(rc.rewriteImage)
+	}
+	rc.imageStore.UseSeriesLimit(cfg.MaxImagesPerNamespace)
+	rc.imageStore.UseMetricStyle(cfg.MetricStyle)
+	if cfg.RetryPolicy.BaseDelay > 0 {
+		rc.imageStore.UseRetryPolicy(&cfg.RetryPolicy)
+	}
+	if cfg.StoreBackend != nil {
+		if err := rc.imageStore.UseBackend(cfg.StoreBackend); err != nil {
+			logrus.Fatalf("Failed to load images from the store backend: %v", err)
+		}
+	}
+
+	if len(cfg.Namespaces) > 0 {
+		rc.controllerIndexers.namespaceIndexer = staticNamespaces(cfg.Namespaces)
+	} else {
+		err = rc.namespacesInformer.Informer().AddIndexers(namespaceIndexers(cfg.NamespaceLabel))
+		if err != nil {
+			panic(err)
+		}
+		rc.controllerIndexers.namespaceIndexer = rc.namespacesInformer.Informer().GetIndexer()
 	}
-	rc.controllerIndexers.namespaceIndexer = rc.namespacesInformer.Informer().GetIndexer()
 
 	if err != nil {
 		panic(err)
@@ -134,13 +371,9 @@ func NewChecker(
 		AddFunc: func(obj interface{}) {
 			rc.reconcile(obj)
 		},
-		UpdateFunc: func(_, newObj interface{}) {
-			rc.reconcile(newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			rc.reconcile(obj)
-		},
-	}, time.Minute)
+		UpdateFunc: rc.reconcileUpdate,
+		DeleteFunc: rc.reconcileDelete,
+	}, cfg.ResyncPeriod)
 	err = rc.deploymentsInformer.Informer().AddIndexers(imageIndexers)
 	if err != nil {
 		panic(err)
@@ -155,13 +388,9 @@ func NewChecker(
 		AddFunc: func(obj interface{}) {
 			rc.reconcile(obj)
 		},
-		UpdateFunc: func(_, newObj interface{}) {
-			rc.reconcile(newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			rc.reconcile(obj)
-		},
-	}, time.Minute)
+		UpdateFunc: rc.reconcileUpdate,
+		DeleteFunc: rc.reconcileDelete,
+	}, cfg.ResyncPeriod)
 	err = rc.statefulSetsInformer.Informer().AddIndexers(imageIndexers)
 	if err != nil {
 		panic(err)
@@ -176,13 +405,9 @@ func NewChecker(
 		AddFunc: func(obj interface{}) {
 			rc.reconcile(obj)
 		},
-		UpdateFunc: func(_, newObj interface{}) {
-			rc.reconcile(newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			rc.reconcile(obj)
-		},
-	}, time.Minute)
+		UpdateFunc: rc.reconcileUpdate,
+		DeleteFunc: rc.reconcileDelete,
+	}, cfg.ResyncPeriod)
 	err = rc.daemonSetsInformer.Informer().AddIndexers(imageIndexers)
 	if err != nil {
 		panic(err)
@@ -197,13 +422,9 @@ func NewChecker(
 		AddFunc: func(obj interface{}) {
 			rc.reconcile(obj)
 		},
-		UpdateFunc: func(_, newObj interface{}) {
-			rc.reconcile(newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			rc.reconcile(obj)
-		},
-	}, time.Minute)
+		UpdateFunc: rc.reconcileUpdate,
+		DeleteFunc: rc.reconcileDelete,
+	}, cfg.ResyncPeriod)
 	err = rc.cronJobsInformer.Informer().AddIndexers(imageIndexers)
 	if err != nil {
 		panic(err)
@@ -214,16 +435,184 @@ func NewChecker(
 	}
 	rc.controllerIndexers.cronJobIndexer = rc.cronJobsInformer.Informer().GetIndexer()
 
-	rc.controllerIndexers.secretIndexer = rc.secretsInformer.Informer().GetIndexer()
+	if cfg.TrackOwnedObjects {
+		replicaSetsInformer := informerFactory.Apps().V1().ReplicaSets().Informer()
+		_, _ = replicaSetsInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				rc.reconcile(obj)
+			},
+			UpdateFunc: rc.reconcileUpdate,
+			DeleteFunc: rc.reconcileDelete,
+		}, cfg.ResyncPeriod)
+		err = replicaSetsInformer.AddIndexers(imageIndexers)
+		if err != nil {
+			panic(err)
+		}
+		err = replicaSetsInformer.SetTransform(getImagesFromReplicaSet)
+		if err != nil {
+			panic(err)
+		}
+		rc.controllerIndexers.replicaSetIndexer = replicaSetsInformer.GetIndexer()
+
+		jobsInformer := informerFactory.Batch().V1().Jobs().Informer()
+		_, _ = jobsInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				rc.reconcile(obj)
+			},
+			UpdateFunc: rc.reconcileUpdate,
+			DeleteFunc: rc.reconcileDelete,
+		}, cfg.ResyncPeriod)
+		err = jobsInformer.AddIndexers(imageIndexers)
+		if err != nil {
+			panic(err)
+		}
+		err = jobsInformer.SetTransform(getImagesFromJob)
+		if err != nil {
+			panic(err)
+		}
+		rc.controllerIndexers.jobIndexer = jobsInformer.GetIndexer()
+	}
+
+	if cfg.PodImages {
+		podsInformer := informerFactory.Core().V1().Pods().Informer()
+		_, _ = podsInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				rc.reconcilePod(obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				rc.reconcileUpdate(oldObj, newObj)
+				if getCis(oldObj).enabled != getCis(newObj).enabled {
+					rc.reconcilePodController(newObj)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				rc.forgetRetags(obj)
+				rc.reconcilePod(obj)
+			},
+		}, cfg.ResyncPeriod)
+		err = podsInformer.AddIndexers(imageIndexers)
+		if err != nil {
+			panic(err)
+		}
+		err = podsInformer.AddIndexers(podControllerIndexers)
+		if err != nil {
+			panic(err)
+		}
+		err = podsInformer.SetTransform(getImagesFromPods(cfg.CheckEphemeralContainers))
+		if err != nil {
+			panic(err)
+		}
+		rc.controllerIndexers.podIndexer = podsInformer.GetIndexer()
+		rc.controllerIndexers.podImages = true
+	} else if cfg.CheckEphemeralContainers {
+		podsInformer := informerFactory.Core().V1().Pods().Informer()
+		_, _ = podsInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				rc.reconcile(obj)
+			},
+			UpdateFunc: rc.reconcileUpdate,
+			DeleteFunc: rc.reconcileDelete,
+		}, cfg.ResyncPeriod)
+		err = podsInformer.AddIndexers(imageIndexers)
+		if err != nil {
+			panic(err)
+		}
+		err = podsInformer.SetTransform(getImagesFromPod)
+		if err != nil {
+			panic(err)
+		}
+		rc.controllerIndexers.podIndexer = podsInformer.GetIndexer()
+	}
+
+	if cfg.PrioritizePDBWorkloads {
+		rc.controllerIndexers.pdbIndexer = informerFactory.Policy().V1().PodDisruptionBudgets().Informer().GetIndexer()
+	}
+
+	// Secrets other than image pull secrets are filtered out by the API server, so that they are never sent to the
+	// exporter. No secrets are read at all in the secretless mode.
+	var (
+		secretInformerFactories []informers.SharedInformerFactory
+		secrets                 pullSecretStore
+	)
+	for _, secretType := range pullSecretTypes {
+		if cfg.Secretless {
+			break
+		}
+
+		fieldSelector := fields.OneTermEqualSelector("type", string(secretType)).String()
+		tweakListOptions := func(options *metav1.ListOptions) {
+			options.FieldSelector = fieldSelector
+		}
+		secretInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, time.Hour, informers.WithTweakListOptions(tweakListOptions))
+		if len(cfg.Namespaces) > 0 {
+			useNamespacedInformers(secretInformerFactory, cfg.Namespaces, []namespacedResource{{object: &corev1.Secret{}, client: kubeClient.CoreV1().RESTClient(), resource: "secrets"}}, tweakListOptions)
+		}
+		secretsInformer := secretInformerFactory.Core().V1().Secrets().Informer()
+		err = secretsInformer.SetTransform(stripSecret)
+		if err != nil {
+			panic(err)
+		}
+		secretInformerFactories = append(secretInformerFactories, secretInformerFactory)
+		secrets = append(secrets, secretsInformer.GetIndexer())
+	}
+	if !cfg.Secretless {
+		rc.controllerIndexers.secretStore = secrets
+	}
 
-	rc.controllerIndexers.forceCheckDisabledControllerKinds = forceCheckDisabledControllerKinds
+	rc.controllerIndexers.forceCheckDisabledControllerKinds = cfg.ForceCheckDisabledControllerKinds
+
+	if len(cfg.WorkloadLabels) > 0 || len(cfg.ExporterConfig.WorkloadMetricLabels) > 0 {
+		// Set up once all indexers are in place, since ControllerIndexers is copied.
+		rc.imageStore.UseExtraLabels(
+			workloadLabelNames(cfg.WorkloadLabels, cfg.ExporterConfig.WorkloadMetricLabels),
+			rc.controllerIndexers.WorkloadLabels(cfg.WorkloadLabels, cfg.ExporterConfig.WorkloadMetricLabels),
+		)
+	}
+	if len(cfg.PriorityClasses) > 0 || cfg.PrioritizePDBWorkloads {
+		rc.imageStore.UsePriority(rc.controllerIndexers.CriticalImages(cfg.PriorityClasses))
+	}
+
+	if cfg.ExportSeverity {
+		normalize := func(image string) string {
+			ref, err := parseImageName(image, cfg.DefaultRegistry, cfg.PlainHTTP)
+			if err != nil {
+				return image
+			}
+			return ref.Name()
+		}
+
+		nodesInformer := informerFactory.Core().V1().Nodes().Informer()
+		err = nodesInformer.SetTransform(getNodeImages(normalize))
+		if err != nil {
+			panic(err)
+		}
+		rc.imageSeverity = &imageSeverity{
+			nodeIndexer:    nodesInformer.GetIndexer(),
+			normalize:      normalize,
+			cachedSeverity: cfg.CachedImageSeverity,
+		}
+	}
 
 	go informerFactory.Start(stopCh)
+	for _, secretInformerFactory := range secretInformerFactories {
+		go secretInformerFactory.Start(stopCh)
+	}
 	logrus.Info("Waiting for cache sync")
 	informerFactory.WaitForCacheSync(stopCh)
+	for _, secretInformerFactory := range secretInformerFactories {
+		secretInformerFactory.WaitForCacheSync(stopCh)
+	}
 	logrus.Info("Caches populated successfully")
 
-	rc.imageStore.RunGC(rc.controllerIndexers.GetContainerInfosForImage)
+	rc.imageStore.RunGC(rc.controllerIndexers.GetContainerInfosForImage, cfg.GCInterval, cfg.KeepOrphansFor)
+	if cfg.StoreBackend != nil {
+		rc.imageStore.RunBackendSync(cfg.StoreBackendSyncInterval)
+	}
+
+	if cfg.Canary.Interval > 0 {
+		rc.canaryPuller = newCanaryPuller(kubeClient, cfg.Canary, rc.controllerIndexers, rc.imageStore)
+		rc.canaryPuller.Run(stopCh)
+	}
 
 	return rc
 }
@@ -235,15 +624,206 @@ func (rc *Checker) Collect(ch chan<- prometheus.Metric) {
 	for _, m := range metrics {
 		ch <- m
 	}
+
+	for _, m := range rc.imageStore.ExtractAggregatedMetrics() {
+		ch <- m
+	}
+
+	if rc.policyEngine.Enabled() {
+		// Policies are evaluated without holding the store lock.
+		var containers []policy.Container
+		rc.imageStore.RangeContainers(func(image string, containerInfo store.ContainerInfo, _ store.AvailabilityMode) {
+			containers = append(containers, policy.Container{Image: image, Info: containerInfo})
+		})
+		for _, m := range rc.policyEngine.EvaluateAll(containers) {
+			ch <- m
+		}
+	}
+
+	if rc.quayTokenWatcher != nil {
+		rc.quayTokenWatcher.collect(ch)
+	}
+
+	if rc.registryPinger != nil {
+		rc.registryPinger.collect(ch)
+	}
+
+	if rc.manifestCache != nil {
+		rc.manifestCache.collect(ch)
+	}
+	if rc.checkResults != nil {
+		rc.checkResults.collect(ch)
+	}
+
+	if rc.circuitBreaker != nil {
+		rc.circuitBreaker.collect(ch)
+	}
+	if rc.lockout != nil {
+		rc.lockout.collect(ch)
+	}
+	if rc.simulatedFailures != nil {
+		rc.simulatedFailures.collect(rc.imageStore, ch)
+	}
+
+	rc.badImageNames.collect(rc.imageStore, ch)
+
+	if rc.canaryPuller != nil {
+		rc.canaryPuller.collect(ch)
+	}
+
+	if rc.tagDrift != nil {
+		rc.tagDrift.collect(rc.imageStore, ch)
+	}
+	if rc.retags != nil {
+		rc.retags.collect(ch)
+	}
+	if rc.imageAges != nil {
+		rc.imageAges.collect(rc.imageStore, ch)
+	}
+	if rc.provenances != nil {
+		rc.provenances.collect(rc.imageStore, ch)
+	}
+	if rc.sboms != nil {
+		rc.sboms.collect(rc.imageStore, ch)
+	}
+	if rc.referrers != nil {
+		rc.referrers.collect(ch)
+	}
+
+	rc.credentialSources.collect(ch)
+	rc.checkCounter.collect(ch)
+	if rc.certExpiry != nil {
+		rc.certExpiry.collect(rc.imageStore, rc.checkedRegistryOf, ch)
+	}
+	rc.oldRegistryResponses.collect(ch)
+	if rc.dnsDiagnostics != nil {
+		rc.dnsDiagnostics.collect(ch)
+	}
+	collectPlatformInfo(rc.config.checkPlatform, ch)
+	ch <- prometheus.MustNewConstMetric(skippedReconcilesDesc, prometheus.CounterValue, float64(rc.skippedReconciles.Load()))
+	rc.controllerIndexers.collectMalformedPullSecrets(ch)
+	rc.controllerIndexers.collectPossiblyUnusedPullSecrets(ch)
+
+	if rc.imageSeverity != nil {
+		rc.imageSeverity.collect(rc.imageStore, ch)
+	}
+}
+
+// checkerDescs are descriptors of metrics the checker exports besides the ones of the store and the policy engine.
+var checkerDescs = []*prometheus.Desc{
+	skippedReconcilesDesc,
+	badImageNameDesc,
+	canaryPullDesc,
+	canaryPullDurationDesc,
+	simulatedFailuresDesc,
+	checkResultCacheHitsDesc,
+	checksDesc,
+	registryCircuitOpenDesc,
+	registryDNSResolutionDesc,
+	registryCertExpiryDesc,
+	credentialLockoutProtectionDesc,
+	credentialResolutionsDesc,
+	pullSecretMissesDesc,
+	malformedPullSecretDesc,
+	possiblyUnusedPullSecretDesc,
+	imageCreatedDesc,
+	manifestCacheHitsDesc,
+	manifestNotModifiedDesc,
+	oldRegistryResponsesDesc,
+	registryUpDesc,
+	registryPingDurationDesc,
+	platformInfoDesc,
+	imageProvenanceDesc,
+	quayAppTokenExpiryDesc,
+	quayAppTokenExpiringDesc,
+	referrersAPIDesc,
+	imageSBOMDesc,
+	imageSBOMFormatDesc,
+	unavailableImageSeverityDesc,
+	tagDigestChangesDesc,
+	imageReplacementsDesc,
+	imageReplacedDesc,
+}
+
+// Describe implements prometheus.Collector. Descriptors of optional features are sent even if they are disabled, so
+// that the set of descriptors doesn't depend on flags.
+func (rc *Checker) Describe(ch chan<- *prometheus.Desc) {
+	rc.imageStore.Describe(ch)
+	rc.policyEngine.Describe(ch)
+	for _, desc := range checkerDescs {
+		ch <- desc
+	}
+}
+
+// Register registers the checker into the registerer, e.g. a custom registry.
+func (rc *Checker) Register(registerer prometheus.Registerer) error {
+	return registerer.Register(rc)
 }
 
-// Describe implements prometheus.Collector.
-func (rc *Checker) Describe(_ chan<- *prometheus.Desc) {}
+// Inventory reports images referenced by enabled workloads, including ignored images.
+func (rc *Checker) Inventory() inventory.Report {
+	images := make(map[string][]store.ContainerInfo)
+	for _, image := range rc.controllerIndexers.ListImages() {
+		images[image] = rc.controllerIndexers.GetContainerInfosForImage(image)
+	}
+
+	report := inventory.Build(images, rc.registryOf)
+	availModes := rc.imageStore.CheckedAvailabilityModes()
+	for i, img := range report.Images {
+		if availMode, ok := availModes[img.Image]; ok {
+			report.Images[i].AvailabilityMode = availMode.String()
+		}
+	}
+
+	return report
+}
+
+// RangeContainers calls f for every container referencing a tracked image, see store.ImageStore.RangeContainers.
+func (rc *Checker) RangeContainers(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+	rc.imageStore.RangeContainers(f)
+}
+
+// FlaggedImages returns sorted images that failed their last check for a reason a node could see differently,
+// e.g. because it uses other credentials or network routes. Malformed references are never included.
+func (rc *Checker) FlaggedImages() []string {
+	flagged := make(map[string]struct{})
+	rc.imageStore.RangeContainers(func(image string, _ store.ContainerInfo, availMode store.AvailabilityMode) {
+		switch availMode {
+		case store.Available, store.BadImageName, store.InvalidReference:
+			return
+		}
+		flagged[image] = struct{}{}
+	})
+
+	ret := make([]string, 0, len(flagged))
+	for image := range flagged {
+		ret = append(ret, image)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
 
 func (rc *Checker) Tick() {
 	rc.imageStore.Check()
 }
 
+// AddTransitionHandler registers a function that is called on image availability mode transitions.
+func (rc *Checker) AddTransitionHandler(handler func(transition store.Transition)) {
+	rc.imageStore.AddTransitionHandler(handler)
+}
+
+// TickFailed rechecks failed images, it is a no-op unless a retry policy is configured.
+func (rc *Checker) TickFailed() {
+	rc.imageStore.CheckFailed()
+}
+
+// CheckImage checks an image on demand. Images that aren't referenced by any container are checked as well,
+// but the result isn't recorded.
+func (rc *Checker) CheckImage(image string) (availMode store.AvailabilityMode, tracked bool) {
+	return rc.imageStore.CheckImage(image)
+}
+
 func (rc *Checker) reconcile(obj interface{}) {
 	cis := getCis(obj)
 
@@ -261,37 +841,301 @@ imagesLoop:
 	}
 }
 
+// reconcilePod reconciles images of the pod and of the template of its controller, which are only checked while
+// the controller has no pods.
+func (rc *Checker) reconcilePod(obj interface{}) {
+	rc.reconcile(obj)
+	rc.reconcilePodController(obj)
+}
+
+func (rc *Checker) reconcilePodController(obj interface{}) {
+	cis := getCis(obj)
+
+	indexer := rc.controllerIndexers.indexerForKind(cis.controllerKind)
+	if indexer == nil || cis.controllerKind == "Pod" {
+		return
+	}
+
+	controller, exists, err := indexer.GetByKey(cis.Namespace + "/" + cis.controllerName)
+	if err != nil || !exists {
+		return
+	}
+
+	rc.reconcile(controller)
+}
+
+// reconcileUpdate skips updates of controllers that don't change anything reconciliation depends on, e.g. scaling by
+// an HPA or status updates, which are frequent during autoscaling. Periodic resyncs are reconciled regardless.
+func (rc *Checker) reconcileUpdate(oldObj, newObj interface{}) {
+	oldCis, newCis := getCis(oldObj), getCis(newObj)
+	if oldCis.ResourceVersion != newCis.ResourceVersion && oldCis.imageHash() == newCis.imageHash() {
+		rc.skippedReconciles.Add(1)
+		return
+	}
+	if rc.retags != nil {
+		rc.retags.record(oldCis, newCis)
+	}
+
+	rc.reconcile(newObj)
+}
+
+func (rc *Checker) reconcileDelete(obj interface{}) {
+	rc.forgetRetags(obj)
+	rc.reconcile(obj)
+}
+
+func (rc *Checker) forgetRetags(obj interface{}) {
+	if rc.retags != nil {
+		rc.retags.forget(getCis(obj))
+	}
+}
+
 func (rc *Checker) Check(imageName string) store.AvailabilityMode {
-	keyChain := rc.controllerIndexers.GetKeychainForImage(imageName)
+	keychains, referenced := rc.controllerIndexers.pullSecretKeychains(imageName)
+	keyChain := rc.credentialSources.chain(keychains, false)
 
 	log := logrus.WithField("image_name", imageName)
-	return rc.checkImageAvailability(log, imageName, keyChain)
+	if referenced && len(keychains) == 0 {
+		registry := rc.registryOf(imageName)
+		rc.credentialSources.miss(registry)
+		log.WithField("registry", registry).Debug("None of the image pull secrets referenced by workloads of the image exists or can be used")
+	}
+	return rc.checkImageAvailability(log, imageName, keyChain, rc.controllerIndexers.nodePoolFor(imageName, rc.config.nodePools))
 }
 
-func (rc *Checker) checkImageAvailability(log *logrus.Entry, imageName string, kc authn.Keychain) (availMode store.AvailabilityMode) {
-	ref, err := parseImageName(imageName, rc.config.defaultRegistry, rc.config.plainHTTP)
+// rewriteImage returns the location the image is actually pulled from according to the image rewrite rules.
+func (rc *Checker) rewriteImage(imageName string) string {
+	return rewriteImage(rc.config.imageRewrites, imageName)
+}
+
+func (rc *Checker) registryOf(imageName string) string {
+	ref, err := parseImageName(rc.rewriteImage(imageName), rc.config.defaultRegistry, rc.config.plainHTTP)
 	if err != nil {
-		return checkImageNameParseErr(log, err)
+		return ""
 	}
 
-	imgErr := wait.ExponentialBackoff(wait.Backoff{
-		Duration: time.Second,
-		Factor:   2,
-		Steps:    2,
-	}, func() (bool, error) {
-		var err error
-		availMode, err = check(ref, kc, rc.registryTransport)
+	return ref.Context().RegistryStr()
+}
 
-		return availMode == store.Available, err
-	})
+// checkedRegistryOf returns the registry the image is checked in, which differs from registryOf if the image is
+// checked in the mirror of its node pool.
+func (rc *Checker) checkedRegistryOf(imageName string) string {
+	checkedImage := rc.rewriteImage(imageName)
+	if pool := rc.controllerIndexers.nodePoolFor(imageName, rc.config.nodePools); pool != nil {
+		checkedImage = pool.Rewrite(checkedImage)
+	}
+
+	ref, err := parseImageName(checkedImage, rc.config.defaultRegistry, rc.config.plainHTTP)
+	if err != nil {
+		return ""
+	}
+
+	return ref.Context().RegistryStr()
+}
+
+// checkImageAvailability checks the image as rewritten by the image rewrite rules, or its mirror and platform of the
+// node pool, if the image is used on a single node pool only.
+func (rc *Checker) checkImageAvailability(log *logrus.Entry, imageName string, kc authn.Keychain, pool *nodePool) (availMode store.AvailabilityMode) {
+	checkedImage, platform := rc.rewriteImage(imageName), rc.config.checkPlatform
+	if pool != nil {
+		checkedImage = pool.Rewrite(checkedImage)
+		if pool.platform != nil {
+			platform = pool.platform
+		}
+		log = log.WithField("node_pool", pool.Name)
+	}
+	if checkedImage != imageName {
+		log = log.WithField("checked_image", checkedImage)
+	}
+
+	if err := detectInvalidReference(imageName); err != nil {
+		log.WithFields(logrus.Fields{
+			"availability_mode": store.InvalidReference.String(),
+			"containers":        rc.containersOf(imageName),
+		}).Error(err)
+		rc.badImageNames.record(imageName, err)
+		return store.InvalidReference
+	}
+
+	ref, err := parseImageName(checkedImage, rc.config.defaultRegistry, rc.config.plainHTTP)
+	if err != nil {
+		availMode = checkImageNameParseErr(log.WithField("containers", rc.containersOf(imageName)), err)
+		if availMode == store.BadImageName {
+			rc.badImageNames.record(imageName, err)
+		}
+		return availMode
+	}
+
+	if err := validateGCPReference(ref); err != nil {
+		log.WithFields(logrus.Fields{
+			"availability_mode": store.BadImageName.String(),
+			"containers":        rc.containersOf(imageName),
+		}).Error(err)
+		rc.badImageNames.record(imageName, err)
+		return store.BadImageName
+	}
+	rc.badImageNames.forget(imageName)
+
+	if rc.simulatedFailures != nil && rc.simulatedFailures.fails(imageName) {
+		log.WithField("availability_mode", store.Absent.String()).Debug("Skipping the check, the image is reported as absent by -simulate-failures")
+		return store.Absent
+	}
+
+	if rc.manifestCache != nil && rc.manifestCache.fresh(ref) {
+		return store.Available
+	}
+
+	registry := ref.Context().RegistryStr()
+
+	var (
+		digest string
+		imgErr error
+
+		credentials      credentialsKey
+		checkCredentials bool
+	)
+	// The cache is looked up first, so that a cached result doesn't take the probe of an open circuit or of paused
+	// credentials, which is only released once the outcome of a check is recorded.
+	result, cacheKey, cached := rc.cachedCheck(log, ref, kc, platform)
+	if rc.lockout != nil && !cached {
+		credentials, checkCredentials = credentialsKeyOf(ref, kc, rc.fallbackKeychain)
+	}
+	if checkCredentials {
+		if lockoutMode, ok := rc.lockout.allow(credentials); !ok {
+			log.WithFields(logrus.Fields{"availability_mode": lockoutMode.String(), "username": credentials.username}).
+				Debugf("Skipping the check, checks with the credentials for %q are paused to protect the account from a lockout", registry)
+			return lockoutMode
+		}
+	}
+	if rc.circuitBreaker != nil && !cached && !rc.circuitBreaker.allow(registry) {
+		if checkCredentials {
+			rc.lockout.release(credentials)
+		}
+		log.WithField("availability_mode", store.RegistryUnavailable.String()).Debugf("Skipping the check, the circuit of %q is open", registry)
+		return store.RegistryUnavailable
+	}
+	if cached {
+		availMode, digest, imgErr = result.availMode, result.digest, result.err
+		log.WithField("availability_mode", availMode.String()).Debug("The check is answered from the check result cache")
+	} else {
+		imgErr = wait.ExponentialBackoff(wait.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Steps:    2,
+		}, func() (bool, error) {
+			checkID := newCheckID()
+
+			var (
+				err       error
+				recording *CheckRecording
+			)
+			getFallback, transport := rc.manifestGetFallback(registry), rc.registryTransport
+			if rc.recorder != nil {
+				recording, transport = rc.recorder.start(checkID, imageName, ref, platform, getFallback, transport)
+			}
+			availMode, digest, err = check(ref, kc, rc.fallbackKeychain, transport, platform, getFallback)
+			if recording != nil {
+				if recordErr := rc.recorder.finish(recording, availMode, err); recordErr != nil {
+					log.Warnf("Failed to record the check: %v", recordErr)
+				}
+			}
+			if IsOldRegistry(err) {
+				rc.oldRegistryResponses.record(registry)
+				availMode = rc.config.oldRegistryMode.availabilityMode()
+			}
+			rc.checkCounter.record(registry, availMode, checkID)
+			log = log.WithField("check_id", checkID)
+
+			return availMode == store.Available, err
+		})
+		rc.storeCheck(cacheKey, availMode, digest, imgErr)
+	}
+
+	if rc.tagDrift != nil && rc.tagDrift.floating(ref) {
+		rc.tagDrift.record(imageName, digest)
+	}
+
+	if rc.imageAges != nil && availMode == store.Available {
+		err := rc.imageAges.record(imageName, digest, func() (time.Time, error) {
+			ctx, cancel := checkContext(ref)
+			defer cancel()
+
+			return imageCreated(ref, digest, platform, remoteOptions(ctx, kc, rc.fallbackKeychain, rc.registryTransport)...)
+		})
+		if err != nil {
+			log.Warnf("Failed to read the creation time of the image: %v", err)
+		}
+	}
+
+	if rc.provenances != nil && availMode == store.Available {
+		err := rc.provenances.record(imageName, digest, func() (bool, error) {
+			ctx, cancel := checkContext(ref)
+			defer cancel()
+
+			return hasProvenance(ref, digest, rc.referrers, rc.registryTransport, remoteOptions(ctx, kc, rc.fallbackKeychain, rc.registryTransport)...)
+		})
+		if err != nil {
+			log.Warnf("Failed to look up provenance attestations of the image: %v", err)
+		}
+	}
+
+	if rc.sboms != nil && availMode == store.Available {
+		err := rc.sboms.record(imageName, digest, func() ([]string, error) {
+			ctx, cancel := checkContext(ref)
+			defer cancel()
+
+			return sbomFormats(ref, digest, rc.referrers, rc.registryTransport, remoteOptions(ctx, kc, rc.fallbackKeychain, rc.registryTransport)...)
+		})
+		if err != nil {
+			log.Warnf("Failed to look up SBOMs of the image: %v", err)
+		}
+	}
+
+	if checkCredentials {
+		if rc.lockout.record(credentials, availMode, imgErr) {
+			log.WithField("username", credentials.username).Warnf("Pausing checks with the credentials for %q for %s after consecutive rejected checks", registry, rc.lockout.config.Cooldown)
+		}
+	}
+	if rc.circuitBreaker != nil && !cached && rc.circuitBreaker.record(registry, imgErr) {
+		scheduled := rc.imageStore.RecheckFailed(func(image string) bool {
+			return rc.registryOf(image) == registry
+		})
+		logrus.WithField("registry", registry).Infof("The registry recovered, rechecking %d failed images first", scheduled)
+	}
+	if rc.dnsDiagnostics != nil && !cached {
+		rc.dnsDiagnostics.record(log, registry, imgErr)
+	}
 
 	if availMode != store.Available {
 		log.WithField("availability_mode", availMode.String()).Error(imgErr)
+	} else if IsOldRegistry(imgErr) {
+		log.WithField("availability_mode", availMode.String()).Warnf("The image can't be verified, reporting it as available: %v", imgErr)
+	}
+	if availMode == store.AuthzFailure && isGCPAmbiguousDenial(registry, imgErr) {
+		log.Warn("Google registries deny access to repositories that don't exist, check both the repository path and the permissions of the identity")
 	}
 
 	return
 }
 
+// manifestGetFallback reports whether HEAD requests rejected as unauthenticated by the registry are retried with GET.
+func (rc *Checker) manifestGetFallback(registry string) bool {
+	settings, ok := rc.config.exporterConfig.Match(registry)
+	return ok && settings.ManifestGetFallback
+}
+
+// containersOf returns containers referencing the image as "namespace/kind/name/container", so that the owners of
+// an image that can't be checked can be found in logs.
+func (rc *Checker) containersOf(image string) []string {
+	var ret []string
+	for _, ci := range rc.controllerIndexers.GetContainerInfosForImage(image) {
+		ret = append(ret, ci.Namespace+"/"+strings.ToLower(ci.ControllerKind)+"/"+ci.ControllerName+"/"+ci.Container)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
 func checkImageNameParseErr(log *logrus.Entry, err error) store.AvailabilityMode {
 	var parseErr *name.ErrBadName
 	if errors.As(err, &parseErr) {
@@ -303,6 +1147,23 @@ func checkImageNameParseErr(log *logrus.Entry, err error) store.AvailabilityMode
 	return store.UnknownError
 }
 
+func newRegistryTransport(tlsConfig *tls.Config, strictTLS bool, exporterConfig *config.Config, userAgent string, proxy *url.URL) http.RoundTripper {
+	customTransport := http.DefaultTransport.(*http.Transport).Clone()
+	customTransport.TLSClientConfig = tlsConfig
+	if proxy != nil {
+		customTransport.Proxy = http.ProxyURL(proxy)
+	}
+
+	registryTransport := newVirtualHostTransport(exporterConfig, customTransport, func(transport http.RoundTripper) http.RoundTripper {
+		if strictTLS {
+			return newTLSLoggingTransport(transport)
+		}
+		return transport
+	})
+
+	return newRedirectTransport(exporterConfig, newUserAgentTransport(userAgent, newTokenEndpointTransport(exporterConfig, newHeaderTransport(exporterConfig, registryTransport))))
+}
+
 func parseImageName(image string, defaultRegistry string, plainHTTP bool) (name.Reference, error) {
 	var (
 		ref name.Reference
@@ -322,36 +1183,76 @@ func parseImageName(image string, defaultRegistry string, plainHTTP bool) (name.
 
 	ref, err = name.ParseReference(image, opts...)
 	if err != nil {
+		// Unlike parsing a tag or a digest, ParseReference doesn't tell what's wrong with the reference.
+		var detailedErr error
+		if strings.Contains(image, "@") {
+			_, detailedErr = name.NewDigest(image, opts...)
+		} else {
+			_, detailedErr = name.NewTag(image, opts...)
+		}
+		if detailedErr != nil {
+			err = detailedErr
+		}
 		return nil, err
 	}
 
 	return ref, nil
 }
 
-func check(ref name.Reference, kc authn.Keychain, registryTransport *http.Transport) (store.AvailabilityMode, error) {
-	var imgErr error
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	// Fallback to default keychain if image is not found in the provided one.
-	// This is a behavior that is close to what CRI does. Because, there is maybe an image pull secret, but with
-	// the wrong credentials. Yet, the image may be available with the default keychain.
+// checkKeychain falls back to the default keychain if the image is not found in the provided one.
+// This is a behavior that is close to what CRI does. Because, there is maybe an image pull secret, but with
+// the wrong credentials. Yet, the image may be available with the default keychain.
+// The fallback keychain also includes credentials obtained via workload identity, if it's enabled.
+func checkKeychain(kc, fallbackKc authn.Keychain) authn.Keychain {
 	if kc != nil {
-		kc = authn.NewMultiKeychain(kc, authn.DefaultKeychain)
-	} else {
-		kc = authn.DefaultKeychain
+		return authn.NewMultiKeychain(kc, fallbackKc)
 	}
 
-	_, imgErr = remote.Head(
-		ref,
-		remote.WithAuthFromKeychain(kc),
+	return fallbackKc
+}
+
+func remoteOptions(ctx context.Context, kc, fallbackKc authn.Keychain, registryTransport http.RoundTripper) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(checkKeychain(kc, fallbackKc)),
 		remote.WithTransport(registryTransport),
 		remote.WithContext(ctx),
-	)
+	}
+}
+
+// checkContext returns the context of registry requests made to check the reference.
+func checkContext(ref name.Reference) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(withCheckedRegistry(context.Background(), ref.Context().RegistryStr()), 15*time.Second)
+}
+
+func check(ref name.Reference, kc, fallbackKc authn.Keychain, registryTransport http.RoundTripper, platform *v1.Platform, getFallback bool) (store.AvailabilityMode, string, error) {
+	ctx, cancel := checkContext(ref)
+	defer cancel()
+
+	options := remoteOptions(ctx, kc, fallbackKc, registryTransport)
+
+	desc, imgErr := remote.Head(ref, options...)
+	if getFallback && IsAuthnFail(imgErr) {
+		// Some registries reject HEAD requests until the token flow has been completed with a GET request.
+		var getDesc *remote.Descriptor
+		getDesc, imgErr = remote.Get(ref, options...)
+		if imgErr == nil {
+			desc = &getDesc.Descriptor
+		}
+	}
+	if imgErr == nil && platform != nil && desc.MediaType.IsIndex() {
+		imgErr = checkPlatformManifest(ref, platform, options...)
+	}
+
+	var digest string
+	if imgErr == nil {
+		digest = desc.Digest.String()
+	}
 
 	var availMode store.AvailabilityMode
-	if IsAbsent(imgErr) {
+	if IsRedirectFailure(imgErr) {
+		// Errors of object storage the registry redirected to aren't about the image.
+		availMode = store.RegistryUnavailable
+	} else if IsAbsent(imgErr) || errors.Is(imgErr, errPlatformNotFound) {
 		availMode = store.Absent
 	} else if IsAuthnFail(imgErr) {
 		availMode = store.AuthnFailure
@@ -359,9 +1260,11 @@ func check(ref name.Reference, kc authn.Keychain, registryTransport *http.Transp
 		availMode = store.AuthzFailure
 	} else if IsOldRegistry(imgErr) {
 		availMode = store.Available
+	} else if IsDNSFailure(imgErr) {
+		availMode = store.RegistryUnavailable
 	} else if imgErr != nil {
 		availMode = store.UnknownError
 	}
 
-	return availMode, imgErr
+	return availMode, digest, imgErr
 }
@@ -1,10 +1,30 @@
 package registry
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/policy"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
 )
 
 func Test_parseImageName(t *testing.T) {
@@ -26,3 +46,161 @@ func Test_parseImageName(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, path.Join(defaultRegistryName, goodImageNameWithoutRegistry), ref.Name())
 }
+
+func Test_check_manifestGetFallback(t *testing.T) {
+	registryHandler := ggcrregistry.New()
+	var rejectHead atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Anonymous HEAD requests for manifests are rejected, like older Nexus versions do.
+		if rejectHead.Load() && r.Method == http.MethodHead && strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registryHandler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	ref, err := name.ParseReference(host+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+	img, err := random.Image(128, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	rejectHead.Store(true)
+
+	availMode, _, err := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, nil, false)
+	require.Error(t, err)
+	require.Equal(t, store.AuthnFailure, availMode)
+
+	availMode, digest, err := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, store.Available, availMode)
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	require.Equal(t, imgDigest.String(), digest)
+
+	rc := &Checker{config: registryCheckerConfig{exporterConfig: &config.Config{Registries: []config.Registry{{Host: host, ManifestGetFallback: true}}}}}
+	require.True(t, rc.manifestGetFallback(host))
+	require.False(t, rc.manifestGetFallback("registry.example.com"))
+	require.False(t, (&Checker{}).manifestGetFallback(host))
+}
+
+var fqNameRegex = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+func TestChecker_Describe(t *testing.T) {
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Absent }, 1, 1)
+	imageStore.UseMetricStyle(store.MetricStyleBoth)
+	imageStore.UseSeriesLimit(1)
+	imageStore.UseImageRewrite(func(image string) string { return "mirror.example.com/" + image })
+	imageStore.UseExtraLabels(workloadLabelNames([]string{"team", "app.kubernetes.io/name"}, nil), func(store.ContainerInfo) map[string]string {
+		return map[string]string{"label_team": "web"}
+	})
+	containerInfos := []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}}
+	imageStore.ReconcileImage("app:latest", containerInfos)
+	imageStore.ReconcileImage("app:v1", containerInfos)
+	imageStore.Check()
+
+	rc := &Checker{
+		imageStore: imageStore,
+		policyEngine: policy.NewEngine(policy.Config{ApprovedRegistries: []string{"registry.example.com"}, DeniedTags: []string{"latest"}}, func(image string) (name.Reference, error) {
+			return name.ParseReference(image)
+		}),
+		badImageNames:        newBadImageNames(),
+		credentialSources:    newCredentialSources(),
+		checkCounter:         newCheckCounter(),
+		oldRegistryResponses: newOldRegistryResponses(),
+		checkResults:         newCheckResultCache(time.Minute),
+		manifestCache:        newManifestCache(time.Minute),
+		circuitBreaker:       newCircuitBreaker(CircuitBreakerConfig{Threshold: 1, Cooldown: time.Minute}),
+		lockout:              newCredentialLockout(CredentialLockoutConfig{Threshold: 1, Cooldown: time.Minute}),
+		simulatedFailures:    newSimulatedFailures(50),
+		tagDrift:             newTagDrift([]string{"latest"}),
+		config:               registryCheckerConfig{checkPlatform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	}
+
+	// A pedantic registry fails to gather metrics that weren't described or that don't match their descriptors.
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, rc.Register(registry))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	ch := make(chan *prometheus.Desc, 1024)
+	rc.Describe(ch)
+	close(ch)
+	described := make(map[string]bool)
+	for desc := range ch {
+		described[fqNameRegex.FindStringSubmatch(desc.String())[1]] = true
+	}
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		require.True(t, described[family.GetName()], family.GetName())
+		require.NotEmpty(t, family.GetHelp(), family.GetName())
+		names[family.GetName()] = true
+	}
+	require.True(t, names["k8s_image_availability_exporter_absent"])
+	require.True(t, names["k8s_image_availability_exporter_availability_mode"])
+	require.True(t, names["k8s_image_availability_exporter_image_policy_violation"])
+	require.True(t, names["k8s_image_availability_exporter_dropped_series"])
+}
+
+func TestChecker_checkImageAvailability_openCircuitCached(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+	rc := &Checker{
+		badImageNames:    newBadImageNames(),
+		checkResults:     newCheckResultCache(time.Hour),
+		circuitBreaker:   breaker,
+		fallbackKeychain: authn.DefaultKeychain,
+	}
+
+	ref, err := name.ParseReference("registry.example.com/app:v1")
+	require.NoError(t, err)
+	key, err := checkResultKey(ref, authn.DefaultKeychain, rc.fallbackKeychain, nil)
+	require.NoError(t, err)
+	rc.checkResults.store(key, store.Available, "", nil)
+
+	breaker.record("registry.example.com", context.DeadlineExceeded)
+	now = now.Add(time.Minute)
+
+	// A check answered from the cache doesn't take the probe of the open circuit.
+	availMode := rc.checkImageAvailability(logrus.NewEntry(logrus.New()), "registry.example.com/app:v1", authn.DefaultKeychain, nil)
+	require.Equal(t, store.Available, availMode)
+	require.True(t, breaker.allow("registry.example.com"))
+}
+
+func TestChecker_checkImageAvailability_probes(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+	lockout := newCredentialLockout(CredentialLockoutConfig{Threshold: 1, Cooldown: 2 * time.Minute})
+	lockout.now = func() time.Time { return now }
+	rc := &Checker{
+		badImageNames:    newBadImageNames(),
+		circuitBreaker:   breaker,
+		lockout:          lockout,
+		fallbackKeychain: fixedKeychain{authn.FromConfig(authn.AuthConfig{Username: "ci", Password: "secret"})},
+	}
+	log := logrus.NewEntry(logrus.New())
+
+	ref := name.MustParseReference("registry.example.com/app:v1")
+	credentials, ok := credentialsKeyOf(ref, nil, rc.fallbackKeychain)
+	require.True(t, ok)
+	lockout.record(credentials, store.AuthnFailure, &transport.Error{StatusCode: http.StatusUnauthorized})
+	breaker.record("registry.example.com", context.DeadlineExceeded)
+
+	// Checks skipped for paused credentials don't take the probe of the circuit, whose cooldown is over.
+	now = now.Add(time.Minute)
+	require.Equal(t, store.AuthnFailure, rc.checkImageAvailability(log, ref.String(), nil, nil))
+	require.True(t, breaker.allow("registry.example.com"))
+
+	// Checks skipped for the open circuit give back the probe of the credentials, whose cooldown is over.
+	now = now.Add(30 * time.Second)
+	breaker.record("registry.example.com", context.DeadlineExceeded)
+	now = now.Add(30 * time.Second)
+	require.Equal(t, store.RegistryUnavailable, rc.checkImageAvailability(log, ref.String(), nil, nil))
+	_, ok = lockout.allow(credentials)
+	require.True(t, ok)
+}
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var registryCircuitOpenDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_registry_circuit_open",
+	"Non-zero indicates that checks of images in the registry are suspended after consecutive transport failures.",
+	[]string{"registry"}, nil,
+)
+
+// CircuitBreakerConfig configures suspending checks of registries that are down.
+type CircuitBreakerConfig struct {
+	// Threshold is the number of consecutive transport failures that open the circuit. The breaker is disabled if
+	// it is zero.
+	Threshold int
+	// Cooldown is the period checks of a registry are suspended for once the circuit is open.
+	Cooldown time.Duration
+}
+
+type circuitState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// circuitBreaker suspends checks of a registry after a number of consecutive transport failures, so that a registry
+// that is hard down doesn't cost a timeout for every image. Once the cooldown is over, a single check is let through
+// to probe the registry, the circuit is closed if it succeeds and opened again otherwise.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+	now    func() time.Time
+
+	lock       sync.Mutex
+	registries map[string]*circuitState
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		config:     config,
+		now:        time.Now,
+		registries: make(map[string]*circuitState),
+	}
+}
+
+// allow reports whether an image in the registry may be checked.
+func (b *circuitBreaker) allow(registry string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.registries[registry]
+	if !ok || state.failures < b.config.Threshold {
+		return true
+	}
+
+	if state.probing || b.now().Before(state.openUntil) {
+		return false
+	}
+
+	state.probing = true
+	return true
+}
+
+// record accounts the result of a check of an image in the registry. It reports whether the check closed the
+// circuit, i.e. the registry recovered.
+func (b *circuitBreaker) record(registry string, err error) (closed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !isTransportFailure(err) {
+		closed = b.open(registry)
+		delete(b.registries, registry)
+		return
+	}
+
+	state, ok := b.registries[registry]
+	if !ok {
+		state = &circuitState{}
+		b.registries[registry] = state
+	}
+
+	state.failures++
+	state.probing = false
+	if state.failures >= b.config.Threshold {
+		state.openUntil = b.now().Add(b.config.Cooldown)
+	}
+
+	return false
+}
+
+func (b *circuitBreaker) open(registry string) bool {
+	state, ok := b.registries[registry]
+	return ok && state.failures >= b.config.Threshold
+}
+
+func (b *circuitBreaker) collect(ch chan<- prometheus.Metric) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for registry := range b.registries {
+		var value float64
+		if b.open(registry) {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(registryCircuitOpenDesc, prometheus.GaugeValue, value, registry)
+	}
+}
+
+// isTransportFailure reports whether the registry couldn't be reached, as opposed to it responding with an error.
+// Gateway errors are counted as well, since that's what proxies in front of a registry that is down respond with.
+func isTransportFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var transpErr *transport.Error
+	if errors.As(err, &transpErr) {
+		switch transpErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(CircuitBreakerConfig{Threshold: 2, Cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	timeout := fmt.Errorf("HEAD: %w", context.DeadlineExceeded)
+
+	b.record("registry.example.com", timeout)
+	require.True(t, b.allow("registry.example.com"))
+
+	b.record("registry.example.com", timeout)
+	require.False(t, b.allow("registry.example.com"))
+	require.True(t, b.allow("other.example.com"))
+
+	// A single probe is let through after the cooldown.
+	now = now.Add(time.Minute)
+	require.True(t, b.allow("registry.example.com"))
+	require.False(t, b.allow("registry.example.com"))
+
+	// A failed probe opens the circuit again.
+	b.record("registry.example.com", timeout)
+	require.False(t, b.allow("registry.example.com"))
+
+	// Any response from the registry closes it.
+	now = now.Add(time.Minute)
+	require.True(t, b.allow("registry.example.com"))
+	b.record("registry.example.com", &transport.Error{StatusCode: http.StatusNotFound})
+	require.True(t, b.allow("registry.example.com"))
+	require.True(t, b.allow("registry.example.com"))
+}
+
+func Test_isTransportFailure(t *testing.T) {
+	require.False(t, isTransportFailure(nil))
+	require.False(t, isTransportFailure(&transport.Error{StatusCode: http.StatusUnauthorized}))
+	require.True(t, isTransportFailure(&transport.Error{StatusCode: http.StatusServiceUnavailable}))
+
+	_, err := http.Get("http://127.0.0.1:1/v2/")
+	require.True(t, isTransportFailure(err))
+}
+
+func Test_circuitBreaker_closed(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{Threshold: 2, Cooldown: time.Minute})
+	timeout := fmt.Errorf("HEAD: %w", context.DeadlineExceeded)
+
+	require.False(t, b.record("registry.example.com", nil))
+
+	// Failures below the threshold don't open the circuit.
+	require.False(t, b.record("registry.example.com", timeout))
+	require.False(t, b.record("registry.example.com", nil))
+
+	require.False(t, b.record("registry.example.com", timeout))
+	require.False(t, b.record("registry.example.com", timeout))
+	require.True(t, b.record("registry.example.com", nil))
+	require.False(t, b.record("registry.example.com", nil))
+}
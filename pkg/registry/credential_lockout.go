@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var credentialLockoutProtectionDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_credential_lockout_protection",
+	"Non-zero indicates that checks with the credentials are paused after consecutive authentication failures or rate limited responses of the registry, to protect the account from a lockout.",
+	[]string{"registry", "username", "credentials"}, nil,
+)
+
+// CredentialLockoutConfig configures pausing checks with credentials the registry keeps rejecting.
+type CredentialLockoutConfig struct {
+	// Threshold is the number of consecutive rejected checks with the same credentials that pauses checks with
+	// them. The protection is disabled if it is zero.
+	Threshold int
+	// Cooldown is the period checks with the credentials are paused for.
+	Cooldown time.Duration
+}
+
+// credentialsKey identifies credentials images in a registry are checked with.
+type credentialsKey struct {
+	registry string
+	username string
+	// hash is the hex-encoded SHA-256 of the credentials.
+	hash string
+}
+
+// credentialsKeyOf returns the key of the credentials the image is checked with. It is false for anonymous checks
+// and if credentials can't be resolved.
+func credentialsKeyOf(ref name.Reference, kc, fallbackKc authn.Keychain) (credentialsKey, bool) {
+	authConfig, hash, err := resolveCredentials(ref, kc, fallbackKc)
+	if err != nil || *authConfig == (authn.AuthConfig{}) {
+		return credentialsKey{}, false
+	}
+
+	return credentialsKey{registry: ref.Context().RegistryStr(), username: authConfig.Username, hash: hash}, true
+}
+
+type lockoutState struct {
+	failures    int
+	pausedUntil time.Time
+	probing     bool
+	// availMode is the result of the last rejected check, which images checked with paused credentials are
+	// reported with.
+	availMode store.AvailabilityMode
+}
+
+// credentialLockout pauses checks with credentials after a number of consecutive checks rejected with 401
+// Unauthorized or 429 Too Many Requests, while checks with other credentials proceed. Registries such as Artifactory
+// lock accounts out after a series of failed logins, which checks of every image with wrong credentials would
+// trigger. Once the cooldown is over, a single check is let through to probe the credentials. Anonymous checks are
+// never paused.
+type credentialLockout struct {
+	config CredentialLockoutConfig
+	now    func() time.Time
+
+	lock        sync.Mutex
+	credentials map[credentialsKey]*lockoutState
+}
+
+func newCredentialLockout(config CredentialLockoutConfig) *credentialLockout {
+	return &credentialLockout{
+		config:      config,
+		now:         time.Now,
+		credentials: make(map[credentialsKey]*lockoutState),
+	}
+}
+
+// allow reports whether an image may be checked with the credentials, and the availability mode to report it with
+// otherwise.
+func (l *credentialLockout) allow(key credentialsKey) (store.AvailabilityMode, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.credentials[key]
+	if !ok || state.failures < l.config.Threshold {
+		return store.Available, true
+	}
+
+	if state.probing || l.now().Before(state.pausedUntil) {
+		return state.availMode, false
+	}
+
+	state.probing = true
+	return store.Available, true
+}
+
+// release gives back the probe taken by allow for a check that wasn't made.
+func (l *credentialLockout) release(key credentialsKey) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if state, ok := l.credentials[key]; ok {
+		state.probing = false
+	}
+}
+
+// record accounts the result of a check with the credentials. It reports whether checks with them are paused.
+func (l *credentialLockout) record(key credentialsKey, availMode store.AvailabilityMode, err error) (paused bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !isLockoutRisk(err) {
+		// The registry not being reachable says nothing about the credentials.
+		if state, ok := l.credentials[key]; ok && isTransportFailure(err) {
+			state.probing = false
+		} else {
+			delete(l.credentials, key)
+		}
+		return false
+	}
+
+	state, ok := l.credentials[key]
+	if !ok {
+		state = &lockoutState{}
+		l.credentials[key] = state
+	}
+
+	state.failures++
+	state.probing = false
+	state.availMode = availMode
+	if state.failures < l.config.Threshold {
+		return false
+	}
+	state.pausedUntil = l.now().Add(l.config.Cooldown)
+
+	return true
+}
+
+func (l *credentialLockout) collect(ch chan<- prometheus.Metric) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for key, state := range l.credentials {
+		var value float64
+		if state.failures >= l.config.Threshold {
+			value = 1
+		}
+		// A prefix of the hash tells credentials of the same user apart, e.g. an old and a new password.
+		ch <- prometheus.MustNewConstMetric(credentialLockoutProtectionDesc, prometheus.GaugeValue, value, key.registry, key.username, key.hash[:12])
+	}
+}
+
+// isLockoutRisk reports whether the registry rejected the credentials or rate limited requests made with them.
+func isLockoutRisk(err error) bool {
+	var transpErr *transport.Error
+	if !errors.As(err, &transpErr) {
+		return false
+	}
+
+	return transpErr.StatusCode == http.StatusUnauthorized || transpErr.StatusCode == http.StatusTooManyRequests
+}
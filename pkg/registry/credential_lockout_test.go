@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_credentialLockout(t *testing.T) {
+	now := time.Now()
+	l := newCredentialLockout(CredentialLockoutConfig{Threshold: 2, Cooldown: time.Minute})
+	l.now = func() time.Time { return now }
+
+	locked := credentialsKey{registry: "artifactory.example.com", username: "ci", hash: "0123456789abcdef"}
+	other := credentialsKey{registry: "artifactory.example.com", username: "deploy", hash: "fedcba9876543210"}
+	unauthorized := &transport.Error{StatusCode: http.StatusUnauthorized}
+
+	require.False(t, l.record(locked, store.AuthnFailure, unauthorized))
+	_, ok := l.allow(locked)
+	require.True(t, ok)
+
+	require.True(t, l.record(locked, store.AuthnFailure, unauthorized))
+	availMode, ok := l.allow(locked)
+	require.False(t, ok)
+	require.Equal(t, store.AuthnFailure, availMode)
+	_, ok = l.allow(other)
+	require.True(t, ok)
+
+	ch := make(chan prometheus.Metric, 1)
+	l.collect(ch)
+	metric := &dto.Metric{}
+	require.NoError(t, (<-ch).Write(metric))
+	require.Equal(t, 1.0, metric.GetGauge().GetValue())
+	require.Len(t, metric.GetLabel(), 3)
+	require.Equal(t, "0123456789ab", metric.GetLabel()[0].GetValue())
+
+	// A single probe is let through after the cooldown. If the registry can't be reached, another probe is.
+	now = now.Add(time.Minute)
+	_, ok = l.allow(locked)
+	require.True(t, ok)
+	_, ok = l.allow(locked)
+	require.False(t, ok)
+	require.False(t, l.record(locked, store.RegistryUnavailable, fmt.Errorf("HEAD: %w", context.DeadlineExceeded)))
+	_, ok = l.allow(locked)
+	require.True(t, ok)
+	_, ok = l.allow(locked)
+	require.False(t, ok)
+
+	// Once the registry accepts the credentials, checks with them resume.
+	require.False(t, l.record(locked, store.Absent, &transport.Error{StatusCode: http.StatusNotFound}))
+	_, ok = l.allow(locked)
+	require.True(t, ok)
+	l.collect(ch)
+	require.Empty(t, ch)
+}
+
+func Test_credentialsKeyOf(t *testing.T) {
+	ref := name.MustParseReference("artifactory.example.com/app:v1")
+
+	_, ok := credentialsKeyOf(ref, nil, authn.NewMultiKeychain())
+	require.False(t, ok, "anonymous checks are never paused")
+
+	key, ok := credentialsKeyOf(ref, nil, fixedKeychain{authn.FromConfig(authn.AuthConfig{Username: "ci", Password: "secret"})})
+	require.True(t, ok)
+	require.Equal(t, "artifactory.example.com", key.registry)
+	require.Equal(t, "ci", key.username)
+
+	rotated, ok := credentialsKeyOf(ref, nil, fixedKeychain{authn.FromConfig(authn.AuthConfig{Username: "ci", Password: "rotated"})})
+	require.True(t, ok)
+	require.NotEqual(t, key, rotated)
+}
+
+func Test_isLockoutRisk(t *testing.T) {
+	require.False(t, isLockoutRisk(nil))
+	require.True(t, isLockoutRisk(&transport.Error{StatusCode: http.StatusUnauthorized}))
+	require.True(t, isLockoutRisk(fmt.Errorf("HEAD: %w", &transport.Error{StatusCode: http.StatusTooManyRequests})))
+	require.False(t, isLockoutRisk(&transport.Error{StatusCode: http.StatusForbidden}))
+}
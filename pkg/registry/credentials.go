@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Sources of registry credentials, in the order of precedence.
+const (
+	credentialSourcePod                   = "pod"
+	credentialSourceServiceAccount        = "service_account"
+	credentialSourceDefaultServiceAccount = "default_service_account"
+	credentialSourceFallback              = "fallback"
+	credentialSourceAnonymous             = "anonymous"
+)
+
+var credentialResolutionsDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_credential_resolutions_total",
+	"Number of registry credential resolutions, differentiated by the source that produced the credentials.",
+	[]string{"source"}, nil,
+)
+
+var pullSecretMissesDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_pull_secret_misses_total",
+	"Number of checks of images whose workloads reference image pull secrets, none of which exists or can be used, so that the image is checked without them.",
+	[]string{"registry"}, nil,
+)
+
+var malformedPullSecretDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_malformed_pull_secret",
+	"Non-zero indicates that an image pull secret referenced by a workload can't be used to pull images.",
+	[]string{"namespace", "secret"}, nil,
+)
+
+var possiblyUnusedPullSecretDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_possibly_unused_pull_secret",
+	"Non-zero indicates that neither a watched workload nor a ServiceAccount references the image pull secret. Advisory, since bare pods and pods of operators are only seen with pod image checks.",
+	[]string{"namespace", "secret"}, nil,
+)
+
+// pullSecretError returns the reason the kubelet can't use the secret as an image pull secret, if any. Both the
+// kubernetes.io/dockerconfigjson and the legacy kubernetes.io/dockercfg types are supported.
+func pullSecretError(secret *corev1.Secret) error {
+	var auths map[string]authn.AuthConfig
+
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok || len(data) == 0 {
+			return fmt.Errorf("missing %q key", corev1.DockerConfigJsonKey)
+		}
+
+		var config struct {
+			Auths map[string]authn.AuthConfig `json:"auths"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("invalid %q: %w", corev1.DockerConfigJsonKey, err)
+		}
+		auths = config.Auths
+	case corev1.SecretTypeDockercfg:
+		data, ok := secret.Data[corev1.DockerConfigKey]
+		if !ok || len(data) == 0 {
+			return fmt.Errorf("missing %q key", corev1.DockerConfigKey)
+		}
+
+		if err := json.Unmarshal(data, &auths); err != nil {
+			return fmt.Errorf("invalid %q: %w", corev1.DockerConfigKey, err)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", secret.Type)
+	}
+
+	for registry := range auths {
+		if !strings.HasPrefix(registry, "https://") && !strings.HasPrefix(registry, "http://") {
+			registry = "https://" + registry
+		}
+		if _, err := url.Parse(registry); err != nil {
+			return fmt.Errorf("invalid registry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pullSecretTypes are the types of secrets the kubelet can use as image pull secrets.
+var pullSecretTypes = []corev1.SecretType{corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg}
+
+// pullSecretStore looks image pull secrets up in caches of Secret informers limited to a single type each, since a
+// field selector can't match several types.
+type pullSecretStore []cache.Store
+
+func (s pullSecretStore) GetByKey(key string) (interface{}, bool, error) {
+	for _, store := range s {
+		obj, exists, err := store.GetByKey(key)
+		if err != nil || exists {
+			return obj, exists, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+func (s pullSecretStore) List() []interface{} {
+	var objs []interface{}
+	for _, store := range s {
+		objs = append(objs, store.List()...)
+	}
+
+	return objs
+}
+
+// stripSecret is the transform of the Secret informers. It keeps only the key, the type and the Docker config of
+// image pull secrets, so that annotations that may hold a copy of the data, e.g. the last applied configuration, are
+// not kept in memory.
+func stripSecret(obj interface{}) (interface{}, error) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return obj, nil
+	}
+
+	stripped := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            secret.Name,
+			Namespace:       secret.Namespace,
+			UID:             secret.UID,
+			ResourceVersion: secret.ResourceVersion,
+		},
+		Type: secret.Type,
+	}
+
+	var key string
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		key = corev1.DockerConfigJsonKey
+	case corev1.SecretTypeDockercfg:
+		key = corev1.DockerConfigKey
+	default:
+		return stripped, nil
+	}
+	if data, ok := secret.Data[key]; ok {
+		stripped.Data = map[string][]byte{key: data}
+	}
+
+	return stripped, nil
+}
+
+type sourcedKeychain struct {
+	source   string
+	keychain authn.Keychain
+}
+
+// credentialSources counts which sources registry credentials are resolved from, and checks of images whose pull
+// secrets are all missing, per registry.
+type credentialSources struct {
+	lock   sync.Mutex
+	counts map[string]uint64
+	misses map[string]uint64
+}
+
+func newCredentialSources() *credentialSources {
+	return &credentialSources{counts: make(map[string]uint64), misses: make(map[string]uint64)}
+}
+
+// miss counts a check of an image in the registry whose workloads reference image pull secrets, none of which
+// exists or can be used.
+func (c *credentialSources) miss(registry string) {
+	c.lock.Lock()
+	c.misses[registry]++
+	c.lock.Unlock()
+}
+
+func (c *credentialSources) inc(source string) {
+	c.lock.Lock()
+	c.counts[source]++
+	c.lock.Unlock()
+}
+
+func (c *credentialSources) collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for source, count := range c.counts {
+		ch <- prometheus.MustNewConstMetric(credentialResolutionsDesc, prometheus.CounterValue, float64(count), source)
+	}
+	for registry, count := range c.misses {
+		ch <- prometheus.MustNewConstMetric(pullSecretMissesDesc, prometheus.CounterValue, float64(count), registry)
+	}
+}
+
+// chain returns a keychain that resolves credentials from the keychains in order. Anonymous resolutions are
+// counted only if the chain is the last resort. It returns nil if there are no keychains.
+func (c *credentialSources) chain(keychains []sourcedKeychain, last bool) authn.Keychain {
+	if len(keychains) == 0 {
+		return nil
+	}
+
+	return &credentialChain{sources: c, keychains: keychains, last: last}
+}
+
+type credentialChain struct {
+	sources   *credentialSources
+	keychains []sourcedKeychain
+	last      bool
+}
+
+func (k *credentialChain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	for _, sk := range k.keychains {
+		auth, err := sk.keychain.Resolve(target)
+		if err != nil {
+			return nil, err
+		}
+		if auth != authn.Anonymous {
+			k.sources.inc(sk.source)
+			return auth, nil
+		}
+	}
+
+	if k.last {
+		k.sources.inc(credentialSourceAnonymous)
+	}
+
+	return authn.Anonymous, nil
+}
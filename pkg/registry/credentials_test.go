@@ -0,0 +1,303 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testPullSecret(t *testing.T, name, registry, username string) *corev1.Secret {
+	t.Helper()
+
+	dockerConfig, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{registry: map[string]string{"username": username, "password": "secret"}},
+	})
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+	}
+}
+
+func TestControllerIndexers_pullSecretKeychains(t *testing.T) {
+	replicas := int32(1)
+	template := podTemplate("registry.example.com/app:v1")
+	template.Spec.ServiceAccountName = "app"
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "pod"}, {Name: "missing"}}
+
+	serviceAccountIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, secret := range map[string]string{"app": "service-account", "default": "default-service-account"} {
+		require.NoError(t, serviceAccountIndexer.Add(&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Namespace: "default", Name: name},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: secret}},
+		}))
+	}
+
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, secretIndexer.Add(testPullSecret(t, "pod", "other.example.com", "pod")))
+	require.NoError(t, secretIndexer.Add(testPullSecret(t, "service-account", "registry.example.com", "service-account")))
+	require.NoError(t, secretIndexer.Add(testPullSecret(t, "default-service-account", "registry.example.com", "default")))
+
+	ci := ControllerIndexers{
+		serviceAccountIndexer: serviceAccountIndexer,
+		secretStore:           secretIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+	}
+
+	// Like the kubelet, only secrets of the pod are used if it has any, even if they are for another registry.
+	keychains, referenced := ci.pullSecretKeychains("registry.example.com/app:v1")
+	require.True(t, referenced)
+	require.Len(t, keychains, 1)
+	require.Equal(t, credentialSourcePod, keychains[0].source)
+
+	sources := newCredentialSources()
+	auth, err := sources.chain(keychains, false).Resolve(name.MustParseReference("registry.example.com/app:v1").Context())
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, auth)
+
+	// Otherwise secrets of the pod's ServiceAccount are used, without the ones of the default ServiceAccount.
+	withDeployment := func(serviceAccountName string) {
+		template := podTemplate("registry.example.com/app:v1")
+		template.Spec.ServiceAccountName = serviceAccountName
+		ci.deploymentIndexer = newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		})
+	}
+	withDeployment("app")
+	keychains, _ = ci.pullSecretKeychains("registry.example.com/app:v1")
+	require.Len(t, keychains, 1)
+	require.Equal(t, credentialSourceServiceAccount, keychains[0].source)
+
+	auth, err = sources.chain(keychains, false).Resolve(name.MustParseReference("registry.example.com/app:v1").Context())
+	require.NoError(t, err)
+	authConfig, err := auth.Authorization()
+	require.NoError(t, err)
+	require.Equal(t, "service-account", authConfig.Username)
+	require.Equal(t, map[string]uint64{credentialSourceServiceAccount: 1}, sources.counts)
+
+	// Pods without a ServiceAccount run as the default one.
+	withDeployment("")
+	keychains, _ = ci.pullSecretKeychains("registry.example.com/app:v1")
+	require.Len(t, keychains, 1)
+	require.Equal(t, credentialSourceDefaultServiceAccount, keychains[0].source)
+
+	auth, err = sources.chain([]sourcedKeychain{{source: credentialSourceFallback, keychain: authn.NewMultiKeychain()}}, true).
+		Resolve(name.MustParseReference("registry.example.com/app:v1").Context())
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, auth)
+	require.Equal(t, uint64(1), sources.counts[credentialSourceAnonymous])
+
+	// No secrets are read in the secretless mode, even if they are referenced.
+	ci.secretStore = nil
+	keychains, referenced = ci.pullSecretKeychains("registry.example.com/app:v1")
+	require.Empty(t, keychains)
+	require.False(t, referenced)
+	ch := make(chan prometheus.Metric, 10)
+	ci.collectMalformedPullSecrets(ch)
+	ci.collectPossiblyUnusedPullSecrets(ch)
+	require.Empty(t, ch)
+}
+
+func TestControllerIndexers_pullSecretKeychains_missing(t *testing.T) {
+	replicas := int32(1)
+	template := podTemplate("registry.example.com/app:v1")
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "deleted"}}
+
+	ci := ControllerIndexers{
+		serviceAccountIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		secretStore:           cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+	}
+
+	keychains, referenced := ci.pullSecretKeychains("registry.example.com/app:v1")
+	require.Empty(t, keychains)
+	require.True(t, referenced)
+
+	sources := newCredentialSources()
+	sources.miss("registry.example.com")
+	sources.miss("registry.example.com")
+	ch := make(chan prometheus.Metric, 1)
+	sources.collect(ch)
+	metric := &dto.Metric{}
+	require.NoError(t, (<-ch).Write(metric))
+	require.Equal(t, 2.0, metric.GetCounter().GetValue())
+	require.Equal(t, "registry.example.com", metric.GetLabel()[0].GetValue())
+}
+
+func TestPullSecretError(t *testing.T) {
+	valid := testPullSecret(t, "valid", "registry.example.com", "user")
+
+	dockercfg := &corev1.Secret{
+		Type: corev1.SecretTypeDockercfg,
+		Data: map[string][]byte{corev1.DockerConfigKey: []byte(`{"registry.example.com":{"username":"user","password":"secret"}}`)},
+	}
+
+	tests := []struct {
+		name    string
+		secret  *corev1.Secret
+		wantErr string
+	}{
+		{name: "dockerconfigjson", secret: valid},
+		{name: "dockercfg", secret: dockercfg},
+		{
+			name:    "malformed dockerconfigjson",
+			secret:  &corev1.Secret{Type: corev1.SecretTypeDockerConfigJson, Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":`)}},
+			wantErr: `invalid ".dockerconfigjson"`,
+		},
+		{
+			name:    "malformed dockercfg",
+			secret:  &corev1.Secret{Type: corev1.SecretTypeDockercfg, Data: map[string][]byte{corev1.DockerConfigKey: []byte(`["registry.example.com"]`)}},
+			wantErr: `invalid ".dockercfg"`,
+		},
+		{
+			name:    "missing key",
+			secret:  &corev1.Secret{Type: corev1.SecretTypeDockerConfigJson},
+			wantErr: `missing ".dockerconfigjson" key`,
+		},
+		{
+			name:    "unsupported type",
+			secret:  &corev1.Secret{Type: corev1.SecretTypeOpaque},
+			wantErr: `unsupported type "Opaque"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pullSecretError(tt.secret)
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPullSecretStore(t *testing.T) {
+	dockerConfigJSON := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	require.NoError(t, dockerConfigJSON.Add(testPullSecret(t, "json", "registry.example.com", "user")))
+	dockercfg := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	require.NoError(t, dockercfg.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cfg"},
+		Type:       corev1.SecretTypeDockercfg,
+	}))
+	store := pullSecretStore{dockerConfigJSON, dockercfg}
+
+	for _, key := range []string{"default/json", "default/cfg"} {
+		_, exists, err := store.GetByKey(key)
+		require.NoError(t, err)
+		require.True(t, exists, key)
+	}
+	_, exists, err := store.GetByKey("default/missing")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.Len(t, store.List(), 2)
+}
+
+func TestStripSecret(t *testing.T) {
+	pullSecret := testPullSecret(t, "pull", "registry.example.com", "user")
+	pullSecret.ResourceVersion = "42"
+	pullSecret.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: "{}"}
+	pullSecret.Data["extra"] = []byte("data")
+
+	obj, err := stripSecret(pullSecret)
+	require.NoError(t, err)
+	require.Equal(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pull", ResourceVersion: "42"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: pullSecret.Data[corev1.DockerConfigJsonKey]},
+	}, obj)
+	require.NoError(t, pullSecretError(obj.(*corev1.Secret)))
+
+	// The transform is idempotent.
+	again, err := stripSecret(obj)
+	require.NoError(t, err)
+	require.Equal(t, obj, again)
+
+	obj, err = stripSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls", Labels: map[string]string{"app": "web"}},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSPrivateKeyKey: []byte("key")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"},
+		Type:       corev1.SecretTypeTLS,
+	}, obj)
+}
+
+func TestControllerIndexers_collectPossiblyUnusedPullSecrets(t *testing.T) {
+	replicas := int32(0)
+	template := podTemplate("registry.example.com/app:v1")
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "pod"}}
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers("watched"))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"watched": ""}}}))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}))
+
+	serviceAccountIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, serviceAccountIndexer.Add(&corev1.ServiceAccount{
+		ObjectMeta:       metav1.ObjectMeta{Namespace: "default", Name: "default"},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "service-account"}},
+	}))
+
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"pod", "service-account", "unused"} {
+		require.NoError(t, secretIndexer.Add(testPullSecret(t, name, "registry.example.com", name)))
+	}
+	require.NoError(t, secretIndexer.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "opaque"}, Type: corev1.SecretTypeOpaque}))
+	unwatched := testPullSecret(t, "unused", "registry.example.com", "unused")
+	unwatched.Namespace = "other"
+	require.NoError(t, secretIndexer.Add(unwatched))
+
+	ci := ControllerIndexers{
+		namespaceIndexer:      namespaceIndexer,
+		serviceAccountIndexer: serviceAccountIndexer,
+		secretStore:           secretIndexer,
+		// Controllers scaled to zero still reference their secrets.
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	ci.collectPossiblyUnusedPullSecrets(ch)
+	close(ch)
+
+	var unused []string
+	for m := range ch {
+		var metric dto.Metric
+		require.NoError(t, m.Write(&metric))
+		unused = append(unused, metric.GetLabel()[0].GetValue()+"/"+metric.GetLabel()[1].GetValue())
+	}
+	require.Equal(t, []string{"default/unused"}, unused)
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// missingLookupTTL is the period lookups that found nothing, e.g. no provenance attestations or SBOMs, are cached
+// for. Those can be attached to an image after it's pushed, so images without them are looked up again eventually.
+const missingLookupTTL = time.Hour
+
+// digestTracker remembers values fetched during deep checks per digest, along with the digests tracked images resolved
+// to. Values are cached by digest and only fetched once an image resolves to a new digest, except for values missing
+// reports, which are fetched again once they're older than missingTTL.
+type digestTracker[T any] struct {
+	lock    sync.Mutex
+	digests map[string]string
+	values  map[string]trackedValue[T]
+
+	missing    func(T) bool
+	missingTTL time.Duration
+	now        func() time.Time
+}
+
+type trackedValue[T any] struct {
+	value     T
+	fetchedAt time.Time
+}
+
+// newDigestTracker returns a tracker that caches every value for as long as its digest is referenced if missing is
+// nil.
+func newDigestTracker[T any](missing func(T) bool, missingTTL time.Duration) *digestTracker[T] {
+	return &digestTracker[T]{
+		digests:    make(map[string]string),
+		values:     make(map[string]trackedValue[T]),
+		missing:    missing,
+		missingTTL: missingTTL,
+		now:        time.Now,
+	}
+}
+
+// record stores the digest the image resolved to and fetches its value with fetch unless it's cached.
+func (t *digestTracker[T]) record(image, digest string, fetch func() (T, error)) error {
+	if len(digest) == 0 {
+		return nil
+	}
+
+	t.lock.Lock()
+	v, known := t.values[digest]
+	t.lock.Unlock()
+
+	if !known || (t.missing != nil && t.missing(v.value) && t.now().Sub(v.fetchedAt) >= t.missingTTL) {
+		value, err := fetch()
+		if err != nil {
+			return err
+		}
+		v = trackedValue[T]{value: value, fetchedAt: t.now()}
+	}
+
+	t.lock.Lock()
+	t.values[digest] = v
+	t.digests[image] = digest
+	t.lock.Unlock()
+
+	return nil
+}
+
+// rangeContainers forgets untracked images and values of digests they no longer reference, then calls f with metric
+// labels of every container of a tracked image along with the value of its digest, if it's known.
+func (t *digestTracker[T]) rangeContainers(imageStore *store.ImageStore, f func(labels []string, value T)) {
+	type container struct {
+		image         string
+		containerInfo store.ContainerInfo
+	}
+
+	tracked := make(map[string]struct{})
+	var containers []container
+	imageStore.RangeContainers(func(image string, containerInfo store.ContainerInfo, _ store.AvailabilityMode) {
+		tracked[image] = struct{}{}
+		containers = append(containers, container{image: image, containerInfo: containerInfo})
+	})
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	referenced := make(map[string]struct{})
+	for image, digest := range t.digests {
+		if _, ok := tracked[image]; !ok {
+			delete(t.digests, image)
+			continue
+		}
+		referenced[digest] = struct{}{}
+	}
+	for digest := range t.values {
+		if _, ok := referenced[digest]; !ok {
+			delete(t.values, digest)
+		}
+	}
+
+	for _, c := range containers {
+		v, ok := t.values[t.digests[c.image]]
+		if !ok {
+			continue
+		}
+
+		f([]string{
+			c.containerInfo.Namespace, strings.ToLower(c.containerInfo.ControllerKind), c.containerInfo.ControllerName,
+			c.containerInfo.Container, c.image,
+		}, v.value)
+	}
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var registryDNSResolutionDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_registry_dns_resolution",
+	"Result of resolving the host of the registry with the resolver after a check of the registry failed at DNS resolution, non-zero indicates that the host resolved.",
+	[]string{"registry", "host", "resolver"}, nil,
+)
+
+const (
+	resolverCluster  = "cluster"
+	resolverFallback = "fallback"
+
+	// dnsDiagnosisInterval limits diagnoses of a registry, since a DNS failure fails checks of all of its images.
+	dnsDiagnosisInterval = time.Minute
+	dnsLookupTimeout     = 5 * time.Second
+)
+
+type lookupFunc func(ctx context.Context, host string) ([]string, error)
+
+type dnsDiagnosis struct {
+	host string
+	at   time.Time
+	// resolved holds whether the host resolved, by resolver.
+	resolved map[string]bool
+}
+
+// dnsDiagnostics resolves hosts that checks of a registry failed to resolve again, with the resolver of the pod, i.e.
+// cluster DNS, and optionally with a fallback resolver, so that a cluster DNS incident can be told apart from a
+// registry that is gone. Diagnoses are kept until a check of the registry gets past DNS resolution.
+type dnsDiagnostics struct {
+	resolvers map[string]lookupFunc
+	now       func() time.Time
+
+	lock       sync.Mutex
+	registries map[string]*dnsDiagnosis
+}
+
+func newDNSDiagnostics(fallbackResolver string) (*dnsDiagnostics, error) {
+	d := &dnsDiagnostics{
+		resolvers:  map[string]lookupFunc{resolverCluster: net.DefaultResolver.LookupHost},
+		now:        time.Now,
+		registries: make(map[string]*dnsDiagnosis),
+	}
+
+	if len(fallbackResolver) > 0 {
+		address, err := resolverAddress(fallbackResolver)
+		if err != nil {
+			return nil, err
+		}
+
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		}
+		d.resolvers[resolverFallback] = resolver.LookupHost
+	}
+
+	return d, nil
+}
+
+// resolverAddress adds the DNS port to a resolver address without one.
+func resolverAddress(s string) (string, error) {
+	if _, _, err := net.SplitHostPort(s); err == nil {
+		return s, nil
+	}
+	if net.ParseIP(s) == nil {
+		return "", fmt.Errorf("%q is neither an IP address nor an address:port", s)
+	}
+
+	return net.JoinHostPort(s, "53"), nil
+}
+
+// record diagnoses the registry if the check failed at DNS resolution, and forgets the diagnosis of the registry
+// otherwise.
+func (d *dnsDiagnostics) record(log *logrus.Entry, registry string, err error) {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		d.lock.Lock()
+		delete(d.registries, registry)
+		d.lock.Unlock()
+		return
+	}
+
+	d.lock.Lock()
+	if diagnosis, ok := d.registries[registry]; ok && diagnosis.host == dnsErr.Name && d.now().Sub(diagnosis.at) < dnsDiagnosisInterval {
+		d.lock.Unlock()
+		return
+	}
+	diagnosis := &dnsDiagnosis{host: dnsErr.Name, at: d.now()}
+	d.registries[registry] = diagnosis
+	d.lock.Unlock()
+
+	resolved := make(map[string]bool, len(d.resolvers))
+	errs := make(map[string]error, len(d.resolvers))
+	for resolver, lookup := range d.resolvers {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		_, errs[resolver] = lookup(ctx, dnsErr.Name)
+		cancel()
+		resolved[resolver] = errs[resolver] == nil
+	}
+
+	d.lock.Lock()
+	diagnosis.resolved = resolved
+	d.lock.Unlock()
+
+	log = log.WithFields(logrus.Fields{"registry": registry, "host": dnsErr.Name})
+	switch {
+	case resolved[resolverCluster]:
+		log.Info("The host resolves with cluster DNS again, the DNS failure was transient")
+	case resolved[resolverFallback]:
+		log.Warnf("The host doesn't resolve with cluster DNS, but resolves with the fallback resolver, cluster DNS is likely failing: %v", errs[resolverCluster])
+	default:
+		var failed []string
+		for resolver, err := range errs {
+			failed = append(failed, fmt.Sprintf("%s: %v", resolver, err))
+		}
+		sort.Strings(failed)
+		log.Warnf("The host doesn't resolve with any resolver, the registry is likely gone or misspelled: %v", failed)
+	}
+}
+
+func (d *dnsDiagnostics) collect(ch chan<- prometheus.Metric) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for registry, diagnosis := range d.registries {
+		for resolver, resolved := range diagnosis.resolved {
+			var value float64
+			if resolved {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(registryDNSResolutionDesc, prometheus.GaugeValue, value, registry, diagnosis.host, resolver)
+		}
+	}
+}
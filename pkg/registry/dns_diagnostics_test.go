@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_check_dnsFailure(t *testing.T) {
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: req.URL.Hostname(), IsNotFound: true}}
+	})
+
+	ref, err := name.ParseReference("registry.example.com/app:v1")
+	require.NoError(t, err)
+
+	availMode, _, err := check(ref, nil, authn.DefaultKeychain, transport, nil, false)
+	require.True(t, IsDNSFailure(err))
+	require.Equal(t, store.RegistryUnavailable, availMode)
+}
+
+func dnsResolutions(t *testing.T, d *dnsDiagnostics) map[string]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 10)
+	d.collect(ch)
+	close(ch)
+
+	ret := make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		ret[labels["registry"]+" "+labels["host"]+" "+labels["resolver"]] = m.GetGauge().GetValue()
+	}
+
+	return ret
+}
+
+func Test_dnsDiagnostics(t *testing.T) {
+	d, err := newDNSDiagnostics("")
+	require.NoError(t, err)
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	lookups := 0
+	clusterResolves := false
+	d.resolvers = map[string]lookupFunc{
+		resolverCluster: func(_ context.Context, host string) ([]string, error) {
+			lookups++
+			if clusterResolves {
+				return []string{"192.0.2.1"}, nil
+			}
+			return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+		},
+		resolverFallback: func(context.Context, string) ([]string, error) {
+			return []string{"192.0.2.1"}, nil
+		},
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	dnsErr := fmt.Errorf("Get: %w", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "server misbehaving", Name: "auth.example.com"}})
+
+	d.record(log, "registry.example.com", dnsErr)
+	require.Equal(t, map[string]float64{
+		"registry.example.com auth.example.com cluster":  0,
+		"registry.example.com auth.example.com fallback": 1,
+	}, dnsResolutions(t, d))
+
+	// Checks of other images of the registry don't diagnose it again within the interval.
+	d.record(log, "registry.example.com", dnsErr)
+	require.Equal(t, 1, lookups)
+
+	now = now.Add(dnsDiagnosisInterval)
+	clusterResolves = true
+	d.record(log, "registry.example.com", dnsErr)
+	require.Equal(t, 2, lookups)
+	require.Equal(t, float64(1), dnsResolutions(t, d)["registry.example.com auth.example.com cluster"])
+
+	// The diagnosis is forgotten once a check gets past DNS resolution.
+	d.record(log, "registry.example.com", errors.New("unexpected EOF"))
+	require.Empty(t, dnsResolutions(t, d))
+}
+
+func Test_resolverAddress(t *testing.T) {
+	for input, expected := range map[string]string{
+		"1.1.1.1":         "1.1.1.1:53",
+		"10.0.0.2:5353":   "10.0.0.2:5353",
+		"2001:4860::8888": "[2001:4860::8888]:53",
+		"dns.example.com": "",
+	} {
+		address, err := resolverAddress(input)
+		if len(expected) == 0 {
+			require.Error(t, err, input)
+			continue
+		}
+		require.NoError(t, err, input)
+		require.Equal(t, expected, address)
+	}
+}
@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/kubeclient"
+)
+
+const (
+	// Credentials are renewed this long before they expire.
+	credentialExpiryLeeway = 5 * time.Minute
+	// Cached credentials expiring within this window are renewed in the background, so that checks don't have to
+	// wait for token exchanges and don't fail if an exchange fails intermittently.
+	credentialRefreshWindow   = 15 * time.Minute
+	credentialRefreshInterval = time.Minute
+)
+
+type exchangeFunc func(registry string) (authn.AuthConfig, time.Time, error)
+
+type cachedCredential struct {
+	auth      authn.AuthConfig
+	expiresAt time.Time
+}
+
+// exchangeKeychain resolves credentials obtained by a token exchange and caches them per registry. Exchanges are made
+// outside of the lock, so that a slow token endpoint only delays checks of its own registry, and concurrent
+// exchanges for the same registry are deduplicated.
+type exchangeKeychain struct {
+	source     string
+	registries []string
+	exchange   exchangeFunc
+	exchanges  singleflight.Group
+
+	lock  sync.Mutex
+	cache map[string]cachedCredential
+}
+
+func newExchangeKeychain(source string, registries []string, exchange exchangeFunc) *exchangeKeychain {
+	return &exchangeKeychain{
+		source:     source,
+		registries: registries,
+		exchange:   exchange,
+		cache:      make(map[string]cachedCredential),
+	}
+}
+
+// Resolve implements authn.Keychain.
+func (k *exchangeKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	if !matchesRegistryPattern(registry, k.registries) {
+		return authn.Anonymous, nil
+	}
+
+	k.lock.Lock()
+	cred, cached := k.cache[registry]
+	k.lock.Unlock()
+	if cached && time.Until(cred.expiresAt) > credentialExpiryLeeway {
+		return authn.FromConfig(cred.auth), nil
+	}
+
+	auth, err := k.refresh(registry)
+	if err != nil {
+		if cached && time.Now().Before(cred.expiresAt) {
+			// Use the credential until it actually expires rather than failing checks.
+			return authn.FromConfig(cred.auth), nil
+		}
+
+		// Fall through to the next keychain instead of failing the whole check. Registries allowing anonymous
+		// pulls keep working as well.
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(auth), nil
+}
+
+// Run renews cached credentials ahead of their expiration.
+func (k *exchangeKeychain) Run(stopCh <-chan struct{}) {
+	go wait.Until(k.refreshExpiring, credentialRefreshInterval, stopCh)
+}
+
+func (k *exchangeKeychain) refreshExpiring() {
+	var expiring []string
+	k.lock.Lock()
+	for registry, cred := range k.cache {
+		if time.Until(cred.expiresAt) < credentialRefreshWindow {
+			expiring = append(expiring, registry)
+		}
+	}
+	k.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, registry := range expiring {
+		registry := registry
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = k.refresh(registry)
+		}()
+	}
+	wg.Wait()
+}
+
+// refresh exchanges credentials for the registry and caches them, joining an exchange already in flight.
+func (k *exchangeKeychain) refresh(registry string) (authn.AuthConfig, error) {
+	auth, err, _ := k.exchanges.Do(registry, func() (interface{}, error) {
+		auth, expiresAt, err := k.exchange(registry)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"registry": registry, "source": k.source}).Errorf("Token exchange failed: %v", err)
+			kubeclient.AuthRefreshFailures.WithLabelValues(k.source).Inc()
+			return nil, err
+		}
+
+		k.lock.Lock()
+		k.cache[registry] = cachedCredential{auth: auth, expiresAt: expiresAt}
+		k.lock.Unlock()
+
+		return auth, nil
+	})
+	if err != nil {
+		return authn.AuthConfig{}, err
+	}
+
+	return auth.(authn.AuthConfig), nil
+}
+
+// tokenClient performs requests to token endpoints.
+type tokenClient struct {
+	client *http.Client
+}
+
+func newTokenClient(transport http.RoundTripper) tokenClient {
+	return tokenClient{client: &http.Client{Transport: transport, Timeout: 15 * time.Second}}
+}
+
+func (c tokenClient) postForm(endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, out)
+}
+
+func (c tokenClient) do(req *http.Request, out interface{}) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status %d: %s", req.Method, req.URL.Redacted(), resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func matchesRegistryPattern(registry string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, registry); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exchangeKeychain(t *testing.T) {
+	release := make(chan struct{})
+	var slowExchanges atomic.Int32
+	keychain := newExchangeKeychain("test", []string{"*.example.com"}, func(registry string) (authn.AuthConfig, time.Time, error) {
+		if registry == "slow.example.com" {
+			slowExchanges.Add(1)
+			<-release
+		}
+		return authn.AuthConfig{Username: registry, Password: "token"}, time.Now().Add(time.Hour), nil
+	})
+
+	resolve := func(t *testing.T, registry string) authn.AuthConfig {
+		t.Helper()
+
+		reg, err := name.NewRegistry(registry)
+		require.NoError(t, err)
+		authenticator, err := keychain.Resolve(reg)
+		require.NoError(t, err)
+		auth, err := authenticator.Authorization()
+		require.NoError(t, err)
+
+		return *auth
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Equal(t, "slow.example.com", resolve(t, "slow.example.com").Username)
+		}()
+	}
+
+	// A slow exchange doesn't block credentials of other registries.
+	require.Equal(t, "fast.example.com", resolve(t, "fast.example.com").Username)
+	require.Equal(t, authn.AuthConfig{}, resolve(t, "registry.other.org"))
+
+	require.Eventually(t, func() bool { return slowExchanges.Load() > 0 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// The credential is cached afterwards.
+	exchanges := slowExchanges.Load()
+	require.Equal(t, "slow.example.com", resolve(t, "slow.example.com").Username)
+	require.Equal(t, exchanges, slowExchanges.Load())
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	artifactRegistryDomainSuffix = ".pkg.dev"
+	artifactRegistryDockerSuffix = "-docker.pkg.dev"
+)
+
+// containerRegistryProjectHosts are Container Registry hosts of Google Cloud projects. Other *.gcr.io hosts, e.g.
+// k8s.gcr.io or mirror.gcr.io, serve images outside of projects, like "k8s.gcr.io/pause".
+var containerRegistryProjectHosts = map[string]bool{
+	"gcr.io":      true,
+	"us.gcr.io":   true,
+	"eu.gcr.io":   true,
+	"asia.gcr.io": true,
+}
+
+func isGCPRegistry(registry string) bool {
+	return matchesRegistryPattern(registry, gcpRegistryPatterns)
+}
+
+// validateGCPReference rejects references to Google registries that no node could ever pull, since the repository
+// layout is fixed: "[REGION.]gcr.io/PROJECT/IMAGE" for project hosts and "LOCATION-docker.pkg.dev/PROJECT/REPOSITORY/IMAGE".
+// Such references are otherwise reported by the registries as permission errors.
+func validateGCPReference(ref name.Reference) error {
+	registry := ref.Context().RegistryStr()
+	if !isGCPRegistry(registry) {
+		return nil
+	}
+
+	segments := len(strings.Split(ref.Context().RepositoryStr(), "/"))
+
+	if strings.HasSuffix(registry, artifactRegistryDomainSuffix) {
+		if !strings.HasSuffix(registry, artifactRegistryDockerSuffix) || registry == artifactRegistryDockerSuffix[1:] {
+			return fmt.Errorf("%q is not a location-scoped Artifact Registry Docker host, expected LOCATION-docker.pkg.dev", registry)
+		}
+		if segments < 3 {
+			return fmt.Errorf("image %q of Artifact Registry must be in the PROJECT/REPOSITORY/IMAGE form", ref.Context().RepositoryStr())
+		}
+
+		return nil
+	}
+
+	if containerRegistryProjectHosts[registry] && segments < 2 {
+		return fmt.Errorf("image %q of Container Registry must be in the PROJECT/IMAGE form", ref.Context().RepositoryStr())
+	}
+
+	return nil
+}
+
+// isGCPAmbiguousDenial reports whether a Google registry denied access to a resource that may as well not exist.
+// Both Container Registry and Artifact Registry respond with 403 to hide the existence of repositories from
+// callers lacking permissions, so nodes pulling the image get the same error.
+func isGCPAmbiguousDenial(registry string, err error) bool {
+	if !isGCPRegistry(registry) {
+		return false
+	}
+
+	var transpErr *transport.Error
+	if !errors.As(err, &transpErr) || transpErr.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	return strings.Contains(transpErr.Error(), "may not exist")
+}
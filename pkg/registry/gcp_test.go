@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateGCPReference(t *testing.T) {
+	for image, valid := range map[string]bool{
+		"us-docker.pkg.dev/project/repo/app:v1":        true,
+		"europe-west1-docker.pkg.dev/project/repo/a/b": true,
+		"us-docker.pkg.dev/project/app:v1":             false,
+		"us-maven.pkg.dev/project/repo/app:v1":         false,
+		"gcr.io/project/app:v1":                        true,
+		"eu.gcr.io/project/app:v1":                     true,
+		"gcr.io/app:v1":                                false,
+		"asia.gcr.io/app:v1":                           false,
+		"k8s.gcr.io/pause:3.9":                         true,
+		"mirror.gcr.io/library/nginx:1.25":             true,
+		"registry.example.com/app:v1":                  true,
+	} {
+		ref, err := name.ParseReference(image)
+		require.NoError(t, err)
+		require.Equal(t, valid, validateGCPReference(ref) == nil, image)
+	}
+}
+
+func Test_isGCPAmbiguousDenial(t *testing.T) {
+	denied := fmt.Errorf("HEAD: %w", &transport.Error{
+		StatusCode: http.StatusForbidden,
+		Errors: []transport.Diagnostic{{
+			Code:    transport.DeniedErrorCode,
+			Message: `Permission "artifactregistry.repositories.downloadArtifacts" denied on resource "projects/p/locations/us/repositories/r" (or it may not exist)`,
+		}},
+	})
+
+	require.True(t, isGCPAmbiguousDenial("us-docker.pkg.dev", denied))
+	require.False(t, isGCPAmbiguousDenial("registry.example.com", denied))
+	require.False(t, isGCPAmbiguousDenial("gcr.io", &transport.Error{StatusCode: http.StatusNotFound}))
+}
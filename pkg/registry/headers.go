@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"net/http"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+// headerTransport sets static headers configured for the registry a request is made to.
+type headerTransport struct {
+	config *config.Config
+	next   http.RoundTripper
+}
+
+func newHeaderTransport(config *config.Config, next http.RoundTripper) http.RoundTripper {
+	for _, registry := range config.Registries {
+		if len(registry.Headers) > 0 {
+			return &headerTransport{config: config, next: next}
+		}
+	}
+
+	return next
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	registry, ok := t.config.Match(req.URL.Host)
+	if !ok || len(registry.Headers) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, value := range registry.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func Test_headerTransport(t *testing.T) {
+	var orgToken string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgToken = r.Header.Get("X-Org-Token")
+	}))
+	defer srv.Close()
+
+	host := srv.Listener.Addr().String()
+	client := &http.Client{Transport: newHeaderTransport(&config.Config{Registries: []config.Registry{
+		{Host: host, Headers: map[string]string{"X-Org-Token": "secret"}},
+	}}, http.DefaultTransport)}
+
+	resp, err := client.Get(srv.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "secret", orgToken)
+
+	// Other registries don't get the headers.
+	client.Transport = newHeaderTransport(&config.Config{Registries: []config.Registry{
+		{Host: "registry.example.com", Headers: map[string]string{"X-Org-Token": "secret"}},
+	}}, http.DefaultTransport)
+
+	resp, err = client.Get(srv.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, orgToken)
+}
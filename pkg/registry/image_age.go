@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var imageCreatedDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_image_created_timestamp_seconds",
+	"Creation time of the image from its config, as set by the build tool, in seconds since the epoch.",
+	[]string{"namespace", "kind", "name", "container", "image"}, nil,
+)
+
+// imageAges remembers creation times of images read from their configs during deep checks. Configs are immutable,
+// so creation times are cached by digest and only fetched once the image resolves to a new digest.
+type imageAges struct {
+	*digestTracker[time.Time]
+}
+
+func newImageAges() *imageAges {
+	return &imageAges{newDigestTracker[time.Time](nil, 0)}
+}
+
+// collect exports creation times of tracked images per container, and forgets untracked images and their digests.
+func (a *imageAges) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	a.rangeContainers(imageStore, func(labels []string, created time.Time) {
+		// Reproducible builds set the creation time to the epoch, it doesn't tell anything about the age.
+		if created.Unix() <= 0 {
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(imageCreatedDesc, prometheus.GaugeValue, float64(created.Unix()), labels...)
+	})
+}
+
+// imageCreated reads the creation time from the config of the image with the digest. Manifest lists are resolved for
+// the platform, or for linux/amd64 if it's nil.
+func imageCreated(ref name.Reference, digest string, platform *v1.Platform, options ...remote.Option) (time.Time, error) {
+	if platform != nil {
+		options = append(options, remote.WithPlatform(*platform))
+	}
+
+	img, err := remote.Image(ref.Context().Digest(digest), options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return configFile.Created.Time, nil
+}
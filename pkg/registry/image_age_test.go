@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_imageAges(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New())
+	defer srv.Close()
+
+	image := strings.TrimPrefix(srv.URL, "http://") + "/app:v1"
+	ref, err := name.ParseReference(image, name.Insecure)
+	require.NoError(t, err)
+
+	created := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	img, err := random.Image(128, 1)
+	require.NoError(t, err)
+	img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+
+	availMode, digest, err := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, store.Available, availMode)
+
+	fetches := 0
+	fetch := func() (time.Time, error) {
+		fetches++
+		return imageCreated(ref, digest, nil, remoteOptions(context.Background(), nil, authn.DefaultKeychain, http.DefaultTransport)...)
+	}
+
+	a := newImageAges()
+	require.NoError(t, a.record(image, digest, fetch))
+	// The creation time of a known digest isn't fetched again.
+	require.NoError(t, a.record(image, digest, fetch))
+	require.Equal(t, 1, fetches)
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	imageStore.ReconcileImage(image, []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}})
+
+	collect := func() []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		a.collect(imageStore, ch)
+		close(ch)
+
+		var ret []prometheus.Metric
+		for m := range ch {
+			ret = append(ret, m)
+		}
+		return ret
+	}
+
+	metrics := collect()
+	require.Len(t, metrics, 1)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	require.Equal(t, float64(created.Unix()), m.GetGauge().GetValue())
+	require.Len(t, m.GetLabel(), 5)
+
+	// Untracked images and their digests are forgotten.
+	imageStore = store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	require.Empty(t, collect())
+	require.Empty(t, a.digests)
+	require.Empty(t, a.values)
+}
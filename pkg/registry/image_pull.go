@@ -2,6 +2,7 @@ package registry
 
 import (
 	"errors"
+	"net"
 	"net/http"
 
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -44,3 +45,9 @@ func IsAuthzFail(err error) bool {
 func IsOldRegistry(err error) bool {
 	return errors.Is(err, remote.ErrSchema1)
 }
+
+// IsDNSFailure reports whether the host of the registry, or of its token service, couldn't be resolved.
+func IsDNSFailure(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
@@ -3,12 +3,16 @@ package registry
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"regexp"
 	"slices"
+	"sort"
 	"strings"
 
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
 	"github.com/flant/k8s-image-availability-exporter/pkg/store"
-	"github.com/google/go-containerregistry/pkg/authn"
 	kubeauth "github.com/google/go-containerregistry/pkg/authn/kubernetes"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
@@ -22,6 +26,12 @@ const (
 	labeledNSIndexName = "labeledNS"
 )
 
+// secretStore is the read-only part of cache.Store lookups of image pull secrets need.
+type secretStore interface {
+	GetByKey(key string) (item interface{}, exists bool, err error)
+	List() []interface{}
+}
+
 type ControllerIndexers struct {
 	namespaceIndexer                  cache.Indexer
 	serviceAccountIndexer             cache.Indexer
@@ -29,17 +39,39 @@ type ControllerIndexers struct {
 	statefulSetIndexer                cache.Indexer
 	daemonSetIndexer                  cache.Indexer
 	cronJobIndexer                    cache.Indexer
-	secretIndexer                     cache.Indexer
+	replicaSetIndexer                 cache.Indexer
+	jobIndexer                        cache.Indexer
+	podIndexer                        cache.Indexer
+	pdbIndexer                        cache.Indexer
+	secretStore                       secretStore
 	forceCheckDisabledControllerKinds []string
+
+	// podImages makes images of pods supersede the ones of templates of their controllers.
+	podImages bool
 }
 
 type controllerWithContainerInfos struct {
 	metav1.ObjectMeta
 	controllerKind       string
 	containerToImages    map[string]string
+	containerPullPolicy  map[string]corev1.PullPolicy
 	pullSecretReferences []corev1.LocalObjectReference
 	serviceAccountName   string
 	enabled              bool
+
+	// controllerName is the name of the top-level controller the images are attributed to. It differs from the
+	// object name for objects owned by another controller, e.g. ReplicaSets of Deployments.
+	controllerName string
+	owned          bool
+	// pod is set for pods tracked for their images, which are attributed to their top-level controllers.
+	pod bool
+
+	// priorityClassName and podLabels of the pod template are used to check images of critical workloads first.
+	priorityClassName string
+	podLabels         map[string]string
+
+	// nodeSelector of the pod template restricts DaemonSets to node pools.
+	nodeSelector map[string]string
 }
 
 var (
@@ -54,7 +86,13 @@ var (
 )
 
 func (ci ControllerIndexers) validCi(cis *controllerWithContainerInfos) bool {
-	if !cis.enabled && !slices.Contains(ci.forceCheckDisabledControllerKinds, strings.ToLower(cis.controllerKind)) {
+	// Owned objects are only relevant while they have running pods, otherwise the whole revision history would be
+	// checked for forcibly checked kinds.
+	if !cis.enabled && (cis.owned || !slices.Contains(ci.forceCheckDisabledControllerKinds, strings.ToLower(cis.controllerKind))) {
+		return false
+	}
+	// Images of templates may have been changed by admission webhooks, so the ones of their pods are checked instead.
+	if ci.podImages && !cis.pod && ci.hasPods(cis) {
 		return false
 	}
 
@@ -97,8 +135,11 @@ func getImagesFromDeployment(obj interface{}) (interface{}, error) {
 		ObjectMeta:           deploymentCopy.ObjectMeta,
 		controllerKind:       "Deployment",
 		containerToImages:    extractImagesFromContainers(deploymentCopy.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(deploymentCopy.Spec.Template.Spec.Containers),
 		pullSecretReferences: deploymentCopy.Spec.Template.Spec.ImagePullSecrets,
 		serviceAccountName:   deploymentCopy.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    deploymentCopy.Spec.Template.Spec.PriorityClassName,
+		podLabels:            deploymentCopy.Spec.Template.Labels,
 		enabled:              *deploymentCopy.Spec.Replicas > 0,
 	}, nil
 }
@@ -116,8 +157,11 @@ func getImagesFromStatefulSet(obj interface{}) (interface{}, error) {
 		ObjectMeta:           statefulSetCopy.ObjectMeta,
 		controllerKind:       "StatefulSet",
 		containerToImages:    extractImagesFromContainers(statefulSetCopy.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(statefulSetCopy.Spec.Template.Spec.Containers),
 		pullSecretReferences: statefulSetCopy.Spec.Template.Spec.ImagePullSecrets,
 		serviceAccountName:   statefulSetCopy.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    statefulSetCopy.Spec.Template.Spec.PriorityClassName,
+		podLabels:            statefulSetCopy.Spec.Template.Labels,
 		enabled:              *statefulSetCopy.Spec.Replicas > 0,
 	}, nil
 }
@@ -135,8 +179,12 @@ func getImagesFromDaemonSet(obj interface{}) (interface{}, error) {
 		ObjectMeta:           daemonSetCopy.ObjectMeta,
 		controllerKind:       "DaemonSet",
 		containerToImages:    extractImagesFromContainers(daemonSetCopy.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(daemonSetCopy.Spec.Template.Spec.Containers),
 		pullSecretReferences: daemonSetCopy.Spec.Template.Spec.ImagePullSecrets,
 		serviceAccountName:   daemonSetCopy.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    daemonSetCopy.Spec.Template.Spec.PriorityClassName,
+		podLabels:            daemonSetCopy.Spec.Template.Labels,
+		nodeSelector:         daemonSetCopy.Spec.Template.Spec.NodeSelector,
 		enabled:              daemonSetCopy.Status.CurrentNumberScheduled > 0,
 	}, nil
 }
@@ -154,12 +202,112 @@ func getImagesFromCronJob(obj interface{}) (interface{}, error) {
 		ObjectMeta:           cronJobCopy.ObjectMeta,
 		controllerKind:       "CronJob",
 		containerToImages:    extractImagesFromContainers(cronJobCopy.Spec.JobTemplate.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(cronJobCopy.Spec.JobTemplate.Spec.Template.Spec.Containers),
 		pullSecretReferences: cronJobCopy.Spec.JobTemplate.Spec.Template.Spec.ImagePullSecrets,
 		serviceAccountName:   cronJobCopy.Spec.JobTemplate.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    cronJobCopy.Spec.JobTemplate.Spec.Template.Spec.PriorityClassName,
+		podLabels:            cronJobCopy.Spec.JobTemplate.Spec.Template.Labels,
 		enabled:              !*cronJobCopy.Spec.Suspend,
 	}, nil
 }
 
+// getImagesFromReplicaSet attributes images of a ReplicaSet to the Deployment owning it, so that images of pods
+// that are still running during a rollout are reported. ReplicaSets without an owning Deployment are ignored.
+func getImagesFromReplicaSet(obj interface{}) (interface{}, error) {
+	if cis, ok := obj.(*controllerWithContainerInfos); ok {
+		return cis, nil
+	}
+
+	replicaSet := obj.(*appsv1.ReplicaSet)
+
+	replicaSetCopy := replicaSet.DeepCopy()
+
+	return &controllerWithContainerInfos{
+		ObjectMeta:           replicaSetCopy.ObjectMeta,
+		controllerKind:       "Deployment",
+		controllerName:       ownerName(replicaSetCopy.OwnerReferences, "Deployment"),
+		owned:                true,
+		containerToImages:    extractImagesFromContainers(replicaSetCopy.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(replicaSetCopy.Spec.Template.Spec.Containers),
+		pullSecretReferences: replicaSetCopy.Spec.Template.Spec.ImagePullSecrets,
+		serviceAccountName:   replicaSetCopy.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    replicaSetCopy.Spec.Template.Spec.PriorityClassName,
+		podLabels:            replicaSetCopy.Spec.Template.Labels,
+		enabled:              replicaSetCopy.Status.Replicas > 0 && len(ownerName(replicaSetCopy.OwnerReferences, "Deployment")) > 0,
+	}, nil
+}
+
+// getImagesFromJob attributes images of a running Job to the CronJob owning it, since the CronJob may have been
+// changed after the Job was created. Jobs without an owning CronJob are ignored.
+func getImagesFromJob(obj interface{}) (interface{}, error) {
+	if cis, ok := obj.(*controllerWithContainerInfos); ok {
+		return cis, nil
+	}
+
+	job := obj.(*batchv1.Job)
+
+	jobCopy := job.DeepCopy()
+
+	return &controllerWithContainerInfos{
+		ObjectMeta:           jobCopy.ObjectMeta,
+		controllerKind:       "CronJob",
+		controllerName:       ownerName(jobCopy.OwnerReferences, "CronJob"),
+		owned:                true,
+		containerToImages:    extractImagesFromContainers(jobCopy.Spec.Template.Spec.Containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(jobCopy.Spec.Template.Spec.Containers),
+		pullSecretReferences: jobCopy.Spec.Template.Spec.ImagePullSecrets,
+		serviceAccountName:   jobCopy.Spec.Template.Spec.ServiceAccountName,
+		priorityClassName:    jobCopy.Spec.Template.Spec.PriorityClassName,
+		podLabels:            jobCopy.Spec.Template.Labels,
+		enabled:              jobCopy.Status.Active > 0 && len(ownerName(jobCopy.OwnerReferences, "CronJob")) > 0,
+	}, nil
+}
+
+// getImagesFromPod tracks images of ephemeral containers of running pods, e.g. the ones added by "kubectl debug".
+// Regular containers of pods are checked via their controllers.
+func getImagesFromPod(obj interface{}) (interface{}, error) {
+	if cis, ok := obj.(*controllerWithContainerInfos); ok {
+		return cis, nil
+	}
+
+	pod := obj.(*corev1.Pod)
+
+	podCopy := pod.DeepCopy()
+
+	containers := make([]corev1.Container, 0, len(podCopy.Spec.EphemeralContainers))
+	for _, container := range podCopy.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container{
+			Name:            container.Name,
+			Image:           container.Image,
+			ImagePullPolicy: container.ImagePullPolicy,
+		})
+	}
+
+	return &controllerWithContainerInfos{
+		ObjectMeta:           podCopy.ObjectMeta,
+		controllerKind:       "Pod",
+		containerToImages:    extractImagesFromContainers(containers),
+		containerPullPolicy:  extractPullPoliciesFromContainers(containers),
+		pullSecretReferences: podCopy.Spec.ImagePullSecrets,
+		serviceAccountName:   podCopy.Spec.ServiceAccountName,
+		priorityClassName:    podCopy.Spec.PriorityClassName,
+		podLabels:            podCopy.Labels,
+		owned:                true,
+		enabled:              podCopy.Status.Phase == corev1.PodRunning && len(containers) > 0,
+	}, nil
+}
+
+// ownerName returns the name of the controlling owner of the kind, or an empty string.
+func ownerName(ownerReferences []metav1.OwnerReference, kind string) string {
+	for _, ref := range ownerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == kind {
+			return ref.Name
+		}
+	}
+
+	return ""
+}
+
 func extractImagesFromContainers(containers []corev1.Container) map[string]string {
 	ret := make(map[string]string)
 
@@ -170,6 +318,16 @@ func extractImagesFromContainers(containers []corev1.Container) map[string]strin
 	return ret
 }
 
+func extractPullPoliciesFromContainers(containers []corev1.Container) map[string]corev1.PullPolicy {
+	ret := make(map[string]corev1.PullPolicy)
+
+	for _, container := range containers {
+		ret[container.Name] = container.ImagePullPolicy
+	}
+
+	return ret
+}
+
 func extractPullSecretKeysFromServiceAccount(namespace string, sa *corev1.ServiceAccount) (ret []string) {
 	for _, ref := range sa.ImagePullSecrets {
 		ret = append(ret, namespace+"/"+ref.Name)
@@ -178,48 +336,105 @@ func extractPullSecretKeysFromServiceAccount(namespace string, sa *corev1.Servic
 	return
 }
 
+// imageHash hashes the part of a controller that reconciliation depends on, so that updates not changing it, such as
+// scaling or status updates, can be told apart.
+func (cis *controllerWithContainerInfos) imageHash() uint64 {
+	h := fnv.New64a()
+
+	containers := make([]string, 0, len(cis.containerToImages))
+	for container := range cis.containerToImages {
+		containers = append(containers, container)
+	}
+	sort.Strings(containers)
+
+	for _, container := range containers {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00", container, cis.containerToImages[container], cis.containerPullPolicy[container])
+	}
+	_, _ = fmt.Fprintf(h, "%s\x00%t\x00%t\x00", cis.controllerName, cis.owned, cis.enabled)
+
+	keys := make([]string, 0, len(cis.nodeSelector))
+	for key := range cis.nodeSelector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(h, "%s=%s\x00", key, cis.nodeSelector[key])
+	}
+
+	return h.Sum64()
+}
+
 func getCis(obj interface{}) *controllerWithContainerInfos {
 	cis := obj.(*controllerWithContainerInfos)
 
 	return cis
 }
 
-func (ci ControllerIndexers) ExtractPullSecretRefs(obj interface{}) (ret []string) {
-	cis := obj.(*controllerWithContainerInfos)
-	var pullSecretRefs []string
-	for _, saRef := range cis.pullSecretReferences {
-		pullSecretRefs = append(pullSecretRefs, fmt.Sprintf("%s/%s", cis.Namespace, saRef.Name))
-	}
-
-	// Image pull secret defined in Pod's `spec.ImagePullSecrets` takes preference over the secret from ServiceAccount.
-	// We are acting the same way as kubelet does:
-	// https://github.com/kubernetes/kubernetes/blob/88b31814f4a55c0af1c7d2712ce736a8fe08887e/plugin/pkg/admission/serviceaccount/admission.go#L163-L168.
-	if len(pullSecretRefs) == 0 {
-		var serviceAccountName string
-		if len(cis.serviceAccountName) > 0 {
-			serviceAccountName = cis.serviceAccountName
-		} else {
-			serviceAccountName = "default"
-		}
+type pullSecretRef struct {
+	key    string
+	source string
+}
 
-		saRaw, exists, err := ci.serviceAccountIndexer.GetByKey(fmt.Sprintf("%s/%s", cis.Namespace, serviceAccountName))
-		if err != nil {
-			logrus.Warn(err)
-			return
+// pullSecretRefs returns image pull secrets the kubelet pulls images of a controller with: secrets from the pod's
+// `spec.ImagePullSecrets` if it has any, secrets of the pod's ServiceAccount otherwise, which is the default
+// ServiceAccount of the namespace unless the pod sets another one. ServiceAccount secrets are only added to pods
+// without secrets of their own:
+// https://github.com/kubernetes/kubernetes/blob/88b31814f4a55c0af1c7d2712ce736a8fe08887e/plugin/pkg/admission/serviceaccount/admission.go#L163-L168
+func (ci ControllerIndexers) pullSecretRefs(cis *controllerWithContainerInfos) (ret []pullSecretRef) {
+	if len(cis.pullSecretReferences) > 0 {
+		for _, ref := range cis.pullSecretReferences {
+			ret = append(ret, pullSecretRef{key: fmt.Sprintf("%s/%s", cis.Namespace, ref.Name), source: credentialSourcePod})
 		}
+		return
+	}
 
-		if exists {
-			pullSecretRefs = append(pullSecretRefs, extractPullSecretKeysFromServiceAccount(cis.Namespace, saRaw.(*corev1.ServiceAccount))...)
-		}
+	if len(cis.serviceAccountName) > 0 && cis.serviceAccountName != "default" {
+		return ci.serviceAccountPullSecretRefs(cis.Namespace, cis.serviceAccountName, credentialSourceServiceAccount)
 	}
 
-	ret = append(ret, pullSecretRefs...)
+	return ci.serviceAccountPullSecretRefs(cis.Namespace, "default", credentialSourceDefaultServiceAccount)
+}
+
+func (ci ControllerIndexers) serviceAccountPullSecretRefs(namespace, name, source string) (ret []pullSecretRef) {
+	// Offline checkers don't watch service accounts.
+	if ci.serviceAccountIndexer == nil {
+		return
+	}
+
+	saRaw, exists, err := ci.serviceAccountIndexer.GetByKey(fmt.Sprintf("%s/%s", namespace, name))
+	if err != nil {
+		logrus.Warn(err)
+		return
+	}
+	if !exists {
+		return
+	}
+
+	for _, key := range extractPullSecretKeysFromServiceAccount(namespace, saRaw.(*corev1.ServiceAccount)) {
+		ret = append(ret, pullSecretRef{key: key, source: source})
+	}
 
 	return
 }
 
+func (ci ControllerIndexers) indexers() []cache.Indexer {
+	indexers := []cache.Indexer{ci.deploymentIndexer, ci.statefulSetIndexer, ci.daemonSetIndexer, ci.cronJobIndexer}
+	if ci.replicaSetIndexer != nil {
+		indexers = append(indexers, ci.replicaSetIndexer)
+	}
+	if ci.jobIndexer != nil {
+		indexers = append(indexers, ci.jobIndexer)
+	}
+	if ci.podIndexer != nil {
+		indexers = append(indexers, ci.podIndexer)
+	}
+
+	return indexers
+}
+
 func (ci ControllerIndexers) GetObjectsByImageIndex(image string) (ret []interface{}) {
-	for _, indexer := range []cache.Indexer{ci.deploymentIndexer, ci.statefulSetIndexer, ci.daemonSetIndexer, ci.cronJobIndexer} {
+	for _, indexer := range ci.indexers() {
 		objs, err := indexer.ByIndex(imageIndexName, image)
 		if err != nil {
 			panic(err)
@@ -240,55 +455,291 @@ func (ci ControllerIndexers) GetContainerInfosForImage(image string) (ret []stor
 			continue
 		}
 
+		controllerName := controllerWithInfos.controllerName
+		if len(controllerName) == 0 {
+			controllerName = controllerWithInfos.Name
+		}
+
 		for k, v := range controllerWithInfos.containerToImages {
 			if v != image {
 				continue
 			}
 
-			ret = append(ret, store.ContainerInfo{
+			containerInfo := store.ContainerInfo{
 				Namespace:      controllerWithInfos.Namespace,
 				ControllerKind: controllerWithInfos.controllerKind,
-				ControllerName: controllerWithInfos.Name,
+				ControllerName: controllerName,
 				Container:      k,
-			})
+				PullPolicy:     string(controllerWithInfos.containerPullPolicy[k]),
+			}
+			// A Deployment and its current ReplicaSet reference the same images.
+			if !slices.Contains(ret, containerInfo) {
+				ret = append(ret, containerInfo)
+			}
 		}
 	}
 
 	return
 }
 
-func (ci ControllerIndexers) GetKeychainForImage(image string) authn.Keychain {
-	objs := ci.GetObjectsByImageIndex(image)
+// pullSecretKeychains returns keychains of image pull secrets of all controllers referencing the image, a keychain
+// per credential source in the order of precedence. Every secret is used once, with its highest precedence source.
+// referenced reports whether any pull secrets are referenced, even if none of them exists.
+func (ci ControllerIndexers) pullSecretKeychains(image string) (keychains []sourcedKeychain, referenced bool) {
+	if ci.secretStore == nil {
+		return nil, false
+	}
 
-	var refSet = map[string]struct{}{}
-	for _, obj := range objs {
-		pullSecretRefs := ci.ExtractPullSecretRefs(obj)
-		for _, ref := range pullSecretRefs {
-			refSet[ref] = struct{}{}
-		}
+	var refs []pullSecretRef
+	for _, obj := range ci.GetObjectsByImageIndex(image) {
+		refs = append(refs, ci.pullSecretRefs(obj.(*controllerWithContainerInfos))...)
 	}
 
-	var dereferencedPullSecrets []corev1.Secret
-	for ref := range refSet {
-		secretObj, exists, err := ci.secretIndexer.GetByKey(ref)
+	return ci.secretKeychains(image, refs), len(refs) > 0
+}
+
+// secretKeychains builds a keychain of the referenced image pull secrets per credential source, in the order the
+// kubelet tries them. There are none if secrets aren't read.
+func (ci ControllerIndexers) secretKeychains(image string, refs []pullSecretRef) (ret []sourcedKeychain) {
+	if ci.secretStore == nil {
+		return nil
+	}
+
+	var (
+		seen    = make(map[string]struct{})
+		secrets = make(map[string][]corev1.Secret)
+	)
+
+	for _, ref := range refs {
+		if _, ok := seen[ref.key]; ok {
+			continue
+		}
+		seen[ref.key] = struct{}{}
+
+		secretObj, exists, err := ci.secretStore.GetByKey(ref.key)
 		if err != nil {
 			panic(err)
 		}
 		if !exists {
+			logrus.WithField("image_name", image).Debugf("Image pull secret %q doesn't exist", ref.key)
 			continue
 		}
-		secretPtr := secretObj.(*corev1.Secret)
-		dereferencedPullSecrets = append(dereferencedPullSecrets, *secretPtr)
+		secret := secretObj.(*corev1.Secret)
+		if err := pullSecretError(secret); err != nil {
+			logrus.WithField("image_name", image).Debugf("Skipping malformed image pull secret %q: %v", ref.key, err)
+			continue
+		}
+		secrets[ref.source] = append(secrets[ref.source], *secret)
 	}
 
-	if len(dereferencedPullSecrets) == 0 {
-		return nil
+	for _, source := range []string{credentialSourcePod, credentialSourceServiceAccount, credentialSourceDefaultServiceAccount} {
+		if len(secrets[source]) == 0 {
+			continue
+		}
+
+		kc, err := kubeauth.NewFromPullSecrets(context.TODO(), secrets[source])
+		if err != nil {
+			logrus.WithField("image_name", image).Errorf("Failed to build a keychain of %s image pull secrets: %v", source, err)
+			continue
+		}
+		ret = append(ret, sourcedKeychain{source: source, keychain: kc})
 	}
 
-	kc, err := kubeauth.NewFromPullSecrets(context.TODO(), dereferencedPullSecrets)
-	if err != nil {
-		logrus.Panic(err)
+	return
+}
+
+// collectMalformedPullSecrets reports existing image pull secrets referenced by any controller that can't be used
+// to pull images.
+func (ci ControllerIndexers) collectMalformedPullSecrets(ch chan<- prometheus.Metric) {
+	if ci.secretStore == nil {
+		return
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, indexer := range ci.indexers() {
+		for _, obj := range indexer.List() {
+			cis := obj.(*controllerWithContainerInfos)
+			if !ci.validCi(cis) {
+				continue
+			}
+
+			for _, ref := range ci.pullSecretRefs(cis) {
+				if _, ok := seen[ref.key]; ok {
+					continue
+				}
+				seen[ref.key] = struct{}{}
+
+				secretObj, exists, err := ci.secretStore.GetByKey(ref.key)
+				if err != nil {
+					panic(err)
+				}
+				if !exists {
+					continue
+				}
+
+				secret := secretObj.(*corev1.Secret)
+				if pullSecretError(secret) != nil {
+					ch <- prometheus.MustNewConstMetric(malformedPullSecretDesc, prometheus.GaugeValue, 1, secret.Namespace, secret.Name)
+				}
+			}
+		}
+	}
+}
+
+// collectPossiblyUnusedPullSecrets reports image pull secrets in watched namespaces that no watched controller or
+// ServiceAccount references. Controllers scaled to zero still count as references, since they may be scaled up again.
+// Pods only count if they are watched, so secrets of bare pods and of pods created by operators are reported unless
+// pod images are checked.
+func (ci ControllerIndexers) collectPossiblyUnusedPullSecrets(ch chan<- prometheus.Metric) {
+	if ci.secretStore == nil {
+		return
+	}
+
+	used := make(map[string]struct{})
+	for _, indexer := range ci.indexers() {
+		for _, obj := range indexer.List() {
+			cis := obj.(*controllerWithContainerInfos)
+			for _, ref := range cis.pullSecretReferences {
+				used[fmt.Sprintf("%s/%s", cis.Namespace, ref.Name)] = struct{}{}
+			}
+		}
+	}
+	for _, obj := range ci.serviceAccountIndexer.List() {
+		sa := obj.(*corev1.ServiceAccount)
+		for _, key := range extractPullSecretKeysFromServiceAccount(sa.Namespace, sa) {
+			used[key] = struct{}{}
+		}
+	}
+
+	for _, obj := range ci.secretStore.List() {
+		secret := obj.(*corev1.Secret)
+		if secret.Type != corev1.SecretTypeDockerConfigJson && secret.Type != corev1.SecretTypeDockercfg {
+			continue
+		}
+		if _, ok := used[fmt.Sprintf("%s/%s", secret.Namespace, secret.Name)]; ok {
+			continue
+		}
+		if nsList, _ := ci.namespaceIndexer.ByIndex(labeledNSIndexName, secret.Namespace); len(nsList) == 0 {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(possiblyUnusedPullSecretDesc, prometheus.GaugeValue, 1, secret.Namespace, secret.Name)
+	}
+}
+
+// imagesUsingPullSecret returns images of enabled controllers that use the image pull secret, either directly or via
+// a ServiceAccount.
+func (ci ControllerIndexers) imagesUsingPullSecret(key string) []string {
+	images := make(map[string]struct{})
+	for _, indexer := range ci.indexers() {
+		for _, obj := range indexer.List() {
+			cis := obj.(*controllerWithContainerInfos)
+			if !ci.validCi(cis) {
+				continue
+			}
+
+			if !slices.ContainsFunc(ci.pullSecretRefs(cis), func(ref pullSecretRef) bool { return ref.key == key }) {
+				continue
+			}
+
+			for _, image := range cis.containerToImages {
+				images[image] = struct{}{}
+			}
+		}
 	}
 
-	return kc
+	ret := make([]string, 0, len(images))
+	for image := range images {
+		ret = append(ret, image)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// ListImages returns images referenced by any controller.
+func (ci ControllerIndexers) ListImages() []string {
+	images := make(map[string]struct{})
+	for _, indexer := range ci.indexers() {
+		for _, image := range indexer.ListIndexFuncValues(imageIndexName) {
+			images[image] = struct{}{}
+		}
+	}
+
+	ret := make([]string, 0, len(images))
+	for image := range images {
+		ret = append(ret, image)
+	}
+
+	return ret
+}
+
+var invalidLabelCharsRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// workloadLabelName converts a Kubernetes label name to a metric label name the same way kube-state-metrics does,
+// e.g. "app.kubernetes.io/instance" becomes "label_app_kubernetes_io_instance".
+func workloadLabelName(label string) string {
+	return "label_" + invalidLabelCharsRegex.ReplaceAllString(label, "_")
+}
+
+func workloadLabelNames(labels []string, metricLabels []config.WorkloadMetricLabel) []string {
+	ret := make([]string, 0, len(labels)+len(metricLabels))
+	for _, label := range labels {
+		ret = append(ret, workloadLabelName(label))
+	}
+	for _, metricLabel := range metricLabels {
+		ret = append(ret, metricLabel.Name)
+	}
+
+	return ret
+}
+
+func (ci ControllerIndexers) indexerForKind(kind string) cache.Indexer {
+	switch kind {
+	case "Deployment":
+		return ci.deploymentIndexer
+	case "StatefulSet":
+		return ci.statefulSetIndexer
+	case "DaemonSet":
+		return ci.daemonSetIndexer
+	case "CronJob":
+		return ci.cronJobIndexer
+	case "Pod":
+		return ci.podIndexer
+	}
+
+	return nil
+}
+
+// WorkloadLabels returns a function that looks up values of the labels of the controller a container belongs to, and
+// values of metric labels mapped from its labels and annotations. Every label is returned, with an empty value if the
+// controller doesn't have it.
+func (ci ControllerIndexers) WorkloadLabels(labels []string, metricLabels []config.WorkloadMetricLabel) func(containerInfo store.ContainerInfo) map[string]string {
+	return func(containerInfo store.ContainerInfo) map[string]string {
+		ret := make(map[string]string, len(labels)+len(metricLabels))
+		for _, name := range workloadLabelNames(labels, metricLabels) {
+			ret[name] = ""
+		}
+
+		indexer := ci.indexerForKind(containerInfo.ControllerKind)
+		if indexer == nil {
+			return ret
+		}
+
+		obj, exists, err := indexer.GetByKey(containerInfo.Namespace + "/" + containerInfo.ControllerName)
+		if err != nil || !exists {
+			return ret
+		}
+
+		workload := obj.(*controllerWithContainerInfos)
+		for _, label := range labels {
+			ret[workloadLabelName(label)] = workload.Labels[label]
+		}
+		for _, metricLabel := range metricLabels {
+			ret[metricLabel.Name] = metricLabel.Value(workload.Labels, workload.Annotations)
+		}
+
+		return ret
+	}
 }
@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func newTestIndexer(t *testing.T, transform cache.TransformFunc, objs ...interface{}) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, imageIndexers)
+	for _, obj := range objs {
+		transformed, err := transform(obj)
+		require.NoError(t, err)
+		require.NoError(t, indexer.Add(transformed))
+	}
+
+	return indexer
+}
+
+func podTemplate(image string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}}}
+}
+
+func ownedBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestControllerIndexers_ownedObjects(t *testing.T) {
+	replicas := int32(1)
+	suspend := false
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+
+	ci := ControllerIndexers{
+		namespaceIndexer: namespaceIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate("app:v2")},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer: newTestIndexer(t, getImagesFromCronJob, &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup"},
+			Spec:       batchv1.CronJobSpec{Suspend: &suspend, JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate("cleanup:v2")}}},
+		}),
+		replicaSetIndexer: newTestIndexer(t, getImagesFromReplicaSet,
+			// The current ReplicaSet duplicates the Deployment.
+			&appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-2", OwnerReferences: ownedBy("Deployment", "web")},
+				Spec:       appsv1.ReplicaSetSpec{Template: podTemplate("app:v2")},
+				Status:     appsv1.ReplicaSetStatus{Replicas: 1},
+			},
+			// The previous ReplicaSet is still being scaled down.
+			&appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1", OwnerReferences: ownedBy("Deployment", "web")},
+				Spec:       appsv1.ReplicaSetSpec{Template: podTemplate("app:v1")},
+				Status:     appsv1.ReplicaSetStatus{Replicas: 1},
+			},
+			// Revision history.
+			&appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0", OwnerReferences: ownedBy("Deployment", "web")},
+				Spec:       appsv1.ReplicaSetSpec{Template: podTemplate("app:v0")},
+			},
+			// Not owned by a Deployment.
+			&appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "standalone"},
+				Spec:       appsv1.ReplicaSetSpec{Template: podTemplate("standalone:v1")},
+				Status:     appsv1.ReplicaSetStatus{Replicas: 1},
+			},
+		),
+		jobIndexer: newTestIndexer(t, getImagesFromJob, &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup-28000000", OwnerReferences: ownedBy("CronJob", "cleanup")},
+			Spec:       batchv1.JobSpec{Template: podTemplate("cleanup:v1")},
+			Status:     batchv1.JobStatus{Active: 1},
+		}),
+		forceCheckDisabledControllerKinds: []string{"deployment"},
+	}
+
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}}, ci.GetContainerInfosForImage("app:v2"))
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}}, ci.GetContainerInfosForImage("app:v1"))
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "CronJob", ControllerName: "cleanup", Container: "app"}}, ci.GetContainerInfosForImage("cleanup:v1"))
+	require.Empty(t, ci.GetContainerInfosForImage("app:v0"))
+	require.Empty(t, ci.GetContainerInfosForImage("standalone:v1"))
+
+	require.ElementsMatch(t, []string{"app:v0", "app:v1", "app:v2", "cleanup:v1", "cleanup:v2", "standalone:v1"}, ci.ListImages())
+}
+
+func TestControllerIndexers_ephemeralContainers(t *testing.T) {
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+
+	debugContainer := func(image string) []corev1.EphemeralContainer {
+		return []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: image, ImagePullPolicy: corev1.PullAlways}}}
+	}
+
+	ci := ControllerIndexers{
+		namespaceIndexer:   namespaceIndexer,
+		deploymentIndexer:  newTestIndexer(t, getImagesFromDeployment),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+		podIndexer: newTestIndexer(t, getImagesFromPod,
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-7d9f8-abcde"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:v1"}}, EphemeralContainers: debugContainer("busybox:1.36")},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			},
+			// Ephemeral containers of finished pods aren't running anymore.
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup-28000000-fghij"},
+				Spec:       corev1.PodSpec{EphemeralContainers: debugContainer("netshoot:v0.11")},
+				Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+			},
+		),
+		forceCheckDisabledControllerKinds: []string{"pod"},
+	}
+
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Pod", ControllerName: "web-7d9f8-abcde", Container: "debugger", PullPolicy: "Always"}}, ci.GetContainerInfosForImage("busybox:1.36"))
+	require.Empty(t, ci.GetContainerInfosForImage("netshoot:v0.11"))
+	// Regular containers are checked via controllers.
+	require.Empty(t, ci.GetContainerInfosForImage("app:v1"))
+}
+
+func TestControllerIndexers_WorkloadLabels(t *testing.T) {
+	replicas := int32(1)
+
+	ci := ControllerIndexers{
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: map[string]string{
+				"app.kubernetes.io/instance": "web-prod",
+				"helm.sh/chart":              "web-1.2.3",
+			}, Annotations: map[string]string{"example.com/owner": "bob"}},
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate("app:v2")},
+		}),
+	}
+
+	labels := ci.WorkloadLabels([]string{"app.kubernetes.io/instance", "team"}, []config.WorkloadMetricLabel{
+		{Name: "chart", Label: "helm.sh/chart", AllowedValues: []string{"web-1.2.3"}},
+		{Name: "owner", Annotation: "example.com/owner", AllowedValues: []string{"alice"}},
+		{Name: "team", Annotation: "example.com/team", AllowedValues: []string{"payments"}},
+	})
+
+	require.Equal(t, map[string]string{
+		"label_app_kubernetes_io_instance": "web-prod",
+		"label_team":                       "",
+		"chart":                            "web-1.2.3",
+		"owner":                            config.OtherValue,
+		"team":                             "",
+	}, labels(store.ContainerInfo{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}))
+
+	// Containers of unknown controllers get the same label names.
+	require.Equal(t, map[string]string{
+		"label_app_kubernetes_io_instance": "",
+		"label_team":                       "",
+		"chart":                            "",
+		"owner":                            "",
+		"team":                             "",
+	}, labels(store.ContainerInfo{Namespace: "default", ControllerKind: "Deployment", ControllerName: "missing"}))
+}
+
+func TestControllerIndexers_CriticalImages(t *testing.T) {
+	replicas := int32(1)
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}}))
+
+	withTemplate := func(name, image, priorityClassName string, labels map[string]string) *appsv1.Deployment {
+		template := podTemplate(image)
+		template.Labels = labels
+		template.Spec.PriorityClassName = priorityClassName
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: name},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+		}
+	}
+
+	pdbIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, pdbIndexer.Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "ingress"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ingress"}}},
+	}))
+
+	ci := ControllerIndexers{
+		namespaceIndexer: namespaceIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment,
+			withTemplate("coredns", "coredns:1.11", "system-cluster-critical", nil),
+			withTemplate("ingress", "ingress:1.9", "", map[string]string{"app": "ingress"}),
+			withTemplate("web", "web:v1", "", map[string]string{"app": "web"}),
+		),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+		pdbIndexer:         pdbIndexer,
+	}
+
+	isCritical := ci.CriticalImages([]string{"system-cluster-critical"})
+	require.True(t, isCritical("coredns:1.11"))
+	require.True(t, isCritical("ingress:1.9"))
+	require.False(t, isCritical("web:v1"))
+}
+
+func TestControllerWithContainerInfos_imageHash(t *testing.T) {
+	deployment := func(replicas int32, image string) *controllerWithContainerInfos {
+		cis, err := getImagesFromDeployment(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate(image)},
+			Status:     appsv1.DeploymentStatus{Replicas: replicas},
+		})
+		require.NoError(t, err)
+
+		return cis.(*controllerWithContainerInfos)
+	}
+
+	hash := deployment(2, "registry.example.com/app:v1").imageHash()
+	require.Equal(t, hash, deployment(5, "registry.example.com/app:v1").imageHash(), "scaling must not change the hash")
+	require.NotEqual(t, hash, deployment(0, "registry.example.com/app:v1").imageHash(), "scaling to zero disables the controller")
+	require.NotEqual(t, hash, deployment(2, "registry.example.com/app:v2").imageHash())
+}
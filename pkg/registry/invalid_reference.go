@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// variableRegex matches shell, envsubst and Kubernetes "$(VAR)" variables, none of which are expanded in image fields.
+var variableRegex = regexp.MustCompile(`\$(\{[A-Za-z_][A-Za-z0-9_]*[^}]*\}|\([A-Za-z_][A-Za-z0-9_]*\)|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// detectInvalidReference returns an error if the image reference contains artifacts of templating that wasn't
+// rendered, which is the most common cause of bad image names in GitOps pipelines.
+func detectInvalidReference(image string) error {
+	if strings.Contains(image, "{{") || strings.Contains(image, "}}") {
+		return errors.New("unexpanded template expression in the image reference")
+	}
+	// text/template, and hence Helm, renders missing values as "<no value>".
+	if strings.Contains(image, "<no value>") {
+		return errors.New(`missing template value rendered as "<no value>" in the image reference`)
+	}
+	if variable := variableRegex.FindString(image); len(variable) > 0 {
+		return fmt.Errorf("unexpanded variable %q in the image reference", variable)
+	}
+
+	repository, _, _ := strings.Cut(image, "@")
+	if strings.HasSuffix(repository, ":") {
+		return errors.New("empty tag in the image reference")
+	}
+
+	return nil
+}
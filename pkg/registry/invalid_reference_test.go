@@ -0,0 +1,32 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_detectInvalidReference(t *testing.T) {
+	for image, expectedErr := range map[string]string{
+		"registry.example.com/app:{{ .Values.image.tag }}": "unexpanded template expression in the image reference",
+		"registry.example.com/app:<no value>":              `missing template value rendered as "<no value>" in the image reference`,
+		"registry.example.com/app:$TAG":                    `unexpanded variable "$TAG" in the image reference`,
+		"${REGISTRY}/app:v1":                               `unexpanded variable "${REGISTRY}" in the image reference`,
+		"registry.example.com/app:${TAG:-latest}":          `unexpanded variable "${TAG:-latest}" in the image reference`,
+		"registry.example.com/app:$(TAG)":                  `unexpanded variable "$(TAG)" in the image reference`,
+		"registry.example.com/app:":                        "empty tag in the image reference",
+		"registry.example.com:5000/app:@sha256:0123":       "empty tag in the image reference",
+	} {
+		err := detectInvalidReference(image)
+		require.EqualError(t, err, expectedErr, image)
+	}
+
+	for _, image := range []string{
+		"app",
+		"registry.example.com:5000/app:v1",
+		"registry.example.com/app@sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		"registry.example.com/App:v1",
+	} {
+		require.NoError(t, detectInvalidReference(image), image)
+	}
+}
@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var manifestPathRegex = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+
+var (
+	manifestCacheHitsDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_manifest_cache_hits_total",
+		"Number of checks of digest-pinned images answered from the manifest cache without contacting the registry.",
+		nil, nil,
+	)
+	manifestNotModifiedDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_manifest_not_modified_total",
+		"Number of conditional manifest requests answered with 304 Not Modified.",
+		nil, nil,
+	)
+)
+
+// cachedManifest holds the headers of the last successful manifest HEAD response.
+type cachedManifest struct {
+	etag          string
+	header        http.Header
+	contentLength int64
+	storedAt      time.Time
+}
+
+// manifestCache remembers manifests recently verified to exist. Digests are immutable, so a digest-pinned image
+// verified within the TTL doesn't need to be checked again. Tags are checked with conditional requests.
+type manifestCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	lock      sync.Mutex
+	verified  map[string]time.Time
+	manifests map[string]cachedManifest
+
+	hits        atomic.Uint64
+	notModified atomic.Uint64
+}
+
+func newManifestCache(ttl time.Duration) *manifestCache {
+	return &manifestCache{
+		ttl:       ttl,
+		now:       time.Now,
+		verified:  make(map[string]time.Time),
+		manifests: make(map[string]cachedManifest),
+	}
+}
+
+// fresh reports whether a digest-pinned reference was verified within the TTL.
+func (c *manifestCache) fresh(ref name.Reference) bool {
+	digest, ok := ref.(name.Digest)
+	if !ok {
+		return false
+	}
+
+	key := digestKey(digest.Context().RegistryStr(), digest.Context().RepositoryStr(), digest.DigestStr())
+
+	c.lock.Lock()
+	verifiedAt, ok := c.verified[key]
+	c.lock.Unlock()
+
+	if !ok || c.now().Sub(verifiedAt) > c.ttl {
+		return false
+	}
+
+	c.hits.Add(1)
+	return true
+}
+
+func (c *manifestCache) markVerified(registry, repository, digest string) {
+	if len(digest) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	c.verified[digestKey(registry, repository, digest)] = now
+
+	// Forget expired digests, e.g. of tags that have moved on.
+	for key, verifiedAt := range c.verified {
+		if now.Sub(verifiedAt) > c.ttl {
+			delete(c.verified, key)
+		}
+	}
+}
+
+// storeManifest remembers the response to a manifest request to make the next request conditional.
+func (c *manifestCache) storeManifest(key string, manifest cachedManifest) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	manifest.storedAt = now
+	c.manifests[key] = manifest
+
+	// Forget manifests of references that weren't checked within the TTL, e.g. of tags no workload uses anymore.
+	for key, manifest := range c.manifests {
+		if now.Sub(manifest.storedAt) > c.ttl {
+			delete(c.manifests, key)
+		}
+	}
+}
+
+func (c *manifestCache) transport(next http.RoundTripper) http.RoundTripper {
+	return &manifestCacheTransport{cache: c, next: next}
+}
+
+func (c *manifestCache) collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(manifestCacheHitsDesc, prometheus.CounterValue, float64(c.hits.Load()))
+	ch <- prometheus.MustNewConstMetric(manifestNotModifiedDesc, prometheus.CounterValue, float64(c.notModified.Load()))
+}
+
+func digestKey(registry, repository, digest string) string {
+	return registry + "/" + repository + "@" + digest
+}
+
+// manifestCacheTransport makes manifest HEAD requests conditional on the ETag of the previous response and records
+// the digests of the manifests that exist.
+type manifestCacheTransport struct {
+	cache *manifestCache
+	next  http.RoundTripper
+}
+
+func (t *manifestCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	match := manifestPathRegex.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodHead || match == nil {
+		return t.next.RoundTrip(req)
+	}
+	repository, reference := match[1], match[2]
+	key := req.URL.String()
+
+	t.cache.lock.Lock()
+	cached, ok := t.cache.manifests[key]
+	t.cache.lock.Unlock()
+
+	if ok {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		t.cache.notModified.Add(1)
+		t.cache.storeManifest(key, cached)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = cached.header.Clone()
+		resp.ContentLength = cached.contentLength
+		resp.Body = http.NoBody
+	case resp.StatusCode == http.StatusOK:
+		etag := resp.Header.Get("ETag")
+		if len(etag) == 0 {
+			if digest := resp.Header.Get("Docker-Content-Digest"); len(digest) > 0 {
+				etag = `"` + digest + `"`
+			}
+		}
+
+		if len(etag) > 0 {
+			t.cache.storeManifest(key, cachedManifest{etag: etag, header: resp.Header.Clone(), contentLength: resp.ContentLength})
+		}
+	default:
+		if resp.StatusCode == http.StatusNotFound {
+			t.cache.lock.Lock()
+			delete(t.cache.manifests, key)
+			t.cache.lock.Unlock()
+		}
+
+		return resp, nil
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if len(digest) == 0 && strings.HasPrefix(reference, "sha256:") {
+		digest = reference
+	}
+	t.cache.markVerified(req.URL.Host, repository, digest)
+
+	return resp, nil
+}
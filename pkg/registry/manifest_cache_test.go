@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+func Test_manifestCache(t *testing.T) {
+	var conditional, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		require.Equal(t, "/v2/app/manifests/v1", r.URL.Path)
+
+		if r.Header.Get("If-None-Match") != "" {
+			conditional++
+			if r.Header.Get("If-None-Match") == `"`+testDigest+`"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("Docker-Content-Digest", testDigest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	now := time.Now()
+	c := newManifestCache(time.Hour)
+	c.now = func() time.Time { return now }
+	transport := c.transport(srv.Client().Transport)
+
+	digestRef, err := name.ParseReference(u.Host+"/app@"+testDigest, name.Insecure)
+	require.NoError(t, err)
+	require.False(t, c.fresh(digestRef))
+
+	tagRef, err := name.ParseReference(u.Host+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+
+	desc, err := remote.Head(tagRef, remote.WithTransport(transport))
+	require.NoError(t, err)
+	require.Equal(t, testDigest, desc.Digest.String())
+
+	// The tag resolved to the digest, so the digest-pinned image is known to exist.
+	require.True(t, c.fresh(digestRef))
+
+	desc, err = remote.Head(tagRef, remote.WithTransport(transport))
+	require.NoError(t, err)
+	require.Equal(t, testDigest, desc.Digest.String())
+	require.Equal(t, int64(100), desc.Size)
+	require.Equal(t, 1, conditional)
+	require.Equal(t, 1, notModified)
+
+	now = now.Add(2 * time.Hour)
+	require.False(t, c.fresh(digestRef))
+
+	// Manifests of references that weren't checked within the TTL are forgotten.
+	c.storeManifest("other", cachedManifest{etag: `"other"`})
+	require.Len(t, c.manifests, 1)
+	require.Contains(t, c.manifests, "other")
+}
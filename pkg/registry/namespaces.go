@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/kubeclient"
+)
+
+// namespacedResource is a resource the checker watches if namespaces are listed explicitly.
+type namespacedResource struct {
+	object   runtime.Object
+	client   rest.Interface
+	resource string
+}
+
+// watchedResources returns the resources the checker watches, except for secrets, which are watched by informers
+// of their own.
+func watchedResources(kubeClient kubernetes.Interface, trackOwnedObjects, watchPods, prioritizePDBWorkloads bool) []namespacedResource {
+	resources := []namespacedResource{
+		{object: &corev1.ServiceAccount{}, client: kubeClient.CoreV1().RESTClient(), resource: "serviceaccounts"},
+		{object: &appsv1.Deployment{}, client: kubeClient.AppsV1().RESTClient(), resource: "deployments"},
+		{object: &appsv1.StatefulSet{}, client: kubeClient.AppsV1().RESTClient(), resource: "statefulsets"},
+		{object: &appsv1.DaemonSet{}, client: kubeClient.AppsV1().RESTClient(), resource: "daemonsets"},
+		{object: &batchv1.CronJob{}, client: kubeClient.BatchV1().RESTClient(), resource: "cronjobs"},
+	}
+	if trackOwnedObjects {
+		resources = append(resources,
+			namespacedResource{object: &appsv1.ReplicaSet{}, client: kubeClient.AppsV1().RESTClient(), resource: "replicasets"},
+			namespacedResource{object: &batchv1.Job{}, client: kubeClient.BatchV1().RESTClient(), resource: "jobs"},
+		)
+	}
+	if watchPods {
+		resources = append(resources, namespacedResource{object: &corev1.Pod{}, client: kubeClient.CoreV1().RESTClient(), resource: "pods"})
+	}
+	if prioritizePDBWorkloads {
+		resources = append(resources, namespacedResource{object: &policyv1.PodDisruptionBudget{}, client: kubeClient.PolicyV1().RESTClient(), resource: "poddisruptionbudgets"})
+	}
+
+	return resources
+}
+
+// useNamespacedInformers registers informers of the resources with the factory that list and watch objects in each
+// of the namespaces separately instead of cluster-wide, so that the exporter only needs permissions in these
+// namespaces. It must be called before informers of the resources are requested from the factory.
+func useNamespacedInformers(factory informers.SharedInformerFactory, namespaces []string, resources []namespacedResource, tweakListOptions func(*metav1.ListOptions)) {
+	if tweakListOptions == nil {
+		tweakListOptions = func(*metav1.ListOptions) {}
+	}
+
+	for _, resource := range resources {
+		resource := resource
+		lw := kubeclient.NewMultiNamespaceListWatch(namespaces, func(namespace string) cache.ListerWatcher {
+			return cache.NewFilteredListWatchFromClient(resource.client, resource.resource, namespace, tweakListOptions)
+		})
+		factory.InformerFor(resource.object, func(_ kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+			return cache.NewSharedIndexInformer(lw, resource.object, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		})
+	}
+}
+
+// staticNamespaces returns an indexer of the namespaces to be used instead of the Namespace informer, which needs
+// cluster-wide permissions, if namespaces are listed explicitly.
+func staticNamespaces(namespaces []string) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	for _, namespace := range namespaces {
+		if err := indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}); err != nil {
+			panic(err)
+		}
+	}
+
+	return indexer
+}
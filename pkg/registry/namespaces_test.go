@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestUseNamespacedInformers(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(kubeClient, time.Hour)
+
+	resources := watchedResources(kubeClient, false, false, false)
+	require.Len(t, resources, 5)
+	useNamespacedInformers(factory, []string{"a", "b"}, resources, nil)
+
+	for _, resource := range resources {
+		factory.InformerFor(resource.object, func(kubernetes.Interface, time.Duration) cache.SharedIndexInformer {
+			t.Fatalf("the cluster-wide informer of %s is created", resource.resource)
+			return nil
+		})
+	}
+	require.NotNil(t, factory.Apps().V1().Deployments().Informer())
+
+	require.Len(t, watchedResources(kubeClient, true, true, true), 9)
+}
+
+func TestStaticNamespaces(t *testing.T) {
+	ci := ControllerIndexers{namespaceIndexer: staticNamespaces([]string{"a", "b"})}
+
+	for namespace, valid := range map[string]bool{"a": true, "b": true, "c": false} {
+		cis := &controllerWithContainerInfos{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}, controllerKind: "Deployment", enabled: true}
+		require.Equal(t, valid, ci.validCi(cis), namespace)
+	}
+}
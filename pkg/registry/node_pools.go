@@ -0,0 +1,60 @@
+package registry
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+// nodePool is a node pool of the config with its platform parsed.
+type nodePool struct {
+	config.NodePool
+	platform *v1.Platform
+}
+
+func newNodePools(pools []config.NodePool) ([]nodePool, error) {
+	ret := make([]nodePool, 0, len(pools))
+	for _, pool := range pools {
+		platform, err := ParseCheckPlatform(pool.Platform)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, nodePool{NodePool: pool, platform: platform})
+	}
+
+	return ret, nil
+}
+
+// nodePoolFor returns the node pool that all enabled DaemonSets referencing the image are restricted to. Images
+// referenced by other controllers, or by DaemonSets of different pools, are checked as usual.
+func (ci ControllerIndexers) nodePoolFor(image string, pools []nodePool) *nodePool {
+	if len(pools) == 0 {
+		return nil
+	}
+
+	var ret *nodePool
+	for _, obj := range ci.GetObjectsByImageIndex(image) {
+		cis := obj.(*controllerWithContainerInfos)
+		if !ci.validCi(cis) {
+			continue
+		}
+		if cis.controllerKind != "DaemonSet" {
+			return nil
+		}
+
+		var pool *nodePool
+		for i := range pools {
+			if pools[i].Selects(cis.nodeSelector) {
+				pool = &pools[i]
+				break
+			}
+		}
+		if pool == nil || (ret != nil && ret != pool) {
+			return nil
+		}
+		ret = pool
+	}
+
+	return ret
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func TestControllerIndexers_nodePoolFor(t *testing.T) {
+	replicas := int32(1)
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+
+	daemonSet := func(name, image string, nodeSelector map[string]string) *appsv1.DaemonSet {
+		template := podTemplate(image)
+		template.Spec.NodeSelector = nodeSelector
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       appsv1.DaemonSetSpec{Template: template},
+			Status:     appsv1.DaemonSetStatus{CurrentNumberScheduled: 1},
+		}
+	}
+	gpu := map[string]string{"pool": "gpu"}
+
+	ci := ControllerIndexers{
+		namespaceIndexer: namespaceIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate("registry.example.com/shared:v1")},
+		}),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer: newTestIndexer(t, getImagesFromDaemonSet,
+			daemonSet("dcgm-exporter", "registry.example.com/dcgm-exporter:3.3", gpu),
+			daemonSet("shared-gpu", "registry.example.com/shared:v1", gpu),
+			daemonSet("node-exporter", "registry.example.com/node-exporter:1.7", nil),
+			daemonSet("driver-gpu", "registry.example.com/driver:1.0", gpu),
+			daemonSet("driver-arm", "registry.example.com/driver:1.0", map[string]string{"pool": "arm"}),
+		),
+		cronJobIndexer: newTestIndexer(t, getImagesFromCronJob),
+	}
+
+	pools, err := newNodePools([]config.NodePool{
+		{Name: "gpu", NodeSelector: gpu, Mirrors: []config.Mirror{{From: "registry.example.com", To: "gpu-registry.example.com"}}, Platform: "linux/arm64"},
+	})
+	require.NoError(t, err)
+
+	pool := ci.nodePoolFor("registry.example.com/dcgm-exporter:3.3", pools)
+	require.NotNil(t, pool)
+	require.Equal(t, "gpu", pool.Name)
+	require.Equal(t, "arm64", pool.platform.Architecture)
+	require.Equal(t, "gpu-registry.example.com/dcgm-exporter:3.3", pool.Rewrite("registry.example.com/dcgm-exporter:3.3"))
+
+	// Images used outside of the pool are checked as usual.
+	require.Nil(t, ci.nodePoolFor("registry.example.com/shared:v1", pools))
+	require.Nil(t, ci.nodePoolFor("registry.example.com/node-exporter:1.7", pools))
+	require.Nil(t, ci.nodePoolFor("registry.example.com/driver:1.0", pools))
+	require.Nil(t, ci.nodePoolFor("registry.example.com/dcgm-exporter:3.3", nil))
+}
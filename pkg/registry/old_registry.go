@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// OldRegistryMode configures how images with legacy Docker schema 1 manifests, which the checks can't verify, are
+// reported.
+type OldRegistryMode string
+
+const (
+	// OldRegistryWarnAvailable reports the images as available and logs a warning.
+	OldRegistryWarnAvailable OldRegistryMode = "warn-available"
+	// OldRegistryUnknown reports the images as unknown errors.
+	OldRegistryUnknown OldRegistryMode = "unknown"
+	// OldRegistryFail reports the images as absent, since current container runtimes can't pull them.
+	OldRegistryFail OldRegistryMode = "fail"
+)
+
+var oldRegistryResponsesDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_old_registry_responses_total",
+	"Number of checks of images in the registry answered with a legacy Docker schema 1 manifest.",
+	[]string{"registry"}, nil,
+)
+
+// ParseOldRegistryMode parses the -old-registry-mode value.
+func ParseOldRegistryMode(s string) (OldRegistryMode, error) {
+	switch mode := OldRegistryMode(s); mode {
+	case OldRegistryWarnAvailable, OldRegistryUnknown, OldRegistryFail:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown old registry mode %q, must be %q, %q or %q", s, OldRegistryWarnAvailable, OldRegistryUnknown, OldRegistryFail)
+}
+
+func (m OldRegistryMode) availabilityMode() store.AvailabilityMode {
+	switch m {
+	case OldRegistryUnknown:
+		return store.UnknownError
+	case OldRegistryFail:
+		return store.Absent
+	}
+
+	return store.Available
+}
+
+// oldRegistryResponses counts responses with legacy manifests per registry.
+type oldRegistryResponses struct {
+	lock       sync.Mutex
+	registries map[string]uint64
+}
+
+func newOldRegistryResponses() *oldRegistryResponses {
+	return &oldRegistryResponses{registries: make(map[string]uint64)}
+}
+
+func (r *oldRegistryResponses) record(registry string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.registries[registry]++
+}
+
+func (r *oldRegistryResponses) collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for registry, count := range r.registries {
+		ch <- prometheus.MustNewConstMetric(oldRegistryResponsesDesc, prometheus.CounterValue, float64(count), registry)
+	}
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestParseOldRegistryMode(t *testing.T) {
+	for s, availMode := range map[string]store.AvailabilityMode{
+		"warn-available": store.Available,
+		"unknown":        store.UnknownError,
+		"fail":           store.Absent,
+	} {
+		mode, err := ParseOldRegistryMode(s)
+		require.NoError(t, err)
+		require.Equal(t, availMode, mode.availabilityMode())
+	}
+
+	_, err := ParseOldRegistryMode("available")
+	require.Error(t, err)
+}
+
+func Test_oldRegistryResponses(t *testing.T) {
+	r := newOldRegistryResponses()
+	r.record("registry.example.com")
+	r.record("registry.example.com")
+
+	ch := make(chan prometheus.Metric, 10)
+	r.collect(ch)
+	close(ch)
+
+	require.Len(t, ch, 1)
+	var m dto.Metric
+	require.NoError(t, (<-ch).Write(&m))
+	require.Equal(t, float64(2), m.GetCounter().GetValue())
+	require.Equal(t, "registry.example.com", m.GetLabel()[0].GetValue())
+}
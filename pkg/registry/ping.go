@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const pingTimeout = 10 * time.Second
+
+var (
+	registryUpDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_registry_up",
+		"Non-zero indicates that the registry responded to the /v2/ ping.",
+		[]string{"registry"}, nil,
+	)
+	registryPingDurationDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_registry_ping_duration_seconds",
+		"Duration of the last /v2/ ping of the registry.",
+		[]string{"registry"}, nil,
+	)
+)
+
+type pingResult struct {
+	up       bool
+	duration time.Duration
+	// err tells why the registry isn't up.
+	err error
+}
+
+// registryPinger periodically pings known registry endpoints regardless of the images discovered in the cluster.
+type registryPinger struct {
+	endpoints []*url.URL
+	client    *http.Client
+
+	lock    sync.RWMutex
+	results map[string]pingResult
+}
+
+func newRegistryPinger(endpoints []string, transport http.RoundTripper) (*registryPinger, error) {
+	p := &registryPinger{
+		client:  &http.Client{Transport: transport},
+		results: make(map[string]pingResult),
+	}
+
+	for _, endpoint := range endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = "/v2/"
+
+		p.endpoints = append(p.endpoints, u)
+	}
+
+	return p, nil
+}
+
+func (p *registryPinger) Run(interval time.Duration, stopCh <-chan struct{}) {
+	for _, endpoint := range p.endpoints {
+		go wait.Until(func(endpoint *url.URL) func() {
+			return func() {
+				result := p.ping(endpoint)
+
+				p.lock.Lock()
+				p.results[endpoint.Host] = result
+				p.lock.Unlock()
+			}
+		}(endpoint), interval, stopCh)
+	}
+}
+
+// ping considers a registry up if it responds with 200 or asks for authentication with 401, like the Docker
+// Registry HTTP API V2 specifies.
+func (p *registryPinger) ping(endpoint *url.URL) pingResult {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	log := logrus.WithField("registry", endpoint.Host)
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		log.Errorf("Failed to ping registry: %v", err)
+		return pingResult{err: err}
+	}
+
+	resp, err := p.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		log.Warnf("Failed to ping registry: %v", err)
+		return pingResult{duration: duration, err: err}
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		log.Warnf("Registry ping returned unexpected status %d", resp.StatusCode)
+		return pingResult{duration: duration, err: fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+
+	return pingResult{up: true, duration: duration}
+}
+
+func (p *registryPinger) collect(ch chan<- prometheus.Metric) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for registry, result := range p.results {
+		var up float64
+		if result.up {
+			up = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(registryUpDesc, prometheus.GaugeValue, up, registry)
+		ch <- prometheus.MustNewConstMetric(registryPingDurationDesc, prometheus.GaugeValue, result.duration.Seconds(), registry)
+	}
+}
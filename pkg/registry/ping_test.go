@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_registryPinger(t *testing.T) {
+	status := http.StatusUnauthorized
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v2/", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p, err := newRegistryPinger([]string{srv.URL, "registry.example.com"}, srv.Client().Transport)
+	require.NoError(t, err)
+	require.Equal(t, "https://registry.example.com/v2/", p.endpoints[1].String())
+
+	require.True(t, p.ping(p.endpoints[0]).up)
+
+	status = http.StatusInternalServerError
+	require.False(t, p.ping(p.endpoints[0]).up)
+}
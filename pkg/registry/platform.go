@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CheckPlatformAuto resolves manifest lists for the platform the exporter runs on.
+const CheckPlatformAuto = "auto"
+
+var platformInfoDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_platform_info",
+	"Platform the exporter runs on, and the platform manifest lists are resolved for, if any.",
+	[]string{"os", "architecture", "check_platform"}, nil,
+)
+
+var errPlatformNotFound = errors.New("no manifest for the platform in the manifest list")
+
+// ParseCheckPlatform parses a platform like "linux/arm64/v8" to resolve manifest lists for, or CheckPlatformAuto. It
+// returns nil for an empty string, in which case manifest lists aren't resolved.
+func ParseCheckPlatform(s string) (*v1.Platform, error) {
+	switch s {
+	case "":
+		return nil, nil
+	case CheckPlatformAuto:
+		return &v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}, nil
+	}
+
+	platform, err := v1.ParsePlatform(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(platform.OS) == 0 || len(platform.Architecture) == 0 {
+		return nil, fmt.Errorf("platform %q must have both OS and architecture", s)
+	}
+
+	return platform, nil
+}
+
+// checkPlatformManifest checks that a manifest list references a manifest for the platform and that the manifest
+// exists. A HEAD request of a manifest list succeeds even if its child manifests were deleted.
+func checkPlatformManifest(ref name.Reference, platform *v1.Platform, options ...remote.Option) error {
+	index, err := remote.Index(ref, options...)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range manifest.Manifests {
+		if child.Platform == nil || !child.Platform.Satisfies(*platform) {
+			continue
+		}
+
+		_, err := remote.Head(ref.Context().Digest(child.Digest.String()), options...)
+
+		return err
+	}
+
+	return fmt.Errorf("%w %s", errPlatformNotFound, platform)
+}
+
+func collectPlatformInfo(platform *v1.Platform, ch chan<- prometheus.Metric) {
+	var checkPlatform string
+	if platform != nil {
+		checkPlatform = platform.String()
+	}
+
+	ch <- prometheus.MustNewConstMetric(platformInfoDesc, prometheus.GaugeValue, 1, runtime.GOOS, runtime.GOARCH, checkPlatform)
+}
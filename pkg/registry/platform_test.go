@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestParseCheckPlatform(t *testing.T) {
+	platform, err := ParseCheckPlatform("")
+	require.NoError(t, err)
+	require.Nil(t, platform)
+
+	platform, err = ParseCheckPlatform("linux/arm64/v8")
+	require.NoError(t, err)
+	require.Equal(t, v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, *platform)
+
+	platform, err = ParseCheckPlatform(CheckPlatformAuto)
+	require.NoError(t, err)
+	require.NotEmpty(t, platform.Architecture)
+
+	_, err = ParseCheckPlatform("linux")
+	require.Error(t, err)
+}
+
+func TestCheck_platform(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New())
+	defer srv.Close()
+
+	amd64, err := random.Image(128, 1)
+	require.NoError(t, err)
+	arm64, err := random.Image(128, 1)
+	require.NoError(t, err)
+
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "http://")+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, index))
+
+	// Delete the arm64 manifest, the manifest list still references it.
+	arm64Digest, err := arm64.Digest()
+	require.NoError(t, err)
+	require.NoError(t, remote.Delete(ref.Context().Digest(arm64Digest.String())))
+
+	for _, tt := range []struct {
+		platform *v1.Platform
+		want     store.AvailabilityMode
+	}{
+		{platform: nil, want: store.Available},
+		{platform: &v1.Platform{OS: "linux", Architecture: "amd64"}, want: store.Available},
+		{platform: &v1.Platform{OS: "linux", Architecture: "arm64"}, want: store.Absent},
+		{platform: &v1.Platform{OS: "linux", Architecture: "s390x"}, want: store.Absent},
+	} {
+		availMode, _, _ := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, tt.platform, false)
+		require.Equal(t, tt.want, availMode, "platform %v", tt.platform)
+	}
+}
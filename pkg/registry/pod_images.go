@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const podControllerIndexName = "podController"
+
+// cronJobJobNameRegex matches names of Jobs created by CronJobs, which are suffixed with the scheduled time in
+// minutes.
+var cronJobJobNameRegex = regexp.MustCompile(`^(.+)-\d{8,}$`)
+
+// podControllerIndexers index pods that may pull images by the top-level controller they are attributed to.
+var podControllerIndexers = cache.Indexers{
+	podControllerIndexName: func(obj interface{}) ([]string, error) {
+		cis := obj.(*controllerWithContainerInfos)
+		if !cis.pod || !cis.enabled {
+			return nil, nil
+		}
+
+		return []string{controllerKey(cis.Namespace, cis.controllerKind, cis.controllerName)}, nil
+	},
+}
+
+func controllerKey(namespace, kind, name string) string {
+	return namespace + "/" + kind + "/" + name
+}
+
+// getImagesFromPods returns a transform tracking images of containers of pending and running pods, attributed to the
+// top-level controllers of the pods. Images of pods are the ones the kubelet actually pulls, after admission
+// webhooks, e.g. Kyverno mutation policies or Istio sidecar injection, have changed the pod template of the
+// controller. Ephemeral containers are tracked as well if checkEphemeralContainers is set.
+func getImagesFromPods(checkEphemeralContainers bool) cache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		if cis, ok := obj.(*controllerWithContainerInfos); ok {
+			return cis, nil
+		}
+
+		pod := obj.(*corev1.Pod)
+
+		podCopy := pod.DeepCopy()
+
+		containers := podCopy.Spec.Containers
+		if checkEphemeralContainers {
+			for _, container := range podCopy.Spec.EphemeralContainers {
+				containers = append(containers, corev1.Container{
+					Name:            container.Name,
+					Image:           container.Image,
+					ImagePullPolicy: container.ImagePullPolicy,
+				})
+			}
+		}
+
+		controllerKind, controllerName := podController(podCopy)
+
+		return &controllerWithContainerInfos{
+			ObjectMeta:           podCopy.ObjectMeta,
+			controllerKind:       controllerKind,
+			controllerName:       controllerName,
+			pod:                  true,
+			owned:                true,
+			containerToImages:    extractImagesFromContainers(containers),
+			containerPullPolicy:  extractPullPoliciesFromContainers(containers),
+			pullSecretReferences: podCopy.Spec.ImagePullSecrets,
+			serviceAccountName:   podCopy.Spec.ServiceAccountName,
+			priorityClassName:    podCopy.Spec.PriorityClassName,
+			podLabels:            podCopy.Labels,
+			nodeSelector:         podCopy.Spec.NodeSelector,
+			// Canary pods pull images on purpose and don't belong to any workload.
+			enabled: (podCopy.Status.Phase == corev1.PodPending || podCopy.Status.Phase == corev1.PodRunning) && len(podCopy.Labels[canaryLabel]) == 0,
+		}, nil
+	}
+}
+
+// podController returns the top-level controller of the pod. The pod template hash of a ReplicaSet of a Deployment
+// and the scheduled time of a Job of a CronJob are cut off their names, so that pods are attributed to the same
+// controllers as templates are. Pods without a controller are attributed to themselves.
+func podController(pod *corev1.Pod) (kind, name string) {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+
+		switch ref.Kind {
+		case "ReplicaSet":
+			if hash := pod.Labels["pod-template-hash"]; len(hash) > 0 && strings.HasSuffix(ref.Name, "-"+hash) {
+				return "Deployment", strings.TrimSuffix(ref.Name, "-"+hash)
+			}
+		case "Job":
+			if match := cronJobJobNameRegex.FindStringSubmatch(ref.Name); match != nil {
+				return "CronJob", match[1]
+			}
+		}
+
+		return ref.Kind, ref.Name
+	}
+
+	return "Pod", pod.Name
+}
+
+// hasPods reports whether the controller has pending or running pods, whose images supersede the ones of its
+// template.
+func (ci ControllerIndexers) hasPods(cis *controllerWithContainerInfos) bool {
+	controllerName := cis.controllerName
+	if len(controllerName) == 0 {
+		controllerName = cis.Name
+	}
+
+	pods, err := ci.podIndexer.ByIndex(podControllerIndexName, controllerKey(cis.Namespace, cis.controllerKind, controllerName))
+	if err != nil {
+		panic(err)
+	}
+
+	return len(pods) > 0
+}
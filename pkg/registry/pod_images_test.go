@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestControllerIndexers_podImages(t *testing.T) {
+	replicas := int32(1)
+	suspend := false
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		imageIndexName:         imageIndexers[imageIndexName],
+		podControllerIndexName: podControllerIndexers[podControllerIndexName],
+	})
+	for _, pod := range []*corev1.Pod{
+		// A webhook rewrote the registry of the image.
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				Name:            "web-7d9f8-abcde",
+				Labels:          map[string]string{"pod-template-hash": "7d9f8"},
+				OwnerReferences: ownedBy("ReplicaSet", "web-7d9f8"),
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "mirror.example.com/app:v1"}, {Name: "istio-proxy", Image: "istio/proxyv2:1.20"}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup-28000000-fghij", OwnerReferences: ownedBy("Job", "cleanup-28000000")},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "mirror.example.com/cleanup:v1"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "standalone"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "standalone:v1"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		// Finished pods don't pull images anymore.
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "idle-5c4b3-klmno", Labels: map[string]string{"pod-template-hash": "5c4b3"}, OwnerReferences: ownedBy("ReplicaSet", "idle-5c4b3")},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "mirror.example.com/idle:v1"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "canary-pqrst", Labels: map[string]string{canaryLabel: "true"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "canary", Image: "app:v1"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+	} {
+		cis, err := getImagesFromPods(false)(pod)
+		require.NoError(t, err)
+		require.NoError(t, podIndexer.Add(cis))
+	}
+
+	ci := ControllerIndexers{
+		namespaceIndexer: namespaceIndexer,
+		deploymentIndexer: newTestIndexer(t, getImagesFromDeployment,
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate("app:v1")},
+			},
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "idle"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate("idle:v1")},
+			},
+		),
+		statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+		daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+		cronJobIndexer: newTestIndexer(t, getImagesFromCronJob, &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup"},
+			Spec:       batchv1.CronJobSpec{Suspend: &suspend, JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate("cleanup:v1")}}},
+		}),
+		podIndexer: podIndexer,
+		podImages:  true,
+	}
+
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "app"}}, ci.GetContainerInfosForImage("mirror.example.com/app:v1"))
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "web", Container: "istio-proxy"}}, ci.GetContainerInfosForImage("istio/proxyv2:1.20"))
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "CronJob", ControllerName: "cleanup", Container: "app"}}, ci.GetContainerInfosForImage("mirror.example.com/cleanup:v1"))
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Pod", ControllerName: "standalone", Container: "app"}}, ci.GetContainerInfosForImage("standalone:v1"))
+	// Templates of controllers with pods are superseded by the pods, and canary pods are ignored.
+	require.Empty(t, ci.GetContainerInfosForImage("app:v1"))
+	require.Empty(t, ci.GetContainerInfosForImage("cleanup:v1"))
+	// Templates of controllers without pods are still checked.
+	require.Equal(t, []store.ContainerInfo{{Namespace: "default", ControllerKind: "Deployment", ControllerName: "idle", Container: "app"}}, ci.GetContainerInfosForImage("idle:v1"))
+	require.Empty(t, ci.GetContainerInfosForImage("mirror.example.com/idle:v1"))
+}
+
+func TestPodController(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		pod   *corev1.Pod
+		kind  string
+		owner string
+	}{
+		{
+			name: "deployment",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-7d9f8-abcde", Labels: map[string]string{"pod-template-hash": "7d9f8"}, OwnerReferences: ownedBy("ReplicaSet", "web-7d9f8")}},
+			kind: "Deployment", owner: "web",
+		},
+		{
+			name: "standalone replicaset",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-abcde", OwnerReferences: ownedBy("ReplicaSet", "web")}},
+			kind: "ReplicaSet", owner: "web",
+		},
+		{
+			name: "cronjob",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cleanup-28000000-fghij", OwnerReferences: ownedBy("Job", "cleanup-28000000")}},
+			kind: "CronJob", owner: "cleanup",
+		},
+		{
+			name: "job",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "migrate-v2-fghij", OwnerReferences: ownedBy("Job", "migrate-v2")}},
+			kind: "Job", owner: "migrate-v2",
+		},
+		{
+			name: "statefulset",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", OwnerReferences: ownedBy("StatefulSet", "db")}},
+			kind: "StatefulSet", owner: "db",
+		},
+		{
+			name: "bare pod",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "debug"}},
+			kind: "Pod", owner: "debug",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, name := podController(tc.pod)
+			require.Equal(t, tc.kind, kind)
+			require.Equal(t, tc.owner, name)
+		})
+	}
+}
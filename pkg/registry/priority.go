@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"slices"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// CriticalImages returns a function that reports whether an image is used by a critical workload, i.e. one with
+// pods of the priority classes or, if PodDisruptionBudgets are watched, pods covered by a PodDisruptionBudget.
+func (ci ControllerIndexers) CriticalImages(priorityClasses []string) func(image string) bool {
+	return func(image string) bool {
+		for _, obj := range ci.GetObjectsByImageIndex(image) {
+			cis := obj.(*controllerWithContainerInfos)
+			if !ci.validCi(cis) {
+				continue
+			}
+
+			if slices.Contains(priorityClasses, cis.priorityClassName) || ci.coveredByPDB(cis) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func (ci ControllerIndexers) coveredByPDB(cis *controllerWithContainerInfos) bool {
+	if ci.pdbIndexer == nil {
+		return false
+	}
+
+	pdbs, err := ci.pdbIndexer.ByIndex(cache.NamespaceIndex, cis.Namespace)
+	if err != nil {
+		return false
+	}
+
+	for _, obj := range pdbs {
+		pdb, ok := obj.(*policyv1.PodDisruptionBudget)
+		if !ok {
+			continue
+		}
+
+		// A nil selector selects no pods, an empty one selects all pods in the namespace.
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(cis.podLabels)) {
+			return true
+		}
+	}
+
+	return false
+}
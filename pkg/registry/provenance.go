@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var imageProvenanceDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_image_has_provenance",
+	"Whether a provenance attestation is attached to the image, found through the referrers API, the cosign attestation tag or BuildKit attestation manifests.",
+	[]string{"namespace", "kind", "name", "container", "image"}, nil,
+)
+
+// provenanceArtifactTypes are artifact types of referrers holding attestations, SLSA provenance is attached as
+// an in-toto statement, optionally wrapped into a DSSE envelope.
+var provenanceArtifactTypes = map[string]struct{}{
+	"application/vnd.in-toto+json":          {},
+	"application/vnd.dsse.envelope.v1+json": {},
+}
+
+// provenances remembers whether images have provenance attestations during deep checks. Attestations can be attached
+// to an image after it's pushed, so images without them are looked up again once missingLookupTTL passes.
+type provenances struct {
+	*digestTracker[bool]
+}
+
+func newProvenances() *provenances {
+	return &provenances{newDigestTracker[bool](func(found bool) bool { return !found }, missingLookupTTL)}
+}
+
+// collect exports whether tracked images have provenance per container, and forgets untracked images and their digests.
+func (p *provenances) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	p.rangeContainers(imageStore, func(labels []string, found bool) {
+		value := 0.0
+		if found {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(imageProvenanceDesc, prometheus.GaugeValue, value, labels...)
+	})
+}
+
+// hasProvenance looks up attestations of the image with the digest. Referrers are listed first, then the tag cosign
+// stores attestations under is checked, and finally the index of the image for attestation manifests added by
+// BuildKit. registryTransport must be the transport set in options.
+func hasProvenance(ref name.Reference, digest string, referrers *referrersSupport, registryTransport http.RoundTripper, options ...remote.Option) (bool, error) {
+	d := ref.Context().Digest(digest)
+
+	descs, err := referrers.list(d, registryTransport, options...)
+	if err != nil {
+		return false, err
+	}
+	for _, desc := range descs {
+		if _, ok := provenanceArtifactTypes[desc.ArtifactType]; ok {
+			return true, nil
+		}
+	}
+
+	_, err = remote.Head(ref.Context().Tag(strings.Replace(digest, ":", "-", 1)+".att"), options...)
+	if err == nil {
+		return true, nil
+	}
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) || transportErr.StatusCode != http.StatusNotFound {
+		return false, err
+	}
+
+	attestations, err := attestationManifests(d, options...)
+	if err != nil {
+		return false, err
+	}
+
+	return len(attestations) > 0, nil
+}
+
+// attestationManifests returns digests of attestation manifests BuildKit adds to the index of the image with
+// the digest, none if the image isn't an index.
+func attestationManifests(d name.Digest, options ...remote.Option) ([]v1.Hash, error) {
+	desc, err := remote.Get(d, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, nil
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []v1.Hash
+	for _, m := range indexManifest.Manifests {
+		if m.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+			ret = append(ret, m.Digest)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_hasProvenance(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New(ggcrregistry.WithReferrersSupport(true)))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	push := func(t *testing.T, repository string) (name.Reference, v1.Hash) {
+		t.Helper()
+
+		ref, err := name.ParseReference(host+"/"+repository+":v1", name.Insecure)
+		require.NoError(t, err)
+		img, err := random.Image(128, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		return ref, digest
+	}
+	lookup := func(t *testing.T, ref name.Reference, digest string) bool {
+		t.Helper()
+
+		found, err := hasProvenance(ref, digest, newReferrersSupport(), http.DefaultTransport, remoteOptions(context.Background(), nil, authn.DefaultKeychain, http.DefaultTransport)...)
+		require.NoError(t, err)
+		return found
+	}
+
+	t.Run("none", func(t *testing.T) {
+		ref, digest := push(t, "none")
+		require.False(t, lookup(t, ref, digest.String()))
+	})
+
+	t.Run("referrers", func(t *testing.T) {
+		ref, digest := push(t, "referrers")
+
+		desc, err := remote.Head(ref.Context().Digest(digest.String()))
+		require.NoError(t, err)
+		attestation := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), "application/vnd.in-toto+json")
+		attestation = mutate.Subject(attestation, *desc).(v1.Image)
+		attestationDigest, err := attestation.Digest()
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref.Context().Digest(attestationDigest.String()), attestation))
+
+		require.True(t, lookup(t, ref, digest.String()))
+	})
+
+	t.Run("cosign", func(t *testing.T) {
+		ref, digest := push(t, "cosign")
+
+		attestation, err := random.Image(64, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref.Context().Tag(strings.Replace(digest.String(), ":", "-", 1)+".att"), attestation))
+
+		require.True(t, lookup(t, ref, digest.String()))
+	})
+
+	t.Run("buildkit", func(t *testing.T) {
+		ref, err := name.ParseReference(host+"/buildkit:v1", name.Insecure)
+		require.NoError(t, err)
+
+		img, err := random.Image(128, 1)
+		require.NoError(t, err)
+		attestation, err := random.Image(64, 1)
+		require.NoError(t, err)
+		index := mutate.AppendManifests(empty.Index,
+			mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+			mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+				Platform:    &v1.Platform{OS: "unknown", Architecture: "unknown"},
+				Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"},
+			}},
+		)
+		require.NoError(t, remote.WriteIndex(ref, index))
+		digest, err := index.Digest()
+		require.NoError(t, err)
+
+		require.True(t, lookup(t, ref, digest.String()))
+	})
+}
+
+func Test_provenances(t *testing.T) {
+	fetches := 0
+	found := false
+	fetch := func() (bool, error) {
+		fetches++
+		return found, nil
+	}
+
+	now := time.Now()
+	p := newProvenances()
+	p.now = func() time.Time { return now }
+	require.NoError(t, p.record("app:v1", "sha256:1", fetch))
+	// Missing attestations are cached for a while.
+	found = true
+	require.NoError(t, p.record("app:v1", "sha256:1", fetch))
+	require.Equal(t, 1, fetches)
+	// Then images without provenance are looked up again, attestations may be attached later.
+	now = now.Add(missingLookupTTL)
+	require.NoError(t, p.record("app:v1", "sha256:1", fetch))
+	// Found attestations are cached for good.
+	now = now.Add(missingLookupTTL)
+	require.NoError(t, p.record("app:v1", "sha256:1", fetch))
+	require.Equal(t, 2, fetches)
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	imageStore.ReconcileImage("app:v1", []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}})
+
+	collect := func() []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		p.collect(imageStore, ch)
+		close(ch)
+
+		var ret []prometheus.Metric
+		for m := range ch {
+			ret = append(ret, m)
+		}
+		return ret
+	}
+
+	metrics := collect()
+	require.Len(t, metrics, 1)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	require.Equal(t, 1.0, m.GetGauge().GetValue())
+
+	// Untracked images and their digests are forgotten.
+	imageStore = store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	require.Empty(t, collect())
+	require.Empty(t, p.digests)
+	require.Empty(t, p.values)
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const quayTokensRefreshInterval = time.Hour
+
+var (
+	quayAppTokenExpiryDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_quay_app_token_expiry_timestamp_seconds",
+		"Expiration time of a Quay application token, as a Unix timestamp.",
+		[]string{"registry", "title", "uuid"}, nil,
+	)
+	quayAppTokenExpiringDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_quay_app_token_expiring",
+		"Non-zero indicates that a Quay application token expires within the warning window.",
+		[]string{"registry", "title", "uuid"}, nil,
+	)
+)
+
+// QuayConfig configures watching of Quay application token expiration.
+// Quay robot account tokens don't expire, so only application tokens are watched.
+type QuayConfig struct {
+	// Registry is the Quay registry host. Watching is disabled if it is empty.
+	Registry string
+	// APITokenPath is a path to an OAuth token that is used to query the Quay API.
+	APITokenPath string
+	// WarningWindow is the period before expiration in which tokens are reported as expiring.
+	WarningWindow time.Duration
+}
+
+type quayAppToken struct {
+	UUID       string `json:"uuid"`
+	Title      string `json:"title"`
+	Expiration string `json:"expiration"`
+}
+
+type quayTokenWatcher struct {
+	config QuayConfig
+	client *http.Client
+
+	lock   sync.RWMutex
+	tokens []quayAppToken
+}
+
+func newQuayTokenWatcher(config QuayConfig, transport http.RoundTripper) *quayTokenWatcher {
+	return &quayTokenWatcher{
+		config: config,
+		client: &http.Client{Transport: transport, Timeout: 15 * time.Second},
+	}
+}
+
+func (w *quayTokenWatcher) Run(stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		tokens, err := w.listAppTokens()
+		if err != nil {
+			logrus.WithField("registry", w.config.Registry).Errorf("Failed to list Quay application tokens: %v", err)
+			return
+		}
+
+		for _, token := range tokens {
+			if expiresAt, ok := token.expiresAt(); ok && time.Until(expiresAt) < w.config.WarningWindow {
+				logrus.WithFields(logrus.Fields{
+					"registry": w.config.Registry,
+					"title":    token.Title,
+					"uuid":     token.UUID,
+				}).Warnf("Quay application token expires at %s", expiresAt.Format(time.RFC3339))
+			}
+		}
+
+		w.lock.Lock()
+		w.tokens = tokens
+		w.lock.Unlock()
+	}, quayTokensRefreshInterval, stopCh)
+}
+
+func (w *quayTokenWatcher) listAppTokens() ([]quayAppToken, error) {
+	apiToken, err := os.ReadFile(w.config.APITokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, (&url.URL{Scheme: "https", Host: w.config.Registry, Path: "/api/v1/user/apptoken"}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(apiToken)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	var list struct {
+		Tokens []quayAppToken `json:"tokens"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Tokens, nil
+}
+
+func (w *quayTokenWatcher) collect(ch chan<- prometheus.Metric) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	for _, token := range w.tokens {
+		expiresAt, ok := token.expiresAt()
+		if !ok {
+			continue
+		}
+
+		var expiring float64
+		if time.Until(expiresAt) < w.config.WarningWindow {
+			expiring = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(quayAppTokenExpiryDesc, prometheus.GaugeValue, float64(expiresAt.Unix()), w.config.Registry, token.Title, token.UUID)
+		ch <- prometheus.MustNewConstMetric(quayAppTokenExpiringDesc, prometheus.GaugeValue, expiring, w.config.Registry, token.Title, token.UUID)
+	}
+}
+
+// expiresAt parses the expiration time that Quay formats according to RFC 1123.
+// Tokens without expiration are never reported.
+func (t quayAppToken) expiresAt() (time.Time, bool) {
+	if len(t.Expiration) == 0 {
+		return time.Time{}, false
+	}
+
+	expiresAt, err := time.Parse(time.RFC1123Z, t.Expiration)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
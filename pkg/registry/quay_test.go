@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_quayTokenWatcher(t *testing.T) {
+	expiring := time.Now().Add(time.Hour).Truncate(time.Second)
+	expired := time.Now().Add(-time.Hour).Truncate(time.Second)
+	valid := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+
+	status := http.StatusOK
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/user/apptoken" || r.Header.Get("Authorization") != "Bearer api-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"tokens": [
+			{"uuid": "1", "title": "expiring", "expiration": "` + expiring.Format(time.RFC1123Z) + `"},
+			{"uuid": "2", "title": "expired", "expiration": "` + expired.Format(time.RFC1123Z) + `"},
+			{"uuid": "3", "title": "valid", "expiration": "` + valid.Format(time.RFC1123Z) + `"},
+			{"uuid": "4", "title": "unparsable", "expiration": "2024-03-01T12:00:00Z"},
+			{"uuid": "5", "title": "never"}
+		]}`))
+	}))
+	defer server.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("api-token\n"), 0o600))
+
+	w := newQuayTokenWatcher(QuayConfig{
+		Registry:      server.Listener.Addr().String(),
+		APITokenPath:  tokenPath,
+		WarningWindow: 7 * 24 * time.Hour,
+	}, server.Client().Transport)
+
+	tokens, err := w.listAppTokens()
+	require.NoError(t, err)
+	require.Len(t, tokens, 5)
+	w.tokens = tokens
+
+	ch := make(chan prometheus.Metric, 20)
+	w.collect(ch)
+	close(ch)
+
+	expiries, expiringTokens := make(map[string]float64), make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if metric.Desc() == quayAppTokenExpiryDesc {
+			expiries[labels["title"]] = m.GetGauge().GetValue()
+		} else {
+			expiringTokens[labels["title"]] = m.GetGauge().GetValue()
+		}
+	}
+
+	// Tokens without a parsable expiration aren't reported.
+	require.Equal(t, map[string]float64{
+		"expiring": float64(expiring.Unix()),
+		"expired":  float64(expired.Unix()),
+		"valid":    float64(valid.Unix()),
+	}, expiries)
+	require.Equal(t, map[string]float64{"expiring": 1, "expired": 1, "valid": 0}, expiringTokens)
+
+	status = http.StatusInternalServerError
+	_, err = w.listAppTokens()
+	require.ErrorContains(t, err, "unexpected status 500")
+}
@@ -0,0 +1,457 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	// maxRecordedBody limits the size of a recorded response body.
+	maxRecordedBody = 64 << 10
+
+	redacted = "REDACTED"
+	// redactedTokenBody replaces bodies of token responses.
+	redactedTokenBody = `{"token":"` + redacted + `"}`
+)
+
+var (
+	// sensitiveHeaders are headers whose values are never recorded.
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Registry-Auth"}
+	// sensitiveQueryParameter matches names of query parameters whose values are never recorded, e.g. signatures of
+	// presigned blob URLs.
+	sensitiveQueryParameter = regexp.MustCompile(`(?i)token|password|secret|signature|credential|^x-amz-|^sig$|^sv$`)
+	// registryContentPath matches paths of the registry API that serve content rather than tokens.
+	registryContentPath = regexp.MustCompile(`^/v2/.+/(manifests|blobs|referrers)/`)
+)
+
+// RecordConfig configures recording registry interactions of failed checks.
+type RecordConfig struct {
+	// Path is the file recordings are appended to. Checks aren't recorded if it is empty.
+	Path string
+	// MaxSize is the size in bytes the file is rotated at.
+	MaxSize int64
+}
+
+// RecordedExchange is the sanitized metadata of a single HTTP request to a registry or its token endpoint and of the
+// response to it.
+type RecordedExchange struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	StatusCode      int         `json:"statusCode,omitempty"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	// Body is the response body. Bodies of token responses are replaced, since they hold tokens.
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"bodyTruncated,omitempty"`
+	// Error is the transport error the request failed with, e.g. a timeout.
+	Error string `json:"error,omitempty"`
+}
+
+// CheckRecording is the record of a failed check of an image.
+type CheckRecording struct {
+	Time             time.Time          `json:"time"`
+	CheckID          string             `json:"checkID"`
+	Image            string             `json:"image"`
+	Reference        string             `json:"reference"`
+	Insecure         bool               `json:"insecure,omitempty"`
+	Platform         string             `json:"platform,omitempty"`
+	GetFallback      bool               `json:"getFallback,omitempty"`
+	AvailabilityMode string             `json:"availabilityMode"`
+	Error            string             `json:"error,omitempty"`
+	Exchanges        []RecordedExchange `json:"exchanges"`
+}
+
+// checkRecorder records registry interactions of failed checks as JSON lines into a file, which is rotated once it
+// exceeds the maximum size, so that weird registry behaviors can be debugged remotely and replayed with Replay.
+type checkRecorder struct {
+	path    string
+	maxSize int64
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newCheckRecorder(path string, maxSize int64) (*checkRecorder, error) {
+	r := &checkRecorder{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *checkRecorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// start returns a recording of a check and the transport recording requests of the check.
+func (r *checkRecorder) start(checkID, image string, ref name.Reference, platform *v1.Platform, getFallback bool, next http.RoundTripper) (*CheckRecording, http.RoundTripper) {
+	recording := &CheckRecording{
+		CheckID:     checkID,
+		Image:       image,
+		Reference:   ref.Name(),
+		Insecure:    ref.Context().Scheme() == "http",
+		GetFallback: getFallback,
+	}
+	if platform != nil {
+		recording.Platform = platform.String()
+	}
+
+	return recording, &recordingTransport{next: next, recording: recording}
+}
+
+// finish writes the recording if the check failed.
+func (r *checkRecorder) finish(recording *CheckRecording, availMode store.AvailabilityMode, checkErr error) error {
+	if availMode == store.Available {
+		return nil
+	}
+
+	recording.Time = time.Now().UTC()
+	recording.AvailabilityMode = availMode.String()
+	if checkErr != nil {
+		recording.Error = checkErr.Error()
+	}
+
+	line, err := json.Marshal(recording)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// rotate keeps a single previous file with the ".1" suffix.
+func (r *checkRecorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		// Keep appending to the current file rather than failing every later write.
+		if openErr := r.open(); openErr != nil {
+			return fmt.Errorf("%w, reopening %s: %v", err, r.path, openErr)
+		}
+		return err
+	}
+
+	return r.open()
+}
+
+type recordingTransport struct {
+	next      http.RoundTripper
+	recording *CheckRecording
+
+	lock sync.Mutex
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := RecordedExchange{
+		Method:         req.Method,
+		URL:            sanitizeURL(req.URL),
+		RequestHeaders: sanitizeHeaders(req.Header),
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		t.add(exchange)
+		return resp, err
+	}
+
+	exchange.StatusCode = resp.StatusCode
+	exchange.ResponseHeaders = sanitizeHeaders(resp.Header)
+
+	// Successful responses outside of manifests, blobs and referrers may come from token endpoints, which some
+	// registries host under /v2/ too, e.g. /v2/token of GCR or /v2/auth of Quay.
+	if resp.StatusCode < http.StatusMultipleChoices && !registryContentPath.MatchString(req.URL.Path) {
+		exchange.Body = redactedTokenBody
+		exchange.ResponseHeaders.Del("Content-Length")
+		t.add(exchange)
+		return resp, nil
+	}
+
+	if resp.Body != nil && resp.Body != http.NoBody {
+		body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxRecordedBody+1))
+		recorded := body
+		if len(recorded) > maxRecordedBody {
+			recorded, exchange.BodyTruncated = recorded[:maxRecordedBody], true
+		}
+		if holdsToken(recorded) {
+			exchange.Body = redactedTokenBody
+			exchange.ResponseHeaders.Del("Content-Length")
+		} else {
+			exchange.Body = string(recorded)
+		}
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), &errReader{err: readErr}, resp.Body), Closer: resp.Body}
+	}
+	t.add(exchange)
+
+	return resp, nil
+}
+
+// holdsToken reports whether the body is a JSON object with a token, whatever the endpoint it came from.
+func holdsToken(body []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	_, token := fields["token"]
+	_, accessToken := fields["access_token"]
+
+	return token || accessToken
+}
+
+func (t *recordingTransport) add(exchange RecordedExchange) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.recording.Exchanges = append(t.recording.Exchanges, exchange)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader returns the error reading the recorded part of a body failed with, if any.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+func sanitizeURL(u *url.URL) string {
+	sanitized := *u
+	sanitized.User = nil
+
+	query := sanitized.Query()
+	for key, values := range query {
+		if sensitiveQueryParameter.MatchString(key) {
+			for i := range values {
+				values[i] = redacted
+			}
+		}
+	}
+	sanitized.RawQuery = query.Encode()
+
+	return sanitized.String()
+}
+
+func sanitizeHeaders(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+
+	sanitized := header.Clone()
+	for _, key := range sensitiveHeaders {
+		if values := sanitized.Values(key); len(values) > 0 {
+			sanitized[http.CanonicalHeaderKey(key)] = []string{redacted}
+		}
+	}
+
+	return sanitized
+}
+
+// ReplayResult compares the availability mode of a recorded check with the one the current classification logic
+// derives from the recorded responses.
+type ReplayResult struct {
+	Time     time.Time `json:"time"`
+	CheckID  string    `json:"checkID"`
+	Image    string    `json:"image"`
+	Recorded string    `json:"recorded"`
+	Replayed string    `json:"replayed"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Changed reports whether the check would be classified differently now.
+func (r ReplayResult) Changed() bool {
+	return r.Recorded != r.Replayed
+}
+
+// ReplayReport lists the results of replayed checks.
+type ReplayReport struct {
+	Checks []ReplayResult `json:"checks"`
+}
+
+// Changed returns the number of checks that would be classified differently now.
+func (r ReplayReport) Changed() (ret int) {
+	for _, result := range r.Checks {
+		if result.Changed() {
+			ret++
+		}
+	}
+
+	return
+}
+
+func (r ReplayReport) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Replay re-runs checks recorded with -record-failed-checks-path against the recorded responses instead of
+// registries.
+func Replay(r io.Reader) (ReplayReport, error) {
+	report := ReplayReport{Checks: []ReplayResult{}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var recording CheckRecording
+		if err := json.Unmarshal(scanner.Bytes(), &recording); err != nil {
+			return report, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		result, err := replay(recording)
+		if err != nil {
+			return report, fmt.Errorf("line %d: %w", line, err)
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report, scanner.Err()
+}
+
+func replay(recording CheckRecording) (ReplayResult, error) {
+	var opts []name.Option
+	if recording.Insecure {
+		opts = append(opts, name.Insecure)
+	}
+	ref, err := name.ParseReference(recording.Reference, opts...)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	var platform *v1.Platform
+	if len(recording.Platform) > 0 {
+		if platform, err = v1.ParsePlatform(recording.Platform); err != nil {
+			return ReplayResult{}, err
+		}
+	}
+
+	// Credentials don't matter, since requests are answered from the recording, which matches them by their URLs.
+	anonymous := authn.NewMultiKeychain()
+	availMode, _, checkErr := check(ref, anonymous, anonymous, &replayTransport{exchanges: recording.Exchanges}, platform, recording.GetFallback)
+
+	result := ReplayResult{
+		Time:     recording.Time,
+		CheckID:  recording.CheckID,
+		Image:    recording.Image,
+		Recorded: recording.AvailabilityMode,
+		Replayed: availMode.String(),
+	}
+	if checkErr != nil {
+		result.Error = checkErr.Error()
+	}
+
+	return result, nil
+}
+
+// replayTransport answers requests with recorded responses to requests with the same method and URL, in the order
+// they were recorded in.
+type replayTransport struct {
+	exchanges []RecordedExchange
+	used      []bool
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.used == nil {
+		t.used = make([]bool, len(t.exchanges))
+	}
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	requestURL := sanitizeURL(req.URL)
+	for i, exchange := range t.exchanges {
+		if t.used[i] || exchange.Method != req.Method || exchange.URL != requestURL {
+			continue
+		}
+		t.used[i] = true
+
+		if len(exchange.Error) > 0 {
+			return nil, replayedError(exchange.Error)
+		}
+
+		header := exchange.ResponseHeaders.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		// Bodies of HEAD responses are empty, while their length is that of the resource.
+		contentLength := int64(len(exchange.Body))
+		if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			contentLength = n
+		}
+		return &http.Response{
+			Request:       req,
+			Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+			StatusCode:    exchange.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			ContentLength: contentLength,
+			Body:          io.NopCloser(strings.NewReader(exchange.Body)),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no recorded response to %s %s", req.Method, requestURL)
+}
+
+// replayedError is a recorded transport error. Its type is lost, so transport failures such as timeouts are only
+// classified by their message.
+type replayedError string
+
+func (e replayedError) Error() string {
+	return string(e)
+}
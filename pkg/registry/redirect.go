@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+const maxRedirects = 10
+
+var errRedirectsDisabled = errors.New("redirects are disabled for the registry")
+
+// RedirectError is a failure of a request a registry redirected, e.g. to a presigned URL of object storage, or a
+// redirect that wasn't followed. It's reported apart from failures of the registry itself, since object storage
+// responds with its own errors, e.g. 403 for a request with two kinds of authorization, that aren't about the image.
+type RedirectError struct {
+	Location   string
+	StatusCode int
+	Err        error
+}
+
+func (e *RedirectError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("redirect to %s: %v", e.Location, e.Err)
+	}
+
+	return fmt.Sprintf("redirect to %s: unexpected status code %d %s", e.Location, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *RedirectError) Unwrap() error {
+	return e.Err
+}
+
+// IsRedirectFailure reports whether a request the registry redirected failed, or a redirect wasn't followed.
+func IsRedirectFailure(err error) bool {
+	var redirectErr *RedirectError
+	return errors.As(err, &redirectErr)
+}
+
+// redirectTransport handles redirects of requests to registries with an explicit redirect policy, instead of leaving
+// them to the http.Client of go-containerregistry, which forwards the Authorization header to subdomains of the
+// registry and can't tell failures of redirected requests apart.
+type redirectTransport struct {
+	config *config.Config
+	next   http.RoundTripper
+}
+
+func newRedirectTransport(exporterConfig *config.Config, next http.RoundTripper) http.RoundTripper {
+	for _, registry := range exporterConfig.Registries {
+		if len(registry.Redirects) > 0 && registry.Redirects != config.RedirectsFollow {
+			return &redirectTransport{config: exporterConfig, next: next}
+		}
+	}
+
+	return next
+}
+
+// RoundTrip follows redirects of GET and HEAD requests, redirects of other requests, e.g. of token requests, are
+// still followed by the http.Client.
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	registry, ok := t.config.Match(req.URL.Host)
+	if !ok || len(registry.Redirects) == 0 || registry.Redirects == config.RedirectsFollow ||
+		(req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return t.next.RoundTrip(req)
+	}
+	registryHost := req.URL.Host
+
+	resp, err := t.next.RoundTrip(req)
+	for redirects := 0; ; redirects++ {
+		if err != nil {
+			if redirects > 0 {
+				return nil, &RedirectError{Location: req.URL.Redacted(), Err: err}
+			}
+			return nil, err
+		}
+
+		if !isRedirect(resp.StatusCode) {
+			if redirects > 0 && resp.StatusCode >= http.StatusBadRequest {
+				discardBody(resp)
+				return nil, &RedirectError{Location: req.URL.Redacted(), StatusCode: resp.StatusCode}
+			}
+			return resp, nil
+		}
+
+		location, locationErr := resp.Location()
+		discardBody(resp)
+		if locationErr != nil {
+			return nil, &RedirectError{Location: resp.Header.Get("Location"), Err: locationErr}
+		}
+		if registry.Redirects == config.RedirectsNone {
+			return nil, &RedirectError{Location: location.Redacted(), Err: errRedirectsDisabled}
+		}
+		if redirects == maxRedirects {
+			return nil, &RedirectError{Location: location.Redacted(), Err: fmt.Errorf("stopped after %d redirects", maxRedirects)}
+		}
+
+		req = redirectRequest(req, location, registryHost)
+		resp, err = t.next.RoundTrip(req)
+	}
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// redirectRequest returns the request to the location, without the Authorization header unless the location is on
+// the host of the registry. Configured headers are only set on requests to the registry by headerTransport.
+func redirectRequest(req *http.Request, location *url.URL, registryHost string) *http.Request {
+	next := req.Clone(req.Context())
+	next.URL = location
+	next.Host = ""
+
+	if location.Host != registryHost {
+		next.Header.Del("Authorization")
+	}
+
+	return next
+}
+
+func discardBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
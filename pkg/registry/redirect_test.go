@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func Test_redirectTransport(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Object storage rejects requests with both a presigned URL and an Authorization header.
+		if len(r.Header.Get("Authorization")) > 0 || r.URL.Query().Get("signature") != "valid" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("manifest"))
+	}))
+	defer storage.Close()
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, storage.URL+"/blobs/app?signature="+strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/"), http.StatusTemporaryRedirect)
+	}))
+	defer registry.Close()
+	host := registry.Listener.Addr().String()
+
+	get := func(t *testing.T, redirects, tag string) (*http.Response, error) {
+		t.Helper()
+
+		transport := newRedirectTransport(&config.Config{Registries: []config.Registry{{Host: host, Redirects: redirects}}}, http.DefaultTransport)
+		req, err := http.NewRequest(http.MethodGet, registry.URL+"/v2/app/manifests/"+tag, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer token")
+
+		return transport.RoundTrip(req)
+	}
+
+	// Redirects are left to the http.Client by default.
+	resp, err := get(t, config.RedirectsFollow, "valid")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	resp, err = get(t, config.RedirectsStripAuthorization, "valid")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = get(t, config.RedirectsStripAuthorization, "expired")
+	require.True(t, IsRedirectFailure(err))
+	require.False(t, IsAuthzFail(err))
+	require.ErrorContains(t, err, "403 Forbidden")
+
+	_, err = get(t, config.RedirectsNone, "valid")
+	require.True(t, IsRedirectFailure(err))
+	require.ErrorIs(t, err, errRedirectsDisabled)
+}
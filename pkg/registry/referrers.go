@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// referrersSupportTTL is how long the detected support of the referrers API is trusted, registries get upgraded.
+const referrersSupportTTL = 24 * time.Hour
+
+var referrersAPIDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_registry_referrers_api",
+	"Whether the registry supports the OCI 1.1 referrers API, referrers in registries without it are read from the referrers tag schema.",
+	[]string{"registry"}, nil,
+)
+
+type referrersCapability struct {
+	supported bool
+	detected  time.Time
+}
+
+// referrersSupport lists referrers of images, which carry signatures, attestations and SBOMs, and remembers per
+// registry whether the referrers API is supported. Referrers in registries known not to support it are read from
+// the fallback tag right away, saving a request.
+type referrersSupport struct {
+	lock       sync.Mutex
+	registries map[string]referrersCapability
+
+	now func() time.Time
+}
+
+func newReferrersSupport() *referrersSupport {
+	return &referrersSupport{
+		registries: make(map[string]referrersCapability),
+		now:        time.Now,
+	}
+}
+
+// referrersProbe records the status of responses to referrers API requests.
+type referrersProbe struct {
+	base   http.RoundTripper
+	status atomic.Int32
+}
+
+func (p *referrersProbe) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.base.RoundTrip(req)
+	if err == nil && strings.Contains(req.URL.Path, "/referrers/") {
+		p.status.Store(int32(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// list returns descriptors of referrers of the image with the digest. registryTransport must be the transport set in
+// options, it's wrapped to detect whether the registry answers the referrers API.
+func (r *referrersSupport) list(d name.Digest, registryTransport http.RoundTripper, options ...remote.Option) ([]v1.Descriptor, error) {
+	registry := d.Context().RegistryStr()
+
+	r.lock.Lock()
+	capability, known := r.registries[registry]
+	r.lock.Unlock()
+	if known && r.now().Sub(capability.detected) > referrersSupportTTL {
+		known = false
+	}
+
+	if known && !capability.supported {
+		return fallbackReferrers(d, options...)
+	}
+
+	probe := &referrersProbe{base: registryTransport}
+	index, err := remote.Referrers(d, append(options, remote.WithTransport(probe))...)
+
+	// Registries at older spec levels answer the referrers endpoint with 404 or 400, which go-containerregistry
+	// handles by reading the fallback tag itself, or with errors like 405 for unknown methods and paths. Support is
+	// unknown until the registry answers otherwise, e.g. after an authentication failure.
+	switch status := int(probe.status.Load()); {
+	case status == http.StatusOK:
+		r.record(registry, true)
+	case status == http.StatusNotFound || status == http.StatusBadRequest:
+		r.record(registry, false)
+	case isUnsupportedEndpoint(err):
+		r.record(registry, false)
+		return fallbackReferrers(d, options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Manifests, nil
+}
+
+func (r *referrersSupport) record(registry string, supported bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.registries[registry] = referrersCapability{supported: supported, detected: r.now()}
+}
+
+func (r *referrersSupport) collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for registry, capability := range r.registries {
+		value := 0.0
+		if capability.supported {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(referrersAPIDesc, prometheus.GaugeValue, value, registry)
+	}
+}
+
+// fallbackReferrers reads referrers of the image with the digest from the tag named after the digest, as defined by
+// the referrers tag schema. A missing tag means there are no referrers.
+func fallbackReferrers(d name.Digest, options ...remote.Option) ([]v1.Descriptor, error) {
+	tag := d.Context().Tag(strings.Replace(d.DigestStr(), ":", "-", 1))
+
+	index, err := remote.Index(tag, options...)
+	if err != nil {
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Manifests, nil
+}
+
+func isUnsupportedEndpoint(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+
+	switch transportErr.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotAcceptable, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
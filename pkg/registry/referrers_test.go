@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_referrersSupport(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// referrersStatus overrides responses of the referrers endpoint if set.
+		referrersStatus int
+		supported       bool
+	}{
+		{name: "referrers API", supported: true},
+		{name: "not found", referrersStatus: http.StatusNotFound},
+		{name: "method not allowed", referrersStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := ggcrregistry.New(ggcrregistry.WithReferrersSupport(true))
+			var referrersRequests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "/referrers/") {
+					referrersRequests.Add(1)
+					if tc.referrersStatus != 0 {
+						w.WriteHeader(tc.referrersStatus)
+						return
+					}
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+			host := strings.TrimPrefix(srv.URL, "http://")
+
+			ref, err := name.ParseReference(host+"/app:v1", name.Insecure)
+			require.NoError(t, err)
+			img, err := random.Image(128, 1)
+			require.NoError(t, err)
+			require.NoError(t, remote.Write(ref, img))
+			desc, err := remote.Head(ref)
+			require.NoError(t, err)
+			d := ref.Context().Digest(desc.Digest.String())
+
+			sbom := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), "application/spdx+json")
+			if tc.supported {
+				sbom = mutate.Subject(sbom, *desc).(v1.Image)
+			}
+			sbomDigest, err := sbom.Digest()
+			require.NoError(t, err)
+			require.NoError(t, remote.Write(ref.Context().Digest(sbomDigest.String()), sbom))
+			if !tc.supported {
+				// Clients push the referrers tag schema index to registries without the referrers API.
+				sbomDesc, err := partial.Descriptor(sbom)
+				require.NoError(t, err)
+				sbomDesc.ArtifactType = "application/spdx+json"
+				index := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), mutate.IndexAddendum{Add: sbom, Descriptor: *sbomDesc})
+				require.NoError(t, remote.WriteIndex(ref.Context().Tag(strings.Replace(desc.Digest.String(), ":", "-", 1)), index))
+			}
+			referrersRequests.Store(0)
+
+			referrers := newReferrersSupport()
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			referrers.now = func() time.Time { return now }
+			list := func() []v1.Descriptor {
+				descs, err := referrers.list(d, http.DefaultTransport, remoteOptions(context.Background(), nil, authn.DefaultKeychain, http.DefaultTransport)...)
+				require.NoError(t, err)
+				return descs
+			}
+
+			descs := list()
+			require.Len(t, descs, 1)
+			require.Equal(t, "application/spdx+json", descs[0].ArtifactType)
+			require.Equal(t, referrersCapability{supported: tc.supported, detected: now}, referrers.registries[host])
+			require.EqualValues(t, 1, referrersRequests.Load())
+
+			// The referrers API isn't tried again in registries known not to support it, until the support expires.
+			require.Len(t, list(), 1)
+			expected := int32(2)
+			if !tc.supported {
+				expected = 1
+			}
+			require.Equal(t, expected, referrersRequests.Load())
+
+			now = now.Add(referrersSupportTTL + time.Second)
+			require.Len(t, list(), 1)
+			require.Equal(t, expected+1, referrersRequests.Load())
+		})
+	}
+}
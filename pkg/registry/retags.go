@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	imageReplacementsDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_replacements_total",
+		"Number of times the image of the container was replaced with another one, e.g. with a new tag during a deploy.",
+		[]string{"namespace", "kind", "name", "container"}, nil,
+	)
+	imageReplacedDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_replaced_timestamp",
+		"Time the image of the container was replaced with new_image, as a Unix timestamp in seconds, exported for the retag grace period.",
+		[]string{"namespace", "kind", "name", "container", "image", "new_image"}, nil,
+	)
+)
+
+type containerKey struct {
+	namespace string
+	kind      string
+	name      string
+	container string
+}
+
+type retag struct {
+	oldImage string
+	newImage string
+	at       time.Time
+}
+
+// retags links the previous image of a container to the image that replaced it for a grace period, so that an image
+// replaced during a deploy can be told apart from one that disappeared from its registry.
+type retags struct {
+	gracePeriod time.Duration
+	now         func() time.Time
+
+	lock         sync.Mutex
+	recent       map[containerKey]retag
+	replacements map[containerKey]uint64
+}
+
+func newRetags(gracePeriod time.Duration) *retags {
+	return &retags{
+		gracePeriod:  gracePeriod,
+		now:          time.Now,
+		recent:       make(map[containerKey]retag),
+		replacements: make(map[containerKey]uint64),
+	}
+}
+
+// record records containers whose image differs between the old and the new version of a controller.
+func (r *retags) record(oldCis, newCis *controllerWithContainerInfos) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for container, oldImage := range oldCis.containerToImages {
+		newImage, ok := newCis.containerToImages[container]
+		if !ok || newImage == oldImage {
+			continue
+		}
+
+		key := containerKey{
+			namespace: newCis.Namespace,
+			kind:      strings.ToLower(newCis.controllerKind),
+			name:      newCis.controllerName,
+			container: container,
+		}
+		r.recent[key] = retag{oldImage: oldImage, newImage: newImage, at: r.now()}
+		r.replacements[key]++
+
+		logrus.WithFields(logrus.Fields{
+			"namespace": key.namespace,
+			"kind":      key.kind,
+			"name":      key.name,
+			"container": container,
+			"image":     oldImage,
+			"new_image": newImage,
+		}).Info("The image of the container was replaced")
+	}
+}
+
+// forget drops links and counters of containers of a deleted controller. Objects owned by a controller, e.g.
+// ReplicaSets of a Deployment, are deleted during its lifetime, so they don't affect anything.
+func (r *retags) forget(cis *controllerWithContainerInfos) {
+	if cis.owned && cis.controllerKind != "Pod" {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	kind := strings.ToLower(cis.controllerKind)
+	for key := range r.replacements {
+		if key.namespace == cis.Namespace && key.kind == kind && key.name == cis.controllerName {
+			delete(r.replacements, key)
+			delete(r.recent, key)
+		}
+	}
+}
+
+func (r *retags) collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	for key, retag := range r.recent {
+		if now.Sub(retag.at) >= r.gracePeriod {
+			delete(r.recent, key)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(imageReplacedDesc, prometheus.GaugeValue, float64(retag.at.Unix()),
+			key.namespace, key.kind, key.name, key.container, retag.oldImage, retag.newImage)
+	}
+
+	for key, replacements := range r.replacements {
+		ch <- prometheus.MustNewConstMetric(imageReplacementsDesc, prometheus.CounterValue, float64(replacements),
+			key.namespace, key.kind, key.name, key.container)
+	}
+}
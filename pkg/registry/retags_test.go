@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func retagMetrics(t *testing.T, r *retags) (replaced map[string]float64, replacements map[string]float64) {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 10)
+	r.collect(ch)
+	close(ch)
+
+	replaced, replacements = make(map[string]float64), make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if m.GetCounter() != nil {
+			replacements[labels["name"]+"/"+labels["container"]] = m.GetCounter().GetValue()
+			continue
+		}
+		replaced[labels["container"]+" "+labels["image"]+" "+labels["new_image"]] = m.GetGauge().GetValue()
+	}
+
+	return replaced, replacements
+}
+
+func Test_retags(t *testing.T) {
+	r := newRetags(10 * time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	deployment := func(images map[string]string) *controllerWithContainerInfos {
+		return &controllerWithContainerInfos{
+			ObjectMeta:        metav1.ObjectMeta{Namespace: "prod", Name: "web"},
+			controllerKind:    "Deployment",
+			controllerName:    "web",
+			containerToImages: images,
+		}
+	}
+
+	v1 := deployment(map[string]string{"app": "registry.example.com/app:v1", "proxy": "envoy:1.29"})
+	v2 := deployment(map[string]string{"app": "registry.example.com/app:v2", "proxy": "envoy:1.29"})
+	r.record(v1, v2)
+
+	replaced, replacements := retagMetrics(t, r)
+	require.Equal(t, map[string]float64{
+		"app registry.example.com/app:v1 registry.example.com/app:v2": float64(now.Unix()),
+	}, replaced)
+	require.Equal(t, map[string]float64{"web/app": 1}, replacements)
+
+	// Links expire after the grace period, counters don't.
+	now = now.Add(10 * time.Minute)
+	replaced, replacements = retagMetrics(t, r)
+	require.Empty(t, replaced)
+	require.Equal(t, map[string]float64{"web/app": 1}, replacements)
+
+	// Containers added or removed aren't replacements.
+	r.record(v2, deployment(map[string]string{"app": "registry.example.com/app:v2", "init": "busybox:1.36"}))
+	_, replacements = retagMetrics(t, r)
+	require.Equal(t, map[string]float64{"web/app": 1}, replacements)
+}
+
+func Test_retags_forget(t *testing.T) {
+	r := newRetags(10 * time.Minute)
+
+	controller := func(kind, name string, owned bool, image string) *controllerWithContainerInfos {
+		return &controllerWithContainerInfos{
+			ObjectMeta:        metav1.ObjectMeta{Namespace: "prod", Name: name},
+			controllerKind:    kind,
+			controllerName:    "web",
+			owned:             owned,
+			containerToImages: map[string]string{"app": image},
+		}
+	}
+
+	r.record(controller("Deployment", "web", false, "app:v1"), controller("Deployment", "web", false, "app:v2"))
+	r.record(controller("StatefulSet", "web", false, "app:v1"), controller("StatefulSet", "web", false, "app:v2"))
+
+	// ReplicaSets are deleted during the lifetime of their Deployment.
+	r.forget(controller("Deployment", "web-5d4f8", true, "app:v1"))
+	require.Len(t, r.recent, 2)
+	require.Len(t, r.replacements, 2)
+
+	r.forget(controller("Deployment", "web", false, "app:v2"))
+	require.Len(t, r.recent, 1)
+	require.Equal(t, map[containerKey]uint64{{namespace: "prod", kind: "statefulset", name: "web", container: "app"}: 1}, r.replacements)
+}
@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"regexp"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+// imageRewrite is an image rewrite rule of the config with its pattern compiled.
+type imageRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func newImageRewrites(rules []config.ImageRewrite) ([]imageRewrite, error) {
+	ret := make([]imageRewrite, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, imageRewrite{pattern: pattern, replacement: rule.Replacement})
+	}
+
+	return ret, nil
+}
+
+// rewriteImage returns the image as rewritten by the first matching rule, or the image itself if none matches.
+func rewriteImage(rewrites []imageRewrite, image string) string {
+	for _, rewrite := range rewrites {
+		if rewrite.pattern.MatchString(image) {
+			return rewrite.pattern.ReplaceAllString(image, rewrite.replacement)
+		}
+	}
+
+	return image
+}
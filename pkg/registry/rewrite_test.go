@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func TestRewriteImage(t *testing.T) {
+	rewrites, err := newImageRewrites([]config.ImageRewrite{
+		{Pattern: `^gcr\.io/(.*)`, Replacement: "mirror.example.com/gcr/$1"},
+		{Pattern: `^(?:docker\.io/)?(?:library/)?([^/.:]+)(:.*)?$`, Replacement: "mirror.example.com/library/$1$2"},
+		{Pattern: `^gcr\.io/distroless/.*`, Replacement: "unreachable.example.com/distroless"},
+	})
+	require.NoError(t, err)
+
+	for image, expected := range map[string]string{
+		"gcr.io/distroless/static:nonroot": "mirror.example.com/gcr/distroless/static:nonroot",
+		"nginx:1.25":                       "mirror.example.com/library/nginx:1.25",
+		"docker.io/library/redis":          "mirror.example.com/library/redis",
+		"registry.example.com/app:v1":      "registry.example.com/app:v1",
+	} {
+		require.Equal(t, expected, rewriteImage(rewrites, image), image)
+	}
+
+	_, err = newImageRewrites([]config.ImageRewrite{{Pattern: "(", Replacement: "x"}})
+	require.Error(t, err)
+}
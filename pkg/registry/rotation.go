@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	kubeauth "github.com/google/go-containerregistry/pkg/authn/kubernetes"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var errSecretless = errors.New("image pull secrets are not read in the secretless mode")
+
+// RotationCheck is the availability of an image with the current and the candidate credentials of a pull secret.
+type RotationCheck struct {
+	Image     string `json:"image"`
+	Current   string `json:"current"`
+	Candidate string `json:"candidate"`
+}
+
+// Regression reports whether the image is available with the current credentials, but not with the candidate ones.
+func (c RotationCheck) Regression() bool {
+	return c.Current == store.Available.String() && c.Candidate != store.Available.String()
+}
+
+// RotationReport lists images authenticated by a pull secret, checked with its current and candidate credentials.
+type RotationReport struct {
+	Secret string          `json:"secret"`
+	Images []RotationCheck `json:"images"`
+}
+
+// Regressions returns the number of images that would become unavailable if the candidate credentials were used.
+func (r RotationReport) Regressions() (ret int) {
+	for _, check := range r.Images {
+		if check.Regression() {
+			ret++
+		}
+	}
+
+	return
+}
+
+func (r RotationReport) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// RotationDryRun checks images of controllers using the pull secret, given as "namespace/name", with both its current
+// credentials and the candidate Docker config JSON, so that the secret can be rotated without breaking pulls. Images
+// the secret has no credentials for are skipped. Other credential sources aren't used for either of the checks.
+func (rc *Checker) RotationDryRun(secretKey string, candidateDockerConfigJSON []byte) (RotationReport, error) {
+	report := RotationReport{Secret: secretKey, Images: []RotationCheck{}}
+	if rc.controllerIndexers.secretStore == nil {
+		return report, errSecretless
+	}
+
+	secretObj, exists, err := rc.controllerIndexers.secretStore.GetByKey(secretKey)
+	if err != nil {
+		return report, err
+	}
+	if !exists {
+		return report, fmt.Errorf("secret %q doesn't exist", secretKey)
+	}
+	current := secretObj.(*corev1.Secret)
+	if err := pullSecretError(current); err != nil {
+		return report, fmt.Errorf("secret %q: %w", secretKey, err)
+	}
+
+	candidate := current.DeepCopy()
+	candidate.Type = corev1.SecretTypeDockerConfigJson
+	candidate.Data = map[string][]byte{corev1.DockerConfigJsonKey: candidateDockerConfigJSON}
+	if err := pullSecretError(candidate); err != nil {
+		return report, fmt.Errorf("candidate credentials: %w", err)
+	}
+
+	currentKc, err := kubeauth.NewFromPullSecrets(context.TODO(), []corev1.Secret{*current})
+	if err != nil {
+		return report, fmt.Errorf("secret %q: %w", secretKey, err)
+	}
+	candidateKc, err := kubeauth.NewFromPullSecrets(context.TODO(), []corev1.Secret{*candidate})
+	if err != nil {
+		return report, fmt.Errorf("candidate credentials: %w", err)
+	}
+
+imagesLoop:
+	for _, image := range rc.controllerIndexers.imagesUsingPullSecret(secretKey) {
+		for _, ignoredImageRegex := range rc.ignoredImagesRegex {
+			if ignoredImageRegex.MatchString(image) {
+				continue imagesLoop
+			}
+		}
+
+		log := logrus.WithField("image_name", image)
+
+		ref, err := parseImageName(image, rc.config.defaultRegistry, rc.config.plainHTTP)
+		if err != nil {
+			log.Debugf("Skipping the image that can't be parsed: %v", err)
+			continue
+		}
+
+		if auth, err := currentKc.Resolve(ref.Context()); err != nil || auth == authn.Anonymous {
+			continue
+		}
+
+		getFallback := rc.manifestGetFallback(ref.Context().RegistryStr())
+		// The keychains are passed as fallback ones, so that nothing else is tried.
+		currentMode, _, currentErr := check(ref, nil, currentKc, rc.registryTransport, rc.config.checkPlatform, getFallback)
+		candidateMode, _, candidateErr := check(ref, nil, candidateKc, rc.registryTransport, rc.config.checkPlatform, getFallback)
+
+		result := RotationCheck{Image: image, Current: currentMode.String(), Candidate: candidateMode.String()}
+		if result.Regression() {
+			log.WithField("availability_mode", result.Candidate).Warnf("The image isn't available with the candidate credentials: %v", candidateErr)
+		} else if currentErr != nil {
+			log.WithField("availability_mode", result.Current).Debugf("The image isn't available with the current credentials: %v", currentErr)
+		}
+
+		report.Images = append(report.Images, result)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestChecker_RotationDryRun(t *testing.T) {
+	registryHandler := ggcrregistry.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "robot" || password != "old" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registryHandler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	image := host + "/app:v1"
+	ref, err := name.ParseReference(image, name.Insecure)
+	require.NoError(t, err)
+	img, err := random.Image(128, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img, remote.WithAuth(&authn.Basic{Username: "robot", Password: "old"})))
+
+	dockerConfig := func(password string) []byte {
+		return []byte(fmt.Sprintf(`{"auths":{%q:{"username":"robot","password":%q}}}`, host, password))
+	}
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, namespaceIndexers(""))
+	require.NoError(t, namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}))
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, secretIndexer.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "registry"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig("old")},
+	}))
+
+	replicas := int32(1)
+	template := podTemplate(image)
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	publicTemplate := podTemplate("registry.example.com/public:v1")
+	publicTemplate.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+
+	rc := &Checker{
+		controllerIndexers: ControllerIndexers{
+			namespaceIndexer:      namespaceIndexer,
+			serviceAccountIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+			secretStore:           secretIndexer,
+			deploymentIndexer: newTestIndexer(t, getImagesFromDeployment,
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+					Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+				},
+				// The secret doesn't have credentials for the registry of the image.
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "public"},
+					Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: publicTemplate},
+				},
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"},
+					Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: podTemplate(host + "/other:v1")},
+				},
+			),
+			statefulSetIndexer: newTestIndexer(t, getImagesFromStatefulSet),
+			daemonSetIndexer:   newTestIndexer(t, getImagesFromDaemonSet),
+			cronJobIndexer:     newTestIndexer(t, getImagesFromCronJob),
+		},
+		registryTransport: http.DefaultTransport,
+		config:            registryCheckerConfig{plainHTTP: true},
+	}
+
+	report, err := rc.RotationDryRun("default/registry", dockerConfig("old"))
+	require.NoError(t, err)
+	require.Equal(t, []RotationCheck{{Image: image, Current: "available", Candidate: "available"}}, report.Images)
+	require.Zero(t, report.Regressions())
+
+	report, err = rc.RotationDryRun("default/registry", dockerConfig("new"))
+	require.NoError(t, err)
+	require.Equal(t, []RotationCheck{{Image: image, Current: "available", Candidate: "authentication_failure"}}, report.Images)
+	require.Equal(t, 1, report.Regressions())
+
+	_, err = rc.RotationDryRun("default/registry", []byte("{"))
+	require.Error(t, err)
+
+	_, err = rc.RotationDryRun("default/missing", dockerConfig("new"))
+	require.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var (
+	imageSBOMDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_has_sbom",
+		"Whether an SBOM is attached to the image, found through the referrers API, the cosign SBOM tag or BuildKit attestation manifests.",
+		[]string{"namespace", "kind", "name", "container", "image"}, nil,
+	)
+	imageSBOMFormatDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_image_sbom_format",
+		"Formats of SBOMs attached to the image, always 1.",
+		[]string{"namespace", "kind", "name", "container", "image", "format"}, nil,
+	)
+)
+
+const (
+	sbomFormatSPDX      = "spdx"
+	sbomFormatCycloneDX = "cyclonedx"
+	sbomFormatSyft      = "syft"
+)
+
+// sbomMediaTypes are media types of SBOM documents, used as artifact types of referrers and as layer media types of
+// SBOMs attached by cosign.
+var sbomMediaTypes = map[string]string{
+	"application/spdx+json":          sbomFormatSPDX,
+	"text/spdx+json":                 sbomFormatSPDX,
+	"text/spdx":                      sbomFormatSPDX,
+	"text/spdx+xml":                  sbomFormatSPDX,
+	"application/vnd.cyclonedx+json": sbomFormatCycloneDX,
+	"application/vnd.cyclonedx+xml":  sbomFormatCycloneDX,
+	"application/vnd.cyclonedx":      sbomFormatCycloneDX,
+	"application/vnd.syft+json":      sbomFormatSyft,
+}
+
+// sbomPredicateTypes are in-toto predicate types of SBOMs stored in attestation manifests by BuildKit.
+var sbomPredicateTypes = map[string]string{
+	"https://spdx.dev/Document": sbomFormatSPDX,
+	"https://cyclonedx.org/bom": sbomFormatCycloneDX,
+	"https://syft.dev/bom":      sbomFormatSyft,
+}
+
+// sboms remembers formats of SBOMs attached to images during deep checks. Like attestations, SBOMs can be attached
+// to an image after it's pushed, so images without them are looked up again once missingLookupTTL passes.
+type sboms struct {
+	*digestTracker[[]string]
+}
+
+func newSBOMs() *sboms {
+	return &sboms{newDigestTracker[[]string](func(formats []string) bool { return len(formats) == 0 }, missingLookupTTL)}
+}
+
+// collect exports whether tracked images have SBOMs and their formats per container, and forgets untracked images
+// and their digests.
+func (s *sboms) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	s.rangeContainers(imageStore, func(labels []string, formats []string) {
+		value := 0.0
+		if len(formats) > 0 {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(imageSBOMDesc, prometheus.GaugeValue, value, labels...)
+		for _, format := range formats {
+			ch <- prometheus.MustNewConstMetric(imageSBOMFormatDesc, prometheus.GaugeValue, 1, append(labels, format)...)
+		}
+	})
+}
+
+// sbomFormats looks up SBOMs of the image with the digest and returns their sorted formats. Referrers are listed
+// first, then the tag cosign attaches SBOMs under is checked, and finally attestation manifests added by BuildKit to
+// the index of the image. registryTransport must be the transport set in options.
+func sbomFormats(ref name.Reference, digest string, referrers *referrersSupport, registryTransport http.RoundTripper, options ...remote.Option) ([]string, error) {
+	d := ref.Context().Digest(digest)
+	formats := make(map[string]struct{})
+
+	descs, err := referrers.list(d, registryTransport, options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range descs {
+		if format, ok := sbomMediaTypes[desc.ArtifactType]; ok {
+			formats[format] = struct{}{}
+		}
+	}
+
+	if len(formats) == 0 {
+		layers, err := manifestLayers(ref.Context().Tag(strings.Replace(digest, ":", "-", 1)+".sbom"), options...)
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			if format, ok := sbomMediaTypes[string(layer.MediaType)]; ok {
+				formats[format] = struct{}{}
+			}
+		}
+	}
+
+	if len(formats) == 0 {
+		attestations, err := attestationManifests(d, options...)
+		if err != nil {
+			return nil, err
+		}
+		for _, attestation := range attestations {
+			layers, err := manifestLayers(ref.Context().Digest(attestation.String()), options...)
+			if err != nil {
+				return nil, err
+			}
+			for _, layer := range layers {
+				if format, ok := sbomPredicateTypes[layer.Annotations["in-toto.io/predicate-type"]]; ok {
+					formats[format] = struct{}{}
+				}
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(formats))
+	for format := range formats {
+		ret = append(ret, format)
+	}
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// manifestLayers returns layer descriptors from the image manifest of the reference.
+func manifestLayers(ref name.Reference, options ...remote.Option) ([]v1.Descriptor, error) {
+	img, err := remote.Image(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Layers, nil
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_sbomFormats(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New(ggcrregistry.WithReferrersSupport(true)))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	push := func(t *testing.T, repository string) (name.Reference, *remote.Descriptor) {
+		t.Helper()
+
+		ref, err := name.ParseReference(host+"/"+repository+":v1", name.Insecure)
+		require.NoError(t, err)
+		img, err := random.Image(128, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+		desc, err := remote.Get(ref)
+		require.NoError(t, err)
+		return ref, desc
+	}
+	lookup := func(t *testing.T, ref name.Reference, digest string) []string {
+		t.Helper()
+
+		formats, err := sbomFormats(ref, digest, newReferrersSupport(), http.DefaultTransport, remoteOptions(context.Background(), nil, authn.DefaultKeychain, http.DefaultTransport)...)
+		require.NoError(t, err)
+		return formats
+	}
+
+	t.Run("none", func(t *testing.T) {
+		ref, desc := push(t, "none")
+		require.Empty(t, lookup(t, ref, desc.Digest.String()))
+	})
+
+	t.Run("referrers", func(t *testing.T) {
+		ref, desc := push(t, "referrers")
+
+		sbom := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), "application/spdx+json")
+		sbom = mutate.Subject(sbom, desc.Descriptor).(v1.Image)
+		sbomDigest, err := sbom.Digest()
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref.Context().Digest(sbomDigest.String()), sbom))
+
+		require.Equal(t, []string{sbomFormatSPDX}, lookup(t, ref, desc.Digest.String()))
+	})
+
+	t.Run("cosign", func(t *testing.T) {
+		ref, desc := push(t, "cosign")
+
+		sbom, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer([]byte(`{"bomFormat":"CycloneDX"}`), "application/vnd.cyclonedx+json")})
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref.Context().Tag(strings.Replace(desc.Digest.String(), ":", "-", 1)+".sbom"), sbom))
+
+		require.Equal(t, []string{sbomFormatCycloneDX}, lookup(t, ref, desc.Digest.String()))
+	})
+
+	t.Run("buildkit", func(t *testing.T) {
+		ref, err := name.ParseReference(host+"/buildkit:v1", name.Insecure)
+		require.NoError(t, err)
+
+		img, err := random.Image(128, 1)
+		require.NoError(t, err)
+		attestation, err := mutate.Append(empty.Image,
+			mutate.Addendum{
+				Layer:       static.NewLayer([]byte(`{}`), "application/vnd.in-toto+json"),
+				Annotations: map[string]string{"in-toto.io/predicate-type": "https://slsa.dev/provenance/v0.2"},
+			},
+			mutate.Addendum{
+				Layer:       static.NewLayer([]byte(`{"spdxVersion":"SPDX-2.3"}`), "application/vnd.in-toto+json"),
+				Annotations: map[string]string{"in-toto.io/predicate-type": "https://spdx.dev/Document"},
+			},
+		)
+		require.NoError(t, err)
+		index := mutate.AppendManifests(empty.Index,
+			mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+			mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+				Platform:    &v1.Platform{OS: "unknown", Architecture: "unknown"},
+				Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"},
+			}},
+		)
+		require.NoError(t, remote.WriteIndex(ref, index))
+		digest, err := index.Digest()
+		require.NoError(t, err)
+
+		require.Equal(t, []string{sbomFormatSPDX}, lookup(t, ref, digest.String()))
+	})
+}
+
+func Test_sboms(t *testing.T) {
+	fetches := 0
+	var formats []string
+	fetch := func() ([]string, error) {
+		fetches++
+		return formats, nil
+	}
+
+	now := time.Now()
+	s := newSBOMs()
+	s.now = func() time.Time { return now }
+	require.NoError(t, s.record("app:v1", "sha256:1", fetch))
+	// Missing SBOMs are cached for a while.
+	formats = []string{sbomFormatCycloneDX, sbomFormatSPDX}
+	require.NoError(t, s.record("app:v1", "sha256:1", fetch))
+	require.Equal(t, 1, fetches)
+	// Then images without SBOMs are looked up again, SBOMs may be attached later.
+	now = now.Add(missingLookupTTL)
+	require.NoError(t, s.record("app:v1", "sha256:1", fetch))
+	// Found SBOMs are cached for good.
+	now = now.Add(missingLookupTTL)
+	require.NoError(t, s.record("app:v1", "sha256:1", fetch))
+	require.Equal(t, 2, fetches)
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	imageStore.ReconcileImage("app:v1", []store.ContainerInfo{{Namespace: "prod", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}})
+
+	collect := func() []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		s.collect(imageStore, ch)
+		close(ch)
+
+		var ret []prometheus.Metric
+		for m := range ch {
+			ret = append(ret, m)
+		}
+		return ret
+	}
+
+	metrics := collect()
+	require.Len(t, metrics, 3)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	require.Equal(t, 1.0, m.GetGauge().GetValue())
+	require.NoError(t, metrics[2].Write(&m))
+	require.Equal(t, "format", m.GetLabel()[1].GetName())
+	require.Equal(t, sbomFormatSPDX, m.GetLabel()[1].GetValue())
+
+	// Untracked images and their digests are forgotten.
+	imageStore = store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	require.Empty(t, collect())
+	require.Empty(t, s.digests)
+	require.Empty(t, s.values)
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var unavailableImageSeverityDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_unavailable_image_severity",
+	"Severity of the image being unavailable for the container: 0 if it is available, the -cached-image-severity value "+
+		"if the pull policy lets nodes use the image cached on every one of them, 1 otherwise.",
+	[]string{"namespace", "container", "image", "kind", "name", "pull_policy"}, nil,
+)
+
+// nodeImages holds normalized names of the images cached on a node, as reported in the node status.
+type nodeImages struct {
+	name   string
+	images map[string]struct{}
+}
+
+type normalizeFunc func(image string) string
+
+func getNodeImages(normalize normalizeFunc) cache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		if ni, ok := obj.(*nodeImages); ok {
+			return ni, nil
+		}
+
+		node, ok := obj.(*corev1.Node)
+		if !ok {
+			return obj, nil
+		}
+
+		ni := &nodeImages{name: node.Name, images: make(map[string]struct{})}
+		for _, image := range node.Status.Images {
+			for _, imageName := range image.Names {
+				ni.images[normalize(imageName)] = struct{}{}
+			}
+		}
+
+		return ni, nil
+	}
+}
+
+// imageSeverity adjusts the severity of unavailable images according to the pull policy and images cached on nodes.
+type imageSeverity struct {
+	nodeIndexer    cache.Indexer
+	normalize      normalizeFunc
+	cachedSeverity float64
+}
+
+// cachedOnAllNodes reports whether the image is cached on every node. Note that kubelets report a limited number
+// of images in the node status, 50 by default.
+func (s *imageSeverity) cachedOnAllNodes(image string) bool {
+	nodes := s.nodeIndexer.List()
+	if len(nodes) == 0 {
+		return false
+	}
+
+	normalized := s.normalize(image)
+	for _, obj := range nodes {
+		ni, ok := obj.(*nodeImages)
+		if !ok {
+			return false
+		}
+		if _, ok := ni.images[normalized]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *imageSeverity) severity(ci store.ContainerInfo, availMode store.AvailabilityMode, cachedOnAllNodes func() bool) float64 {
+	if availMode == store.Available {
+		return 0
+	}
+
+	if ci.PullPolicy != string(corev1.PullAlways) && cachedOnAllNodes() {
+		return s.cachedSeverity
+	}
+
+	return 1
+}
+
+// collect exports severities of all containers. Whether an image is cached is computed once per image.
+func (s *imageSeverity) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	cached := make(map[string]bool)
+
+	var metrics []prometheus.Metric
+	imageStore.RangeContainers(func(image string, ci store.ContainerInfo, availMode store.AvailabilityMode) {
+		value := s.severity(ci, availMode, func() bool {
+			isCached, ok := cached[image]
+			if !ok {
+				isCached = s.cachedOnAllNodes(image)
+				cached[image] = isCached
+			}
+			return isCached
+		})
+
+		metrics = append(metrics, prometheus.MustNewConstMetric(unavailableImageSeverityDesc, prometheus.GaugeValue, value,
+			ci.Namespace, ci.Container, image, strings.ToLower(ci.ControllerKind), ci.ControllerName, ci.PullPolicy))
+	})
+
+	for _, m := range metrics {
+		ch <- m
+	}
+}
@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_imageSeverity(t *testing.T) {
+	normalize := func(image string) string {
+		ref, err := parseImageName(image, "", false)
+		require.NoError(t, err)
+		return ref.Name()
+	}
+	transform := getNodeImages(normalize)
+	indexer := cache.NewIndexer(func(obj interface{}) (string, error) { return obj.(*nodeImages).name, nil }, cache.Indexers{})
+
+	s := &imageSeverity{nodeIndexer: indexer, normalize: normalize, cachedSeverity: 0.3}
+	require.False(t, s.cachedOnAllNodes("nginx:1.25"))
+
+	for _, node := range []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Status: corev1.NodeStatus{Images: []corev1.ContainerImage{{Names: []string{"docker.io/library/nginx:1.25", "registry.example.com/app:v1"}}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: corev1.NodeStatus{Images: []corev1.ContainerImage{{Names: []string{"docker.io/library/nginx:1.25"}}}}},
+	} {
+		obj, err := transform(node)
+		require.NoError(t, err)
+		require.NoError(t, indexer.Add(obj))
+	}
+
+	require.True(t, s.cachedOnAllNodes("nginx:1.25"))
+	require.False(t, s.cachedOnAllNodes("registry.example.com/app:v1"))
+
+	cached := func() bool { return true }
+	notCached := func() bool { return false }
+
+	require.Equal(t, 0.0, s.severity(store.ContainerInfo{PullPolicy: "IfNotPresent"}, store.Available, notCached))
+	require.Equal(t, 0.3, s.severity(store.ContainerInfo{PullPolicy: "IfNotPresent"}, store.Absent, cached))
+	require.Equal(t, 1.0, s.severity(store.ContainerInfo{PullPolicy: "IfNotPresent"}, store.Absent, notCached))
+	require.Equal(t, 1.0, s.severity(store.ContainerInfo{PullPolicy: "Always"}, store.Absent, cached))
+}
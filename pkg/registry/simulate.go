@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	kubeauth "github.com/google/go-containerregistry/pkg/authn/kubernetes"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+// SimulatedContainer is the availability of the image of a container in a manifest that isn't applied yet.
+type SimulatedContainer struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	Mode      string `json:"mode"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Simulate checks images of the containers and init containers of a pod spec as if it was applied to the namespace:
+// with its image pull secrets, the ones of its service account and of the default service account of the namespace.
+// Results aren't recorded and the images aren't tracked.
+func (rc *Checker) Simulate(namespace string, spec corev1.PodSpec) []SimulatedContainer {
+	refs := rc.controllerIndexers.pullSecretRefs(&controllerWithContainerInfos{
+		ObjectMeta:           metav1.ObjectMeta{Namespace: namespace},
+		pullSecretReferences: spec.ImagePullSecrets,
+		serviceAccountName:   spec.ServiceAccountName,
+	})
+
+	ret := make([]SimulatedContainer, 0, len(spec.InitContainers)+len(spec.Containers))
+	for _, container := range append(append([]corev1.Container(nil), spec.InitContainers...), spec.Containers...) {
+		var keychains []authn.Keychain
+		for _, sk := range rc.controllerIndexers.secretKeychains(container.Image, refs) {
+			keychains = append(keychains, sk.keychain)
+		}
+
+		availMode, err := rc.simulate(container.Image, authn.NewMultiKeychain(keychains...))
+		result := SimulatedContainer{Container: container.Name, Image: container.Image, Mode: availMode.String()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		ret = append(ret, result)
+	}
+
+	return ret
+}
+
+// simulate checks an image once, without side effects on the metrics of tracked images.
+func (rc *Checker) simulate(image string, kc authn.Keychain) (store.AvailabilityMode, error) {
+	if err := detectInvalidReference(image); err != nil {
+		return store.InvalidReference, err
+	}
+
+	ref, err := parseImageName(image, rc.config.defaultRegistry, rc.config.plainHTTP)
+	if err != nil {
+		return store.BadImageName, err
+	}
+	if err := validateGCPReference(ref); err != nil {
+		return store.BadImageName, err
+	}
+
+	result, cacheKey, cached := rc.cachedCheck(logrus.WithField("image_name", image), ref, kc, rc.config.checkPlatform)
+	if cached {
+		return result.availMode, result.err
+	}
+
+	availMode, digest, err := check(ref, kc, rc.fallbackKeychain, rc.registryTransport, rc.config.checkPlatform, rc.manifestGetFallback(ref.Context().RegistryStr()))
+	if IsOldRegistry(err) {
+		availMode = rc.config.oldRegistryMode.availabilityMode()
+	}
+	rc.storeCheck(cacheKey, availMode, digest, err)
+
+	return availMode, err
+}
+
+// OfflineConfig configures a checker of manifests that doesn't connect to a cluster.
+type OfflineConfig struct {
+	SkipVerify      bool
+	StrictTLS       bool
+	PlainHTTP       bool
+	CAPaths         []string
+	DefaultRegistry string
+	CheckPlatform   *v1.Platform
+	OldRegistryMode OldRegistryMode
+	ExporterConfig  *config.Config
+	UserAgent       string
+	SOCKSProxy      string
+	// DockerConfigJSON holds credentials in the format of a kubernetes.io/dockerconfigjson secret. Static credentials
+	// of the config file and the docker config of the exporter are tried as well.
+	DockerConfigJSON []byte
+}
+
+// NewOfflineChecker creates a checker whose Simulate method checks images with the provided credentials instead of
+// pull secrets of a cluster, e.g. in a CD pipeline that has no access to the cluster.
+func NewOfflineChecker(cfg OfflineConfig) (*Checker, error) {
+	tlsConfig, err := newTLSConfig(cfg.SkipVerify, cfg.StrictTLS, cfg.PlainHTTP, cfg.CAPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := parseSOCKSProxy(cfg.SOCKSProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS proxy: %w", err)
+	}
+
+	exporterConfig := cfg.ExporterConfig
+	if exporterConfig == nil {
+		exporterConfig = &config.Config{}
+	}
+
+	var keychains []authn.Keychain
+	if len(cfg.DockerConfigJSON) > 0 {
+		secret := corev1.Secret{
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: cfg.DockerConfigJSON},
+		}
+		if err := pullSecretError(&secret); err != nil {
+			return nil, fmt.Errorf("credentials: %w", err)
+		}
+		kc, err := kubeauth.NewFromPullSecrets(context.TODO(), []corev1.Secret{secret})
+		if err != nil {
+			return nil, fmt.Errorf("credentials: %w", err)
+		}
+		keychains = append(keychains, kc)
+	}
+	if staticKeychain := newStaticKeychain(exporterConfig); staticKeychain != nil {
+		keychains = append(keychains, staticKeychain)
+	}
+
+	return &Checker{
+		registryTransport: newRegistryTransport(tlsConfig, cfg.StrictTLS, exporterConfig, cfg.UserAgent, proxy),
+		fallbackKeychain:  authn.NewMultiKeychain(append(keychains, authn.DefaultKeychain)...),
+		config: registryCheckerConfig{
+			defaultRegistry: cfg.DefaultRegistry,
+			plainHTTP:       cfg.PlainHTTP,
+			checkPlatform:   cfg.CheckPlatform,
+			exporterConfig:  exporterConfig,
+			oldRegistryMode: cfg.OldRegistryMode,
+		},
+	}, nil
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newAuthenticatedRegistry serves a registry with the app:v1 image that requires robot:secret basic auth.
+func newAuthenticatedRegistry(t *testing.T) (host string) {
+	registryHandler := ggcrregistry.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "robot" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registryHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	host = strings.TrimPrefix(srv.URL, "http://")
+
+	ref, err := name.ParseReference(host+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+	img, err := random.Image(128, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img, remote.WithAuth(&authn.Basic{Username: "robot", Password: "secret"})))
+
+	return host
+}
+
+func TestChecker_Simulate(t *testing.T) {
+	host := newAuthenticatedRegistry(t)
+
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, secretIndexer.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(
+			fmt.Sprintf(`{"auths":{%q:{"username":"robot","password":"secret"}}}`, host),
+		)},
+	}))
+	serviceAccountIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, serviceAccountIndexer.Add(&corev1.ServiceAccount{
+		ObjectMeta:       metav1.ObjectMeta{Namespace: "team", Name: "app"},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+	}))
+
+	rc := &Checker{
+		controllerIndexers: ControllerIndexers{
+			serviceAccountIndexer: serviceAccountIndexer,
+			secretStore:           secretIndexer,
+		},
+		fallbackKeychain:  authn.NewMultiKeychain(),
+		registryTransport: http.DefaultTransport,
+		config:            registryCheckerConfig{plainHTTP: true},
+	}
+
+	spec := corev1.PodSpec{
+		ServiceAccountName: "app",
+		InitContainers:     []corev1.Container{{Name: "init", Image: host + "/app:v1"}},
+		Containers: []corev1.Container{
+			{Name: "app", Image: host + "/app:v2"},
+			{Name: "sidecar", Image: host + "/sidecar:{{ .Values.tag }}"},
+		},
+	}
+
+	modes := func(containers []SimulatedContainer) (ret []string) {
+		for _, c := range containers {
+			ret = append(ret, c.Container+"="+c.Mode)
+		}
+		return
+	}
+
+	// Pull secrets of the service account in the namespace are used.
+	require.Equal(t, []string{"init=available", "app=absent", "sidecar=invalid_reference"}, modes(rc.Simulate("team", spec)))
+	require.Equal(t, []string{"init=authentication_failure", "app=authentication_failure", "sidecar=invalid_reference"}, modes(rc.Simulate("other", spec)))
+}
+
+func TestNewOfflineChecker(t *testing.T) {
+	host := newAuthenticatedRegistry(t)
+
+	spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: host + "/app:v1"}}}
+
+	rc, err := NewOfflineChecker(OfflineConfig{
+		PlainHTTP:        true,
+		DockerConfigJSON: []byte(fmt.Sprintf(`{"auths":{%q:{"username":"robot","password":"secret"}}}`, host)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "available", rc.Simulate("team", spec)[0].Mode)
+
+	rc, err = NewOfflineChecker(OfflineConfig{PlainHTTP: true})
+	require.NoError(t, err)
+	require.Equal(t, "authentication_failure", rc.Simulate("team", spec)[0].Mode)
+
+	_, err = NewOfflineChecker(OfflineConfig{DockerConfigJSON: []byte("{")})
+	require.Error(t, err)
+}
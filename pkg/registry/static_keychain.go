@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+// staticKeychain resolves credentials configured for registries in the config file.
+type staticKeychain struct {
+	config *config.Config
+}
+
+func newStaticKeychain(config *config.Config) authn.Keychain {
+	for _, registry := range config.Registries {
+		if registry.HasCredentials() {
+			return &staticKeychain{config: config}
+		}
+	}
+
+	return nil
+}
+
+func (k *staticKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry, ok := k.config.Match(target.RegistryStr())
+	if !ok || !registry.HasCredentials() {
+		return authn.Anonymous, nil
+	}
+
+	return &authn.Basic{Username: registry.Username, Password: registry.Password}, nil
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func Test_staticKeychain(t *testing.T) {
+	require.Nil(t, newStaticKeychain(&config.Config{Registries: []config.Registry{{Host: "registry.example.com"}}}))
+
+	kc := newStaticKeychain(&config.Config{Registries: []config.Registry{
+		{Host: "*.example.com", Username: "mirror", Password: "secret"},
+	}})
+	require.NotNil(t, kc)
+
+	auth, err := kc.Resolve(name.MustParseReference("mirror.example.com/library/alpine:3").Context())
+	require.NoError(t, err)
+	authConfig, err := auth.Authorization()
+	require.NoError(t, err)
+	require.Equal(t, "mirror", authConfig.Username)
+	require.Equal(t, "secret", authConfig.Password)
+
+	auth, err = kc.Resolve(name.MustParseReference("alpine:3").Context())
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, auth)
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+var tagDigestChangesDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_tag_digest_changes_total",
+	"Number of times the floating tag of the image was observed pointing to a different digest.",
+	[]string{"image"}, nil,
+)
+
+type tagDigest struct {
+	digest  string
+	changes uint64
+}
+
+// tagDrift tracks digests of floating tags, such as "stable" or "v1", that upstream may republish without changing
+// the image reference workloads use.
+type tagDrift struct {
+	tags []string
+
+	lock    sync.Mutex
+	digests map[string]*tagDigest
+}
+
+func newTagDrift(tags []string) *tagDrift {
+	return &tagDrift{
+		tags:    tags,
+		digests: make(map[string]*tagDigest),
+	}
+}
+
+// floating reports whether the reference is a tag that is tracked for digest changes.
+func (d *tagDrift) floating(ref name.Reference) bool {
+	tag, ok := ref.(name.Tag)
+	return ok && slices.Contains(d.tags, tag.TagStr())
+}
+
+// record stores the digest the image resolved to and reports whether it differs from the previous one.
+func (d *tagDrift) record(image, digest string) bool {
+	if len(digest) == 0 {
+		return false
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	current, ok := d.digests[image]
+	if !ok {
+		d.digests[image] = &tagDigest{digest: digest}
+		return false
+	}
+	if current.digest == digest {
+		return false
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"image_name":      image,
+		"previous_digest": current.digest,
+		"digest":          digest,
+	}).Info("Floating tag points to a different digest")
+
+	current.digest = digest
+	current.changes++
+
+	return true
+}
+
+// collect exports digest changes of tracked images. Images that aren't referenced by any container anymore are
+// forgotten.
+func (d *tagDrift) collect(imageStore *store.ImageStore, ch chan<- prometheus.Metric) {
+	images := make(map[string]struct{})
+	imageStore.RangeContainers(func(image string, _ store.ContainerInfo, _ store.AvailabilityMode) {
+		images[image] = struct{}{}
+	})
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for image, current := range d.digests {
+		if _, ok := images[image]; !ok {
+			delete(d.digests, image)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(tagDigestChangesDesc, prometheus.CounterValue, float64(current.changes), image)
+	}
+}
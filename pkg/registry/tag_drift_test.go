@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_tagDrift(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New())
+	defer srv.Close()
+
+	image := strings.TrimPrefix(srv.URL, "http://") + "/app:stable"
+	ref, err := name.ParseReference(image, name.Insecure)
+	require.NoError(t, err)
+
+	d := newTagDrift([]string{"stable"})
+	require.True(t, d.floating(ref))
+	require.False(t, d.floating(ref.Context().Tag("v1.2.3")))
+	require.False(t, d.floating(ref.Context().Digest("sha256:0000000000000000000000000000000000000000000000000000000000000000")))
+
+	publish := func() {
+		img, err := random.Image(128, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+	}
+	resolve := func() string {
+		availMode, digest, err := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, nil, false)
+		require.NoError(t, err)
+		require.Equal(t, store.Available, availMode)
+		require.NotEmpty(t, digest)
+		return digest
+	}
+
+	publish()
+	require.False(t, d.record(image, resolve()))
+	require.False(t, d.record(image, resolve()))
+
+	// Upstream republishes the tag.
+	publish()
+	require.True(t, d.record(image, resolve()))
+	require.False(t, d.record(image, ""))
+
+	imageStore := store.NewImageStore(func(string) store.AvailabilityMode { return store.Available }, 1, 1)
+	imageStore.ReconcileImage(image, []store.ContainerInfo{{Namespace: "default", ControllerKind: "DaemonSet", ControllerName: "agent", Container: "agent"}})
+
+	collect := func(imageStore *store.ImageStore) []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		d.collect(imageStore, ch)
+		close(ch)
+
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		return metrics
+	}
+
+	metrics := collect(imageStore)
+	require.Len(t, metrics, 1)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	require.Equal(t, float64(1), m.GetCounter().GetValue())
+
+	// Images that aren't referenced anymore are forgotten.
+	require.Empty(t, collect(store.NewImageStore(nil, 1, 1)))
+	require.False(t, d.record(image, resolve()))
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// strictCipherSuites is the list of approved TLS 1.2 cipher suites used in the strict TLS mode.
+// TLS 1.3 cipher suites are not configurable in Go and are all considered approved.
+var strictCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var (
+	errStrictTLSSkipVerify = errors.New("registry certificate verification can't be skipped in the strict TLS mode")
+	errStrictTLSPlainHTTP  = errors.New("plain HTTP to registries can't be allowed in the strict TLS mode")
+)
+
+func newTLSConfig(skipVerify, strictTLS, plainHTTP bool, caPths []string) (*tls.Config, error) {
+	if strictTLS && skipVerify {
+		return nil, errStrictTLSSkipVerify
+	}
+	if strictTLS && plainHTTP {
+		return nil, errStrictTLSPlainHTTP
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if skipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	} else if len(caPths) > 0 {
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		for _, caPath := range caPths {
+			pemCerts, err := os.ReadFile(caPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open file %q: %w", caPath, err)
+			}
+			if ok := rootCAs.AppendCertsFromPEM(pemCerts); !ok {
+				return nil, fmt.Errorf("error parsing %q content as a PEM encoded certificate", caPath)
+			}
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if strictTLS {
+		tlsConfig.MinVersion = tls.VersionTLS12
+		tlsConfig.CipherSuites = strictCipherSuites
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsLoggingTransport logs negotiated TLS parameters once per registry host, and again whenever they change.
+type tlsLoggingTransport struct {
+	next http.RoundTripper
+
+	seen sync.Map
+}
+
+func newTLSLoggingTransport(next http.RoundTripper) *tlsLoggingTransport {
+	return &tlsLoggingTransport{next: next}
+}
+
+func (t *tlsLoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.TLS == nil {
+		return resp, err
+	}
+
+	params := tlsParams{
+		version:     tls.VersionName(resp.TLS.Version),
+		cipherSuite: tls.CipherSuiteName(resp.TLS.CipherSuite),
+	}
+	if prev, loaded := t.seen.Swap(req.URL.Host, params); !loaded || prev.(tlsParams) != params {
+		logrus.WithFields(logrus.Fields{
+			"registry":     req.URL.Host,
+			"tls_version":  params.version,
+			"cipher_suite": params.cipherSuite,
+		}).Info("Negotiated TLS parameters with registry")
+	}
+
+	return resp, nil
+}
+
+type tlsParams struct {
+	version     string
+	cipherSuite string
+}
@@ -0,0 +1,28 @@
+package registry
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newTLSConfig(t *testing.T) {
+	_, err := newTLSConfig(true, true, false, nil)
+	require.ErrorIs(t, err, errStrictTLSSkipVerify)
+	_, err = newTLSConfig(false, true, true, nil)
+	require.ErrorIs(t, err, errStrictTLSPlainHTTP)
+	_, err = newTLSConfig(false, false, false, []string{"/nonexistent/ca.pem"})
+	require.ErrorContains(t, err, "/nonexistent/ca.pem")
+
+	tlsConfig, err := newTLSConfig(true, false, false, nil)
+	require.NoError(t, err)
+	require.True(t, tlsConfig.InsecureSkipVerify)
+	require.Zero(t, tlsConfig.MinVersion)
+
+	tlsConfig, err = newTLSConfig(false, true, false, nil)
+	require.NoError(t, err)
+	require.False(t, tlsConfig.InsecureSkipVerify)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.Equal(t, strictCipherSuites, tlsConfig.CipherSuites)
+}
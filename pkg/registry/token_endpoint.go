@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+var challengeServiceRegexp = regexp.MustCompile(`(?i)[\s,]service="([^"]*)"`)
+
+// tokenEndpointTransport replaces the authentication challenge of registries with an overridden token endpoint, so
+// that go-containerregistry requests tokens from it. Pull-through caches often serve /v2/ themselves, but either
+// don't send a challenge or send one with a realm that isn't reachable, and checks end up as authentication failures.
+type tokenEndpointTransport struct {
+	config *config.Config
+	next   http.RoundTripper
+}
+
+func newTokenEndpointTransport(config *config.Config, next http.RoundTripper) http.RoundTripper {
+	for _, registry := range config.Registries {
+		if len(registry.TokenEndpoint) > 0 {
+			return &tokenEndpointTransport{config: config, next: next}
+		}
+	}
+
+	return next
+}
+
+func (t *tokenEndpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	registry, ok := t.config.Match(req.URL.Host)
+	if !ok || len(registry.TokenEndpoint) == 0 {
+		return resp, nil
+	}
+
+	service := registry.TokenService
+	if len(service) == 0 {
+		if match := challengeServiceRegexp.FindStringSubmatch(" " + resp.Header.Get("WWW-Authenticate")); match != nil {
+			service = match[1]
+		} else {
+			service = req.URL.Host
+		}
+	}
+	resp.Header.Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q,service=%q", registry.TokenEndpoint, service))
+
+	return resp, nil
+}
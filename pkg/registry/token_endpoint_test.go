@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func Test_tokenEndpointTransport(t *testing.T) {
+	var service string
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service = r.URL.Query().Get("service")
+		_, _ = w.Write([]byte(`{"token": "anonymous"}`))
+	}))
+	defer tokens.Close()
+
+	registryHandler := ggcrregistry.New()
+	var requireToken atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The cache requires tokens issued by another host, but doesn't send a challenge.
+		if requireToken.Load() && r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registryHandler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	ref, err := name.ParseReference(host+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+	img, err := random.Image(128, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	requireToken.Store(true)
+
+	availMode, _, err := check(ref, nil, authn.DefaultKeychain, http.DefaultTransport, nil, false)
+	require.Error(t, err)
+	require.Equal(t, store.AuthnFailure, availMode)
+
+	exporterConfig := &config.Config{Registries: []config.Registry{{Host: host, TokenEndpoint: tokens.URL + "/token"}}}
+	availMode, _, err = check(ref, nil, authn.DefaultKeychain, newTokenEndpointTransport(exporterConfig, http.DefaultTransport), nil, false)
+	require.NoError(t, err)
+	require.Equal(t, store.Available, availMode)
+	require.Equal(t, host, service)
+
+	exporterConfig.Registries[0].TokenService = "cache"
+	_, _, err = check(ref, nil, authn.DefaultKeychain, newTokenEndpointTransport(exporterConfig, http.DefaultTransport), nil, false)
+	require.NoError(t, err)
+	require.Equal(t, "cache", service)
+}
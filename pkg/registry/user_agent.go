@@ -0,0 +1,25 @@
+package registry
+
+import "net/http"
+
+// userAgentTransport identifies the exporter to registries and to identity providers it gets registry tokens from.
+// The User-Agent set by go-containerregistry is overridden, while headers configured for a registry take precedence.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func newUserAgentTransport(userAgent string, next http.RoundTripper) http.RoundTripper {
+	if len(userAgent) == 0 {
+		return next
+	}
+
+	return &userAgentTransport{userAgent: userAgent, next: next}
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	return t.next.RoundTrip(req)
+}
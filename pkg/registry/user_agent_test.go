@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func Test_userAgentTransport(t *testing.T) {
+	var userAgents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	ref, err := name.ParseReference(host+"/app:v1", name.Insecure)
+	require.NoError(t, err)
+
+	transport := newRegistryTransport(nil, false, &config.Config{}, "k8s-image-availability-exporter/v1.0.0 (cluster production)", nil)
+	_, err = remote.Head(ref, remote.WithTransport(transport))
+	require.Error(t, err)
+	require.NotEmpty(t, userAgents)
+	for _, userAgent := range userAgents {
+		require.Equal(t, "k8s-image-availability-exporter/v1.0.0 (cluster production)", userAgent)
+	}
+
+	// A User-Agent configured for the registry takes precedence.
+	userAgents = nil
+	transport = newRegistryTransport(nil, false, &config.Config{Registries: []config.Registry{
+		{Host: host, Headers: map[string]string{"User-Agent": "custom"}},
+	}}, "k8s-image-availability-exporter/v1.0.0", nil)
+	_, err = remote.Head(ref, remote.WithTransport(transport))
+	require.Error(t, err)
+	require.Equal(t, "custom", userAgents[0])
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/validation"
+)
+
+// ValidateConfig checks registry settings the way NewChecker uses them and pings the registry endpoints, as well as
+// hosts of the config file that aren't patterns, without starting any checks.
+func ValidateConfig(
+	report *validation.Report,
+	skipVerify bool,
+	strictTLS bool,
+	plainHTTP bool,
+	caPths []string,
+	defaultRegistry string,
+	workloadIdentity WorkloadIdentityConfig,
+	azureConfigPath string,
+	registryEndpoints []string,
+	exporterConfig *config.Config,
+	userAgent string,
+	socksProxy string,
+) {
+	if len(defaultRegistry) > 0 {
+		_, err := name.NewRegistry(defaultRegistry)
+		report.Check("-default-registry", err)
+	}
+
+	tlsConfig, err := newTLSConfig(skipVerify, strictTLS, plainHTTP, caPths)
+	report.Check("registry TLS settings", err)
+	if err != nil {
+		return
+	}
+
+	proxy, err := parseSOCKSProxy(socksProxy)
+	if len(socksProxy) > 0 {
+		report.Check("-registry-socks-proxy", err)
+	}
+	if err != nil {
+		return
+	}
+
+	registryTransport := newRegistryTransport(tlsConfig, strictTLS, exporterConfig, userAgent, proxy)
+
+	if len(workloadIdentity.Provider) > 0 {
+		_, err := newWorkloadIdentityKeychain(workloadIdentity, registryTransport)
+		report.Check("-workload-identity-provider", err)
+	}
+	if len(azureConfigPath) > 0 {
+		_, err := newAzureConfigKeychain(azureConfigPath, registryTransport)
+		report.Check("-azure-config-path", err)
+	}
+
+	endpoints := append([]string(nil), registryEndpoints...)
+	for _, registry := range exporterConfig.Registries {
+		if !strings.ContainsAny(registry.Host, `*?[\`) {
+			endpoints = append(endpoints, registry.Host)
+		}
+	}
+
+	pinger, err := newRegistryPinger(endpoints, registryTransport)
+	report.Check("-registry-endpoints", err)
+	if err != nil {
+		return
+	}
+
+	for _, endpoint := range pinger.endpoints {
+		report.Check(fmt.Sprintf("registry %s is reachable", endpoint.Host), pinger.ping(endpoint).err)
+	}
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+	"github.com/flant/k8s-image-availability-exporter/pkg/validation"
+)
+
+func TestValidateConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	report := &validation.Report{}
+	ValidateConfig(report, false, false, false, nil, "", WorkloadIdentityConfig{}, "", []string{srv.URL}, &config.Config{
+		Registries: []config.Registry{{Host: "*.example.com"}},
+	}, "", "")
+	require.False(t, report.Failed())
+
+	report = &validation.Report{}
+	ValidateConfig(report, false, false, false, []string{"/nonexistent/ca.pem"}, "", WorkloadIdentityConfig{}, "", []string{srv.URL}, &config.Config{}, "", "")
+	require.True(t, report.Failed())
+
+	report = &validation.Report{}
+	ValidateConfig(report, false, false, false, nil, "", WorkloadIdentityConfig{Provider: "unknown"}, "", nil, &config.Config{}, "", "")
+	require.True(t, report.Failed())
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Write(&buf))
+	require.Contains(t, buf.String(), `FAIL -workload-identity-provider: unknown workload identity provider "unknown"`)
+
+	report = &validation.Report{}
+	ValidateConfig(report, false, false, false, nil, "", WorkloadIdentityConfig{}, "", nil, &config.Config{}, "", "http://proxy:3128")
+	require.True(t, report.Failed())
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+// route is how connections to a registry are made.
+type route struct {
+	serverName  string
+	dialAddress string
+}
+
+// virtualHostTransport overrides the TLS server name, the Host header and the address connections are made to for
+// registries that are fronted by a gateway routing by a name other than the one in the image reference, or that are
+// only reachable through a node-local socket or port. A TLS server name and a dial address can only be set per
+// connection, so requests with overridden ones are sent through a separate clone of the base transport.
+type virtualHostTransport struct {
+	config *config.Config
+	next   http.RoundTripper
+	routes map[route]http.RoundTripper
+}
+
+// newVirtualHostTransport returns the base transport wrapped with wrap, unless the config overrides the TLS server
+// name, the Host header or the dial address of any registry.
+func newVirtualHostTransport(config *config.Config, base *http.Transport, wrap func(http.RoundTripper) http.RoundTripper) http.RoundTripper {
+	t := &virtualHostTransport{
+		config: config,
+		next:   wrap(base),
+		routes: make(map[route]http.RoundTripper),
+	}
+
+	overridden := false
+	for _, registry := range config.Registries {
+		if len(registry.HostHeader) > 0 {
+			overridden = true
+		}
+		r := routeOf(registry)
+		if r == (route{}) {
+			continue
+		}
+		overridden = true
+
+		if _, ok := t.routes[r]; ok {
+			continue
+		}
+		t.routes[r] = wrap(r.transport(base))
+	}
+
+	if !overridden {
+		return t.next
+	}
+
+	return t
+}
+
+func routeOf(registry config.Registry) route {
+	return route{serverName: registry.ServerName, dialAddress: registry.ExpandedDialAddress()}
+}
+
+func (r route) transport(base *http.Transport) *http.Transport {
+	transport := base.Clone()
+
+	if len(r.serverName) > 0 {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.ServerName = r.serverName
+	}
+
+	if len(r.dialAddress) > 0 {
+		// Node-local registries are reached directly, bypassing any proxy.
+		transport.Proxy = nil
+
+		network, address := "tcp", r.dialAddress
+		if path, ok := strings.CutPrefix(r.dialAddress, "unix://"); ok {
+			network, address = "unix", path
+		}
+
+		dialer := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+
+	return transport
+}
+
+func (t *virtualHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	registry, ok := t.config.Match(req.URL.Host)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	if len(registry.HostHeader) > 0 {
+		req = req.Clone(req.Context())
+		req.Host = registry.HostHeader
+	}
+
+	if next, ok := t.routes[routeOf(registry)]; ok {
+		return next.RoundTrip(req)
+	}
+
+	return t.next.RoundTrip(req)
+}
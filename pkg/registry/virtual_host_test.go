@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/config"
+)
+
+func Test_virtualHostTransport(t *testing.T) {
+	var serverName, host string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName, host = r.TLS.ServerName, r.Host
+	}))
+	defer srv.Close()
+
+	base := srv.Client().Transport.(*http.Transport)
+	identity := func(transport http.RoundTripper) http.RoundTripper { return transport }
+
+	// The certificate of the test server is valid for example.com.
+	client := &http.Client{Transport: newVirtualHostTransport(&config.Config{Registries: []config.Registry{
+		{Host: srv.Listener.Addr().String(), ServerName: "example.com", HostHeader: "registry.example.com"},
+	}}, base, identity)}
+
+	resp, err := client.Get(srv.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "example.com", serverName)
+	require.Equal(t, "registry.example.com", host)
+
+	// Without overrides, the base transport is used as is.
+	transport := newVirtualHostTransport(&config.Config{Registries: []config.Registry{{Host: "registry.example.com"}}}, base, identity)
+	require.Same(t, base, transport)
+
+	client.Transport = transport
+	resp, err = client.Get(srv.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, serverName)
+	require.Equal(t, srv.Listener.Addr().String(), host)
+}
+
+func Test_virtualHostTransport_dialAddress(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "registry.sock")
+	socket, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	var host string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	srv.Listener = socket
+	srv.Start()
+	defer srv.Close()
+
+	t.Setenv("REGISTRY_SOCKET", socketPath)
+	client := &http.Client{Transport: newVirtualHostTransport(&config.Config{Registries: []config.Registry{
+		{Host: "registry.local:5000", DialAddress: "unix://${REGISTRY_SOCKET}"},
+	}}, http.DefaultTransport.(*http.Transport), func(transport http.RoundTripper) http.RoundTripper { return transport })}
+
+	resp, err := client.Get("http://registry.local:5000/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "registry.local:5000", host)
+}
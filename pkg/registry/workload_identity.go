@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	WorkloadIdentityGCP   = "gcp"
+	WorkloadIdentityAzure = "azure"
+	WorkloadIdentityOIDC  = "oidc"
+
+	gcpSTSURL               = "https://sts.googleapis.com/v1/token"
+	gcpIAMCredentialsURLFmt = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken"
+	gcpCloudPlatformScope   = "https://www.googleapis.com/auth/cloud-platform"
+	gcpRegistryUsername     = "oauth2accesstoken"
+
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var gcpRegistryPatterns = []string{"gcr.io", "*.gcr.io", "*.pkg.dev"}
+
+// WorkloadIdentityConfig describes how the pod's projected service account token is exchanged for registry
+// credentials.
+type WorkloadIdentityConfig struct {
+	// Provider is one of "gcp", "azure" or "oidc". Workload identity is disabled if it is empty.
+	Provider string
+	// TokenPath is a path to the projected service account token.
+	TokenPath string
+	// Audience is the GCP workload identity provider resource name or the audience of a generic token exchange.
+	Audience string
+	// ServiceAccount is an optional GCP service account to impersonate after the token exchange.
+	ServiceAccount string
+	// ClientID and TenantID identify the Azure application with a federated credential.
+	ClientID string
+	TenantID string
+	// TokenURL is the RFC 8693 token exchange endpoint of a generic OIDC provider.
+	TokenURL string
+	// Registries is a list of registry host patterns to use exchanged credentials for. Defaults to well-known
+	// registry domains for the "gcp" and "azure" providers.
+	Registries []string
+}
+
+type workloadIdentity struct {
+	config WorkloadIdentityConfig
+	tokenClient
+}
+
+type subjectTokenExchangeFunc func(registry, subjectToken string) (authn.AuthConfig, time.Time, error)
+
+func newWorkloadIdentityKeychain(config WorkloadIdentityConfig, transport http.RoundTripper) (*exchangeKeychain, error) {
+	wi := &workloadIdentity{config: config, tokenClient: newTokenClient(transport)}
+
+	var exchange subjectTokenExchangeFunc
+	registries := config.Registries
+
+	switch config.Provider {
+	case WorkloadIdentityGCP:
+		if len(config.Audience) == 0 {
+			return nil, fmt.Errorf("workload identity audience is required for the %q provider", config.Provider)
+		}
+		exchange = wi.exchangeGCP
+		if len(registries) == 0 {
+			registries = gcpRegistryPatterns
+		}
+	case WorkloadIdentityAzure:
+		if len(config.ClientID) == 0 || len(config.TenantID) == 0 {
+			return nil, fmt.Errorf("workload identity client ID and tenant ID are required for the %q provider", config.Provider)
+		}
+		exchange = wi.exchangeAzure
+		if len(registries) == 0 {
+			registries = azureRegistryPatterns
+		}
+	case WorkloadIdentityOIDC:
+		if len(config.TokenURL) == 0 || len(config.Registries) == 0 {
+			return nil, fmt.Errorf("workload identity token URL and registries are required for the %q provider", config.Provider)
+		}
+		exchange = wi.exchangeOIDC
+	default:
+		return nil, fmt.Errorf("unknown workload identity provider %q", config.Provider)
+	}
+
+	return newExchangeKeychain("workload_identity", registries, func(registry string) (authn.AuthConfig, time.Time, error) {
+		subjectToken, err := os.ReadFile(config.TokenPath)
+		if err != nil {
+			return authn.AuthConfig{}, time.Time{}, fmt.Errorf("failed to read workload identity token: %w", err)
+		}
+
+		return exchange(registry, strings.TrimSpace(string(subjectToken)))
+	}), nil
+}
+
+func (wi *workloadIdentity) exchangeGCP(_, subjectToken string) (authn.AuthConfig, time.Time, error) {
+	var stsResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err := wi.postForm(gcpSTSURL, url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"audience":             {wi.config.Audience},
+		"scope":                {gcpCloudPlatformScope},
+		"requested_token_type": {accessTokenType},
+		"subject_token_type":   {jwtTokenType},
+		"subject_token":        {subjectToken},
+	}, &stsResp)
+	if err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
+	}
+
+	accessToken := stsResp.AccessToken
+	expiresAt := time.Now().Add(time.Duration(stsResp.ExpiresIn) * time.Second)
+
+	if len(wi.config.ServiceAccount) > 0 {
+		var iamResp struct {
+			AccessToken string    `json:"accessToken"`
+			ExpireTime  time.Time `json:"expireTime"`
+		}
+		body, _ := json.Marshal(map[string][]string{"scope": {gcpCloudPlatformScope}})
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(gcpIAMCredentialsURLFmt, url.PathEscape(wi.config.ServiceAccount)), strings.NewReader(string(body)))
+		if err != nil {
+			return authn.AuthConfig{}, time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		if err := wi.do(req, &iamResp); err != nil {
+			return authn.AuthConfig{}, time.Time{}, err
+		}
+
+		accessToken = iamResp.AccessToken
+		expiresAt = iamResp.ExpireTime
+	}
+
+	return authn.AuthConfig{Username: gcpRegistryUsername, Password: accessToken}, expiresAt, nil
+}
+
+func (wi *workloadIdentity) exchangeAzure(registry, subjectToken string) (authn.AuthConfig, time.Time, error) {
+	var aadResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := wi.postForm(fmt.Sprintf(azureAuthorityURLFmt, azurePublicCloudAuthorityHost, url.PathEscape(wi.config.TenantID)), url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {wi.config.ClientID},
+		"scope":                 {azureManagementScope},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {subjectToken},
+	}, &aadResp)
+	if err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
+	}
+
+	return exchangeACRRefreshToken(wi.tokenClient, registry, wi.config.TenantID, aadResp.AccessToken)
+}
+
+func (wi *workloadIdentity) exchangeOIDC(_, subjectToken string) (authn.AuthConfig, time.Time, error) {
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"requested_token_type": {accessTokenType},
+		"subject_token_type":   {jwtTokenType},
+		"subject_token":        {subjectToken},
+	}
+	if len(wi.config.Audience) > 0 {
+		form.Set("audience", wi.config.Audience)
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := wi.postForm(wi.config.TokenURL, form, &resp); err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
+	}
+
+	return authn.AuthConfig{RegistryToken: resp.AccessToken}, time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second), nil
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_workloadIdentityKeychain(t *testing.T) {
+	var exchanges int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, tokenExchangeGrantType, r.PostForm.Get("grant_type"))
+		require.Equal(t, "sa-token", r.PostForm.Get("subject_token"))
+		require.Equal(t, "registry", r.PostForm.Get("audience"))
+
+		exchanges++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "registry-token", "expires_in": 3600})
+	}))
+	defer srv.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("sa-token\n"), 0o600))
+
+	_, err := newWorkloadIdentityKeychain(WorkloadIdentityConfig{Provider: WorkloadIdentityOIDC}, srv.Client().Transport)
+	require.Error(t, err)
+
+	kc, err := newWorkloadIdentityKeychain(WorkloadIdentityConfig{
+		Provider:   WorkloadIdentityOIDC,
+		TokenPath:  tokenPath,
+		Audience:   "registry",
+		TokenURL:   srv.URL,
+		Registries: []string{"*.example.com"},
+	}, srv.Client().Transport)
+	require.NoError(t, err)
+
+	auth, err := kc.Resolve(name.MustParseReference("registry.example.com/test:test").Context())
+	require.NoError(t, err)
+	authConfig, err := auth.Authorization()
+	require.NoError(t, err)
+	require.Equal(t, "registry-token", authConfig.RegistryToken)
+
+	// Credentials are cached until they are about to expire.
+	_, err = kc.Resolve(name.MustParseReference("registry.example.com/test:test").Context())
+	require.NoError(t, err)
+	require.Equal(t, 1, exchanges)
+
+	auth, err = kc.Resolve(name.MustParseReference("docker.io/test:test").Context())
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, auth)
+}
@@ -0,0 +1,218 @@
+// Package reports maintains ImageAvailabilityReport objects summarizing unavailable images of every namespace, so
+// that tenants without access to Prometheus can see them with kubectl.
+package reports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	// Name is the name of the report in every namespace.
+	Name = "images"
+
+	ConditionImagesAvailable = "ImagesAvailable"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "k8s-image-availability-exporter"
+
+	// maxUnavailableImages limits the size of a report, the number of unavailable images is reported in full.
+	maxUnavailableImages = 500
+)
+
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "k8s-image-availability-exporter.flant.com",
+	Version:  "v1alpha1",
+	Resource: "imageavailabilityreports",
+}
+
+// UnavailableImage is an image that isn't available, along with containers referencing it as "kind/name/container".
+type UnavailableImage struct {
+	Image            string   `json:"image"`
+	AvailabilityMode string   `json:"availabilityMode"`
+	Containers       []string `json:"containers"`
+}
+
+// Status is the status of an ImageAvailabilityReport.
+type Status struct {
+	Conditions        []metav1.Condition `json:"conditions,omitempty"`
+	Images            int                `json:"images"`
+	Unavailable       int                `json:"unavailable"`
+	UnavailableImages []UnavailableImage `json:"unavailableImages,omitempty"`
+}
+
+// Reporter keeps a report in every namespace with tracked images and deletes reports of namespaces without them.
+type Reporter struct {
+	client          dynamic.Interface
+	rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+	now             func() time.Time
+}
+
+func New(client dynamic.Interface, rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))) *Reporter {
+	return &Reporter{client: client, rangeContainers: rangeContainers, now: time.Now}
+}
+
+// Run syncs the reports every interval until stopCh is closed.
+func (r *Reporter) Run(interval time.Duration, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := r.Sync(context.TODO()); err != nil {
+			logrus.Warnf("Failed to sync image availability reports: %v", err)
+		}
+	}, interval, stopCh)
+}
+
+// Sync creates, updates and deletes reports to match the current availability of images.
+func (r *Reporter) Sync(ctx context.Context) error {
+	desired := r.statuses()
+
+	existing, err := r.client.Resource(GroupVersionResource).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list reports: %w", err)
+	}
+
+	var errs []error
+	for i := range existing.Items {
+		report := &existing.Items[i]
+		namespace := report.GetNamespace()
+		status, ok := desired[namespace]
+		if !ok || report.GetName() != Name {
+			err := r.client.Resource(GroupVersionResource).Namespace(namespace).Delete(ctx, report.GetName(), metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to delete report %s/%s: %w", namespace, report.GetName(), err))
+			}
+			continue
+		}
+		delete(desired, namespace)
+
+		if err := r.updateStatus(ctx, report, status); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update report %s/%s: %w", namespace, report.GetName(), err))
+		}
+	}
+
+	for namespace, status := range desired {
+		report := &unstructured.Unstructured{}
+		report.SetGroupVersionKind(GroupVersionResource.GroupVersion().WithKind("ImageAvailabilityReport"))
+		report.SetNamespace(namespace)
+		report.SetName(Name)
+		report.SetLabels(map[string]string{managedByLabel: managedBy})
+
+		created, err := r.client.Resource(GroupVersionResource).Namespace(namespace).Create(ctx, report, metav1.CreateOptions{})
+		if err == nil {
+			err = r.updateStatus(ctx, created, status)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create report %s/%s: %w", namespace, Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// updateStatus updates the status of the report unless it's up-to-date already. The transition time of the condition
+// is preserved unless its status changes.
+func (r *Reporter) updateStatus(ctx context.Context, report *unstructured.Unstructured, status Status) error {
+	var current Status
+	if currentObj, ok := report.Object["status"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(currentObj, &current); err != nil {
+			logrus.WithField("namespace", report.GetNamespace()).Debugf("Overwriting the malformed report status: %v", err)
+			current = Status{}
+		}
+	}
+
+	status.Conditions = current.Conditions
+	meta.SetStatusCondition(&status.Conditions, r.condition(status))
+	if equality.Semantic.DeepEqual(current, status) {
+		return nil
+	}
+
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	report.Object["status"] = statusObj
+
+	_, err = r.client.Resource(GroupVersionResource).Namespace(report.GetNamespace()).UpdateStatus(ctx, report, metav1.UpdateOptions{})
+	return err
+}
+
+func (r *Reporter) condition(status Status) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionImagesAvailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             "AllImagesAvailable",
+		Message:            fmt.Sprintf("All %d images are available", status.Images),
+		LastTransitionTime: metav1.NewTime(r.now().Truncate(time.Second)),
+	}
+	if status.Unavailable > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ImagesUnavailable"
+		condition.Message = fmt.Sprintf("%d of %d images are unavailable", status.Unavailable, status.Images)
+	}
+
+	return condition
+}
+
+// statuses returns the desired status of the report of every namespace with tracked images.
+func (r *Reporter) statuses() map[string]Status {
+	type imageKey struct {
+		namespace string
+		image     string
+	}
+
+	images := make(map[imageKey]*UnavailableImage)
+	r.rangeContainers(func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode) {
+		key := imageKey{namespace: containerInfo.Namespace, image: image}
+		unavailableImage, ok := images[key]
+		if !ok {
+			unavailableImage = &UnavailableImage{Image: image, AvailabilityMode: availMode.String()}
+			images[key] = unavailableImage
+		}
+		unavailableImage.Containers = append(unavailableImage.Containers,
+			strings.ToLower(containerInfo.ControllerKind)+"/"+containerInfo.ControllerName+"/"+containerInfo.Container)
+	})
+
+	unavailable := make(map[string][]UnavailableImage)
+	ret := make(map[string]Status)
+	for key, image := range images {
+		status := ret[key.namespace]
+		status.Images++
+		if image.AvailabilityMode != store.Available.String() {
+			status.Unavailable++
+			sort.Strings(image.Containers)
+			unavailable[key.namespace] = append(unavailable[key.namespace], *image)
+		}
+		ret[key.namespace] = status
+	}
+
+	for namespace, images := range unavailable {
+		sort.Slice(images, func(i, j int) bool { return images[i].Image < images[j].Image })
+		if len(images) > maxUnavailableImages {
+			images = images[:maxUnavailableImages]
+		}
+
+		status := ret[namespace]
+		status.UnavailableImages = images
+		ret[namespace] = status
+	}
+
+	return ret
+}
@@ -0,0 +1,91 @@
+package reports
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+type testContainer struct {
+	image         string
+	containerInfo store.ContainerInfo
+	availMode     store.AvailabilityMode
+}
+
+func getStatus(t *testing.T, reporter *Reporter, namespace string) Status {
+	t.Helper()
+
+	report, err := reporter.client.Resource(GroupVersionResource).Namespace(namespace).Get(context.TODO(), Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	var status Status
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(report.Object["status"].(map[string]interface{}), &status))
+	return status
+}
+
+func TestReporter_Sync(t *testing.T) {
+	stale := &unstructured.Unstructured{}
+	stale.SetGroupVersionKind(GroupVersionResource.GroupVersion().WithKind("ImageAvailabilityReport"))
+	stale.SetNamespace("removed")
+	stale.SetName(Name)
+	stale.SetLabels(map[string]string{managedByLabel: managedBy})
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{GroupVersionResource: "ImageAvailabilityReportList"}, stale)
+
+	containers := []testContainer{
+		{"registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "app"}, store.Absent},
+		{"registry.example.com/app:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "CronJob", ControllerName: "migrate", Container: "migrate"}, store.Absent},
+		{"registry.example.com/sidecar:v1", store.ContainerInfo{Namespace: "tenant", ControllerKind: "Deployment", ControllerName: "app", Container: "sidecar"}, store.Available},
+		{"registry.example.com/web:v1", store.ContainerInfo{Namespace: "web", ControllerKind: "Deployment", ControllerName: "web", Container: "web"}, store.Available},
+	}
+	reporter := New(client, func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		for _, c := range containers {
+			f(c.image, c.containerInfo, c.availMode)
+		}
+	})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reporter.now = func() time.Time { return now }
+
+	require.NoError(t, reporter.Sync(context.TODO()))
+
+	_, err := client.Resource(GroupVersionResource).Namespace("removed").Get(context.TODO(), Name, metav1.GetOptions{})
+	require.Error(t, err)
+
+	status := getStatus(t, reporter, "tenant")
+	require.Equal(t, 2, status.Images)
+	require.Equal(t, 1, status.Unavailable)
+	require.Equal(t, []UnavailableImage{{
+		Image:            "registry.example.com/app:v1",
+		AvailabilityMode: "absent",
+		Containers:       []string{"cronjob/migrate/migrate", "deployment/app/app"},
+	}}, status.UnavailableImages)
+	require.Len(t, status.Conditions, 1)
+	require.Equal(t, metav1.ConditionFalse, status.Conditions[0].Status)
+	require.Equal(t, "1 of 2 images are unavailable", status.Conditions[0].Message)
+
+	status = getStatus(t, reporter, "web")
+	require.Equal(t, metav1.ConditionTrue, status.Conditions[0].Status)
+	require.Empty(t, status.UnavailableImages)
+
+	// The transition time only changes along with the status of the condition.
+	now = now.Add(time.Hour)
+	require.NoError(t, reporter.Sync(context.TODO()))
+	require.True(t, getStatus(t, reporter, "tenant").Conditions[0].LastTransitionTime.Equal(&metav1.Time{Time: now.Add(-time.Hour)}))
+
+	containers[0].availMode, containers[1].availMode = store.Available, store.Available
+	require.NoError(t, reporter.Sync(context.TODO()))
+	status = getStatus(t, reporter, "tenant")
+	require.Zero(t, status.Unavailable)
+	require.Equal(t, metav1.ConditionTrue, status.Conditions[0].Status)
+	require.True(t, status.Conditions[0].LastTransitionTime.Equal(&metav1.Time{Time: now}))
+}
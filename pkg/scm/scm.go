@@ -0,0 +1,316 @@
+// Package scm reports verdicts of manifest verification to source code management systems, so that GitOps
+// repositories get a commit status and a merge request note telling whether the images they reference are available.
+package scm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+
+	DefaultStatusName = "k8s-image-availability-exporter"
+
+	defaultGitHubAPIURL = "https://api.github.com"
+	defaultGitLabAPIURL = "https://gitlab.com/api/v4"
+
+	// maxNoteRows limits the number of unavailable containers listed in a note.
+	maxNoteRows = 50
+	// notesPerPage is the page size of listed notes, the maximum both APIs allow.
+	notesPerPage = 100
+)
+
+// Config configures a reporter.
+type Config struct {
+	// Provider is either "github" or "gitlab".
+	Provider string
+	// APIURL defaults to the API of github.com or gitlab.com.
+	APIURL string
+	// Repository is "owner/name" on GitHub, and a project ID or path on GitLab.
+	Repository string
+	// Commit is the SHA of the commit to set the status of.
+	Commit string
+	// MergeRequest is the number of a pull request on GitHub, or the IID of a merge request on GitLab, to add a note
+	// with the verdict to. The note added by a previous report with the same StatusName is updated instead, if any.
+	// No note is added if it's 0.
+	MergeRequest int
+	Token        string
+	// StatusName is the context of the status on GitHub, and its name on GitLab.
+	StatusName string
+	// TargetURL is linked from the status, e.g. the URL of the CI job.
+	TargetURL string
+	Timeout   time.Duration
+}
+
+// Reporter posts the verdict of a report.
+type Reporter interface {
+	Report(ctx context.Context, report manifests.Report) error
+}
+
+// New creates a reporter of the provider.
+func New(config Config) (Reporter, error) {
+	if len(config.Repository) == 0 {
+		return nil, fmt.Errorf("a repository is required")
+	}
+	if len(config.Commit) == 0 {
+		return nil, fmt.Errorf("a commit is required")
+	}
+	if config.MergeRequest < 0 {
+		return nil, fmt.Errorf("invalid merge request %d", config.MergeRequest)
+	}
+	if len(config.StatusName) == 0 {
+		config.StatusName = DefaultStatusName
+	}
+
+	client := &client{http: &http.Client{Timeout: config.Timeout}, header: http.Header{}}
+	switch config.Provider {
+	case ProviderGitHub:
+		owner, name, ok := strings.Cut(config.Repository, "/")
+		if !ok || len(owner) == 0 || len(name) == 0 || strings.Contains(name, "/") {
+			return nil, fmt.Errorf(`GitHub repository must be "owner/name", got %q`, config.Repository)
+		}
+		if len(config.APIURL) == 0 {
+			config.APIURL = defaultGitHubAPIURL
+		}
+		client.header.Set("Accept", "application/vnd.github+json")
+		if len(config.Token) > 0 {
+			client.header.Set("Authorization", "Bearer "+config.Token)
+		}
+		return &gitHubReporter{config: config, client: client}, nil
+	case ProviderGitLab:
+		if len(config.APIURL) == 0 {
+			config.APIURL = defaultGitLabAPIURL
+		}
+		if len(config.Token) > 0 {
+			client.header.Set("PRIVATE-TOKEN", config.Token)
+		}
+		return &gitLabReporter{config: config, client: client}, nil
+	}
+
+	return nil, fmt.Errorf(`unknown provider %q, must be "github" or "gitlab"`, config.Provider)
+}
+
+type gitHubReporter struct {
+	config Config
+	client *client
+}
+
+// Report sets a commit status and adds or updates a pull request comment if a pull request is configured.
+func (r *gitHubReporter) Report(ctx context.Context, report manifests.Report) error {
+	repo := strings.TrimSuffix(r.config.APIURL, "/") + "/repos/" + r.config.Repository
+
+	state := "success"
+	if !report.Available {
+		state = "failure"
+	}
+	if err := r.client.do(ctx, http.MethodPost, repo+"/statuses/"+url.PathEscape(r.config.Commit), map[string]string{
+		"state":       state,
+		"context":     r.config.StatusName,
+		"description": Description(report),
+		"target_url":  r.config.TargetURL,
+	}, nil); err != nil {
+		return fmt.Errorf("setting the commit status: %w", err)
+	}
+
+	if r.config.MergeRequest == 0 {
+		return nil
+	}
+	comments := fmt.Sprintf("%s/issues/%d/comments", repo, r.config.MergeRequest)
+	id, err := r.client.findNote(ctx, comments, noteMarker(r.config.StatusName))
+	if err != nil {
+		return fmt.Errorf("finding the previous pull request comment: %w", err)
+	}
+	body := map[string]string{"body": Note(r.config.StatusName, r.config.Commit, report)}
+	if id == 0 {
+		err = r.client.do(ctx, http.MethodPost, comments, body, nil)
+	} else {
+		err = r.client.do(ctx, http.MethodPatch, fmt.Sprintf("%s/issues/comments/%d", repo, id), body, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("commenting the pull request: %w", err)
+	}
+
+	return nil
+}
+
+type gitLabReporter struct {
+	config Config
+	client *client
+}
+
+// Report sets a commit status and adds or updates a merge request note if a merge request is configured.
+func (r *gitLabReporter) Report(ctx context.Context, report manifests.Report) error {
+	project := strings.TrimSuffix(r.config.APIURL, "/") + "/projects/" + url.PathEscape(r.config.Repository)
+
+	state := "success"
+	if !report.Available {
+		state = "failed"
+	}
+	if err := r.client.do(ctx, http.MethodPost, project+"/statuses/"+url.PathEscape(r.config.Commit), map[string]string{
+		"state":       state,
+		"name":        r.config.StatusName,
+		"description": Description(report),
+		"target_url":  r.config.TargetURL,
+	}, nil); err != nil {
+		return fmt.Errorf("setting the commit status: %w", err)
+	}
+
+	if r.config.MergeRequest == 0 {
+		return nil
+	}
+	notes := fmt.Sprintf("%s/merge_requests/%d/notes", project, r.config.MergeRequest)
+	id, err := r.client.findNote(ctx, notes, noteMarker(r.config.StatusName))
+	if err != nil {
+		return fmt.Errorf("finding the previous merge request note: %w", err)
+	}
+	body := map[string]string{"body": Note(r.config.StatusName, r.config.Commit, report)}
+	if id == 0 {
+		err = r.client.do(ctx, http.MethodPost, notes, body, nil)
+	} else {
+		err = r.client.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", notes, id), body, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("adding the merge request note: %w", err)
+	}
+
+	return nil
+}
+
+// Description summarizes the verdict in a line, e.g. the description of a commit status.
+func Description(report manifests.Report) string {
+	total := 0
+	for _, obj := range report.Objects {
+		total += len(obj.Containers)
+	}
+
+	description := fmt.Sprintf("All %d images are available", total)
+	if unavailable := report.Unavailable(); unavailable > 0 {
+		description = fmt.Sprintf("%d of %d images are unavailable", unavailable, total)
+	}
+
+	return description
+}
+
+// Note renders the verdict as Markdown with a table of containers whose images are unavailable. It starts with a
+// hidden marker of the title, so that the note of a later report with the same title replaces it.
+func Note(title, commit string, report manifests.Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n#### %s: %s\n\nImages of %s were checked before they are applied.\n", noteMarker(title), title, Description(report), commit)
+	if report.Available {
+		return b.String()
+	}
+
+	b.WriteString("\n| Object | Container | Image | Availability |\n| --- | --- | --- | --- |\n")
+	rows := 0
+	for _, obj := range report.Objects {
+		for _, container := range obj.Containers {
+			if container.Mode == store.Available.String() {
+				continue
+			}
+			if rows++; rows > maxNoteRows {
+				continue
+			}
+			fmt.Fprintf(&b, "| %s %s/%s | %s | `%s` | %s |\n", obj.Kind, obj.Namespace, obj.Name, container.Container, escapeCell(container.Image), container.Mode)
+		}
+	}
+	if rows > maxNoteRows {
+		fmt.Fprintf(&b, "\n%d more containers are omitted.\n", rows-maxNoteRows)
+	}
+
+	return b.String()
+}
+
+// noteMarker is an HTML comment identifying notes of the title, which isn't rendered.
+func noteMarker(title string) string {
+	return fmt.Sprintf("<!-- %s: %s -->", DefaultStatusName, strings.ReplaceAll(title, "--", "- -"))
+}
+
+// escapeCell keeps an invalid image reference from breaking the table.
+func escapeCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "`", "'", "\n", " ").Replace(s)
+}
+
+type client struct {
+	http   *http.Client
+	header http.Header
+}
+
+// findNote returns the ID of the first note or comment listed at endpoint whose body starts with marker, or 0 if
+// there's none. Both GitHub and GitLab list them as objects with "id" and "body" fields.
+func (c *client) findNote(ctx context.Context, endpoint, marker string) (int64, error) {
+	for page := 1; ; page++ {
+		var notes []struct {
+			ID   int64  `json:"id"`
+			Body string `json:"body"`
+		}
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s?per_page=%d&page=%d", endpoint, notesPerPage, page), nil, &notes); err != nil {
+			return 0, err
+		}
+
+		for _, note := range notes {
+			if strings.HasPrefix(note.Body, marker) {
+				return note.ID, nil
+			}
+		}
+		if len(notes) < notesPerPage {
+			return 0, nil
+		}
+	}
+}
+
+// do sends body, if any, as JSON without its empty values, and decodes the response into ret, if it isn't nil.
+func (c *client) do(ctx context.Context, method, endpoint string, body map[string]string, ret interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		for key, value := range body {
+			if len(value) == 0 {
+				delete(body, key)
+			}
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, payload)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header.Clone()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if ret != nil {
+		if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+			return fmt.Errorf("failed to decode the response of %s: %w", req.URL.Redacted(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package scm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/manifests"
+	"github.com/flant/k8s-image-availability-exporter/pkg/registry"
+)
+
+var failedReport = manifests.Report{
+	Available: false,
+	Objects: []manifests.Object{{
+		Kind:      "Deployment",
+		Namespace: "prod",
+		Name:      "app",
+		Containers: []registry.SimulatedContainer{
+			{Container: "app", Image: "registry.example.com/app@sha256:0123", Mode: "absent"},
+			{Container: "sidecar", Image: "registry.example.com/sidecar:v1", Mode: "available"},
+		},
+	}},
+}
+
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]string
+}
+
+type note struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// newServer responds with status, and with the next of pages of notes to listings.
+func newServer(t *testing.T, status int, pages ...[]note) (*httptest.Server, *[]request) {
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{method: r.Method, path: r.URL.EscapedPath(), header: r.Header}
+		if r.Method == http.MethodGet {
+			req.path += "?" + r.URL.RawQuery
+		} else {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req.body))
+		}
+		requests = append(requests, req)
+
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			page := []note{}
+			if len(pages) > 0 {
+				page, pages = pages[0], pages[1:]
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(page))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestGitHubReporter(t *testing.T) {
+	srv, requests := newServer(t, http.StatusCreated)
+
+	reporter, err := New(Config{Provider: ProviderGitHub, APIURL: srv.URL, Repository: "flant/apps", Commit: "abc123", MergeRequest: 7, Token: "token"})
+	require.NoError(t, err)
+	require.NoError(t, reporter.Report(context.Background(), failedReport))
+
+	require.Len(t, *requests, 3)
+	status := (*requests)[0]
+	require.Equal(t, "/repos/flant/apps/statuses/abc123", status.path)
+	require.Equal(t, "Bearer token", status.header.Get("Authorization"))
+	require.Equal(t, map[string]string{"state": "failure", "context": DefaultStatusName, "description": "1 of 2 images are unavailable"}, status.body)
+
+	require.Equal(t, "/repos/flant/apps/issues/7/comments?per_page=100&page=1", (*requests)[1].path)
+	comment := (*requests)[2]
+	require.Equal(t, http.MethodPost, comment.method)
+	require.Equal(t, "/repos/flant/apps/issues/7/comments", comment.path)
+	require.True(t, strings.HasPrefix(comment.body["body"], "<!-- k8s-image-availability-exporter: k8s-image-availability-exporter -->\n"))
+	require.Contains(t, comment.body["body"], "| Deployment prod/app | app | `registry.example.com/app@sha256:0123` | absent |")
+	require.NotContains(t, comment.body["body"], "sidecar")
+}
+
+func TestGitLabReporter(t *testing.T) {
+	srv, requests := newServer(t, http.StatusCreated)
+
+	reporter, err := New(Config{Provider: ProviderGitLab, APIURL: srv.URL, Repository: "group/apps", Commit: "abc123", Token: "token", StatusName: "images", TargetURL: "https://ci.example.com/1"})
+	require.NoError(t, err)
+	require.NoError(t, reporter.Report(context.Background(), manifests.Report{Available: true}))
+
+	// No note is added without a merge request.
+	require.Len(t, *requests, 1)
+	status := (*requests)[0]
+	require.Equal(t, "/projects/group%2Fapps/statuses/abc123", status.path)
+	require.Equal(t, "token", status.header.Get("PRIVATE-TOKEN"))
+	require.Equal(t, map[string]string{"state": "success", "name": "images", "description": "All 0 images are available", "target_url": "https://ci.example.com/1"}, status.body)
+}
+
+func TestGitHubReporter_updatesComment(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, []note{
+		{ID: 41, Body: "LGTM"},
+		{ID: 42, Body: Note(DefaultStatusName, "abc122", failedReport)},
+	})
+
+	reporter, err := New(Config{Provider: ProviderGitHub, APIURL: srv.URL, Repository: "flant/apps", Commit: "abc123", MergeRequest: 7})
+	require.NoError(t, err)
+	require.NoError(t, reporter.Report(context.Background(), manifests.Report{Available: true}))
+
+	require.Len(t, *requests, 3)
+	comment := (*requests)[2]
+	require.Equal(t, http.MethodPatch, comment.method)
+	require.Equal(t, "/repos/flant/apps/issues/comments/42", comment.path)
+	require.Contains(t, comment.body["body"], "All 0 images are available")
+}
+
+func TestGitLabReporter_updatesNote(t *testing.T) {
+	// Notes of other status names are kept, and the previous note is found on the second page.
+	firstPage := make([]note, notesPerPage)
+	for i := range firstPage {
+		firstPage[i] = note{ID: int64(i + 1), Body: Note("other", "abc122", failedReport)}
+	}
+	srv, requests := newServer(t, http.StatusOK, firstPage, []note{{ID: 142, Body: Note("images", "abc122", failedReport)}})
+
+	reporter, err := New(Config{Provider: ProviderGitLab, APIURL: srv.URL, Repository: "group/apps", Commit: "abc123", MergeRequest: 3, StatusName: "images"})
+	require.NoError(t, err)
+	require.NoError(t, reporter.Report(context.Background(), failedReport))
+
+	require.Len(t, *requests, 4)
+	require.Equal(t, "/projects/group%2Fapps/merge_requests/3/notes?per_page=100&page=1", (*requests)[1].path)
+	require.Equal(t, "/projects/group%2Fapps/merge_requests/3/notes?per_page=100&page=2", (*requests)[2].path)
+	note := (*requests)[3]
+	require.Equal(t, http.MethodPut, note.method)
+	require.Equal(t, "/projects/group%2Fapps/merge_requests/3/notes/142", note.path)
+	require.Contains(t, note.body["body"], "abc123")
+}
+
+func TestReporter_error(t *testing.T) {
+	srv, _ := newServer(t, http.StatusForbidden)
+
+	reporter, err := New(Config{Provider: ProviderGitLab, APIURL: srv.URL, Repository: "42", Commit: "abc123"})
+	require.NoError(t, err)
+	require.ErrorContains(t, reporter.Report(context.Background(), failedReport), "403 Forbidden")
+}
+
+func TestNew(t *testing.T) {
+	for _, config := range []Config{
+		{Provider: "bitbucket", Repository: "flant/apps", Commit: "abc123"},
+		{Provider: ProviderGitHub, Repository: "apps", Commit: "abc123"},
+		{Provider: ProviderGitHub, Repository: "flant/apps"},
+		{Provider: ProviderGitLab, Commit: "abc123"},
+		{Provider: ProviderGitLab, Repository: "42", Commit: "abc123", MergeRequest: -1},
+	} {
+		_, err := New(config)
+		require.Error(t, err, "%+v", config)
+	}
+}
+
+func TestNote(t *testing.T) {
+	report := manifests.Report{Objects: []manifests.Object{{Kind: "Pod", Namespace: "default", Name: "p"}}}
+	for i := 0; i < maxNoteRows+2; i++ {
+		report.Objects[0].Containers = append(report.Objects[0].Containers, registry.SimulatedContainer{Container: "c", Image: "bad|image", Mode: "bad_image_format"})
+	}
+
+	note := Note("images", "abc123", report)
+	require.Equal(t, maxNoteRows, strings.Count(note, "`bad\\|image`"))
+	require.Contains(t, note, "2 more containers are omitted.")
+}
@@ -0,0 +1,180 @@
+// Package slo tracks a service level objective of image availability, so that burn rates and the remaining error
+// budget are exported without recording rules over long ranges of the availability metrics.
+package slo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+const sampleInterval = time.Minute
+
+// burnRateWindows are the windows of multiwindow burn rate alerts from the Google SRE workbook. Only the ones
+// shorter than the SLO window are exported, along with the SLO window itself.
+var burnRateWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 3 * 24 * time.Hour}
+
+var (
+	objectiveDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_slo_objective",
+		"Objective of the ratio of available images.",
+		nil, nil,
+	)
+	availabilityDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_slo_availability",
+		"Ratio of available images over the window, sampled every minute.",
+		[]string{"window"}, nil,
+	)
+	burnRateDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_slo_burn_rate",
+		"Rate the error budget is spent at over the window, 1 spends exactly the whole budget over the SLO window.",
+		[]string{"window"}, nil,
+	)
+	errorBudgetRemainingDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_slo_error_budget_remaining",
+		"Ratio of the error budget of the SLO window that is left, negative once the objective is missed.",
+		nil, nil,
+	)
+)
+
+// ValidateObjective checks that the objective is a percentage in the (0, 100) range.
+func ValidateObjective(objective float64) error {
+	if objective <= 0 || objective >= 100 {
+		return fmt.Errorf("must be greater than 0 and less than 100, got %v", objective)
+	}
+
+	return nil
+}
+
+type sample struct {
+	time      time.Time
+	available int
+	total     int
+}
+
+// Tracker samples the ratio of available images. Every distinct image referenced by containers counts once per
+// sample, so that the SLI is the ratio of image-minutes the images were available for. Samples are kept in memory
+// for the SLO window, so budget spent before a restart is forgotten.
+type Tracker struct {
+	objective       float64
+	window          time.Duration
+	rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))
+	now             func() time.Time
+
+	lock    sync.Mutex
+	samples []sample
+}
+
+// New creates a tracker of the objective, a percentage of available images, over the window.
+func New(objective float64, window time.Duration, rangeContainers func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode))) *Tracker {
+	return &Tracker{
+		objective:       objective / 100,
+		window:          window,
+		rangeContainers: rangeContainers,
+		now:             time.Now,
+	}
+}
+
+// Run samples availability every minute until stopCh is closed.
+func (t *Tracker) Run(stopCh <-chan struct{}) {
+	go wait.Until(t.sample, sampleInterval, stopCh)
+}
+
+func (t *Tracker) sample() {
+	s := sample{time: t.now()}
+	seen := make(map[string]bool)
+	t.rangeContainers(func(image string, _ store.ContainerInfo, availMode store.AvailabilityMode) {
+		if seen[image] {
+			return
+		}
+		seen[image] = true
+		s.total++
+		if availMode == store.Available {
+			s.available++
+		}
+	})
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	cutoff := s.time.Add(-t.window)
+	expired := 0
+	for expired < len(t.samples) && !t.samples[expired].time.After(cutoff) {
+		expired++
+	}
+	t.samples = append(t.samples[expired:], s)
+}
+
+// availability returns the ratio of available images over the window, or false if nothing was sampled in it.
+func (t *Tracker) availability(window time.Duration) (float64, bool) {
+	cutoff := t.now().Add(-window)
+
+	var available, total int
+	for i := len(t.samples) - 1; i >= 0 && t.samples[i].time.After(cutoff); i-- {
+		available += t.samples[i].available
+		total += t.samples[i].total
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	return float64(available) / float64(total), true
+}
+
+// Describe implements prometheus.Collector.
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- objectiveDesc
+	ch <- availabilityDesc
+	ch <- burnRateDesc
+	ch <- errorBudgetRemainingDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(objectiveDesc, prometheus.GaugeValue, t.objective)
+
+	windows := make([]time.Duration, 0, len(burnRateWindows)+1)
+	for _, window := range burnRateWindows {
+		if window < t.window {
+			windows = append(windows, window)
+		}
+	}
+	windows = append(windows, t.window)
+
+	for _, window := range windows {
+		availability, ok := t.availability(window)
+		if !ok {
+			continue
+		}
+		burnRate := (1 - availability) / (1 - t.objective)
+
+		label := formatWindow(window)
+		ch <- prometheus.MustNewConstMetric(availabilityDesc, prometheus.GaugeValue, availability, label)
+		ch <- prometheus.MustNewConstMetric(burnRateDesc, prometheus.GaugeValue, burnRate, label)
+		if window == t.window {
+			ch <- prometheus.MustNewConstMetric(errorBudgetRemainingDesc, prometheus.GaugeValue, 1-burnRate)
+		}
+	}
+}
+
+// formatWindow formats the window the way Prometheus range selectors do, e.g. "30m", "6h" or "30d".
+func formatWindow(window time.Duration) string {
+	switch {
+	case window%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", window/(24*time.Hour))
+	case window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	}
+
+	return fmt.Sprintf("%ds", window/time.Second)
+}
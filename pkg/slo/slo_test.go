@@ -0,0 +1,73 @@
+package slo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flant/k8s-image-availability-exporter/pkg/store"
+)
+
+func TestTracker(t *testing.T) {
+	modes := map[string]store.AvailabilityMode{"a:v1": store.Available, "b:v1": store.Available, "c:v1": store.Available, "d:v1": store.Available}
+	rangeContainers := func(f func(image string, containerInfo store.ContainerInfo, availMode store.AvailabilityMode)) {
+		for image, mode := range modes {
+			// Images referenced by several containers count once.
+			f(image, store.ContainerInfo{Container: "app"}, mode)
+			f(image, store.ContainerInfo{Container: "sidecar"}, mode)
+		}
+	}
+
+	now := time.Unix(0, 0)
+	tracker := New(50, time.Hour, rangeContainers)
+	tracker.now = func() time.Time { return now }
+
+	require.Equal(t, 1, testutil.CollectAndCount(tracker), "nothing is sampled yet")
+
+	// Samples older than the window are dropped.
+	for i := 0; i < 60; i++ {
+		now = now.Add(time.Minute)
+		tracker.sample()
+	}
+	modes["d:v1"] = store.Absent
+	for i := 0; i < 30; i++ {
+		now = now.Add(time.Minute)
+		tracker.sample()
+	}
+	require.Len(t, tracker.samples, 60)
+
+	require.NoError(t, testutil.CollectAndCompare(tracker, strings.NewReader(`
+# HELP k8s_image_availability_exporter_slo_availability Ratio of available images over the window, sampled every minute.
+# TYPE k8s_image_availability_exporter_slo_availability gauge
+k8s_image_availability_exporter_slo_availability{window="1h"} 0.875
+k8s_image_availability_exporter_slo_availability{window="30m"} 0.75
+k8s_image_availability_exporter_slo_availability{window="5m"} 0.75
+# HELP k8s_image_availability_exporter_slo_burn_rate Rate the error budget is spent at over the window, 1 spends exactly the whole budget over the SLO window.
+# TYPE k8s_image_availability_exporter_slo_burn_rate gauge
+k8s_image_availability_exporter_slo_burn_rate{window="1h"} 0.25
+k8s_image_availability_exporter_slo_burn_rate{window="30m"} 0.5
+k8s_image_availability_exporter_slo_burn_rate{window="5m"} 0.5
+# HELP k8s_image_availability_exporter_slo_error_budget_remaining Ratio of the error budget of the SLO window that is left, negative once the objective is missed.
+# TYPE k8s_image_availability_exporter_slo_error_budget_remaining gauge
+k8s_image_availability_exporter_slo_error_budget_remaining 0.75
+# HELP k8s_image_availability_exporter_slo_objective Objective of the ratio of available images.
+# TYPE k8s_image_availability_exporter_slo_objective gauge
+k8s_image_availability_exporter_slo_objective 0.5
+`)))
+}
+
+func TestValidateObjective(t *testing.T) {
+	require.NoError(t, ValidateObjective(99.9))
+	require.Error(t, ValidateObjective(0))
+	require.Error(t, ValidateObjective(100))
+}
+
+func TestFormatWindow(t *testing.T) {
+	require.Equal(t, "5m", formatWindow(5*time.Minute))
+	require.Equal(t, "6h", formatWindow(6*time.Hour))
+	require.Equal(t, "30d", formatWindow(30*24*time.Hour))
+	require.Equal(t, "90s", formatWindow(90*time.Second))
+}
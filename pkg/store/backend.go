@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Backend persists the availability of images outside of the exporter, e.g. in Redis, so that it survives restarts.
+type Backend interface {
+	// Load returns the last known availability of images.
+	Load() (map[string]AvailabilityMode, error)
+	// Save records the availability of an image.
+	Save(image string, availMode AvailabilityMode) error
+}
+
+// SharedBackend is a Backend shared by several replicas of the exporter, which split checks of images between
+// themselves. Every replica tracks and exports all images, the ones it doesn't check are refreshed from the backend,
+// see RunBackendSync.
+type SharedBackend interface {
+	Backend
+	// Owns reports whether the image is checked by this replica.
+	Owns(image string) bool
+}
+
+// ParseAvailabilityMode parses the name of an availability mode, as returned by AvailabilityMode.String.
+func ParseAvailabilityMode(s string) (AvailabilityMode, error) {
+	for availMode, desc := range AvailabilityModeDescMap {
+		if desc == s {
+			return availMode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown availability mode %q", s)
+}
+
+// UseBackend loads the last known availability of images from the backend and writes results of subsequent checks
+// to it. Images reconciled for the first time start with the loaded availability instead of being reported as
+// available until they are checked. Only images the backend reports as owned are checked if it's a SharedBackend.
+// Must be called before any image is reconciled.
+func (s *ImageStore) UseBackend(backend Backend) error {
+	known, err := backend.Load()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.backend = backend
+	s.knownModes = known
+	if shared, ok := backend.(SharedBackend); ok {
+		s.owns = shared.Owns
+	}
+
+	return nil
+}
+
+// RunBackendSync refreshes the availability of images checked by other replicas from a SharedBackend every interval.
+// It does nothing for other backends.
+func (s *ImageStore) RunBackendSync(interval time.Duration) {
+	if s.owns == nil {
+		return
+	}
+
+	go wait.Forever(func() {
+		if err := s.syncBackend(); err != nil {
+			logrus.Warnf("Failed to load the availability of images from the store backend: %v", err)
+		}
+	}, interval)
+}
+
+// syncBackend applies the availability of images other replicas checked. Transition handlers aren't called for them,
+// since the replica that checked the image already did.
+func (s *ImageStore) syncBackend() error {
+	known, err := s.backend.Load()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for image, availMode := range known {
+		if s.owns(image) {
+			continue
+		}
+
+		imageInfo, ok := s.imageSet[image]
+		if !ok {
+			s.knownModes[image] = availMode
+			continue
+		}
+		if imageInfo.checked && imageInfo.AvailMode == availMode {
+			continue
+		}
+
+		_ = s.recordCheck(image, imageInfo, availMode, false)
+	}
+
+	return nil
+}
+
+// save writes the result of a check to the backend, if any. Must be called without the lock held.
+func (s *ImageStore) save(image string, availMode AvailabilityMode) {
+	if s.backend == nil {
+		return
+	}
+
+	if err := s.backend.Save(image, availMode); err != nil {
+		logrus.WithField("image_name", image).Warnf("Failed to save the availability to the store backend: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBackend map[string]AvailabilityMode
+
+func (b fakeBackend) Load() (map[string]AvailabilityMode, error) {
+	ret := make(map[string]AvailabilityMode, len(b))
+	for image, availMode := range b {
+		ret[image] = availMode
+	}
+	return ret, nil
+}
+
+func (b fakeBackend) Save(image string, availMode AvailabilityMode) error {
+	b[image] = availMode
+	return nil
+}
+
+func TestImageStore_UseBackend(t *testing.T) {
+	backend := fakeBackend{"known:absent": Absent, "known:available": Available}
+
+	store := NewImageStore(func(imageName string) AvailabilityMode { return Available }, 10, 10)
+	require.NoError(t, store.UseBackend(backend))
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"known:absent", "known:available", "new:v1"} {
+		store.ReconcileImage(image, info)
+	}
+
+	// Known images start with the stored availability until they are checked.
+	require.Equal(t, Absent, store.imageSet["known:absent"].AvailMode)
+	require.True(t, store.imageSet["known:absent"].checked)
+	require.Equal(t, 1, store.errQueue.Len())
+
+	store.Check()
+	require.Equal(t, Available, store.imageSet["known:absent"].AvailMode)
+	require.Equal(t, fakeBackend{"known:absent": Available, "known:available": Available, "new:v1": Available}, backend)
+
+	_, err := ParseAvailabilityMode("unknown")
+	require.Error(t, err)
+	availMode, err := ParseAvailabilityMode(AuthzFailure.String())
+	require.NoError(t, err)
+	require.Equal(t, AuthzFailure, availMode)
+}
+
+type fakeSharedBackend struct {
+	fakeBackend
+	owned map[string]bool
+}
+
+func (b fakeSharedBackend) Owns(image string) bool {
+	return b.owned[image]
+}
+
+func TestImageStore_UseBackend_shared(t *testing.T) {
+	backend := fakeSharedBackend{fakeBackend: fakeBackend{}, owned: map[string]bool{"owned:v1": true}}
+
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return Available
+	}, 10, 10)
+	require.NoError(t, store.UseBackend(backend))
+
+	var transitions []Transition
+	store.AddTransitionHandler(func(transition Transition) {
+		transitions = append(transitions, transition)
+	})
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("owned:v1", info)
+	store.ReconcileImage("foreign:v1", info)
+
+	// Only images owned by the replica are checked.
+	store.Check()
+	require.Equal(t, []string{"owned:v1"}, checked)
+	require.False(t, store.imageSet["foreign:v1"].checked)
+
+	// The rest is refreshed with results of other replicas, without notifying transition handlers.
+	backend.fakeBackend["foreign:v1"] = Absent
+	backend.fakeBackend["foreign:v2"] = AuthnFailure
+	backend.fakeBackend["owned:v1"] = Absent
+	require.NoError(t, store.syncBackend())
+	require.Equal(t, Absent, store.imageSet["foreign:v1"].AvailMode)
+	require.True(t, store.imageSet["foreign:v1"].checked)
+	require.Equal(t, Available, store.imageSet["owned:v1"].AvailMode)
+	require.Empty(t, transitions)
+
+	store.ReconcileImage("foreign:v2", info)
+	require.Equal(t, AuthnFailure, store.imageSet["foreign:v2"].AvailMode)
+
+	// Failed images of other replicas aren't rescheduled.
+	require.Zero(t, store.RecheckFailed(func(string) bool { return true }))
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	minBatchFactor = 0.01
+	maxBatchFactor = 10
+
+	batchGrowFactor = 1.5
+
+	// Registries failing more than this share of checks get their batches halved.
+	maxErrorRate = 0.5
+)
+
+var (
+	registryBatchSizeDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_registry_batch_size",
+		"Number of image checks allowed per pass for a registry, including failed image rechecks.",
+		[]string{"registry"}, nil,
+	)
+	registryCheckLatencyDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_registry_check_latency_p95_seconds",
+		"95th percentile of image check latency for a registry observed during the last pass.",
+		[]string{"registry"}, nil,
+	)
+	checkPassDurationDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_check_pass_duration_seconds",
+		"Duration of the last image check pass.",
+		nil, nil,
+	)
+)
+
+type registryFunc func(image string) string
+
+type registryBatch struct {
+	factor float64
+
+	normalUsed int
+	errUsed    int
+	deferred   int
+
+	latencies []time.Duration
+	errors    int
+
+	lastP95 time.Duration
+}
+
+// BatchTuner limits the number of image checks per pass for every registry, growing and shrinking the limits based
+// on observed latency and error rate, so that a whole pass fits into the target duration.
+type BatchTuner struct {
+	lock sync.Mutex
+
+	targetPassDuration time.Duration
+	normalBatchSize    int
+	errBatchSize       int
+
+	registryOf registryFunc
+	registries map[string]*registryBatch
+
+	passStart        time.Time
+	lastPassDuration time.Duration
+}
+
+func NewBatchTuner(targetPassDuration time.Duration, normalBatchSize, errBatchSize int, registryOf registryFunc) *BatchTuner {
+	return &BatchTuner{
+		targetPassDuration: targetPassDuration,
+		normalBatchSize:    normalBatchSize,
+		errBatchSize:       errBatchSize,
+		registryOf:         registryOf,
+		registries:         make(map[string]*registryBatch),
+	}
+}
+
+func (t *BatchTuner) startPass() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.passStart = time.Now()
+	for _, rb := range t.registries {
+		rb.normalUsed, rb.errUsed, rb.deferred, rb.errors = 0, 0, 0, 0
+		rb.latencies = rb.latencies[:0]
+	}
+}
+
+// admit reports whether one more image of a registry may be checked during the current pass.
+// Unused failed image checks may be spent on normal checks.
+func (t *BatchTuner) admit(registry string, errQ bool) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	rb := t.registry(registry)
+	normalLimit, errLimit := t.limits(rb)
+
+	if errQ {
+		if rb.errUsed >= errLimit {
+			rb.deferred++
+			return false
+		}
+		rb.errUsed++
+		return true
+	}
+
+	if rb.normalUsed >= normalLimit+errLimit-rb.errUsed {
+		rb.deferred++
+		return false
+	}
+	rb.normalUsed++
+	return true
+}
+
+func (t *BatchTuner) observe(registry string, latency time.Duration, failed bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	rb := t.registry(registry)
+	rb.latencies = append(rb.latencies, latency)
+	if failed {
+		rb.errors++
+	}
+}
+
+func (t *BatchTuner) endPass() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.lastPassDuration = time.Since(t.passStart)
+
+	var active int
+	for _, rb := range t.registries {
+		if len(rb.latencies) > 0 {
+			active++
+		}
+	}
+	if active == 0 {
+		return
+	}
+
+	budget := t.targetPassDuration / time.Duration(active)
+
+	for _, rb := range t.registries {
+		if len(rb.latencies) == 0 {
+			continue
+		}
+
+		rb.lastP95 = percentile(rb.latencies, 0.95)
+
+		normalLimit, errLimit := t.limits(rb)
+		expected := time.Duration(normalLimit+errLimit) * rb.lastP95
+
+		switch {
+		case float64(rb.errors)/float64(len(rb.latencies)) > maxErrorRate:
+			rb.factor /= 2
+		case expected > budget:
+			rb.factor *= float64(budget) / float64(expected)
+		case rb.deferred > 0 && time.Duration(float64(expected)*batchGrowFactor) <= budget:
+			rb.factor *= batchGrowFactor
+		}
+
+		rb.factor = math.Max(minBatchFactor, math.Min(maxBatchFactor, rb.factor))
+	}
+}
+
+func (t *BatchTuner) registry(registry string) *registryBatch {
+	rb, ok := t.registries[registry]
+	if !ok {
+		rb = &registryBatch{factor: 1}
+		t.registries[registry] = rb
+	}
+
+	return rb
+}
+
+func (t *BatchTuner) limits(rb *registryBatch) (normalLimit, errLimit int) {
+	normalLimit = int(math.Max(1, math.Floor(float64(t.normalBatchSize)*rb.factor)))
+	errLimit = int(math.Max(1, math.Floor(float64(t.errBatchSize)*rb.factor)))
+
+	return
+}
+
+func (t *BatchTuner) metrics() (ret []prometheus.Metric) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for registry, rb := range t.registries {
+		normalLimit, errLimit := t.limits(rb)
+
+		ret = append(ret,
+			prometheus.MustNewConstMetric(registryBatchSizeDesc, prometheus.GaugeValue, float64(normalLimit+errLimit), registry),
+			prometheus.MustNewConstMetric(registryCheckLatencyDesc, prometheus.GaugeValue, rb.lastP95.Seconds(), registry),
+		)
+	}
+
+	ret = append(ret, prometheus.MustNewConstMetric(checkPassDurationDesc, prometheus.GaugeValue, t.lastPassDuration.Seconds()))
+
+	return
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return sorted[idx]
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func registryOfTestImage(image string) string {
+	return strings.SplitN(image, "_", 2)[0]
+}
+
+func TestBatchTuner(t *testing.T) {
+	t.Run("limits are shared between queues", func(t *testing.T) {
+		tuner := NewBatchTuner(time.Minute, 2, 1, registryOfTestImage)
+		tuner.startPass()
+
+		require.True(t, tuner.admit("test", true))
+		require.False(t, tuner.admit("test", true))
+		require.True(t, tuner.admit("test", false))
+		require.True(t, tuner.admit("test", false))
+		require.False(t, tuner.admit("test", false))
+
+		// Unused failed image checks are spent on normal checks.
+		require.True(t, tuner.admit("other", false))
+		require.True(t, tuner.admit("other", false))
+		require.True(t, tuner.admit("other", false))
+		require.False(t, tuner.admit("other", false))
+	})
+
+	t.Run("slow registry shrinks, fast one grows", func(t *testing.T) {
+		tuner := NewBatchTuner(10*time.Second, 10, 10, registryOfTestImage)
+		tuner.startPass()
+
+		for i := 0; i < 21; i++ {
+			if tuner.admit("slow", false) {
+				tuner.observe("slow", time.Second, false)
+			}
+			if tuner.admit("fast", false) {
+				tuner.observe("fast", time.Millisecond, false)
+			}
+		}
+		tuner.endPass()
+
+		normal, errs := tuner.limits(tuner.registries["slow"])
+		require.LessOrEqual(t, normal+errs, 5)
+
+		normal, errs = tuner.limits(tuner.registries["fast"])
+		require.Equal(t, 30, normal+errs)
+	})
+
+	t.Run("failing registry is halved", func(t *testing.T) {
+		tuner := NewBatchTuner(time.Hour, 10, 10, registryOfTestImage)
+		tuner.startPass()
+		for i := 0; i < 4; i++ {
+			require.True(t, tuner.admit("broken", false))
+			tuner.observe("broken", time.Millisecond, true)
+		}
+		tuner.endPass()
+
+		normal, errs := tuner.limits(tuner.registries["broken"])
+		require.Equal(t, 10, normal+errs)
+	})
+}
+
+func TestImageStore_CheckWithBatchTuner(t *testing.T) {
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return Available
+	}, 1, 1)
+	store.UseBatchTuner(NewBatchTuner(time.Hour, 1, 1, registryOfTestImage))
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"a_1", "a_2", "a_3", "b_1"} {
+		store.ReconcileImage(image, info)
+	}
+
+	store.Check()
+	require.Equal(t, []string{"a_1", "a_2", "b_1"}, checked)
+	require.Equal(t, 4, store.queue.Len())
+}
+
+func TestImageStore_CheckFailedWithBatchTuner(t *testing.T) {
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return Absent
+	}, 1, 1)
+	tuner := NewBatchTuner(time.Hour, 1, 1, registryOfTestImage)
+	store.UseBatchTuner(tuner)
+	store.UseRetryPolicy(&RetryPolicy{})
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"a_1", "a_2", "a_3"} {
+		store.ReconcileImage(image, info)
+	}
+
+	store.Check()
+	store.Check()
+	require.Equal(t, 3, store.errQueue.Len())
+	checked = nil
+
+	// The fast lane isn't limited by the batches of the tuner and doesn't spend them.
+	store.CheckFailed()
+	require.Equal(t, []string{"a_1", "a_2", "a_3"}, checked)
+	require.Zero(t, tuner.registries["a"].errUsed)
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var checkWindowOpenDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_check_window_open",
+	"Non-zero indicates that the current time is within a check window, so that available images are rechecked.",
+	nil, nil,
+)
+
+// CheckWindows are daily time windows available images are rechecked in, e.g. to spare a registry during its nightly
+// backup. Outside of them, only images that haven't been checked yet and failed images are checked.
+type CheckWindows struct {
+	location *time.Location
+	windows  []checkWindow
+	now      func() time.Time
+}
+
+// checkWindow spans from start to end since midnight. A window that ends before it starts spans midnight.
+type checkWindow struct {
+	start, end time.Duration
+}
+
+// ParseCheckWindows parses comma-separated windows like "08:00-22:00" in the location. A window that ends before it
+// starts spans midnight, e.g. "22:00-06:00".
+func ParseCheckWindows(s string, location *time.Location) (*CheckWindows, error) {
+	ret := &CheckWindows{location: location, now: time.Now}
+	for _, window := range strings.Split(s, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(window), "-")
+		if !ok {
+			return nil, fmt.Errorf(`check window %q must be "HH:MM-HH:MM"`, window)
+		}
+
+		var (
+			w   checkWindow
+			err error
+		)
+		if w.start, err = parseTimeOfDay(start); err != nil {
+			return nil, fmt.Errorf("invalid start of check window %q: %w", window, err)
+		}
+		if w.end, err = parseTimeOfDay(end); err != nil {
+			return nil, fmt.Errorf("invalid end of check window %q: %w", window, err)
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("check window %q is empty", window)
+		}
+
+		ret.windows = append(ret.windows, w)
+	}
+
+	return ret, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *CheckWindows) open(t time.Time) bool {
+	t = t.In(w.location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	for _, window := range w.windows {
+		if window.start < window.end {
+			if sinceMidnight >= window.start && sinceMidnight < window.end {
+				return true
+			}
+		} else if sinceMidnight >= window.start || sinceMidnight < window.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *CheckWindows) metrics() []prometheus.Metric {
+	var value float64
+	if w.open(w.now()) {
+		value = 1
+	}
+
+	return []prometheus.Metric{prometheus.MustNewConstMetric(checkWindowOpenDesc, prometheus.GaugeValue, value)}
+}
+
+// checkedAvailable reports whether the image is available according to a check, as opposed to an image that hasn't
+// been checked yet or a failed one.
+func checkedAvailable(imageInfo ImageInfo) bool {
+	return imageInfo.checked && imageInfo.AvailMode == Available
+}
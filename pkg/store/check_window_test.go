@@ -0,0 +1,92 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func windowOpen(t *testing.T, windows *CheckWindows) float64 {
+	t.Helper()
+
+	metrics := windows.metrics()
+	require.Len(t, metrics, 1)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func TestParseCheckWindows(t *testing.T) {
+	windows, err := ParseCheckWindows("08:00-12:30, 22:00-02:00", time.UTC)
+	require.NoError(t, err)
+
+	for clock, open := range map[string]bool{
+		"07:59": false,
+		"08:00": true,
+		"12:29": true,
+		"12:30": false,
+		"21:59": false,
+		"23:00": true,
+		"00:00": true,
+		"01:59": true,
+		"02:00": false,
+	} {
+		at, err := time.Parse("15:04", clock)
+		require.NoError(t, err)
+		require.Equal(t, open, windows.open(at), clock)
+	}
+
+	// Windows are in their location.
+	moscow := time.FixedZone("MSK", 3*60*60)
+	windows, err = ParseCheckWindows("08:00-09:00", moscow)
+	require.NoError(t, err)
+	require.True(t, windows.open(time.Date(2024, 3, 1, 5, 30, 0, 0, time.UTC)))
+	require.False(t, windows.open(time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)))
+
+	for _, invalid := range []string{"", "08:00", "08:00-25:00", "8am-9am", "08:00-08:00"} {
+		_, err := ParseCheckWindows(invalid, time.UTC)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestImageStore_UseCheckWindows(t *testing.T) {
+	modes := map[string]AvailabilityMode{"available": Available, "failed": Absent}
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return modes[imageName]
+	}, 10, 10)
+
+	windows, err := ParseCheckWindows("08:00-22:00", time.UTC)
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	windows.now = func() time.Time { return now }
+	store.UseCheckWindows(windows)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("available", info)
+	store.ReconcileImage("failed", info)
+
+	// New images are checked outside of windows.
+	store.Check()
+	require.ElementsMatch(t, []string{"available", "failed"}, checked)
+
+	// Failed images are rechecked, available ones aren't.
+	checked = nil
+	store.ReconcileImage("new", info)
+	store.Check()
+	require.ElementsMatch(t, []string{"failed", "new"}, checked)
+
+	checked = nil
+	store.Check()
+	require.Equal(t, []string{"failed"}, checked)
+	require.Equal(t, float64(0), windowOpen(t, windows))
+
+	now = now.Add(10 * time.Hour)
+	checked = nil
+	store.Check()
+	require.ElementsMatch(t, []string{"available", "failed", "new"}, checked)
+	require.Equal(t, float64(1), windowOpen(t, windows))
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// containerLabels are labels of availability metrics of every container.
+var containerLabels = []string{"namespace", "container", "kind", "name", "image"}
+
+// originalImageLabel is added to availability metrics of containers if images are rewritten, see UseImageRewrite.
+const originalImageLabel = "original_image"
+
+var availabilityModeHelps = map[AvailabilityMode]string{
+	Available:           "Non-zero indicates that the image of the container is available in its registry.",
+	Absent:              "Non-zero indicates that the image of the container is absent from its registry.",
+	BadImageName:        "Non-zero indicates that the image reference of the container can't be parsed.",
+	RegistryUnavailable: "Non-zero indicates that the registry of the image of the container can't be reached.",
+	AuthnFailure:        "Non-zero indicates that the registry of the image of the container rejects the credentials.",
+	AuthzFailure:        "Non-zero indicates that the credentials aren't allowed to pull the image of the container.",
+	UnknownError:        "Non-zero indicates that the image of the container can't be checked for an unknown reason.",
+	InvalidReference:    "Non-zero indicates that the image reference of the container contains unexpanded templating.",
+}
+
+// Help returns the help of the availability metric of the mode.
+func (a AvailabilityMode) Help() string {
+	return availabilityModeHelps[a]
+}
+
+// availabilityDescs are descriptors of availability metrics of containers. Their labels depend on the way the store
+// is set up, so they are rebuilt by UseExtraLabels and UseImageRewrite, which must be called before the store is
+// registered.
+type availabilityDescs struct {
+	labelNames []string
+	perMode    map[AvailabilityMode]*prometheus.Desc
+	enum       *prometheus.Desc
+}
+
+func newAvailabilityDescs(rewriteImage bool, extraLabels []string) *availabilityDescs {
+	labelNames := append([]string(nil), containerLabels...)
+	if rewriteImage {
+		labelNames = append(labelNames, originalImageLabel)
+	}
+
+	extraLabels = append([]string(nil), extraLabels...)
+	sort.Strings(extraLabels)
+	for i, label := range extraLabels {
+		// Distinct workload labels may be sanitized to the same label name.
+		if i == 0 || label != extraLabels[i-1] {
+			labelNames = append(labelNames, label)
+		}
+	}
+
+	descs := &availabilityDescs{
+		labelNames: labelNames,
+		perMode:    make(map[AvailabilityMode]*prometheus.Desc, len(AvailabilityModeDescMap)),
+		enum:       prometheus.NewDesc(availabilityModeMetricName, availabilityModeHelp, labelNames, nil),
+	}
+	for availMode, desc := range AvailabilityModeDescMap {
+		descs.perMode[availMode] = prometheus.NewDesc("k8s_image_availability_exporter_"+desc, availMode.Help(), labelNames, nil)
+	}
+
+	return descs
+}
+
+// labelValues returns values of labels in the order of the descriptors.
+func (d *availabilityDescs) labelValues(labels map[string]string) []string {
+	ret := make([]string, 0, len(d.labelNames))
+	for _, name := range d.labelNames {
+		ret = append(ret, labels[name])
+	}
+
+	return ret
+}
+
+// perModeMetrics returns a gauge per availability mode, only the gauge of the mode is non-zero.
+func (d *availabilityDescs) perModeMetrics(labelValues []string, mode AvailabilityMode) (ret []prometheus.Metric) {
+	for availMode, desc := range d.perMode {
+		var value float64
+		if availMode == mode {
+			value = 1
+		}
+
+		ret = append(ret, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...))
+	}
+
+	return
+}
+
+// Describe sends descriptors of all metrics the store exports, including the ones of optional features that may be
+// disabled.
+func (s *ImageStore) Describe(ch chan<- *prometheus.Desc) {
+	if s.metricStyle.perMode() {
+		for _, desc := range s.descs.perMode {
+			ch <- desc
+		}
+	}
+	if s.metricStyle.enum() {
+		ch <- s.descs.enum
+	}
+
+	for _, desc := range []*prometheus.Desc{
+		namespaceUnavailableImagesDesc,
+		kindUnavailableImagesDesc,
+		clusterReschedulableDesc,
+		rescheduleBlockersDesc,
+		droppedSeriesDesc,
+		registryBatchSizeDesc,
+		registryCheckLatencyDesc,
+		checkPassDurationDesc,
+		deferredChecksDesc,
+		registryDeferredChecksDesc,
+		retryBudgetExhaustedDesc,
+		checkWindowOpenDesc,
+	} {
+		ch <- desc
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"github.com/gammazero/deque"
+)
+
+// imageQueue is a queue of images waiting for a check.
+type imageQueue interface {
+	Len() int
+	PushBack(image string)
+	PushFront(image string)
+	PopFront() string
+}
+
+// fairQueue is a queue of images that interleaves registries. Images are checked in cycles: images pushed to the back
+// are checked during the next cycle, which takes an image of every registry in turns, so that a large cohort of images
+// in one registry doesn't delay checks of a small registry until the end of the cycle. Images of a registry keep their
+// order, and every image is still checked once per cycle.
+type fairQueue struct {
+	registryOf registryFunc
+
+	cycle *deque.Deque[string]
+
+	next    map[string]*deque.Deque[string]
+	nextLen int
+	// registries holds registries of the next cycle in the order their first image was pushed.
+	registries []string
+}
+
+// newFairQueue returns a queue interleaving registries returned by registryOf. With a nil registryOf, it keeps
+// the order images are pushed in.
+func newFairQueue(registryOf registryFunc) *fairQueue {
+	return &fairQueue{
+		registryOf: registryOf,
+		cycle:      deque.New[string](2048, 2048),
+		next:       make(map[string]*deque.Deque[string]),
+	}
+}
+
+func (q *fairQueue) Len() int {
+	return q.cycle.Len() + q.nextLen
+}
+
+// PushBack queues the image for the next cycle.
+func (q *fairQueue) PushBack(image string) {
+	var registry string
+	if q.registryOf != nil {
+		registry = q.registryOf(image)
+	}
+
+	queue, ok := q.next[registry]
+	if !ok {
+		queue = deque.New[string]()
+		q.next[registry] = queue
+		q.registries = append(q.registries, registry)
+	}
+	queue.PushBack(image)
+	q.nextLen++
+}
+
+// PushFront queues the image to be popped next.
+func (q *fairQueue) PushFront(image string) {
+	q.cycle.PushFront(image)
+}
+
+// PopFront pops the next image of the current cycle, starting the next cycle if the current one is over. It panics if
+// the queue is empty.
+func (q *fairQueue) PopFront() string {
+	if q.cycle.Len() == 0 {
+		q.startCycle()
+	}
+
+	return q.cycle.PopFront()
+}
+
+func (q *fairQueue) startCycle() {
+	registries := q.registries
+	for len(registries) > 0 {
+		remaining := registries[:0]
+		for _, registry := range registries {
+			queue := q.next[registry]
+			q.cycle.PushBack(queue.PopFront())
+			if queue.Len() > 0 {
+				remaining = append(remaining, registry)
+			}
+		}
+		registries = remaining
+	}
+
+	q.next = make(map[string]*deque.Deque[string])
+	q.nextLen = 0
+	q.registries = nil
+}
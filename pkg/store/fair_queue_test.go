@@ -0,0 +1,54 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fairQueue(t *testing.T) {
+	q := newFairQueue(registryOfTestImage)
+	for _, image := range []string{"a_1", "a_2", "a_3", "a_4", "b_1", "c_1", "c_2"} {
+		q.PushBack(image)
+	}
+	require.Equal(t, 7, q.Len())
+
+	pop := func(n int) (ret []string) {
+		for i := 0; i < n; i++ {
+			ret = append(ret, q.PopFront())
+		}
+		return
+	}
+
+	// Registries are interleaved, keeping the order of images in every registry.
+	require.Equal(t, []string{"a_1", "b_1", "c_1"}, pop(3))
+
+	// Images pushed back during a cycle wait for the next one.
+	q.PushBack("b_1")
+	q.PushFront("c_1")
+	require.Equal(t, 6, q.Len())
+	require.Equal(t, []string{"c_1", "a_2", "c_2", "a_3", "a_4"}, pop(5))
+
+	require.Equal(t, []string{"b_1"}, pop(1))
+	require.Zero(t, q.Len())
+}
+
+func TestImageStore_FairScheduling(t *testing.T) {
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return Available
+	}, 2, 0)
+	store.UseFairScheduling(registryOfTestImage)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"docker.io_1", "docker.io_2", "docker.io_3", "internal_1"} {
+		store.ReconcileImage(image, info)
+	}
+
+	// Images of the small registry aren't checked after the whole cohort of the large one.
+	store.Check()
+	require.Equal(t, []string{"docker.io_1", "internal_1"}, checked)
+	store.Check()
+	require.Equal(t, []string{"docker.io_1", "internal_1", "docker.io_2", "docker.io_3"}, checked)
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageStore_collectGarbage(t *testing.T) {
+	var checks []string
+	store := NewImageStore(func(image string) AvailabilityMode {
+		checks = append(checks, image)
+		return Absent
+	}, 1, 1)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("test", info)
+	store.Check()
+	require.Equal(t, []string{"test"}, checks)
+
+	referenced := map[string][]ContainerInfo{}
+	gc := func(image string) []ContainerInfo { return referenced[image] }
+
+	now := time.Now()
+	store.collectGarbage(gc, time.Hour, now)
+	require.Contains(t, store.imageSet, "test")
+	require.Empty(t, store.ExtractMetrics(), "orphans must not be exported")
+
+	// Orphans aren't checked.
+	store.Check()
+	require.Len(t, checks, 1)
+
+	// A recreated controller gets the last known availability back and the image is queued again.
+	store.ReconcileImage("test", info)
+	require.Equal(t, Absent, store.imageSet["test"].AvailMode)
+	require.False(t, store.imageSet["test"].orphaned())
+	store.Check()
+	require.Len(t, checks, 2)
+
+	store.collectGarbage(gc, time.Hour, now)
+	store.collectGarbage(gc, time.Hour, now.Add(30*time.Minute))
+	require.Contains(t, store.imageSet, "test")
+	store.collectGarbage(gc, time.Hour, now.Add(time.Hour))
+	require.NotContains(t, store.imageSet, "test")
+}
+
+func TestImageStore_collectGarbageWithoutRetention(t *testing.T) {
+	store := NewImageStore(reconcile(t), 1, 1)
+	store.ReconcileImage("test", []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}})
+
+	store.collectGarbage(func(string) []ContainerInfo { return nil }, 0, time.Now())
+	require.NotContains(t, store.imageSet, "test")
+}
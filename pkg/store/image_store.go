@@ -1,8 +1,10 @@
 package store
 
 import (
+	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gammazero/deque"
@@ -20,6 +22,8 @@ const (
 	AuthnFailure
 	AuthzFailure
 	UnknownError
+	// InvalidReference is an image reference with unexpanded templating, e.g. "{{ .Values.tag }}" or "$TAG".
+	InvalidReference
 )
 
 var AvailabilityModeDescMap = map[AvailabilityMode]string{
@@ -30,8 +34,35 @@ var AvailabilityModeDescMap = map[AvailabilityMode]string{
 	AuthnFailure:        "authentication_failure",
 	AuthzFailure:        "authorization_failure",
 	UnknownError:        "unknown_error",
+	InvalidReference:    "invalid_reference",
 }
 
+var (
+	namespaceUnavailableImagesDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_namespace_unavailable_images",
+		"Number of distinct unavailable images referenced in a namespace.",
+		[]string{"namespace"}, nil,
+	)
+	kindUnavailableImagesDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_controller_kind_unavailable_images",
+		"Number of distinct unavailable images referenced by controllers of a kind.",
+		[]string{"kind"}, nil,
+	)
+	clusterReschedulableDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_cluster_reschedulable",
+		"Whether every image referenced by tracked workloads was checked and is available, so that all of them could be rescheduled.",
+		nil, nil,
+	)
+	rescheduleBlockersDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_reschedule_blockers",
+		"Number of distinct images that prevent tracked workloads from being rescheduled, by reason.",
+		[]string{"reason"}, nil,
+	)
+)
+
+// notCheckedReason is the reschedule blocker reason of images that weren't checked yet.
+const notCheckedReason = "not_checked"
+
 func (a AvailabilityMode) String() string {
 	return AvailabilityModeDescMap[a]
 }
@@ -41,75 +72,263 @@ type ContainerInfo struct {
 	ControllerKind string
 	ControllerName string
 	Container      string
+	PullPolicy     string
 }
 
 type ImageInfo struct {
 	ContainerInfo map[ContainerInfo]struct{}
 	AvailMode     AvailabilityMode
+
+	checked bool
+	retry   retryState
+	// generation is the store version the image last changed in, see metricsSnapshot.
+	generation uint64
+	// orphanedSince is the time GC found the image not referenced by any controller, see RunGC.
+	orphanedSince time.Time
+	// dequeued is set once an orphaned image is dropped from its queue, so it has to be queued again if it's
+	// referenced again.
+	dequeued bool
+}
+
+func (i ImageInfo) orphaned() bool {
+	return !i.orphanedSince.IsZero()
+}
+
+// Transition describes a change of an image availability mode. The first check of an image is reported as
+// a transition only if the image isn't available.
+type Transition struct {
+	Image          string
+	OldMode        AvailabilityMode
+	NewMode        AvailabilityMode
+	FirstCheck     bool
+	ContainerInfos []ContainerInfo
+}
+
+func (t Transition) OldModeString() string {
+	if t.FirstCheck {
+		return "none"
+	}
+
+	return t.OldMode.String()
 }
 
 type ImageStore struct {
 	lock sync.RWMutex
 
-	imageSet map[string]ImageInfo
-	queue    *deque.Deque[string]
-	errQueue *deque.Deque[string]
+	imageSet      map[string]ImageInfo
+	queue         *fairQueue
+	errQueue      *deque.Deque[string]
+	priorityQueue *deque.Deque[string]
 
 	check checkFunc
 
 	concurrentNormalChecks int
 	concurrentErrorChecks  int
+
+	batchTuner   *BatchTuner
+	retryPolicy  *RetryPolicy
+	passBudget   *PassBudget
+	checkWindows *CheckWindows
+
+	transitionHandlers []transitionFunc
+
+	maxImagesPerNamespace int
+
+	extraLabels     extraLabelsFunc
+	extraLabelNames []string
+	rewriteImage    func(image string) string
+	metricStyle     MetricStyle
+	descs           *availabilityDescs
+	isPriority      priorityFunc
+
+	backend Backend
+	// knownModes holds availability loaded from the backend for images that haven't been reconciled yet.
+	knownModes map[string]AvailabilityMode
+	// owns reports whether the image is checked by this replica, if the backend is shared.
+	owns func(image string) bool
+
+	// version is incremented on every change of the exported metrics, it is only incremented with the lock held.
+	version      atomic.Uint64
+	snapshot     atomic.Pointer[metricsSnapshot]
+	snapshotLock sync.Mutex
 }
 
 type checkFunc func(imageName string) AvailabilityMode
 type gcFunc func(image string) []ContainerInfo
+type transitionFunc func(transition Transition)
+type extraLabelsFunc func(containerInfo ContainerInfo) map[string]string
+type priorityFunc func(image string) bool
 
 func NewImageStore(check checkFunc, concurrentNormalChecks, concurrentErrorChecks int) *ImageStore {
 	return &ImageStore{
-		imageSet: make(map[string]ImageInfo),
-		queue:    deque.New[string](2048, 2048),
-		errQueue: deque.New[string](512, 512),
+		imageSet:      make(map[string]ImageInfo),
+		queue:         newFairQueue(nil),
+		errQueue:      deque.New[string](512, 512),
+		priorityQueue: deque.New[string](128, 128),
 
 		check: check,
 
 		concurrentNormalChecks: concurrentNormalChecks,
 		concurrentErrorChecks:  concurrentErrorChecks,
+
+		descs: newAvailabilityDescs(false, nil),
 	}
 }
 
-func (s *ImageStore) RunGC(gc gcFunc) {
+// UseBatchTuner replaces fixed batch sizes with adaptive per-registry ones.
+func (s *ImageStore) UseBatchTuner(tuner *BatchTuner) {
+	s.batchTuner = tuner
+}
+
+// UseRetryPolicy moves rechecks of failed images out of Check into a separate fast lane, see CheckFailed.
+func (s *ImageStore) UseRetryPolicy(policy *RetryPolicy) {
+	s.retryPolicy = policy
+}
+
+// UseFairScheduling interleaves checks of available images across registries returned by registryOf, instead of
+// checking them in the order they were added. It must be called before images are reconciled.
+func (s *ImageStore) UseFairScheduling(registryOf registryFunc) {
+	s.queue = newFairQueue(registryOf)
+}
+
+// UsePassBudget limits the time spent on checks during a single Check call.
+func (s *ImageStore) UsePassBudget(budget *PassBudget) {
+	s.passBudget = budget
+}
+
+// UseCheckWindows restricts checks of available images to the windows, see CheckWindows.
+func (s *ImageStore) UseCheckWindows(windows *CheckWindows) {
+	s.checkWindows = windows
+}
+
+// UsePriority makes available images for which isPriority returns true be checked before the rest of available
+// images. Without the batch tuner, they may take up to half of the normal checks of a pass. isPriority is called with
+// the store locked, so it must not call store methods.
+func (s *ImageStore) UsePriority(isPriority priorityFunc) {
+	s.isPriority = isPriority
+}
+
+// UseSeriesLimit limits the number of distinct images exported per namespace, images beyond the limit are collapsed
+// into the "other" image.
+func (s *ImageStore) UseSeriesLimit(maxImagesPerNamespace int) {
+	s.maxImagesPerNamespace = maxImagesPerNamespace
+	s.invalidateSnapshot()
+}
+
+// UseMetricStyle selects whether availability is exported as per-mode gauges, a single enum gauge or both.
+func (s *ImageStore) UseMetricStyle(style MetricStyle) {
+	s.metricStyle = style
+	s.invalidateSnapshot()
+}
+
+// AddTransitionHandler registers a function that is called on image availability mode transitions. Handlers are
+// called synchronously from the checking goroutine and must not block.
+func (s *ImageStore) AddTransitionHandler(handler transitionFunc) {
+	s.transitionHandlers = append(s.transitionHandlers, handler)
+}
+
+// RunGC refreshes containers of images every interval. Metrics of all images are rebuilt afterwards, so that changes
+// of extra labels are picked up. Images that aren't referenced anymore are kept as orphans for keepOrphansFor: they
+// aren't checked or exported, but keep their availability, so that images of controllers that are deleted and
+// recreated shortly after, e.g. by GitOps tools, aren't verified from scratch.
+func (s *ImageStore) RunGC(gc gcFunc, interval, keepOrphansFor time.Duration) {
 	go wait.Forever(func() {
-		s.lock.Lock()
-		defer s.lock.Unlock()
+		s.collectGarbage(gc, keepOrphansFor, time.Now())
+	}, interval)
+}
 
-		for image, imgInfo := range s.imageSet {
-			ci := gc(image)
+func (s *ImageStore) collectGarbage(gc gcFunc, keepOrphansFor time.Duration, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for image, imgInfo := range s.imageSet {
+		ci := gc(image)
 
-			if len(ci) == 0 {
+		if len(ci) > 0 {
+			imgInfo.orphanedSince = time.Time{}
+		} else {
+			if !imgInfo.orphaned() {
+				imgInfo.orphanedSince = now
+			}
+			if now.Sub(imgInfo.orphanedSince) >= keepOrphansFor {
 				delete(s.imageSet, image)
 
 				continue
 			}
-
-			imgInfo.ContainerInfo = containerInfoSliceToSet(ci)
-			s.imageSet[image] = imgInfo
 		}
 
-	}, 5*time.Minute)
+		imgInfo.ContainerInfo = containerInfoSliceToSet(ci)
+		s.touch(&imgInfo)
+		s.imageSet[image] = imgInfo
+	}
+	s.version.Add(1)
 }
 
+// ExtractMetrics returns availability metrics of all containers from the current snapshot, along with metrics of the
+// store's components.
 func (s *ImageStore) ExtractMetrics() (ret []prometheus.Metric) {
+	ret = append(ret, s.currentSnapshot().containers...)
+
+	if s.batchTuner != nil {
+		ret = append(ret, s.batchTuner.metrics()...)
+	}
+
+	if s.passBudget != nil {
+		ret = append(ret, s.passBudget.metrics()...)
+	}
+
+	if s.checkWindows != nil {
+		ret = append(ret, s.checkWindows.metrics()...)
+	}
+
+	if s.retryPolicy != nil {
+		var exhausted int
+		now := time.Now()
+		s.lock.RLock()
+		for _, info := range s.imageSet {
+			if s.retryPolicy.exhausted(info.retry, now) {
+				exhausted++
+			}
+		}
+		s.lock.RUnlock()
+		ret = append(ret, prometheus.MustNewConstMetric(retryBudgetExhaustedDesc, prometheus.GaugeValue, float64(exhausted)))
+	}
+
+	return
+}
+
+// RangeContainers calls f for every container referencing an image. The store is read-locked during the call,
+// so f must not call other store methods.
+func (s *ImageStore) RangeContainers(f func(image string, containerInfo ContainerInfo, availMode AvailabilityMode)) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
 	for imageName, info := range s.imageSet {
 		for containerInfo := range info.ContainerInfo {
-			ret = append(ret, newNamedConstMetrics(containerInfo.ControllerKind, containerInfo.ControllerName,
-				containerInfo.Namespace, containerInfo.Container, imageName, info.AvailMode)...)
+			f(imageName, containerInfo, info.AvailMode)
 		}
 	}
+}
 
-	return
+// CheckedAvailabilityModes returns availability modes of images that were checked at least once.
+func (s *ImageStore) CheckedAvailabilityModes() map[string]AvailabilityMode {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make(map[string]AvailabilityMode)
+	for imageName, info := range s.imageSet {
+		if info.checked {
+			ret[imageName] = info.AvailMode
+		}
+	}
+
+	return ret
+}
+
+// ExtractAggregatedMetrics returns the number of distinct unavailable images per namespace and per controller kind
+// from the current snapshot.
+func (s *ImageStore) ExtractAggregatedMetrics() []prometheus.Metric {
+	return append([]prometheus.Metric(nil), s.currentSnapshot().aggregated...)
 }
 
 func (s *ImageStore) ReconcileImage(imageName string, containerInfos []ContainerInfo) {
@@ -124,84 +343,320 @@ func (s *ImageStore) ReconcileImage(imageName string, containerInfos []Container
 	if !ok {
 		containerInfoMap := containerInfoSliceToSet(containerInfos)
 
-		s.imageSet[imageName] = ImageInfo{ContainerInfo: containerInfoMap}
-		s.queue.PushBack(imageName)
+		imageInfo = ImageInfo{ContainerInfo: containerInfoMap}
+		if availMode, ok := s.knownModes[imageName]; ok {
+			imageInfo.AvailMode = availMode
+			imageInfo.checked = true
+			delete(s.knownModes, imageName)
+		}
+		s.touch(&imageInfo)
+		s.imageSet[imageName] = imageInfo
+		if imageInfo.AvailMode == Available {
+			s.availableQueue(imageName).PushBack(imageName)
+		} else {
+			s.errQueue.PushBack(imageName)
+		}
 
 		return
 	}
 
+	if imageInfo.orphaned() {
+		imageInfo.orphanedSince = time.Time{}
+		if imageInfo.dequeued {
+			imageInfo.dequeued = false
+			if imageInfo.AvailMode == Available {
+				s.availableQueue(imageName).PushBack(imageName)
+			} else {
+				s.errQueue.PushBack(imageName)
+			}
+		}
+	}
+
 	for _, ci := range containerInfos {
-		imageInfo.ContainerInfo[ci] = struct{}{}
+		if _, ok := imageInfo.ContainerInfo[ci]; !ok {
+			imageInfo.ContainerInfo[ci] = struct{}{}
+			s.touch(&imageInfo)
+		}
 	}
 
 	s.imageSet[imageName] = imageInfo
 }
 
 func (s *ImageStore) Check() {
+	s.lock.RLock()
+	queueLen, errQueueLen, priorityQueueLen := s.queue.Len(), s.errQueue.Len(), s.priorityQueue.Len()
+	s.lock.RUnlock()
+
+	// Failed images are rechecked in the fast lane.
+	if s.retryPolicy != nil {
+		errQueueLen = 0
+	}
+
+	var pass *budgetPass
+	if s.passBudget != nil {
+		pass = s.passBudget.startPass()
+		defer pass.end()
+	}
+
+	// Outside of check windows, available images that have been checked are skipped.
+	var skip func(imageInfo ImageInfo) bool
+	if s.checkWindows != nil && !s.checkWindows.open(s.checkWindows.now()) {
+		skip = checkedAvailable
+	}
+
+	if s.batchTuner != nil {
+		s.batchTuner.startPass()
+		defer s.batchTuner.endPass()
+
+		// Every queued image is considered, the tuner decides which ones fit into the current pass.
+		_ = s.popCheckPush(s.priorityQueue, priorityQueueLen, pass, skip)
+		_ = s.popCheckPush(s.errQueue, errQueueLen, pass, nil)
+		_ = s.popCheckPush(s.queue, queueLen, pass, skip)
+
+		return
+	}
+
 	var (
 		normalChecks = s.concurrentNormalChecks
 		errChecks    = s.concurrentErrorChecks
 	)
 
-	if qLen := s.queue.Len(); qLen < s.concurrentNormalChecks {
-		normalChecks = qLen
+	// Priority images are checked first, but can't starve the rest of the images.
+	if priorityQueueLen > 0 {
+		normalChecks -= s.popCheckPush(s.priorityQueue, min(priorityQueueLen, max(1, normalChecks/2)), pass, skip)
 	}
-	if qLen := s.errQueue.Len(); qLen < s.concurrentErrorChecks {
-		errChecks = qLen
+
+	if queueLen < normalChecks {
+		normalChecks = queueLen
+	}
+	if errQueueLen < s.concurrentErrorChecks {
+		errChecks = errQueueLen
 	}
 
-	errPops := s.popCheckPush(true, errChecks)
+	errPops := s.popCheckPush(s.errQueue, errChecks, pass, nil)
 
 	if errPops < errChecks {
 		normalChecks += errChecks - errPops
 	}
 
-	_ = s.popCheckPush(false, normalChecks)
+	_ = s.popCheckPush(s.queue, normalChecks, pass, skip)
 }
 
-func (s *ImageStore) popCheckPush(errQ bool, count int) (pops int) {
-	for pops < count {
-		s.lock.Lock()
-		var imageRaw interface{}
-		if errQ {
-			imageRaw = s.errQueue.PopFront()
+// CheckImage checks an image immediately, out of the queue order. The result is recorded if the image is still
+// tracked, so it becomes visible in metrics and transition handlers right away. Requested checks aren't counted
+// against the retry budget.
+func (s *ImageStore) CheckImage(image string) (availMode AvailabilityMode, tracked bool) {
+	availMode = s.check(image)
+
+	s.lock.Lock()
+	imageInfo, tracked := s.imageSet[image]
+	if !tracked {
+		s.lock.Unlock()
+		return availMode, false
+	}
+	transition := s.recordCheck(image, imageInfo, availMode, false)
+	s.lock.Unlock()
+
+	s.save(image, availMode)
+	s.notifyTransition(transition)
+
+	return availMode, true
+}
+
+// CheckFailed rechecks failed images that are due according to the retry policy.
+func (s *ImageStore) CheckFailed() {
+	if s.retryPolicy == nil {
+		return
+	}
+
+	s.lock.RLock()
+	errQueueLen := s.errQueue.Len()
+	s.lock.RUnlock()
+
+	_ = s.popCheckPush(s.errQueue, errQueueLen, nil, nil)
+}
+
+// RecheckFailed moves failed images matching f from the failed image queue to the front of the priority queue, so
+// that they are checked first during the next passes, e.g. once their registry recovers from an outage. Images of
+// critical workloads are checked first, and the retry backoff of every image is reset. It returns the number of
+// images scheduled.
+func (s *ImageStore) RecheckFailed(f func(image string) bool) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var critical, rest []string
+	for n := s.errQueue.Len(); n > 0; n-- {
+		image := s.errQueue.PopFront()
+
+		imageInfo, ok := s.imageSet[image]
+		if !ok || imageInfo.orphaned() || imageInfo.AvailMode == Available || (s.owns != nil && !s.owns(image)) || !f(image) {
+			s.errQueue.PushBack(image)
+			continue
+		}
+
+		imageInfo.retry = retryState{}
+		s.imageSet[image] = imageInfo
+
+		if s.isPriority != nil && s.isPriority(image) {
+			critical = append(critical, image)
 		} else {
-			imageRaw = s.queue.PopFront()
+			rest = append(rest, image)
 		}
+	}
+
+	scheduled := append(critical, rest...)
+	for i := len(scheduled) - 1; i >= 0; i-- {
+		s.priorityQueue.PushFront(scheduled[i])
+	}
+
+	return len(scheduled)
+}
+
+// popCheckPush checks up to count images from the front of a queue. If pass is not nil, images that don't fit into
+// its budget are left at the front of the queue, so that they are checked first during the next pass. If skip is not
+// nil, images it returns true for are moved to the back of the queue without being checked or counted, and the queue
+// is scanned at most once. So are images owned by other replicas sharing the backend.
+func (s *ImageStore) popCheckPush(queue imageQueue, count int, pass *budgetPass, skip func(imageInfo ImageInfo) bool) (pops int) {
+	errQ := queue == s.errQueue
+
+	// The fast lane is paced by the retry backoff, so it neither spends nor skews the batches of the tuner, which are
+	// reset every pass.
+	batchTuner := s.batchTuner
+	if errQ && s.retryPolicy != nil {
+		batchTuner = nil
+	}
+
+	scanLimit := math.MaxInt
+	if skip != nil || s.owns != nil {
+		s.lock.RLock()
+		scanLimit = queue.Len()
+		s.lock.RUnlock()
+	}
+
+	var deferred []string
+	defer func() {
+		if len(deferred) == 0 {
+			return
+		}
+
+		s.lock.Lock()
+		for i := len(deferred) - 1; i >= 0; i-- {
+			queue.PushFront(deferred[i])
+		}
+		s.lock.Unlock()
+	}()
+
+	for scanned := 0; pops < count && scanned < scanLimit; scanned++ {
+		s.lock.Lock()
+		if queue.Len() == 0 {
+			s.lock.Unlock()
+			return
+		}
+		image := queue.PopFront()
 		pops++
-		image := imageRaw.(string)
 
-		_, ok := s.imageSet[image]
+		imageInfo, ok := s.imageSet[image]
 		if !ok {
 			s.lock.Unlock()
 			continue
 		}
+
+		// Orphans aren't checked, they are queued again once referenced.
+		if imageInfo.orphaned() {
+			imageInfo.dequeued = true
+			s.imageSet[image] = imageInfo
+			s.lock.Unlock()
+			continue
+		}
+
+		// Images checked by other replicas are refreshed from the backend instead.
+		if (skip != nil && skip(imageInfo)) || (s.owns != nil && !s.owns(image)) {
+			queue.PushBack(image)
+			pops--
+			s.lock.Unlock()
+			continue
+		}
+
+		if pass != nil && pass.exhausted() {
+			s.lock.Unlock()
+			deferred = append(deferred, image)
+			pass.deferred += count - pops + 1
+
+			// Don't let the unused checks be spent on the other queue.
+			return count
+		}
+
+		if errQ && s.retryPolicy != nil && !s.retryPolicy.eligible(&imageInfo.retry, time.Now()) {
+			s.imageSet[image] = imageInfo
+			s.errQueue.PushBack(image)
+			s.lock.Unlock()
+			continue
+		}
+
+		var budgetRegistry string
+		if pass != nil {
+			var exhausted bool
+			if budgetRegistry, exhausted = pass.registryExhausted(image); exhausted {
+				s.lock.Unlock()
+				deferred = append(deferred, image)
+				continue
+			}
+		}
+
+		var registry string
+		if batchTuner != nil {
+			registry = batchTuner.registryOf(image)
+			if !batchTuner.admit(registry, errQ) {
+				queue.PushBack(image)
+				s.lock.Unlock()
+				continue
+			}
+		}
 		s.lock.Unlock()
 
+		checkStart := time.Now()
 		availMode := s.check(image)
+		if batchTuner != nil {
+			batchTuner.observe(registry, time.Since(checkStart), availMode == RegistryUnavailable || availMode == UnknownError)
+		}
+		if pass != nil {
+			pass.observe(budgetRegistry, time.Since(checkStart))
+		}
 
 		s.lock.Lock()
 
-		imageInfo, ok := s.imageSet[image]
+		imageInfo, ok = s.imageSet[image]
 		if !ok {
 			s.lock.Unlock()
 			continue
 		}
-		imageInfo.AvailMode = availMode
-		s.imageSet[image] = imageInfo
+
+		transition := s.recordCheck(image, imageInfo, availMode, errQ)
 
 		if availMode == Available {
-			s.queue.PushBack(image)
+			s.availableQueue(image).PushBack(image)
 		} else {
 			s.errQueue.PushBack(image)
 		}
 
 		s.lock.Unlock()
+
+		s.save(image, availMode)
+		s.notifyTransition(transition)
 	}
 
 	return
 }
 
+// availableQueue returns the queue an available image is checked from. Must be called with the lock held.
+func (s *ImageStore) availableQueue(image string) imageQueue {
+	if s.isPriority != nil && s.isPriority(image) {
+		return s.priorityQueue
+	}
+
+	return s.queue
+}
+
 func containerInfoSliceToSet(containerInfos []ContainerInfo) map[ContainerInfo]struct{} {
 	var containerInfoMap = make(map[ContainerInfo]struct{})
 	for _, ci := range containerInfos {
@@ -211,31 +666,110 @@ func containerInfoSliceToSet(containerInfos []ContainerInfo) map[ContainerInfo]s
 	return containerInfoMap
 }
 
-func newNamedConstMetrics(ownerKind, ownerName, namespace, container, image string, avalMode AvailabilityMode) (ret []prometheus.Metric) {
+func containerInfoSetToSlice(containerInfoMap map[ContainerInfo]struct{}) []ContainerInfo {
+	containerInfos := make([]ContainerInfo, 0, len(containerInfoMap))
+	for ci := range containerInfoMap {
+		containerInfos = append(containerInfos, ci)
+	}
+
+	return containerInfos
+}
+
+// UseExtraLabels adds labels returned by f to availability metrics of every container. names are the labels f
+// returns, labels it doesn't return for a container are empty.
+func (s *ImageStore) UseExtraLabels(names []string, f extraLabelsFunc) {
+	s.extraLabels = f
+	s.extraLabelNames = names
+	s.descs = newAvailabilityDescs(s.rewriteImage != nil, names)
+	s.invalidateSnapshot()
+}
+
+// UseImageRewrite makes availability metrics report images as rewritten by f, i.e. the location they are actually
+// pulled from, in the image label, while the original_image label keeps the image as written in the pod template.
+func (s *ImageStore) UseImageRewrite(f func(image string) string) {
+	s.rewriteImage = f
+	s.descs = newAvailabilityDescs(true, s.extraLabelNames)
+	s.invalidateSnapshot()
+}
+
+func (s *ImageStore) newContainerMetrics(containerInfo ContainerInfo, image string, avalMode AvailabilityMode) (ret []prometheus.Metric) {
 	labels := map[string]string{
-		"namespace": namespace,
-		"container": container,
-		"image":     image,
-		"kind":      strings.ToLower(ownerKind),
-		"name":      ownerName,
+		"namespace": containerInfo.Namespace,
+		"container": containerInfo.Container,
+		"kind":      strings.ToLower(containerInfo.ControllerKind),
+		"name":      containerInfo.ControllerName,
+	}
+	s.addImageLabels(labels, image)
+	s.addExtraLabels(labels, containerInfo)
+
+	labelValues := s.descs.labelValues(labels)
+	if s.metricStyle.perMode() {
+		ret = append(ret, s.descs.perModeMetrics(labelValues, avalMode)...)
+	}
+	if s.metricStyle.enum() {
+		ret = append(ret, prometheus.MustNewConstMetric(s.descs.enum, prometheus.GaugeValue, float64(avalMode), labelValues...))
+	}
+
+	return
+}
+
+func (s *ImageStore) addImageLabels(labels map[string]string, image string) {
+	if s.rewriteImage == nil {
+		labels["image"] = image
+		return
+	}
+
+	labels["image"] = s.rewriteImage(image)
+	labels[originalImageLabel] = image
+}
+
+func (s *ImageStore) addExtraLabels(labels map[string]string, containerInfo ContainerInfo) {
+	if s.extraLabels == nil {
+		return
 	}
 
-	return getMetric(labels, avalMode)
+	for k, v := range s.extraLabels(containerInfo) {
+		labels[k] = v
+	}
 }
 
-func getMetric(labels map[string]string, mode AvailabilityMode) (ret []prometheus.Metric) {
-	for availMode, desc := range AvailabilityModeDescMap {
-		var value float64
-		if availMode == mode {
-			value = 1
+// recordCheck stores the result of a check and returns the resulting transition, if any. Must be called with the
+// lock held.
+func (s *ImageStore) recordCheck(image string, imageInfo ImageInfo, availMode AvailabilityMode, retry bool) *Transition {
+	var transition *Transition
+	if (imageInfo.checked && imageInfo.AvailMode != availMode) || (!imageInfo.checked && availMode != Available) {
+		transition = &Transition{
+			Image:          image,
+			OldMode:        imageInfo.AvailMode,
+			NewMode:        availMode,
+			FirstCheck:     !imageInfo.checked,
+			ContainerInfos: containerInfoSetToSlice(imageInfo.ContainerInfo),
 		}
+	}
 
-		ret = append(ret, prometheus.MustNewConstMetric(
-			prometheus.NewDesc("k8s_image_availability_exporter_"+desc, "", nil, labels),
-			prometheus.GaugeValue,
-			value,
-		))
+	if imageInfo.AvailMode != availMode {
+		s.touch(&imageInfo)
+	} else if !imageInfo.checked {
+		// The first check changes the reschedule blockers even if the image is available, while its own metrics
+		// don't change.
+		s.version.Add(1)
+	}
+	imageInfo.AvailMode = availMode
+	imageInfo.checked = true
+	if s.retryPolicy != nil {
+		s.retryPolicy.record(&imageInfo.retry, availMode, retry, time.Now())
 	}
+	s.imageSet[image] = imageInfo
 
-	return
+	return transition
+}
+
+func (s *ImageStore) notifyTransition(transition *Transition) {
+	if transition == nil {
+		return
+	}
+
+	for _, handler := range s.transitionHandlers {
+		handler(*transition)
+	}
 }
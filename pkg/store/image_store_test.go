@@ -2,10 +2,13 @@ package store
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +24,39 @@ func insertImagesIntoStore(t *testing.T, store *ImageStore, successfulChecks, fa
 	}
 }
 
+var fqNameRegex = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// series formats a series like metricString does.
+func series(name string, labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(labels))
+	for _, label := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", label, labels[label]))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricString formats a metric as name{label="value",...} with labels sorted by name.
+func metricString(t *testing.T, m prometheus.Metric) string {
+	t.Helper()
+
+	var metric dto.Metric
+	require.NoError(t, m.Write(&metric))
+
+	labels := make(prometheus.Labels)
+	for _, pair := range metric.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+
+	return series(fqNameRegex.FindStringSubmatch(m.Desc().String())[1], labels)
+}
+
 func TestImageStore_AddOrUpdateImage(t *testing.T) {
 	store := NewImageStore(reconcile(t), 2, 3)
 
@@ -44,7 +80,7 @@ func TestImageStore_AddOrUpdateImage(t *testing.T) {
 	store.Check()
 
 	metrics := store.ExtractMetrics()
-	require.Len(t, metrics, 70)
+	require.Len(t, metrics, 80)
 }
 
 func reconcile(t *testing.T) func(imageName string) AvailabilityMode {
@@ -87,11 +123,9 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 			},
 		}
 
-		expectedMetrics := []*prometheus.Desc{
-			prometheus.NewDesc(
+		expectedMetrics := []string{
+			series(
 				"k8s_image_availability_exporter_registry_unavailable",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -100,10 +134,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authentication_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -112,10 +144,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authorization_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -124,10 +154,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_unknown_error",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -136,10 +164,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_available",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -148,10 +174,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_absent",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -160,10 +184,18 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_bad_image_format",
-				"",
-				nil,
+				prometheus.Labels{
+					"container": "test_container",
+					"image":     "test_0",
+					"kind":      "deployment",
+					"name":      "test_name",
+					"namespace": "test_ns",
+				},
+			),
+			series(
+				"k8s_image_availability_exporter_invalid_reference",
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -180,17 +212,12 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 		metrics := store.ExtractMetrics()
 		require.Len(t, metrics, len(expectedMetrics))
 
-		expectedMetricsStr := make([]string, 0, len(expectedMetrics))
-		for _, em := range expectedMetrics {
-			expectedMetricsStr = append(expectedMetricsStr, em.String())
-		}
-
 		returnedMetricsStr := make([]string, 0, len(metrics))
 		for _, m := range metrics {
-			returnedMetricsStr = append(returnedMetricsStr, m.Desc().String())
+			returnedMetricsStr = append(returnedMetricsStr, metricString(t, m))
 		}
 
-		assert.ElementsMatch(t, expectedMetricsStr, returnedMetricsStr)
+		assert.ElementsMatch(t, expectedMetrics, returnedMetricsStr)
 	})
 
 	t.Run("two containers, different kind", func(t *testing.T) {
@@ -213,11 +240,9 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 			},
 		}
 
-		expectedMetrics := []*prometheus.Desc{
-			prometheus.NewDesc(
+		expectedMetrics := []string{
+			series(
 				"k8s_image_availability_exporter_registry_unavailable",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -226,10 +251,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authentication_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -238,10 +261,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authorization_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -250,10 +271,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_unknown_error",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -262,10 +281,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_available",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -274,10 +291,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_absent",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -286,10 +301,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_bad_image_format",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container",
 					"image":     "test_0",
@@ -298,10 +311,18 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns",
 				},
 			),
-			prometheus.NewDesc(
+			series(
+				"k8s_image_availability_exporter_invalid_reference",
+				prometheus.Labels{
+					"container": "test_container",
+					"image":     "test_0",
+					"kind":      "deployment",
+					"name":      "test_name",
+					"namespace": "test_ns",
+				},
+			),
+			series(
 				"k8s_image_availability_exporter_registry_unavailable",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -310,10 +331,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authentication_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -322,10 +341,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_authorization_failure",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -334,10 +351,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_unknown_error",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -346,10 +361,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_available",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -358,10 +371,8 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_absent",
-				"",
-				nil,
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -370,10 +381,18 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 					"namespace": "test_ns2",
 				},
 			),
-			prometheus.NewDesc(
+			series(
 				"k8s_image_availability_exporter_bad_image_format",
-				"",
-				nil,
+				prometheus.Labels{
+					"container": "test_container2",
+					"image":     "test_0",
+					"kind":      "statefulset",
+					"name":      "test_name2",
+					"namespace": "test_ns2",
+				},
+			),
+			series(
+				"k8s_image_availability_exporter_invalid_reference",
 				prometheus.Labels{
 					"container": "test_container2",
 					"image":     "test_0",
@@ -390,16 +409,200 @@ func TestImageStore_ExtractMetrics(t *testing.T) {
 		metrics := store.ExtractMetrics()
 		require.Len(t, metrics, len(expectedMetrics))
 
-		expectedMetricsStr := make([]string, 0, len(expectedMetrics))
-		for _, em := range expectedMetrics {
-			expectedMetricsStr = append(expectedMetricsStr, em.String())
-		}
-
 		returnedMetricsStr := make([]string, 0, len(metrics))
 		for _, m := range metrics {
-			returnedMetricsStr = append(returnedMetricsStr, m.Desc().String())
+			returnedMetricsStr = append(returnedMetricsStr, metricString(t, m))
+		}
+
+		assert.ElementsMatch(t, expectedMetrics, returnedMetricsStr)
+	})
+}
+
+func TestImageStore_TransitionHandlers(t *testing.T) {
+	mode := Available
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		return mode
+	}, 10, 10)
+
+	var transitions []Transition
+	store.AddTransitionHandler(func(transition Transition) {
+		transitions = append(transitions, transition)
+	})
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("test", info)
+
+	store.Check()
+	require.Empty(t, transitions)
+
+	mode = Absent
+	store.Check()
+	require.Len(t, transitions, 1)
+	assert.Equal(t, Transition{Image: "test", OldMode: Available, NewMode: Absent, ContainerInfos: info}, transitions[0])
+
+	store.Check()
+	require.Len(t, transitions, 1)
+
+	store.ReconcileImage("fail", info)
+	store.Check()
+	require.Len(t, transitions, 2)
+	assert.True(t, transitions[1].FirstCheck)
+	assert.Equal(t, "none", transitions[1].OldModeString())
+}
+
+func TestImageStore_ExtractAggregatedMetrics(t *testing.T) {
+	store := NewImageStore(reconcile(t), 10, 10)
+
+	deployment := ContainerInfo{Namespace: "a", ControllerKind: "Deployment", ControllerName: "web", Container: "web"}
+	// The image is unavailable in both namespaces, but counted once for each of them and for each kind.
+	store.ReconcileImage("fail_shared", []ContainerInfo{
+		deployment,
+		{Namespace: "a", ControllerKind: "Deployment", ControllerName: "worker", Container: "worker"},
+		{Namespace: "b", ControllerKind: "StatefulSet", ControllerName: "db", Container: "db"},
+	})
+	store.ReconcileImage("fail_web", []ContainerInfo{deployment})
+	store.ReconcileImage("ok", []ContainerInfo{{Namespace: "c", ControllerKind: "DaemonSet", ControllerName: "agent", Container: "agent"}})
+	store.Check()
+
+	var aggregated []string
+	for _, m := range store.ExtractAggregatedMetrics() {
+		if m.Desc() != namespaceUnavailableImagesDesc && m.Desc() != kindUnavailableImagesDesc {
+			continue
 		}
+		var metric dto.Metric
+		require.NoError(t, m.Write(&metric))
+		aggregated = append(aggregated, fmt.Sprintf("%s %v", metricString(t, m), metric.GetGauge().GetValue()))
+	}
+
+	// Namespaces and kinds referencing only available images are reported with zero values.
+	require.ElementsMatch(t, []string{
+		`k8s_image_availability_exporter_namespace_unavailable_images{namespace="a"} 2`,
+		`k8s_image_availability_exporter_namespace_unavailable_images{namespace="b"} 1`,
+		`k8s_image_availability_exporter_namespace_unavailable_images{namespace="c"} 0`,
+		`k8s_image_availability_exporter_controller_kind_unavailable_images{kind="deployment"} 2`,
+		`k8s_image_availability_exporter_controller_kind_unavailable_images{kind="statefulset"} 1`,
+		`k8s_image_availability_exporter_controller_kind_unavailable_images{kind="daemonset"} 0`,
+	}, aggregated)
+}
 
-		assert.ElementsMatch(t, expectedMetricsStr, returnedMetricsStr)
+func TestImageStore_SeriesLimit(t *testing.T) {
+	store := NewImageStore(reconcile(t), 10, 10)
+	store.UseSeriesLimit(1)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	insertImagesIntoStore(t, store, 2, 1, info)
+	store.Check()
+
+	var images []string
+	for _, m := range store.ExtractMetrics() {
+		line := metricString(t, m)
+		if strings.HasPrefix(line, "k8s_image_availability_exporter_absent{") {
+			images = append(images, line)
+		}
+		if strings.HasPrefix(line, "k8s_image_availability_exporter_dropped_series{") {
+			images = append(images, line)
+		}
+	}
+
+	// The failed image is preferred, both available images are collapsed.
+	require.Len(t, images, 3)
+	assert.Contains(t, strings.Join(images, "\n"), `image="fail_0"`)
+	assert.Contains(t, strings.Join(images, "\n"), `image="other"`)
+}
+
+func TestImageStore_ExtraLabels(t *testing.T) {
+	store := NewImageStore(reconcile(t), 10, 10)
+	store.UseExtraLabels([]string{"label_team"}, func(containerInfo ContainerInfo) map[string]string {
+		return map[string]string{"label_team": containerInfo.ControllerName + "-team"}
 	})
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "web", Container: "test"}}
+	insertImagesIntoStore(t, store, 1, 0, info)
+	store.Check()
+
+	var found bool
+	for _, m := range store.ExtractMetrics() {
+		line := metricString(t, m)
+		if strings.HasPrefix(line, "k8s_image_availability_exporter_available{") {
+			assert.Contains(t, line, `label_team="web-team"`)
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestImageStore_ImageRewrite(t *testing.T) {
+	store := NewImageStore(reconcile(t), 10, 10)
+	store.UseImageRewrite(func(image string) string { return "mirror.example.com/" + image })
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "web", Container: "test"}}
+	insertImagesIntoStore(t, store, 1, 0, info)
+	store.Check()
+
+	var found bool
+	for _, m := range store.ExtractMetrics() {
+		line := metricString(t, m)
+		if strings.HasPrefix(line, "k8s_image_availability_exporter_available{") {
+			assert.Contains(t, line, `image="mirror.example.com/test_0"`)
+			assert.Contains(t, line, `original_image="test_0"`)
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestImageStore_Priority(t *testing.T) {
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		return Available
+	}, 2, 2)
+	store.UsePriority(func(image string) bool { return strings.HasPrefix(image, "critical_") })
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	insertImagesIntoStore(t, store, 3, 0, info)
+	store.ReconcileImage("critical_0", info)
+
+	// The critical image is checked during every pass, the rest take turns.
+	for i := 0; i < 3; i++ {
+		store.Check()
+	}
+	require.Equal(t, []string{"critical_0", "test_0", "critical_0", "test_1", "critical_0", "test_2"}, checked)
+}
+
+func TestImageStore_RecheckFailed(t *testing.T) {
+	var (
+		checked []string
+		down    = true
+	)
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		if down && strings.HasPrefix(imageName, "down.example.com/") {
+			return RegistryUnavailable
+		}
+		if strings.HasSuffix(imageName, ":absent") {
+			return Absent
+		}
+		return Available
+	}, 4, 4)
+	store.UsePriority(func(image string) bool { return strings.HasSuffix(image, ":critical") })
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"down.example.com/app:v1", "down.example.com/app:critical", "up.example.com/app:absent"} {
+		store.ReconcileImage(image, info)
+	}
+	store.Check()
+	require.Equal(t, 3, store.errQueue.Len())
+
+	down = false
+	scheduled := store.RecheckFailed(func(image string) bool { return strings.HasPrefix(image, "down.example.com/") })
+	require.Equal(t, 2, scheduled)
+	require.Equal(t, 1, store.errQueue.Len())
+
+	// Images of critical workloads are rechecked first.
+	checked = nil
+	store.Check()
+	require.Equal(t, []string{"down.example.com/app:critical", "down.example.com/app:v1", "up.example.com/app:absent"}, checked)
+	require.Equal(t, Available, store.imageSet["down.example.com/app:v1"].AvailMode)
+	require.Equal(t, 1, store.errQueue.Len())
 }
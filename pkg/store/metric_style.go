@@ -0,0 +1,54 @@
+package store
+
+import "fmt"
+
+// MetricStyle selects how availability of container images is exported.
+type MetricStyle string
+
+const (
+	// MetricStylePerMode exports a gauge per availability mode, only the gauge of the current mode is non-zero.
+	MetricStylePerMode MetricStyle = "per-mode"
+	// MetricStyleEnum exports a single gauge with the numeric value of the current availability mode.
+	MetricStyleEnum MetricStyle = "enum"
+	// MetricStyleBoth exports both, e.g. while migrating dashboards and alerts from one style to the other.
+	MetricStyleBoth MetricStyle = "both"
+)
+
+const availabilityModeMetricName = "k8s_image_availability_exporter_availability_mode"
+
+// availabilityModeHelp documents the values of the enum gauge, since they are part of the exporter's interface.
+var availabilityModeHelp = func() string {
+	help := "Availability mode of the image:"
+	for mode := Available; mode <= InvalidReference; mode++ {
+		help += fmt.Sprintf(" %d=%s", mode, mode)
+	}
+	return help
+}()
+
+func ParseMetricStyle(style string) (MetricStyle, error) {
+	switch s := MetricStyle(style); s {
+	case MetricStylePerMode, MetricStyleEnum, MetricStyleBoth:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown metric style %q, must be %q, %q or %q", style, MetricStylePerMode, MetricStyleEnum, MetricStyleBoth)
+	}
+}
+
+func (m MetricStyle) perMode() bool {
+	return m != MetricStyleEnum
+}
+
+func (m MetricStyle) enum() bool {
+	return m == MetricStyleEnum || m == MetricStyleBoth
+}
+
+// seriesPerContainer is the number of availability series exported for a single container.
+func (m MetricStyle) seriesPerContainer() (ret int) {
+	if m.perMode() {
+		ret += len(AvailabilityModeDescMap)
+	}
+	if m.enum() {
+		ret++
+	}
+	return
+}
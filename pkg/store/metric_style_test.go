@@ -0,0 +1,67 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func countAvailabilitySeries(t *testing.T, store *ImageStore) (perMode, enum int) {
+	t.Helper()
+
+	for _, m := range store.ExtractMetrics() {
+		line := metricString(t, m)
+		switch {
+		case strings.HasPrefix(line, availabilityModeMetricName+"{"):
+			enum++
+		case strings.HasPrefix(line, "k8s_image_availability_exporter_available{"):
+			perMode++
+		}
+	}
+
+	return
+}
+
+func TestImageStore_MetricStyle(t *testing.T) {
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+
+	for style, expected := range map[MetricStyle][2]int{
+		MetricStylePerMode: {1, 0},
+		MetricStyleEnum:    {0, 1},
+		MetricStyleBoth:    {1, 1},
+	} {
+		store := NewImageStore(reconcile(t), 10, 10)
+		store.UseMetricStyle(style)
+		insertImagesIntoStore(t, store, 1, 0, info)
+		store.Check()
+
+		perMode, enum := countAvailabilitySeries(t, store)
+		require.Equal(t, expected, [2]int{perMode, enum}, style)
+	}
+}
+
+func TestImageStore_EnumValue(t *testing.T) {
+	store := NewImageStore(reconcile(t), 10, 10)
+	store.UseMetricStyle(MetricStyleEnum)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	insertImagesIntoStore(t, store, 0, 1, info)
+	store.Check()
+
+	metrics := store.ExtractMetrics()
+	require.Len(t, metrics, 1)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	require.Equal(t, float64(UnknownError), m.GetGauge().GetValue())
+}
+
+func TestParseMetricStyle(t *testing.T) {
+	style, err := ParseMetricStyle("both")
+	require.NoError(t, err)
+	require.Equal(t, MetricStyleBoth, style)
+
+	_, err = ParseMetricStyle("boolean")
+	require.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deferredChecksDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_deferred_checks",
+		"Number of image checks deferred to the next pass during the last pass because the pass time budget was exceeded.",
+		nil, nil,
+	)
+	registryDeferredChecksDesc = prometheus.NewDesc(
+		"k8s_image_availability_exporter_registry_deferred_checks",
+		"Number of image checks deferred to the next pass during the last pass because the registry time budget was exceeded.",
+		[]string{"registry"}, nil,
+	)
+)
+
+// PassBudget limits the time a single check pass may take, both in total and per registry, so that a slow registry
+// can't starve checks of images in other registries. Images that don't fit into a pass are checked first during
+// the next one.
+type PassBudget struct {
+	total       time.Duration
+	perRegistry time.Duration
+	registryOf  registryFunc
+
+	lock                 sync.Mutex
+	lastDeferred         int
+	lastRegistryDeferred map[string]int
+}
+
+// NewPassBudget creates a budget, either of the durations may be zero to not limit it.
+func NewPassBudget(total, perRegistry time.Duration, registryOf registryFunc) *PassBudget {
+	return &PassBudget{
+		total:                total,
+		perRegistry:          perRegistry,
+		registryOf:           registryOf,
+		lastRegistryDeferred: make(map[string]int),
+	}
+}
+
+// budgetPass tracks the time spent during a single pass. It is only used from the checking goroutine.
+type budgetPass struct {
+	budget *PassBudget
+
+	start            time.Time
+	spent            map[string]time.Duration
+	deferred         int
+	registryDeferred map[string]int
+}
+
+func (b *PassBudget) startPass() *budgetPass {
+	return &budgetPass{
+		budget:           b,
+		start:            time.Now(),
+		spent:            make(map[string]time.Duration),
+		registryDeferred: make(map[string]int),
+	}
+}
+
+func (p *budgetPass) exhausted() bool {
+	return p.budget.total > 0 && time.Since(p.start) >= p.budget.total
+}
+
+// registryExhausted reports whether the registry has used up its budget. The registry is looked up only if there
+// is a per-registry budget.
+func (p *budgetPass) registryExhausted(image string) (registry string, exhausted bool) {
+	if p.budget.perRegistry <= 0 {
+		return "", false
+	}
+
+	registry = p.budget.registryOf(image)
+	if p.spent[registry] < p.budget.perRegistry {
+		return registry, false
+	}
+
+	p.registryDeferred[registry]++
+	return registry, true
+}
+
+func (p *budgetPass) observe(registry string, duration time.Duration) {
+	if p.budget.perRegistry > 0 {
+		p.spent[registry] += duration
+	}
+}
+
+func (p *budgetPass) end() {
+	b := p.budget
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastDeferred = p.deferred
+	for registry := range b.lastRegistryDeferred {
+		b.lastRegistryDeferred[registry] = 0
+	}
+	for registry, deferred := range p.registryDeferred {
+		b.lastRegistryDeferred[registry] = deferred
+	}
+}
+
+func (b *PassBudget) metrics() (ret []prometheus.Metric) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ret = append(ret, prometheus.MustNewConstMetric(deferredChecksDesc, prometheus.GaugeValue, float64(b.lastDeferred)))
+	for registry, deferred := range b.lastRegistryDeferred {
+		ret = append(ret, prometheus.MustNewConstMetric(registryDeferredChecksDesc, prometheus.GaugeValue, float64(deferred), registry))
+	}
+
+	return
+}
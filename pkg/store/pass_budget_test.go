@@ -0,0 +1,52 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageStore_RegistryPassBudget(t *testing.T) {
+	var checked []string
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked = append(checked, imageName)
+		time.Sleep(time.Millisecond)
+		return Available
+	}, 10, 10)
+	budget := NewPassBudget(0, time.Nanosecond, func(image string) string {
+		return strings.Split(image, "_")[0]
+	})
+	store.UsePassBudget(budget)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	for _, image := range []string{"a_1", "a_2", "b_1"} {
+		store.ReconcileImage(image, info)
+	}
+
+	// Every registry gets a single check, the deferred image is checked first during the next pass.
+	store.Check()
+	require.Equal(t, []string{"a_1", "b_1"}, checked)
+	require.Equal(t, map[string]int{"a": 1}, budget.lastRegistryDeferred)
+
+	store.Check()
+	require.Equal(t, []string{"a_1", "b_1", "a_2", "b_1"}, checked)
+}
+
+func TestImageStore_PassBudget(t *testing.T) {
+	var checked int
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checked++
+		return Available
+	}, 10, 10)
+	budget := NewPassBudget(time.Nanosecond, 0, nil)
+	store.UsePassBudget(budget)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	insertImagesIntoStore(t, store, 3, 0, info)
+
+	store.Check()
+	require.Zero(t, checked)
+	require.Equal(t, 3, budget.lastDeferred)
+}
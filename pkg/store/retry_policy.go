@@ -0,0 +1,75 @@
+package store
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const retryBudgetWindow = 24 * time.Hour
+
+var retryBudgetExhaustedDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_retry_budget_exhausted_images",
+	"Number of failing images that exhausted their daily recheck budget.",
+	nil, nil,
+)
+
+// RetryPolicy controls rechecks of failed images in the fast lane. Every failed image is rechecked with an
+// exponential backoff, but no more than DailyBudget times a day.
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	DailyBudget int
+}
+
+type retryState struct {
+	failures  int
+	nextCheck time.Time
+
+	windowStart time.Time
+	used        int
+}
+
+func (p *RetryPolicy) eligible(state *retryState, now time.Time) bool {
+	if now.Before(state.nextCheck) {
+		return false
+	}
+
+	if p.DailyBudget <= 0 {
+		return true
+	}
+
+	if now.Sub(state.windowStart) >= retryBudgetWindow {
+		state.windowStart = now
+		state.used = 0
+	}
+
+	return state.used < p.DailyBudget
+}
+
+func (p *RetryPolicy) record(state *retryState, availMode AvailabilityMode, retry bool, now time.Time) {
+	if availMode == Available {
+		state.failures = 0
+		state.nextCheck = time.Time{}
+		return
+	}
+
+	if retry {
+		state.used++
+	}
+	state.failures++
+
+	delay := p.BaseDelay
+	for i := 1; i < state.failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	state.nextCheck = now.Add(delay)
+}
+
+func (p *RetryPolicy) exhausted(state retryState, now time.Time) bool {
+	return p.DailyBudget > 0 && state.used >= p.DailyBudget && now.Sub(state.windowStart) < retryBudgetWindow
+}
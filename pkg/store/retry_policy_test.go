@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, DailyBudget: 3}
+	now := time.Now()
+
+	var state retryState
+	require.True(t, policy.eligible(&state, now))
+
+	policy.record(&state, Absent, false, now)
+	require.Equal(t, now.Add(time.Second), state.nextCheck)
+	require.False(t, policy.eligible(&state, now))
+
+	policy.record(&state, Absent, true, now)
+	require.Equal(t, now.Add(2*time.Second), state.nextCheck)
+	policy.record(&state, Absent, true, now)
+	require.Equal(t, now.Add(4*time.Second), state.nextCheck)
+	policy.record(&state, Absent, true, now)
+	require.Equal(t, now.Add(5*time.Second), state.nextCheck)
+
+	later := now.Add(time.Minute)
+	require.False(t, policy.eligible(&state, later))
+	require.True(t, policy.exhausted(state, later))
+
+	nextDay := now.Add(retryBudgetWindow + time.Minute)
+	require.True(t, policy.eligible(&state, nextDay))
+	require.False(t, policy.exhausted(state, nextDay))
+
+	policy.record(&state, Available, true, nextDay)
+	require.Zero(t, state.failures)
+	require.True(t, policy.eligible(&state, nextDay))
+}
+
+func TestImageStore_CheckFailed(t *testing.T) {
+	var checks int
+	store := NewImageStore(func(imageName string) AvailabilityMode {
+		checks++
+		return Absent
+	}, 10, 10)
+	store.UseRetryPolicy(&RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	store.ReconcileImage("test", []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}})
+
+	store.Check()
+	require.Equal(t, 1, checks)
+	require.Equal(t, 1, store.errQueue.Len())
+
+	// Failed images are not rechecked in the normal lane and are backed off in the fast one.
+	store.Check()
+	store.CheckFailed()
+	require.Equal(t, 1, checks)
+	require.Equal(t, 1, store.errQueue.Len())
+}
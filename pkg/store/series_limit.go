@@ -0,0 +1,83 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const overflowImage = "other"
+
+var droppedSeriesDesc = prometheus.NewDesc(
+	"k8s_image_availability_exporter_dropped_series",
+	"Number of availability series not exported because their images were collapsed into the \"other\" image.",
+	[]string{"namespace"}, nil,
+)
+
+// extractLimitedMetrics exports no more than maxImagesPerNamespace distinct images per namespace. Unavailable images
+// are preferred, so that alerts keep working. The rest of the containers are counted per availability mode in
+// series of the "other" image. Must be called with the store lock held.
+func (s *ImageStore) extractLimitedMetrics() (ret []prometheus.Metric) {
+	imagesByNamespace := make(map[string][]string)
+	for imageName, info := range s.imageSet {
+		namespaces := make(map[string]struct{})
+		for containerInfo := range info.ContainerInfo {
+			namespaces[containerInfo.Namespace] = struct{}{}
+		}
+		for namespace := range namespaces {
+			imagesByNamespace[namespace] = append(imagesByNamespace[namespace], imageName)
+		}
+	}
+
+	for namespace, images := range imagesByNamespace {
+		sort.Slice(images, func(i, j int) bool {
+			iAvailable, jAvailable := s.imageSet[images[i]].AvailMode == Available, s.imageSet[images[j]].AvailMode == Available
+			if iAvailable != jAvailable {
+				return !iAvailable
+			}
+			return images[i] < images[j]
+		})
+
+		var (
+			overflow = make(map[AvailabilityMode]int)
+			dropped  int
+		)
+
+		for i, imageName := range images {
+			info := s.imageSet[imageName]
+			for containerInfo := range info.ContainerInfo {
+				if containerInfo.Namespace != namespace {
+					continue
+				}
+
+				if i < s.maxImagesPerNamespace {
+					ret = append(ret, s.newContainerMetrics(containerInfo, imageName, info.AvailMode)...)
+					continue
+				}
+
+				overflow[info.AvailMode]++
+				dropped++
+			}
+		}
+
+		// The enum style can't represent counts, so the collapsed containers are only reported as dropped series.
+		if dropped > 0 && s.metricStyle.perMode() {
+			labels := map[string]string{
+				"namespace": namespace,
+				"container": "",
+				"kind":      "",
+				"name":      "",
+			}
+			s.addImageLabels(labels, overflowImage)
+			s.addExtraLabels(labels, ContainerInfo{Namespace: namespace})
+			labelValues := s.descs.labelValues(labels)
+			for availMode, desc := range s.descs.perMode {
+				ret = append(ret, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(overflow[availMode]), labelValues...))
+			}
+		}
+
+		ret = append(ret, prometheus.MustNewConstMetric(droppedSeriesDesc, prometheus.GaugeValue, float64(dropped*s.metricStyle.seriesPerContainer()), namespace))
+	}
+
+	return
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsSnapshot is an immutable set of availability metrics built from a version of the store. Scrapes share the
+// latest snapshot as long as the store doesn't change, and a new snapshot reuses metrics of the images that didn't
+// change since the previous one, so that scrapes of large stores hold the store lock for a short time only.
+type metricsSnapshot struct {
+	version uint64
+
+	images     map[string]imageMetrics
+	containers []prometheus.Metric
+	aggregated []prometheus.Metric
+}
+
+// imageMetrics are container metrics of an image as of its generation.
+type imageMetrics struct {
+	generation uint64
+	metrics    []prometheus.Metric
+}
+
+// touch marks the image as changed, so that its metrics are rebuilt by the next snapshot. Must be called with the
+// lock held.
+func (s *ImageStore) touch(imageInfo *ImageInfo) {
+	imageInfo.generation = s.version.Add(1)
+}
+
+// invalidateSnapshot makes the next scrape rebuild metrics of all images, e.g. once the way they are built changes.
+func (s *ImageStore) invalidateSnapshot() {
+	s.snapshotLock.Lock()
+	s.snapshot.Store(nil)
+	s.snapshotLock.Unlock()
+}
+
+// currentSnapshot returns a snapshot of the current version of the store, building it if necessary.
+func (s *ImageStore) currentSnapshot() *metricsSnapshot {
+	if snapshot := s.snapshot.Load(); snapshot != nil && snapshot.version == s.version.Load() {
+		return snapshot
+	}
+
+	// Concurrent scrapes wait for a single snapshot to be built instead of building their own.
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+
+	prev := s.snapshot.Load()
+	if prev != nil && prev.version == s.version.Load() {
+		return prev
+	}
+
+	s.lock.RLock()
+	snapshot := s.buildSnapshot(prev)
+	s.lock.RUnlock()
+
+	s.snapshot.Store(snapshot)
+
+	return snapshot
+}
+
+// buildSnapshot builds a snapshot, reusing metrics of unchanged images from prev, which may be nil. Must be called
+// with the lock held.
+func (s *ImageStore) buildSnapshot(prev *metricsSnapshot) *metricsSnapshot {
+	snapshot := &metricsSnapshot{
+		version: s.version.Load(),
+		images:  make(map[string]imageMetrics, len(s.imageSet)),
+	}
+
+	if s.maxImagesPerNamespace > 0 {
+		// Images compete for the per-namespace limit, so metrics can't be reused.
+		snapshot.containers = s.extractLimitedMetrics()
+	} else {
+		for imageName, info := range s.imageSet {
+			cached, ok := imageMetrics{}, false
+			if prev != nil {
+				cached, ok = prev.images[imageName]
+			}
+
+			if !ok || cached.generation != info.generation {
+				cached = imageMetrics{generation: info.generation}
+				for containerInfo := range info.ContainerInfo {
+					cached.metrics = append(cached.metrics, s.newContainerMetrics(containerInfo, imageName, info.AvailMode)...)
+				}
+			}
+
+			snapshot.images[imageName] = cached
+			snapshot.containers = append(snapshot.containers, cached.metrics...)
+		}
+	}
+
+	snapshot.aggregated = s.aggregatedMetrics()
+
+	return snapshot
+}
+
+// aggregatedMetrics returns the number of distinct unavailable images per namespace and per controller kind, and
+// whether the whole cluster could be rescheduled along with the images preventing it by reason. Namespaces, kinds and
+// reasons without unavailable images are reported with zero values. Must be called with the lock held.
+func (s *ImageStore) aggregatedMetrics() (ret []prometheus.Metric) {
+	var (
+		byNamespace = make(map[string]map[string]struct{})
+		byKind      = make(map[string]map[string]struct{})
+		blockers    = make(map[string]int)
+	)
+
+	for availMode, reason := range AvailabilityModeDescMap {
+		if availMode != Available {
+			blockers[reason] = 0
+		}
+	}
+	blockers[notCheckedReason] = 0
+
+	for imageName, info := range s.imageSet {
+		// Orphans aren't referenced by any controller, so they don't need to be pulled.
+		if !info.orphaned() {
+			switch {
+			case !info.checked:
+				blockers[notCheckedReason]++
+			case info.AvailMode != Available:
+				blockers[info.AvailMode.String()]++
+			}
+		}
+
+		for containerInfo := range info.ContainerInfo {
+			kind := strings.ToLower(containerInfo.ControllerKind)
+			if _, ok := byNamespace[containerInfo.Namespace]; !ok {
+				byNamespace[containerInfo.Namespace] = make(map[string]struct{})
+			}
+			if _, ok := byKind[kind]; !ok {
+				byKind[kind] = make(map[string]struct{})
+			}
+
+			if info.AvailMode == Available {
+				continue
+			}
+
+			byNamespace[containerInfo.Namespace][imageName] = struct{}{}
+			byKind[kind][imageName] = struct{}{}
+		}
+	}
+
+	for namespace, images := range byNamespace {
+		ret = append(ret, prometheus.MustNewConstMetric(namespaceUnavailableImagesDesc, prometheus.GaugeValue, float64(len(images)), namespace))
+	}
+	for kind, images := range byKind {
+		ret = append(ret, prometheus.MustNewConstMetric(kindUnavailableImagesDesc, prometheus.GaugeValue, float64(len(images)), kind))
+	}
+
+	reschedulable := 1.0
+	for reason, count := range blockers {
+		if count > 0 {
+			reschedulable = 0
+		}
+		ret = append(ret, prometheus.MustNewConstMetric(rescheduleBlockersDesc, prometheus.GaugeValue, float64(count), reason))
+	}
+	ret = append(ret, prometheus.MustNewConstMetric(clusterReschedulableDesc, prometheus.GaugeValue, reschedulable))
+
+	return
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func imageMetricsOf(t *testing.T, store *ImageStore, image string) []prometheus.Metric {
+	t.Helper()
+
+	snapshot := store.currentSnapshot()
+	require.Contains(t, snapshot.images, image)
+
+	return snapshot.images[image].metrics
+}
+
+func TestImageStore_snapshot(t *testing.T) {
+	modes := map[string]AvailabilityMode{"a": Available, "b": Available}
+	store := NewImageStore(func(image string) AvailabilityMode { return modes[image] }, 2, 2)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("a", info)
+	store.ReconcileImage("b", info)
+
+	first := store.currentSnapshot()
+	require.Same(t, first, store.currentSnapshot(), "an unchanged store must reuse the snapshot")
+
+	// The first check changes reschedule blockers, but not metrics of the images.
+	store.Check()
+	require.NotSame(t, first, store.currentSnapshot())
+	require.Equal(t, first.images["a"].metrics, imageMetricsOf(t, store, "a"))
+
+	// Checks that don't change availability don't invalidate the snapshot.
+	first = store.currentSnapshot()
+	store.Check()
+	require.Same(t, first, store.currentSnapshot())
+
+	a, b := imageMetricsOf(t, store, "a"), imageMetricsOf(t, store, "b")
+
+	modes["b"] = Absent
+	_, _ = store.CheckImage("b")
+
+	second := store.currentSnapshot()
+	require.NotSame(t, first, second)
+	require.Equal(t, a, imageMetricsOf(t, store, "a"), "metrics of unchanged images must be reused")
+	require.NotEqual(t, b, imageMetricsOf(t, store, "b"))
+
+	store.ReconcileImage("a", []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "other", Container: "test"}})
+	require.Len(t, imageMetricsOf(t, store, "a"), 2*len(AvailabilityModeDescMap))
+}
+
+func TestImageStore_ExtractMetricsDoesNotWaitForLock(t *testing.T) {
+	store := NewImageStore(reconcile(t), 2, 2)
+	store.ReconcileImage("test", []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}})
+	_ = store.ExtractMetrics()
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	done := make(chan []prometheus.Metric)
+	go func() {
+		done <- store.ExtractMetrics()
+	}()
+
+	select {
+	case metrics := <-done:
+		require.Len(t, metrics, len(AvailabilityModeDescMap))
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExtractMetrics waited for the store lock")
+	}
+}
+
+func TestImageStore_reschedulable(t *testing.T) {
+	modes := map[string]AvailabilityMode{"a": Available, "b": Available}
+	store := NewImageStore(func(image string) AvailabilityMode { return modes[image] }, 1, 1)
+
+	info := []ContainerInfo{{Namespace: "test", ControllerKind: "Deployment", ControllerName: "test", Container: "test"}}
+	store.ReconcileImage("a", info)
+	store.ReconcileImage("b", info)
+
+	reschedulable := func() (float64, map[string]float64) {
+		var value float64
+		blockers := make(map[string]float64)
+		for _, m := range store.ExtractAggregatedMetrics() {
+			var metric dto.Metric
+			require.NoError(t, m.Write(&metric))
+
+			switch m.Desc() {
+			case clusterReschedulableDesc:
+				value = metric.GetGauge().GetValue()
+			case rescheduleBlockersDesc:
+				blockers[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+			}
+		}
+		return value, blockers
+	}
+
+	value, blockers := reschedulable()
+	require.Zero(t, value, "unchecked images may be unavailable")
+	require.Equal(t, float64(2), blockers[notCheckedReason])
+	require.Len(t, blockers, len(AvailabilityModeDescMap))
+
+	_, _ = store.CheckImage("a")
+	modes["b"] = Absent
+	_, _ = store.CheckImage("b")
+	value, blockers = reschedulable()
+	require.Zero(t, value)
+	require.Zero(t, blockers[notCheckedReason])
+	require.Equal(t, float64(1), blockers[Absent.String()])
+
+	modes["b"] = Available
+	_, _ = store.CheckImage("b")
+	value, blockers = reschedulable()
+	require.Equal(t, float64(1), value)
+	require.Zero(t, blockers[Absent.String()])
+}
@@ -0,0 +1,54 @@
+package validation
+
+import (
+	"fmt"
+	"io"
+)
+
+// Report collects results of configuration checks, so that all problems are reported at once instead of failing on
+// the first one.
+type Report struct {
+	results []result
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// Check records the result of a check of the named setting, a nil error means the setting is valid.
+func (r *Report) Check(name string, err error) {
+	r.results = append(r.results, result{name: name, err: err})
+}
+
+// Failed reports whether any of the checks failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.results {
+		if res.err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write writes a line per check in the order they were made, followed by a summary.
+func (r *Report) Write(w io.Writer) error {
+	var failed int
+	for _, res := range r.results {
+		var err error
+		if res.err != nil {
+			failed++
+			_, err = fmt.Fprintf(w, "FAIL %s: %v\n", res.name, res.err)
+		} else {
+			_, err = fmt.Fprintf(w, "OK   %s\n", res.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d checks, %d failed\n", len(r.results), failed)
+
+	return err
+}
@@ -0,0 +1,22 @@
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	report := &Report{}
+	report.Check("ignored-images", nil)
+	require.False(t, report.Failed())
+
+	report.Check("capath", errors.New("no such file"))
+	require.True(t, report.Failed())
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Write(&buf))
+	require.Equal(t, "OK   ignored-images\nFAIL capath: no such file\n2 checks, 1 failed\n", buf.String())
+}
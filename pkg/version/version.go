@@ -0,0 +1,52 @@
+// Package version identifies the build of the exporter.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version is set at build time with -ldflags "-X github.com/flant/k8s-image-availability-exporter/pkg/version.Version=v1.0.0".
+var Version = "dev"
+
+// Revision returns the VCS revision the binary was built from, if it's known.
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
+
+// UserAgent returns the default User-Agent of requests to registries, which identifies the cluster, if its name is set.
+func UserAgent(clusterName string) string {
+	if len(clusterName) == 0 {
+		return fmt.Sprintf("k8s-image-availability-exporter/%s", Version)
+	}
+
+	return fmt.Sprintf("k8s-image-availability-exporter/%s (cluster %s)", Version, clusterName)
+}
+
+// NewBuildInfoCollector returns a collector of a constant metric labeled by the version of the exporter.
+func NewBuildInfoCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "k8s_image_availability_exporter",
+		Name:      "build_info",
+		Help:      "Version of the exporter, the value is always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":   Version,
+			"revision":  Revision(),
+			"goversion": runtime.Version(),
+		},
+	}, func() float64 { return 1 })
+}
@@ -0,0 +1,20 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAgent(t *testing.T) {
+	require.Equal(t, "k8s-image-availability-exporter/dev", UserAgent(""))
+	require.Equal(t, "k8s-image-availability-exporter/dev (cluster production)", UserAgent("production"))
+}
+
+func TestNewBuildInfoCollector(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(NewBuildInfoCollector()))
+	require.Equal(t, 1, testutil.CollectAndCount(registry, "k8s_image_availability_exporter_build_info"))
+}